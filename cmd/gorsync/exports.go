@@ -0,0 +1,318 @@
+//go:build cgo
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	stdsync "sync"
+	"unsafe"
+
+	"gorsync/pkg/net"
+	"gorsync/pkg/sync"
+)
+
+// 共享库导出函数，仅在启用 cgo 时编译，CGO_ENABLED=0 时只构建命令行程序
+
+// #cgo CFLAGS: -I./
+// #include <stdlib.h>
+import "C"
+
+// 全局变量，用于存储服务器实例
+var (
+	serverInstance *net.Server
+	serverMutex    = &stdsync.Mutex{}
+)
+
+// maxSyncLog 每个后台同步缓存的最多输出字节数，宿主程序长时间不读取时丢弃最早的输出
+const maxSyncLog = 1024 * 1024
+
+// syncJob StartSync 在后台执行的同步，每个同步有自己的输出、取消信号和统计数据，互不影响
+type syncJob struct {
+	syncer *sync.Syncer
+	log    syncLog
+	cancel chan struct{}
+	stop   stdsync.Once
+	done   chan struct{}
+	err    error // done 关闭后有效
+}
+
+// syncLog 缓存后台同步的输出，直到宿主程序通过 GetSyncLog 取走
+type syncLog struct {
+	mutex stdsync.Mutex
+	data  []byte
+}
+
+func (l *syncLog) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.data = append(l.data, p...)
+	if excess := len(l.data) - maxSyncLog; excess > 0 {
+		l.data = append(l.data[:0], l.data[excess:]...)
+	}
+	return len(p), nil
+}
+
+// drain 取走缓存的输出
+func (l *syncLog) drain() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	data := string(l.data)
+	l.data = l.data[:0]
+	return data
+}
+
+// 后台同步的句柄表
+var (
+	syncJobs   = make(map[int64]*syncJob)
+	nextJob    int64
+	syncsMutex stdsync.Mutex
+)
+
+// StartServer 启动服务
+//
+//export StartServer
+func StartServer() C.int {
+	// 检查是否已经有服务器实例在运行
+	serverMutex.Lock()
+	defer serverMutex.Unlock()
+
+	if serverInstance != nil {
+		fmt.Printf("Server already running\n")
+		return 1 // 失败，服务器已在运行
+	}
+
+	// 使用默认的当前目录和 8730 端口
+	rootDir := "."
+	port := 8730
+
+	// 创建并启动服务器
+	serverInstance = net.NewServer(rootDir, port)
+
+	// 在后台启动服务器
+	go func() {
+		if err := serverInstance.Start(); err != nil {
+			fmt.Printf("Failed to start server: %v\n", err)
+			// 清理服务器实例
+			serverMutex.Lock()
+			serverInstance = nil
+			serverMutex.Unlock()
+		}
+	}()
+	return 0 // 成功
+}
+
+// SyncFiles 同步文件
+//
+//export SyncFiles
+func SyncFiles(localPath *C.char, remotePath *C.char) C.int {
+	// 将 C 字符串转换为 Go 字符串
+	goLocalPath := C.GoString(localPath)
+	goRemotePath := C.GoString(remotePath)
+	host, port, path, err := parseRemoteAddr(goRemotePath)
+
+	if err != nil {
+		fmt.Printf("Invalid remote address: %v", err)
+		return 1 // 失败
+	}
+
+	// 创建同步器并执行同步操作
+	syncer := sync.NewPeerSyncer(goLocalPath, host, path, port)
+
+	if err := syncer.Sync(); err != nil {
+		fmt.Printf("Sync failed: %v\n", err)
+		return 1 // 失败
+	}
+
+	return 0 // 成功
+}
+
+// StartSync 在后台开始同步，返回用于 GetSyncStatsJSON、GetSyncLog、CancelSync、WaitSync 和 ReleaseSync 的句柄，
+// 失败时返回 -1。可以从多个线程同时调用，同步的输出不写到标准输出，而是缓存起来由 GetSyncLog 取走
+//
+//export StartSync
+func StartSync(localPath *C.char, remotePath *C.char) C.longlong {
+	host, port, path, err := parseRemoteAddr(C.GoString(remotePath))
+	if err != nil {
+		fmt.Printf("Invalid remote address: %v\n", err)
+		return -1 // 失败
+	}
+
+	job := &syncJob{
+		syncer: sync.NewPeerSyncer(C.GoString(localPath), host, path, port),
+		cancel: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if err := job.syncer.SetOptions(sync.Options{Output: &job.log, Cancel: job.cancel}); err != nil {
+		fmt.Printf("Invalid options: %v\n", err)
+		return -1 // 失败
+	}
+	syncsMutex.Lock()
+	nextJob++
+	handle := nextJob
+	syncJobs[handle] = job
+	syncsMutex.Unlock()
+
+	go func() {
+		job.err = job.syncer.Sync()
+		if job.err != nil {
+			fmt.Fprintf(&job.log, "Sync failed: %v\n", job.err)
+		}
+		close(job.done)
+	}()
+	return C.longlong(handle)
+}
+
+// lookupSync 返回句柄对应的后台同步，句柄无效时返回 nil
+func lookupSync(handle C.longlong) *syncJob {
+	syncsMutex.Lock()
+	defer syncsMutex.Unlock()
+
+	return syncJobs[int64(handle)]
+}
+
+// GetSyncStatsJSON 以 JSON 返回后台同步的统计数据（sync.SyncStats），同步进行中和结束后都可以调用。
+// 返回的字符串需用 FreeString 释放，句柄无效时返回 NULL
+//
+//export GetSyncStatsJSON
+func GetSyncStatsJSON(handle C.longlong) *C.char {
+	job := lookupSync(handle)
+	if job == nil {
+		return nil
+	}
+	stats := job.syncer.Stats()
+	select {
+	case <-job.done:
+	default:
+		// 后台 goroutine 可能还没有进入 Sync
+		stats.Running = true
+	}
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return nil
+	}
+	return C.CString(string(data))
+}
+
+// GetSyncLog 取走后台同步自上次调用以来的输出，返回的字符串需用 FreeString 释放，句柄无效时返回 NULL
+//
+//export GetSyncLog
+func GetSyncLog(handle C.longlong) *C.char {
+	job := lookupSync(handle)
+	if job == nil {
+		return nil
+	}
+	return C.CString(job.log.drain())
+}
+
+// CancelSync 取消后台同步：不再开始新的操作并断开正在进行的传输，用 WaitSync 等待其结束。
+// 句柄无效时返回 -1
+//
+//export CancelSync
+func CancelSync(handle C.longlong) C.int {
+	job := lookupSync(handle)
+	if job == nil {
+		return -1
+	}
+	job.stop.Do(func() {
+		close(job.cancel)
+	})
+	return 0 // 成功
+}
+
+// WaitSync 等待后台同步结束，返回值与 SyncFiles 相同，句柄无效时返回 -1
+//
+//export WaitSync
+func WaitSync(handle C.longlong) C.int {
+	job := lookupSync(handle)
+	if job == nil {
+		return -1
+	}
+	<-job.done
+	if job.err != nil {
+		return 1 // 失败
+	}
+	return 0 // 成功
+}
+
+// ReleaseSync 释放已结束的后台同步的句柄，同步仍在进行时返回 1
+//
+//export ReleaseSync
+func ReleaseSync(handle C.longlong) C.int {
+	syncsMutex.Lock()
+	defer syncsMutex.Unlock()
+
+	job, ok := syncJobs[int64(handle)]
+	if !ok {
+		return -1
+	}
+	select {
+	case <-job.done:
+	default:
+		return 1 // 同步仍在进行
+	}
+	delete(syncJobs, int64(handle))
+	return 0 // 成功
+}
+
+// FreeString 释放 GetSyncStatsJSON 返回的字符串
+//
+//export FreeString
+func FreeString(s *C.char) {
+	C.free(unsafe.Pointer(s))
+}
+
+// PauseSync 暂停进程中的所有传输
+//
+//export PauseSync
+func PauseSync() C.int {
+	net.PauseTransfers()
+	return 0 // 成功
+}
+
+// ResumeSync 恢复进程中的所有传输，用 PauseSyncJob 单独暂停的后台同步仍然暂停
+//
+//export ResumeSync
+func ResumeSync() C.int {
+	net.ResumeTransfers()
+	return 0 // 成功
+}
+
+// PauseSyncJob 只暂停一个后台同步的传输，暂停期间 CancelSync 仍会中止同步。句柄无效时返回 -1
+//
+//export PauseSyncJob
+func PauseSyncJob(handle C.longlong) C.int {
+	job := lookupSync(handle)
+	if job == nil {
+		return -1
+	}
+	job.syncer.Pause()
+	return 0 // 成功
+}
+
+// ResumeSyncJob 恢复 PauseSyncJob 暂停的后台同步，句柄无效时返回 -1
+//
+//export ResumeSyncJob
+func ResumeSyncJob(handle C.longlong) C.int {
+	job := lookupSync(handle)
+	if job == nil {
+		return -1
+	}
+	job.syncer.Resume()
+	return 0 // 成功
+}
+
+// StopServer 停止所有服务器
+//
+//export StopServer
+func StopServer() C.int {
+	// 清理服务器实例
+	serverMutex.Lock()
+	serverInstance.Stop()
+	serverInstance = nil
+	serverMutex.Unlock()
+
+	return 0 // 成功
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorsync/pkg/net"
+	"gorsync/pkg/utils"
+)
+
+// runFind 在服务器上查找满足条件的文件并逐行打印，服务器只返回匹配项，不需要下载整个列表。
+// 条件参数可以写在远程地址之前或之后，例如 gorsync find host:/data -name '*.iso' -larger 1G
+func runFind(args []string) {
+	fs := flag.NewFlagSet("find", flag.ExitOnError)
+	name := fs.String("name", "", "文件名模式（只匹配最后一级），例如 '*.iso'")
+	larger := fs.String("larger", "", "只查找大于这个大小的文件，例如 1G")
+	smaller := fs.String("smaller", "", "只查找小于这个大小的文件")
+	newer := fs.String("newer", "", "只查找修改时间晚于这个时间的文件：日期（2006-01-02）、RFC3339 时间或距现在的时长（7d、36h）")
+	older := fs.String("older", "", "只查找修改时间早于这个时间的文件，格式同 --newer")
+	kind := fs.String("type", "", "f 只查找文件，d 只查找目录，为空时都查找")
+	limit := fs.Int("limit", net.DefaultFindLimit, fmt.Sprintf("最多返回的结果数，最大 %d", net.MaxFindLimit))
+	long := fs.Bool("l", false, "同时打印大小和修改时间")
+	identityFile := fs.String("identity-file", "", "包含客户端身份令牌的文件，在该客户端映射的目录中查找")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: gorsync find <host[:port]:path> [--name <pattern>] [--larger <size>] [--smaller <size>] [--newer <time>] [--older <time>] [--type f|d] [--limit <n>] [-l]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	// 远程地址之后的条件参数
+	var remote string
+	if fs.NArg() > 0 {
+		remote = fs.Arg(0)
+		fs.Parse(fs.Args()[1:])
+	}
+	if remote == "" || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	query := net.FindQuery{Name: *name, Type: *kind, Limit: *limit}
+	var err error
+	if *larger != "" {
+		if query.Larger, err = utils.ParseSize(*larger); err != nil {
+			log.Fatalf("Invalid --larger: %v", err)
+		}
+	}
+	if *smaller != "" {
+		if query.Smaller, err = utils.ParseSize(*smaller); err != nil {
+			log.Fatalf("Invalid --smaller: %v", err)
+		}
+	}
+	now := time.Now()
+	if *newer != "" {
+		if query.Newer, err = parseFindTime(*newer, now); err != nil {
+			log.Fatalf("Invalid --newer: %v", err)
+		}
+	}
+	if *older != "" {
+		if query.Older, err = parseFindTime(*older, now); err != nil {
+			log.Fatalf("Invalid --older: %v", err)
+		}
+	}
+	if err := query.Validate(); err != nil {
+		log.Fatalf("Invalid query: %v", err)
+	}
+
+	host, port, path, err := parseRemoteAddr(remote)
+	if err != nil {
+		log.Fatalf("Invalid remote address: %v", err)
+	}
+	client := net.NewClient(host, port)
+	client.SetIdentity(readIdentity(*identityFile))
+	info, err := client.Probe()
+	if err != nil {
+		log.Printf("Find failed: %v", err)
+		os.Exit(exitCode(err))
+	}
+	if !slices.Contains(info.Capabilities, net.CapFind) {
+		log.Fatalf("Find failed: %s:%d does not accept find requests", host, port)
+	}
+
+	files, truncated, err := client.Find(path, query)
+	if err != nil {
+		log.Printf("Find failed: %v", err)
+		os.Exit(exitCode(err))
+	}
+	for _, f := range files {
+		p := f.Path
+		if f.IsDir {
+			p += "/"
+		}
+		if *long {
+			fmt.Printf("%10s  %s  %s\n", utils.FormatSize(f.Size), time.Unix(f.ModTime, 0).Format("2006-01-02 15:04:05"), p)
+		} else {
+			fmt.Println(p)
+		}
+	}
+	if truncated {
+		fmt.Fprintf(os.Stderr, "Stopped after %d match(es), use --limit to see more\n", len(files))
+	}
+}
+
+// parseFindTime 解析 --newer 和 --older 的时间：日期、RFC3339 时间，或距 now 的时长（支持 d 表示天）
+func parseFindTime(s string, now time.Time) (int64, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t.Unix(), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.Unix(), nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n).Unix(), nil
+		}
+	} else if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d).Unix(), nil
+	}
+	return 0, fmt.Errorf("invalid time %q, expected a date, an RFC3339 time or a duration such as 7d", s)
+}
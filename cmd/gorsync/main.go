@@ -1,39 +1,287 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"maps"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
-	stdsync "sync"
+	"syscall"
+	"time"
 
 	"gorsync/pkg/net"
 	"gorsync/pkg/sync"
+	"gorsync/pkg/utils"
 )
 
-// #cgo CFLAGS: -I./
-// #include <stdlib.h>
-import "C"
-
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "scrub" {
+		runScrub(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "manifest" {
+		runManifest(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "history" {
+		runHistory(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "stat" {
+		runStat(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "ping" {
+		runPing(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "complete" {
+		runComplete(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "find" {
+		runFind(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "probe" {
+		runProbe(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		runAdmin(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "service" {
+		runService(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "status" {
+		runStatus(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replicate" {
+		runReplicate(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "selftest" {
+		runSelftest(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "push" {
+		runPush(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && (os.Args[1] == "rm" || os.Args[1] == "mkdir") {
+		runRemoteCommand(os.Args[1], os.Args[2:])
+		return
+	}
+
 	path := flag.String("path", "", "本地目录路径")
-	remote := flag.String("remote", "", "远程地址，格式: host[:port]:path，例如 127.0.0.1:8730:/home/src 或 127.0.0.1:/home/src (默认端口8730)")
+	remote := flag.String("remote", "", "远程地址，格式: host[:port]:path，例如 127.0.0.1:8730:/home/src 或 127.0.0.1:/home/src (默认端口8730)；也可以是 http(s):// 开头的静态源URL")
+	httpManifest := flag.String("http-manifest", net.DefaultHTTPManifest, "HTTP(S) 静态源上由 gorsync manifest 生成的清单文件，相对于 --remote 的URL")
 	listen := flag.Int("listen", 8730, "启动服务器模式并指定监听端口，默认8730端口(传入0或省略值时使用默认端口)")
+	noFind := flag.Bool("no-find", false, "服务器模式下拒绝 find 请求，避免客户端触发对大目录的遍历")
+	portRange := flag.Int("port-range", 0, "监听端口被占用时依次尝试之后的这么多个端口，使用第一个空闲的端口并打印出来")
+	deleteDuring := flag.Bool("delete-during", false, "处理到某个目录时立即删除其中的多余文件，不等待所有传输完成；同步中途失败时本地可能缺少文件")
+	deleteDelay := flag.Bool("delete-delay", false, "传输过程中记录需要删除的文件，全部传输成功后再删除（默认）")
+	deleteAfter := flag.Bool("delete-after", false, "全部传输成功后重新扫描本地目录并删除多余文件")
+	pruneEmptyDirs := flag.Bool("prune-empty-dirs", false, "不创建不包含任何文件的目录，并删除同步后留下的空目录")
+	bwlimit := flag.String("bwlimit", "", "下载带宽限制，例如 10MB，或按时间段设置 10MB@08:00-20:00,0 (0 表示不限速)")
+	controlToken := flag.String("control-token", "", "控制请求的认证令牌，服务器模式下设置后允许远程触发拉取")
+	checkpoint := flag.String("checkpoint", "", "定期将已完成的文件记录到该文件，同步中断后再次运行时跳过已确认文件的校验")
+	noVerify := flag.Bool("no-verify", false, "下载完成后不再读取整个文件与服务器的MD5比较，只依靠 TCP 和 --integrity-key 的校验，用于可信的高速局域网上大文件的再次读取占主要耗时的情况")
+	verifySample := flag.String("verify-sample", "", "下载完成后只对这个比例的随机抽样比较整个文件的MD5，例如 10%，每隔 --verify-full-interval 全部比较一次；为空表示全部比较")
+	verifyFullInterval := flag.Duration("verify-full-interval", sync.DefaultFullVerify, "--verify-sample 时全部比较一次的间隔，上次全部比较的时间记录在本地目录下的 .gorsync-verify.json")
+	requireChecksum := flag.Bool("require-checksum", false, "大小相同但一端的MD5计算失败、无法比较内容的文件重新下载，而不是只按大小视为相同；服务器仍然无法提供MD5时该文件下载失败")
+	verifyReadback := flag.Bool("verify-readback", false, "每个文件下载完成后丢弃缓存并从磁盘重新读取，校验写入的数据是否与传输的MD5一致")
+	manifest := flag.String("manifest", "", "同步成功后将文件的大小、修改时间和MD5写入该清单，供 scrub 命令检查位衰减")
+	history := flag.String("history", "", "每次同步后将汇总信息（时间、字节数、变更文件数、错误）追加到该文件，供 history 命令查看")
+	report := flag.String("report", "", "同步结束时将完整的 JSON 报告（计划、每个文件的结果、耗时、MD5、错误）写入该文件")
+	identityFile := flag.String("identity-file", "", "包含客户端身份令牌的文件，服务器按令牌把请求映射到该客户端的目录，使每台主机可以使用相同的命令行")
+	integrityKey := flag.String("integrity-key", "", "完整性模式的共享密钥，服务器和客户端需设置相同的值，文件内容以 HMAC-SHA256 校验")
+	metadataOnly := flag.Bool("metadata-only", false, "只比较并应用权限和修改时间，不传输文件内容，也不删除文件")
+	owner := flag.Bool("owner", false, "与 --metadata-only 一起使用时同时同步文件属主（需要 root 权限）")
+	noPerms := flag.Bool("no-perms", false, "不比较也不设置文件和目录的权限，用于 Samba/CIFS 挂载、Windows 等权限总是与远程不同的目标")
+	permsSpecial := flag.Bool("perms-special", false, "应用远程文件的 setuid 和 setgid 位，默认去掉这两个位以免下载的文件获得意外的权限")
+	winAttrs := flag.Bool("win-attrs", false, "同步 Windows 文件的只读、隐藏和系统属性（服务器和客户端都运行于 Windows 时有效）")
+	winStreams := flag.Bool("win-streams", false, "同步不超过 64KB 的 NTFS 备用数据流（例如 Zone.Identifier），更大的流和未启用时的流只在同步结束时列出")
+	macXattrs := flag.Bool("mac-xattrs", false, "同步不超过 1MB 的 macOS 扩展属性（com.apple.FinderInfo、资源分支等），服务器和客户端都运行于 macOS 时有效")
+	stripMac := flag.Bool("strip-mac-metadata", false, "同步到非 macOS 目标时不下载 AppleDouble（._name）和 .DS_Store 文件，并删除本地已有的这些文件")
+	winACL := flag.Bool("win-acl", false, "同时同步 NTFS 安全描述符（属主、属组和 DACL），隐含 --win-attrs，设置其他账户为属主需要管理员权限")
+	var remoteSubdirs stringList
+	flag.Var(&remoteSubdirs, "remote-subdir", "只同步远程路径下的指定子目录到本地同名子目录，可重复指定；也可写成 host:/data/{logs,conf}")
+	deleteGrace := flag.Int("delete-grace", 0, "本地多余文件需连续 N 次同步都不在远程时才删除，0 表示立即删除")
+	snapshotCmd := flag.String("snapshot-cmd", "", "服务器模式下读取源目录前执行的快照命令，环境变量 GORSYNC_SOURCE 为源目录，命令需在标准输出打印快照中对应的目录")
+	snapshotReleaseCmd := flag.String("snapshot-release-cmd", "", "同步结束后释放快照的命令，环境变量 GORSYNC_SOURCE 和 GORSYNC_SNAPSHOT 分别为源目录和快照目录")
+	targetFS := flag.String("target-fs", "", "目标文件系统的兼容模式：fat（FAT32）或 exfat，不设置权限和属主，修改时间按 2 秒精度比较，改名无法保存的文件名，跳过特殊文件和（fat）超过 4GB 的文件")
+	nameMap := flag.String("name-map", "", "转换目标上无法创建的文件名（:?*\"<>| 和控制字符、结尾的点和空格、设备名）：underscore（换成 _）、unicode（换成私用区字符，与 Cygwin/WSL 相同）或 percent（%XX 转义），转换记录保存在 .gorsync-names.json，push 时据此还原")
+	minServerVersion := flag.Int("min-server-version", 0, "服务器的协议版本低于此值或无法报告版本时立即失败，便于自动化任务及早发现过旧的服务器；0 表示只拒绝不兼容的版本")
+	modifyWindow := flag.Int("modify-window", 0, "修改时间相差不超过这么多秒时视为相同，用于 FAT/exFAT（2 秒精度）等时间戳精度较低的文件系统")
+	reconnectTimeout := flag.Duration("reconnect-timeout", sync.DefaultReconnectTimeout, "同步过程中服务器重启或断开时等待其恢复的最长时间，恢复后从中断的文件继续；0 表示直接失败")
+	skipLocked := flag.Bool("skip-locked", false, "目标文件被其他进程占用时跳过并在下次同步时重试，而不是中止同步（Windows）")
+	lockRetries := flag.Int("lock-retries", 5, "目标文件被其他进程占用时替换文件的重试次数（Windows）")
+	postReceiveCmd := flag.String("post-receive-cmd", "", "服务器模式下每次推送提交后执行的命令，环境变量 GORSYNC_CHANGED_FILE 为每行一个变更路径的文件，GORSYNC_CHANGED_COUNT 为路径数")
+	maxOpenFiles := flag.Int("max-open-files", 0, "同时打开的文件和连接的最大数量，超出时并行下载和新连接排队等待；0 表示按 ulimit -n 自动计算")
+	maxMemory := flag.String("max-memory", "", "进程的内存上限，例如 256MB：接近上限时更积极地回收内存，正在传输的文件数据缓冲区不超过上限的 1/4，超出时并行传输排队等待；为空表示不限制")
+	maxTransfers := flag.Int("max-transfers", 0, "服务器模式下同时处理的列表和传输请求数，超出时排队，交互式同步优先于 --nice 的后台同步，0 表示不限制")
+	encodeWorkers := flag.Int("encode-workers", 0, "服务器模式下每个大文件传输的压缩和加密（--transform）使用的 goroutine 数，读取、编码和发送也分别并行进行；0 表示按 CPU 数（最多 8 个），1 表示不并行")
+	nice := flag.Bool("nice", false, "作为后台同步运行，服务器达到 --max-transfers 时排在交互式同步之后")
+	config := flag.String("config", "", "服务器模式下的 JSON 配置文件（controlToken、integrityKey、snapshotCmd、snapshotReleaseCmd、postReceiveCmd、maxTransfers），收到 SIGHUP 或 reload 控制请求时重新加载")
+	reloadOn := flag.String("reload-on", "", "请求指定服务器(host[:port])重新加载配置文件，需要 --control-token")
+	seedManifest := flag.String("seed-manifest", "", "服务器模式下使用 gorsync manifest 预先生成的清单中的MD5，大小和修改时间未变的文件不再实时计算哈希")
+	pidFile := flag.String("pid-file", "", "服务器模式下写入进程号的文件，文件中的进程仍在运行时拒绝启动")
+	serviceName := flag.String("service-name", "", "作为该名称的 Windows 服务运行监听模式，由 gorsync service install 写入服务的命令行，不需要手动指定")
+	debugPprof := flag.String("debug-pprof", "", "在本机地址上提供 net/http/pprof 性能分析接口，例如 6060 或 127.0.0.1:6060，只接受回环地址")
+	cpuProfile := flag.String("cpu-profile", "", "把本次运行的 CPU 性能分析数据写入该文件，用 go tool pprof 查看")
+	heapProfile := flag.String("heap-profile", "", "运行结束时把堆内存分析数据写入该文件")
+	logFile := flag.String("log-file", "", "将输出写入该日志文件而不是标准输出，并按大小或时间轮转")
+	logMaxSize := flag.String("log-max-size", "100MB", "日志文件超过该大小时轮转，0 表示不按大小轮转")
+	logRotate := flag.Duration("log-rotate-interval", 0, "按时间轮转日志文件的间隔，例如 24h，0 表示不按时间轮转")
+	logMaxBackups := flag.Int("log-max-backups", 7, "保留的旧日志文件数量，0 表示不限制")
+	logMaxAge := flag.Duration("log-max-age", 0, "旧日志文件的保留时间，例如 720h，0 表示不限制")
+	eventLog := flag.Bool("event-log", false, "将输出写入 Windows 事件日志（来源 gorsync），用于作为 Windows 服务运行、没有控制台的服务器；可与 --log-file 同时使用")
+	parallel := flag.Int("parallel", 1, "同时下载的最大文件数")
+	connPool := flag.Int("conn-pool", net.DefaultPoolSize, "列表、stat、checksum 和签名请求复用的空闲连接数，0 表示每个请求新建连接")
+	connIdle := flag.Duration("conn-idle", net.DefaultPoolIdle, "空闲连接保留的时间，超过后关闭")
+	adaptive := flag.Bool("adaptive", false, "根据吞吐量和连接延迟在 1 到 --parallel 之间动态调整同时下载的文件数")
+	progress := flag.String("progress", "file", "下载进度显示方式：file（列出每个文件）、total（只显示汇总）或 none")
+	progressInterval := flag.Duration("progress-interval", net.DefaultProgressInterval, "下载进度的报告间隔")
+	quiet := flag.Bool("quiet", false, "不显示下载进度和每个文件的下载信息")
+	var transformRules stringList
+	var policyRules stringList
+	flag.Var(&policyRules, "policy", "按文件名选择的传输策略，格式为 pattern=setting[,setting...]，setting 为 compress、nocompress、delta、nodelta 或 block=<size>，可重复指定，每项以第一条设置了它的匹配规则为准")
+	flag.Var(&transformRules, "transform", "传输匹配的文件时应用的变换，格式为 pattern=gzip[,aes]，可重复指定，aes 需要两端设置 --integrity-key")
+	textMode := flag.String("text-mode", "", "按文本处理的文件扩展名（逗号分隔），auto 表示按内容判断；比较时忽略换行符和 BOM 差异，下载时转换为本机换行符")
+	noDedup := flag.Bool("no-dedup", false, "内容相同的远程文件分别下载，不从本地已有或本次先下载的副本复制")
+	dict := flag.Bool("dict", false, "请求服务器从远程目录的小文件训练压缩字典，大量相似的小文件（配置、JSON、源代码）用字典压缩传输")
+	appendOnly := flag.Bool("append", false, "远程文件只是在本地内容之后追加了数据时（例如日志）只下载新增的部分")
+	partial := flag.Bool("partial", false, "下载因连接断开或中断而失败时保留已下载的部分，重试或下次同步时确认服务器上的开头未变后只下载剩余部分")
+	var tailPatterns stringList
+	flag.Var(&tailPatterns, "tail", "同步完成后持续跟踪匹配的远程文件（例如 *.log），只下载新增的部分，直到收到终止信号；可重复指定")
+	tailInterval := flag.Duration("tail-interval", sync.DefaultTailInterval, "跟踪模式下检查远程文件大小的间隔")
+	repeatUntilStable := flag.Int("repeat-until-stable", 0, "重复同步直到某一轮没有任何变化，最多执行这么多轮，用于同步仍在变化的目录；0 表示只同步一轮")
+	spillDir := flag.String("spill-dir", "", "在这个目录下用排序的临时文件保存两端的文件列表，按路径分批规划和传输，内存占用与文件数无关，用于数百万个文件的目录；为空表示在内存中比较")
+	maxFiles := flag.Int("max-files", 0, "一次同步最多下载的文件数，计划超出时中止（--dry-run 时只警告），0 表示不限制")
+	maxTransfer := flag.String("max-transfer-size", "0", "一次同步最多下载的数据量，例如 10GB，计划超出时中止（--dry-run 时只警告），0 表示不限制")
+	budget := flag.String("budget", "", "跨多次同步累计的下载流量预算，例如 50GB/month（周期为 day、week 或 month），计划超出剩余预算时中止")
+	budgetWarn := flag.Int("budget-warn", sync.DefaultBudgetWarn, "流量用到预算的这个百分比时警告，0 表示不警告")
+	budgetFile := flag.String("budget-file", "", "记录流量预算用量的文件，默认为本地目录下的 .gorsync-budget.json，多个同步共用一条连接时可指定同一个文件")
+	requireMarker := flag.Bool("require-marker", false, "本地目录必须包含 .gorsync-dest 标记文件才同步，防止 --path 写错或备份盘未挂载时在错误的目录中大量删除或重新下载")
+	initMarker := flag.Bool("init-marker", false, "同步前在本地目录中创建 .gorsync-dest 标记文件，确认这是同步目标，只需在第一次同步时使用")
+	force := flag.Bool("force", false, "本地目录与 --history 中上次同步时相比变为空或换了文件系统（备份盘可能没有挂载）时仍然同步")
+	dryRun := flag.Bool("dry-run", false, "只打印将要执行的下载、改名和删除操作，不修改本地文件")
+	var pullPeers stringList
+	flag.Var(&pullPeers, "peer", "服务器模式下允许拉取的对端，格式为 host[:port]:path=本地目录，可重复指定；没有登记对端时拒绝所有拉取请求")
+	pullOn := flag.String("pull-on", "", "请求指定服务器(host[:port])从 --remote 拉取文件到该服务器的 --path 目录")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of gorsync:\n")
 		fmt.Fprintf(os.Stderr, "  Sync mode (all operations use TCP, remote-first mode only):\n")
 		fmt.Fprintf(os.Stderr, "    gorsync --path <local> --remote <host[:port]:path>")
+		fmt.Fprintf(os.Stderr, "  HTTP mirror mode (pull from a static HTTP(S) site serving a gorsync manifest):\n")
+		fmt.Fprintf(os.Stderr, "    gorsync --path <local> --remote <http(s)://host/path/> [--http-manifest <file>]")
 		fmt.Fprintf(os.Stderr, "  Listen mode:\n")
-		fmt.Fprintf(os.Stderr, "    gorsync --listen [<port>]")
+		fmt.Fprintf(os.Stderr, "    gorsync --listen [<port>] [--control-token <token>] [--integrity-key <secret>] [--config <file>]")
+		fmt.Fprintf(os.Stderr, "  Reload mode (ask a server to re-read its config file):\n")
+		fmt.Fprintf(os.Stderr, "    gorsync --reload-on <host[:port]> --control-token <token>")
+		fmt.Fprintf(os.Stderr, "  Trigger mode (ask a server to pull from another peer):\n")
+		fmt.Fprintf(os.Stderr, "    gorsync --pull-on <host[:port]> --control-token <token> --path <server path> --remote <host[:port]:path>")
+		fmt.Fprintf(os.Stderr, "  Scrub mode (check a replica against its manifest):\n")
+		fmt.Fprintf(os.Stderr, "    gorsync scrub --path <local> --manifest <file>")
+		fmt.Fprintf(os.Stderr, "  Manifest mode (precompute hashes for a server's --seed-manifest):\n")
+		fmt.Fprintf(os.Stderr, "    gorsync manifest --path <dir> --manifest <file>")
+		fmt.Fprintf(os.Stderr, "  History mode (show previous runs and compare the last two):\n")
+		fmt.Fprintf(os.Stderr, "    gorsync history --history <file> [--n <count>]")
+		fmt.Fprintf(os.Stderr, "  Push mode (upload a local directory, sending only changed blocks):\n")
+		fmt.Fprintf(os.Stderr, "    gorsync push --control-token <token> --path <local> [--atomic] <host[:port]:path>")
+		fmt.Fprintf(os.Stderr, "  Probe mode (show a server's protocol version and capabilities):\n")
+		fmt.Fprintf(os.Stderr, "    gorsync probe <host[:port]>")
+		fmt.Fprintf(os.Stderr, "  Complete mode (list remote directories matching a partial path, for shell completion):\n")
+		fmt.Fprintf(os.Stderr, "    gorsync complete [--identity-file <file>] <host[:port]:[path]>")
+		fmt.Fprintf(os.Stderr, "  Find mode (search a server for files by name, size or date without listing everything):\n")
+		fmt.Fprintf(os.Stderr, "    gorsync find <host[:port]:path> [--name <pattern>] [--larger <size>] [--smaller <size>] [--newer <time>] [--older <time>] [--type f|d] [--limit <n>] [-l]")
+		fmt.Fprintf(os.Stderr, "  Ping mode (check that a server is up):\n")
+		fmt.Fprintf(os.Stderr, "    gorsync ping [--healthcheck] [--timeout <duration>] <host[:port]>")
+		fmt.Fprintf(os.Stderr, "  Admin mode (inspect and manage a running server):\n")
+		fmt.Fprintf(os.Stderr, "    gorsync admin --control-token <token> <host[:port]> status|connections|transfers|kick <id>|reload|stats")
+		fmt.Fprintf(os.Stderr, "  Service mode (register the listener as a systemd unit or Windows service):\n")
+		fmt.Fprintf(os.Stderr, "    gorsync service install [--name <name>] [--port <port>] [--config <file>] [-- <listener flags>] | uninstall | start | stop")
+		fmt.Fprintf(os.Stderr, "  Status mode (show a local server's activity through its status pipe, Windows):\n")
+		fmt.Fprintf(os.Stderr, "    gorsync status [--port <port>]")
+		fmt.Fprintf(os.Stderr, "  Replicate mode (copy between two servers, directly or relayed through this host):\n")
+		fmt.Fprintf(os.Stderr, "    gorsync replicate --control-token <token> [--relay auto|never|always] [--staging <dir>] <source host[:port]:path> <dest host[:port]:path>")
+		fmt.Fprintf(os.Stderr, "  Self-check (sync a generated tree through a temporary local server):\n")
+		fmt.Fprintf(os.Stderr, "    gorsync selftest [--verbose] [--keep]\n")
 		fmt.Fprintf(os.Stderr, "\nOptions:\n")
 		flag.PrintDefaults()
 	}
 
 	flag.Parse()
 
+	if *maxOpenFiles < 0 {
+		log.Fatalf("Invalid --max-open-files: %d", *maxOpenFiles)
+	}
+	utils.SetMaxOpenFiles(*maxOpenFiles)
+	if *maxMemory != "" {
+		limit, err := utils.ParseSize(*maxMemory)
+		if err != nil || limit <= 0 {
+			log.Fatalf("Invalid --max-memory: %s", *maxMemory)
+		}
+		utils.SetMemoryLimit(limit)
+	}
+
+	var outputs []io.Writer
+	if *logFile != "" {
+		maxSize, err := utils.ParseSize(*logMaxSize)
+		if err != nil {
+			log.Fatalf("Invalid log size: %v", err)
+		}
+		rotating, err := utils.NewRotatingFile(*logFile, maxSize, *logRotate, *logMaxBackups, *logMaxAge)
+		if err != nil {
+			log.Fatalf("Failed to open log file: %v", err)
+		}
+		defer rotating.Close()
+		outputs = append(outputs, rotating)
+	}
+	if *eventLog {
+		events, err := utils.OpenEventLog("gorsync")
+		if err != nil {
+			log.Fatalf("Failed to open event log: %v", err)
+		}
+		defer events.Close()
+		outputs = append(outputs, events)
+	}
+	// 服务没有控制台时直接写标准输出可能丢失甚至阻塞，全部输出转到日志文件或事件日志
+	if len(outputs) > 0 {
+		flush, err := utils.RedirectOutput(io.MultiWriter(outputs...))
+		if err != nil {
+			log.Fatalf("Failed to redirect output: %v", err)
+		}
+		defer flush()
+	}
+
+	if *debugPprof != "" {
+		addr, err := utils.StartDebugServer(*debugPprof)
+		if err != nil {
+			log.Fatalf("Failed to start pprof server: %v", err)
+		}
+		fmt.Printf("pprof available at http://%s/debug/pprof/\n", addr)
+	}
+	stopProfiles, err := utils.StartProfiles(*cpuProfile, *heapProfile)
+	if err != nil {
+		log.Fatalf("Failed to start profiling: %v", err)
+	}
+	defer stopProfiles()
+
+	net.HandlePauseSignal()
+
 	var syncer *sync.Syncer
 
 	var listenFlag bool
@@ -55,10 +303,141 @@ func main() {
 		fmt.Printf("Starting listener on port %d\n", port)
 
 		server := net.NewServer("", port)
-		if err := server.Start(); err != nil {
+		if *integrityKey != "" {
+			server.SetIntegrityKey(*integrityKey)
+		}
+		if *snapshotCmd != "" {
+			server.SetSnapshotHooks(*snapshotCmd, *snapshotReleaseCmd)
+		}
+		if *postReceiveCmd != "" {
+			server.SetPostReceiveCmd(*postReceiveCmd)
+		}
+		server.SetMaxTransfers(*maxTransfers)
+		if *encodeWorkers < 0 {
+			log.Fatalf("Invalid --encode-workers: %d", *encodeWorkers)
+		}
+		server.SetEncodeWorkers(*encodeWorkers)
+		if *portRange < 0 {
+			log.Fatalf("Invalid --port-range: %d", *portRange)
+		}
+		server.SetPortRange(*portRange)
+		server.SetFind(!*noFind)
+		if *seedManifest != "" {
+			hashes, err := sync.LoadSeedHashes(*seedManifest)
+			if err != nil {
+				log.Fatalf("Failed to load seed manifest: %v", err)
+			}
+			server.SeedHashes(hashes)
+		}
+		server.SetControl(*controlToken, func(localPath, remote string) error {
+			return pullFromPeer(localPath, remote, server.IntegrityKey())
+		})
+		peers, err := parsePullPeers(pullPeers)
+		if err != nil {
+			log.Fatalf("Invalid --peer: %v", err)
+		}
+		if err := server.SetPeers(peers); err != nil {
+			log.Fatalf("Invalid --peer: %v", err)
+		}
+		if *config != "" {
+			base := net.ServerConfig{
+				ControlToken:       *controlToken,
+				IntegrityKey:       *integrityKey,
+				SnapshotCmd:        *snapshotCmd,
+				SnapshotReleaseCmd: *snapshotReleaseCmd,
+				PostReceiveCmd:     *postReceiveCmd,
+				MaxTransfers:       *maxTransfers,
+				Peers:              peers,
+			}
+			if err := server.SetConfigFile(*config, base); err != nil {
+				log.Fatalf("Failed to load config: %v", err)
+			}
+			net.HandleReloadSignal(server)
+		}
+		if *pidFile != "" {
+			if err := utils.CheckPIDFile(*pidFile); err != nil {
+				log.Fatalf("Failed to start server: %v", err)
+			}
+			defer utils.RemovePIDFile(*pidFile)
+		}
+
+		// 开始监听后写入 PID 文件并通知 systemd（Type=notify）
+		server.SetReadyHandler(func() {
+			if *pidFile != "" {
+				if err := utils.WritePIDFile(*pidFile); err != nil {
+					fmt.Printf("Failed to write pid file: %v\n", err)
+				}
+			}
+			if err := utils.SdNotify("READY=1"); err != nil {
+				fmt.Printf("Failed to notify service manager: %v\n", err)
+			}
+			// Windows 上通过命名管道向本机的 gorsync status 报告状态
+			if pipe, err := server.ServeStatusPipe(); err != nil {
+				fmt.Printf("Failed to serve status: %v\n", err)
+			} else if pipe != "" {
+				fmt.Printf("Status available on %s\n", pipe)
+			}
+		})
+
+		// 收到终止信号时停止监听，以便清理 PID 文件
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-stop
+			utils.SdNotify("STOPPING=1")
+			server.Stop()
+		}()
+
+		start := server.Start
+		if *serviceName != "" {
+			start = func() error {
+				return utils.RunService(*serviceName, server.Start, func() { server.Stop() })
+			}
+		}
+		if err := start(); err != nil {
+			if *pidFile != "" {
+				utils.RemovePIDFile(*pidFile)
+			}
 			log.Fatalf("Failed to start server: %v", err)
 		}
 
+		return
+	} else if *reloadOn != "" {
+		if *controlToken == "" {
+			flag.Usage()
+			os.Exit(1)
+		}
+
+		host, port, err := parseHostAddr(*reloadOn)
+		if err != nil {
+			log.Fatalf("Invalid server address: %v", err)
+		}
+
+		client := net.NewClient(host, port)
+		if err := client.RequestReload(*controlToken); err != nil {
+			log.Fatalf("Reload failed: %v", err)
+		}
+
+		fmt.Println("Config reloaded successfully!")
+		return
+	} else if *pullOn != "" {
+		if *path == "" || *remote == "" || *controlToken == "" {
+			flag.Usage()
+			os.Exit(1)
+		}
+
+		host, port, err := parseHostAddr(*pullOn)
+		if err != nil {
+			log.Fatalf("Invalid server address: %v", err)
+		}
+
+		fmt.Printf("Requesting %s:%d to pull %s into %s\n", host, port, *remote, *path)
+		client := net.NewClient(host, port)
+		if err := client.RequestPull(*controlToken, *path, *remote); err != nil {
+			log.Fatalf("Pull failed: %v", err)
+		}
+
+		fmt.Println("Pull completed successfully!")
 		return
 	} else if *remote != "" {
 		if *path == "" {
@@ -74,27 +453,828 @@ func main() {
 		if _, err := os.Stat(absPath); os.IsNotExist(err) {
 			log.Fatalf("Directory does not exist: %s", absPath)
 		}
-
-		host, remotePort, remotePath, err := parseRemoteAddr(*remote)
-		if err != nil {
-			log.Fatalf("Invalid remote address: %v", err)
+		if *initMarker {
+			if err := sync.CreateMarker(absPath); err != nil {
+				log.Fatalf("Failed to create destination marker: %v", err)
+			}
+			fmt.Printf("Marked %s as a sync destination\n", absPath)
 		}
 
-		fmt.Printf("Syncing with peer %s:%d\n", host, remotePort)
-		fmt.Printf("Local path: %s\n", absPath)
-		fmt.Printf("Remote path: %s\n", remotePath)
+		var subdirs []string
+		if isHTTPSource(*remote) {
+			// 普通 HTTP(S) 服务器上的静态源，文件列表来自清单
+			subdirs = remoteSubdirs
+			fmt.Printf("Syncing from HTTP source %s\n", *remote)
+			fmt.Printf("Local path: %s\n", absPath)
+			syncer = sync.NewHTTPSyncer(absPath, *remote, *httpManifest)
+		} else {
+			host, remotePort, remotePath, err := parseRemoteAddr(*remote)
+			if err != nil {
+				log.Fatalf("Invalid remote address: %v", err)
+			}
+			remotePath, braceSubdirs := splitSubdirs(remotePath)
+			subdirs = append(braceSubdirs, remoteSubdirs...)
+
+			fmt.Printf("Syncing with peer %s:%d\n", host, remotePort)
+			fmt.Printf("Local path: %s\n", absPath)
+			fmt.Printf("Remote path: %s\n", remotePath)
+			syncer = sync.NewPeerSyncer(absPath, host, remotePath, remotePort)
+		}
+		if len(subdirs) > 0 {
+			fmt.Printf("Remote subdirectories: %s\n", strings.Join(subdirs, ", "))
+		}
 		fmt.Printf("Sync mode: remote-first\n")
-		syncer = sync.NewPeerSyncer(absPath, host, remotePath, remotePort)
+
+		opts := sync.Options{
+			PruneEmptyDirs:   *pruneEmptyDirs,
+			VerifyReadback:   *verifyReadback,
+			NoVerify:         *noVerify,
+			RequireChecksum:  *requireChecksum,
+			FullVerify:       *verifyFullInterval,
+			IntegrityKey:     *integrityKey,
+			Identity:         readIdentity(*identityFile),
+			MetadataOnly:     *metadataOnly,
+			SpillDir:         *spillDir,
+			Owner:            *owner,
+			PermsSpecial:     *permsSpecial,
+			NoPerms:          *noPerms,
+			Nice:             *nice,
+			WindowsAttrs:     *winAttrs,
+			WindowsACL:       *winACL,
+			WindowsStreams:   *winStreams,
+			MacXattrs:        *macXattrs,
+			StripMacMetadata: *stripMac,
+			Subdirs:          subdirs,
+			DeleteGrace:      *deleteGrace,
+			SkipLocked:       *skipLocked,
+			ReconnectTimeout: *reconnectTimeout,
+			ModifyWindow:     time.Duration(*modifyWindow) * time.Second,
+			MinServerVersion: *minServerVersion,
+			TargetFS:         *targetFS,
+			NameMapping:      *nameMap,
+			Parallel:         *parallel,
+			ConnPool:         *connPool,
+			ConnIdle:         *connIdle,
+			Adaptive:         *adaptive,
+			Progress:         *progress,
+			ProgressEvery:    *progressInterval,
+			Quiet:            *quiet,
+			DryRun:           *dryRun,
+			Append:           *appendOnly,
+			NoDedup:          *noDedup,
+			Dict:             *dict,
+			Partial:          *partial,
+			RequireMarker:    *requireMarker,
+			Force:            *force,
+			MaxPasses:        *repeatUntilStable,
+			MaxFiles:         *maxFiles,
+		}
+		maxTransferSize, err := utils.ParseSize(*maxTransfer)
+		if err != nil {
+			log.Fatalf("Invalid max transfer size: %v", err)
+		}
+		opts.MaxTransfer = maxTransferSize
+		if *budget != "" {
+			transferBudget, err := sync.ParseTransferBudget(*budget)
+			if err != nil {
+				log.Fatalf("Invalid budget: %v", err)
+			}
+			transferBudget.Warn = *budgetWarn
+			opts.Budget = transferBudget
+			if *budgetFile != "" {
+				budgetPath, err := filepath.Abs(*budgetFile)
+				if err != nil {
+					log.Fatalf("Invalid budget file: %v", err)
+				}
+				opts.BudgetFile = budgetPath
+			}
+		}
+		if *verifySample != "" {
+			percent, err := sync.ParseVerifySample(*verifySample)
+			if err != nil {
+				log.Fatalf("Invalid --verify-sample: %v", err)
+			}
+			opts.VerifySample = percent
+		}
+		if *textMode != "" {
+			filter, err := utils.ParseTextFilter(*textMode)
+			if err != nil {
+				log.Fatalf("Invalid text mode: %v", err)
+			}
+			opts.TextMode = filter
+		}
+		for _, value := range transformRules {
+			rule, err := net.ParseTransformRule(value)
+			if err != nil {
+				log.Fatalf("Invalid transform: %v", err)
+			}
+			opts.Transforms = append(opts.Transforms, rule)
+		}
+		opts.Policies = parsePolicies(policyRules)
+		if *bwlimit != "" {
+			schedule, err := utils.ParseBandwidthSchedule(*bwlimit)
+			if err != nil {
+				log.Fatalf("Invalid bandwidth limit: %v", err)
+			}
+			opts.Bandwidth = schedule
+		}
+		if *checkpoint != "" {
+			checkpointPath, err := filepath.Abs(*checkpoint)
+			if err != nil {
+				log.Fatalf("Invalid checkpoint path: %v", err)
+			}
+			opts.Checkpoint = checkpointPath
+		}
+		if *manifest != "" {
+			manifestPath, err := filepath.Abs(*manifest)
+			if err != nil {
+				log.Fatalf("Invalid manifest path: %v", err)
+			}
+			opts.Manifest = manifestPath
+		}
+		if *history != "" {
+			historyPath, err := filepath.Abs(*history)
+			if err != nil {
+				log.Fatalf("Invalid history path: %v", err)
+			}
+			opts.History = historyPath
+		}
+		if *report != "" {
+			reportPath, err := filepath.Abs(*report)
+			if err != nil {
+				log.Fatalf("Invalid report path: %v", err)
+			}
+			opts.Report = reportPath
+		}
+		switch {
+		case *deleteDuring && *deleteDelay, *deleteDuring && *deleteAfter, *deleteDelay && *deleteAfter:
+			log.Fatalf("--delete-during, --delete-delay and --delete-after are mutually exclusive")
+		case *deleteDuring:
+			opts.DeleteMode = sync.DeleteDuring
+		case *deleteDelay:
+			opts.DeleteMode = sync.DeleteDelay
+		case *deleteAfter:
+			opts.DeleteMode = sync.DeleteAfter
+		}
+		utils.SetRenameRetries(*lockRetries)
+		if err := syncer.SetOptions(opts); err != nil {
+			log.Fatalf("Invalid options: %v", err)
+		}
 	} else {
 		flag.Usage()
 		os.Exit(1)
 	}
 
 	if err := syncer.Sync(); err != nil {
-		log.Fatalf("Sync failed: %v", err)
+		stopProfiles()
+		log.Printf("Sync failed: %v", err)
+		os.Exit(exitCode(err))
 	}
 
 	fmt.Println("Sync completed successfully!")
+
+	if len(tailPatterns) > 0 && !*dryRun {
+		// 收到终止信号时停止跟踪
+		stop := make(chan struct{})
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			close(stop)
+		}()
+
+		fmt.Printf("Following %s, press Ctrl+C to stop\n", strings.Join(tailPatterns, ", "))
+		if err := syncer.Tail(tailPatterns, *tailInterval, stop); err != nil {
+			stopProfiles()
+			log.Fatalf("Tail failed: %v", err)
+		}
+	}
+}
+
+// runScrub 重新计算本地文件的MD5并与清单比较，发现内容损坏时以非零状态退出
+func runScrub(args []string) {
+	fs := flag.NewFlagSet("scrub", flag.ExitOnError)
+	path := fs.String("path", "", "本地目录路径")
+	manifest := fs.String("manifest", "", "同步时通过 --manifest 写入的清单文件")
+	fs.Parse(args)
+
+	if *path == "" || *manifest == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	results, err := sync.Scrub(*path, *manifest)
+	if err != nil {
+		log.Fatalf("Scrub failed: %v", err)
+	}
+
+	counts := make(map[string]int)
+	for _, r := range results {
+		counts[r.Status]++
+		switch r.Status {
+		case sync.ScrubOK:
+		case sync.ScrubCorrupted, sync.ScrubError:
+			fmt.Printf("%s: %s (%s)\n", r.Status, r.Path, r.Message)
+		default:
+			fmt.Printf("%s: %s\n", r.Status, r.Path)
+		}
+	}
+
+	fmt.Printf("Scrubbed %d files: %d ok, %d modified, %d missing, %d corrupted, %d errors\n",
+		len(results), counts[sync.ScrubOK], counts[sync.ScrubModified], counts[sync.ScrubMissing],
+		counts[sync.ScrubCorrupted], counts[sync.ScrubError])
+
+	if counts[sync.ScrubCorrupted] > 0 || counts[sync.ScrubError] > 0 {
+		os.Exit(1)
+	}
+}
+
+// runManifest 离线计算目录下所有文件的MD5并写入清单，供服务器的 --seed-manifest 使用
+func runManifest(args []string) {
+	fs := flag.NewFlagSet("manifest", flag.ExitOnError)
+	path := fs.String("path", "", "要计算哈希的目录")
+	manifest := fs.String("manifest", "", "写入的清单文件")
+	fs.Parse(args)
+
+	if *path == "" || *manifest == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	count, err := sync.BuildManifest(*path, *manifest)
+	if err != nil {
+		log.Fatalf("Manifest failed: %v", err)
+	}
+	fmt.Printf("Manifest written: %s (%d files)\n", *manifest, count)
+}
+
+// runHistory 打印最近几次同步的汇总信息以及最后两次同步的差异
+func runHistory(args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	history := fs.String("history", "", "同步时通过 --history 写入的历史文件")
+	count := fs.Int("n", 10, "显示的最近同步次数")
+	fs.Parse(args)
+
+	if *history == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	runs, err := sync.LoadHistory(*history)
+	if err != nil {
+		log.Fatalf("Failed to load history: %v", err)
+	}
+	if len(runs) == 0 {
+		fmt.Println("No sync runs recorded")
+		return
+	}
+
+	shown := runs
+	if *count > 0 && len(shown) > *count {
+		shown = shown[len(shown)-*count:]
+	}
+
+	fmt.Printf("%-19s  %10s  %8s  %12s  %8s  %s\n", "START", "DURATION", "FILES", "BYTES", "DELETED", "RESULT")
+	for _, run := range shown {
+		result := "ok"
+		if run.Error != "" {
+			result = "error: " + run.Error
+			if run.Session != "" {
+				// 便于在服务器日志中找到对应的记录
+				result = fmt.Sprintf("error (session %s): %s", run.Session, run.Error)
+			}
+		} else if run.SkippedPaths > 0 || run.FilesLocked > 0 {
+			result = fmt.Sprintf("ok (%d skipped, %d locked)", run.SkippedPaths, run.FilesLocked)
+		}
+		fmt.Printf("%-19s  %10s  %8d  %12s  %8d  %s\n",
+			time.Unix(run.Start, 0).Format("2006-01-02 15:04:05"),
+			time.Duration(run.Duration)*time.Millisecond,
+			run.FilesTransferred, utils.FormatSize(run.BytesTransferred), run.FilesDeleted, result)
+	}
+
+	if len(runs) < 2 {
+		return
+	}
+
+	prev, last := runs[len(runs)-2], runs[len(runs)-1]
+	fmt.Printf("\nChanges since previous run:\n")
+	fmt.Printf("  Duration:          %s -> %s\n",
+		time.Duration(prev.Duration)*time.Millisecond, time.Duration(last.Duration)*time.Millisecond)
+	fmt.Printf("  Remote files:      %d -> %d (%+d)\n", prev.FilesTotal, last.FilesTotal, last.FilesTotal-prev.FilesTotal)
+	fmt.Printf("  Files transferred: %d -> %d (%+d)\n",
+		prev.FilesTransferred, last.FilesTransferred, last.FilesTransferred-prev.FilesTransferred)
+	fmt.Printf("  Bytes transferred: %s -> %s\n",
+		utils.FormatSize(prev.BytesTransferred), utils.FormatSize(last.BytesTransferred))
+	fmt.Printf("  Files deleted:     %d -> %d (%+d)\n", prev.FilesDeleted, last.FilesDeleted, last.FilesDeleted-prev.FilesDeleted)
+	fmt.Printf("  Skipped paths:     %d -> %d (%+d)\n", prev.SkippedPaths, last.SkippedPaths, last.SkippedPaths-prev.SkippedPaths)
+	if prev.Error != last.Error {
+		fmt.Printf("  Error:             %q -> %q\n", prev.Error, last.Error)
+	}
+}
+
+// runStat 打印服务器上单个路径的元数据，可选计算校验和
+func runStat(args []string) {
+	fs := flag.NewFlagSet("stat", flag.ExitOnError)
+	checksum := fs.String("checksum", "", "同时计算文件的校验和：md5、sha1 或 sha256")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: gorsync stat [--checksum md5|sha1|sha256] <host[:port]:path>...\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	failed := false
+	for _, remote := range fs.Args() {
+		host, port, path, err := parseRemoteAddr(remote)
+		if err != nil {
+			log.Fatalf("Invalid remote address: %v", err)
+		}
+
+		client := net.NewClient(host, port)
+		info, err := client.Stat(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", remote, err)
+			failed = true
+			continue
+		}
+
+		fmt.Printf("Path:     %s\n", info.Path)
+		kind := "file"
+		if info.IsDir {
+			kind = "directory"
+		} else if info.Type != "" {
+			kind = info.Type
+		}
+		fmt.Printf("Type:     %s\n", kind)
+		fmt.Printf("Size:     %d (%s)\n", info.Size, utils.FormatSize(info.Size))
+		fmt.Printf("Mode:     %s\n", net.FileMode(info.Mode, true))
+		fmt.Printf("Modified: %s\n", time.Unix(info.ModTime, 0).Format("2006-01-02 15:04:05"))
+		if info.Owner != nil {
+			fmt.Printf("Owner:    %d:%d\n", info.Owner.Uid, info.Owner.Gid)
+		}
+		if info.Windows != nil {
+			fmt.Printf("Attrs:    %#x\n", info.Windows.Attributes)
+			for _, stream := range info.Windows.Streams {
+				fmt.Printf("Stream:   %s (%s)\n", stream.Name, utils.FormatSize(stream.Size))
+			}
+		}
+		for _, xattr := range info.Xattrs {
+			fmt.Printf("Xattr:    %s (%s)\n", xattr.Name, utils.FormatSize(xattr.Size))
+		}
+
+		if *checksum != "" && !info.IsDir {
+			sum, err := client.Checksum(path, *checksum)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", remote, err)
+				failed = true
+				continue
+			}
+			fmt.Printf("%-9s %s\n", strings.ToUpper(*checksum)+":", sum)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// runRemoteCommand 在服务器上删除路径或创建目录
+func runRemoteCommand(command string, args []string) {
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	controlToken := fs.String("control-token", "", "服务器的控制请求令牌")
+	mode := fs.String("mode", "0755", "mkdir 创建的目录权限（八进制）")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: gorsync %s --control-token <token> <host[:port]:path>...\n", command)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *controlToken == "" || fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	perm, err := strconv.ParseUint(*mode, 8, 32)
+	if err != nil || perm > 0777 {
+		log.Fatalf("Invalid mode: %s", *mode)
+	}
+
+	for _, remote := range fs.Args() {
+		host, port, path, err := parseRemoteAddr(remote)
+		if err != nil {
+			log.Fatalf("Invalid remote address: %v", err)
+		}
+
+		client := net.NewClient(host, port)
+		if command == "rm" {
+			err = client.RequestDelete(*controlToken, path)
+		} else {
+			err = client.RequestMkdir(*controlToken, path, os.FileMode(perm))
+		}
+		if err != nil {
+			log.Fatalf("%s %s failed: %v", command, remote, err)
+		}
+		fmt.Printf("%s %s: ok\n", command, remote)
+	}
+}
+
+// runPush 把本地目录增量上传到服务器
+func runPush(args []string) {
+	fs := flag.NewFlagSet("push", flag.ExitOnError)
+	path := fs.String("path", "", "要上传的本地目录")
+	controlToken := fs.String("control-token", "", "服务器的控制请求令牌")
+	identityFile := fs.String("identity-file", "", "包含客户端身份令牌的文件，可代替 --control-token，上传到服务器为该客户端映射的目录")
+	bwlimit := fs.String("bwlimit", "", "上传带宽限制，例如 10MB")
+	atomic := fs.Bool("atomic", false, "所有文件先暂存在服务器上，全部上传成功后才一起替换，中途失败或断开时服务器上的文件保持不变")
+	stripMac := fs.Bool("strip-mac-metadata", false, "不上传 macOS 的 AppleDouble（._name）和 .DS_Store 文件")
+	minServerVersion := fs.Int("min-server-version", 0, "服务器的协议版本低于此值或无法报告版本时立即失败")
+	connPool := fs.Int("conn-pool", net.DefaultPoolSize, "签名请求复用的空闲连接数，0 表示每个请求新建连接")
+	var policyRules stringList
+	fs.Var(&policyRules, "policy", "按文件名选择的传输策略，push 使用其中的 delta、nodelta 和 block=<size>，可重复指定")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: gorsync push --control-token <token> --path <local> [--atomic] <host[:port]:path>\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	token := *controlToken
+	if token == "" {
+		token = readIdentity(*identityFile)
+	}
+	if token == "" || *path == "" || fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	absPath, err := filepath.Abs(*path)
+	if err != nil {
+		log.Fatalf("Invalid path: %v", err)
+	}
+	host, port, remotePath, err := parseRemoteAddr(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("Invalid remote address: %v", err)
+	}
+
+	syncer := sync.NewPeerSyncer(absPath, host, remotePath, port)
+	opts := sync.Options{StripMacMetadata: *stripMac, MinServerVersion: *minServerVersion, Policies: parsePolicies(policyRules), ConnPool: *connPool}
+	if *bwlimit != "" {
+		schedule, err := utils.ParseBandwidthSchedule(*bwlimit)
+		if err != nil {
+			log.Fatalf("Invalid bandwidth limit: %v", err)
+		}
+		opts.Bandwidth = schedule
+	}
+	if err := syncer.SetOptions(opts); err != nil {
+		log.Fatalf("Invalid options: %v", err)
+	}
+	if err := syncer.Push(token, *atomic); err != nil {
+		log.Printf("Push failed: %v", err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// parsePolicies 解析 --policy 规则，格式错误时退出
+func parsePolicies(values []string) []net.TransferPolicy {
+	var policies []net.TransferPolicy
+	for _, value := range values {
+		policy, err := net.ParseTransferPolicy(value)
+		if err != nil {
+			log.Fatalf("Invalid policy: %v", err)
+		}
+		policies = append(policies, policy)
+	}
+	return policies
+}
+
+// readIdentity 读取身份令牌文件，path 为空时返回空字符串
+func readIdentity(path string) string {
+	if path == "" {
+		return ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Failed to read identity file: %v", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		log.Fatalf("Identity file is empty: %s", path)
+	}
+	return token
+}
+
+// runComplete 补全远程路径：列出服务器上与 host[:port]:path 的最后一级前缀匹配的子目录，每行一个完整的远程地址，
+// 路径为空时列出服务器的顶层目录，供 shell 的补全函数调用
+func runComplete(args []string) {
+	fs := flag.NewFlagSet("complete", flag.ExitOnError)
+	identityFile := fs.String("identity-file", "", "包含客户端身份令牌的文件，列出该客户端映射的目录")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: gorsync complete [--identity-file <file>] <host[:port]:[path]>\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || !strings.Contains(fs.Arg(0), ":") {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	remote := fs.Arg(0)
+	colon := strings.LastIndex(remote, ":")
+	addr, partial := remote[:colon], remote[colon+1:]
+	host, port, err := parseHostAddr(addr)
+	if err != nil {
+		log.Fatalf("Invalid address: %v", err)
+	}
+	// 路径为空时列出顶层目录，没有 / 的相对路径与同步时一样相对于服务器的当前目录（或根目录）
+	dir, prefix, browse := "", partial, "."
+	if partial == "" {
+		dir, browse = "/", "/"
+	} else if slash := strings.LastIndex(partial, "/"); slash >= 0 {
+		dir, prefix = partial[:slash+1], partial[slash+1:]
+		browse = dir
+	}
+
+	client := net.NewClient(host, port)
+	client.SetIdentity(readIdentity(*identityFile))
+	dirs, err := client.Browse(browse)
+	if err != nil {
+		// 补全时不输出错误，避免打乱正在编辑的命令行
+		os.Exit(1)
+	}
+	for _, name := range dirs {
+		if strings.HasPrefix(name, prefix) {
+			fmt.Printf("%s:%s%s/\n", addr, dir, name)
+		}
+	}
+}
+
+// runProbe 打印服务器的协议版本和支持的特性
+func runProbe(args []string) {
+	fs := flag.NewFlagSet("probe", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: gorsync probe <host[:port]>\n")
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	host, port, err := parseHostAddr(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("Invalid address: %v", err)
+	}
+
+	info, err := net.NewClient(host, port).Probe()
+	if err != nil {
+		// 不认识 probe 请求的旧版本服务器会返回未知请求类型的错误
+		log.Fatalf("Probe failed: %v", err)
+	}
+
+	if info.Version == net.ProtocolVersion {
+		fmt.Printf("Protocol version:   %d\n", info.Version)
+	} else {
+		fmt.Printf("Protocol version:   %d (incompatible, this client speaks %d)\n", info.Version, net.ProtocolVersion)
+	}
+	fmt.Printf("Requests:           %s\n", strings.Join(info.Requests, ", "))
+	fmt.Printf("Capabilities:       %s\n", strings.Join(info.Capabilities, ", "))
+	fmt.Printf("Transforms:         %s\n", strings.Join(info.Transforms, ", "))
+	fmt.Printf("Checksums:          %s\n", strings.Join(info.Checksums, ", "))
+	fmt.Printf("Control requests:   %s\n", enabledString(info.Control))
+	fmt.Printf("Server-side pull:   %s\n", enabledString(info.Pull))
+	fmt.Printf("Integrity mode:     %s\n", enabledString(info.Integrity))
+	fmt.Printf("Snapshots:          %s\n", enabledString(info.Snapshots))
+	fmt.Printf("Hash cache entries: %d\n", info.HashCacheEntries)
+	fmt.Printf("Listing cache TTL:  %s\n", time.Duration(info.ListingCacheTTL)*time.Millisecond)
+	if info.MaxTransfers > 0 {
+		fmt.Printf("Max transfers:      %d\n", info.MaxTransfers)
+	} else {
+		fmt.Printf("Max transfers:      unlimited\n")
+	}
+}
+
+// runAdmin 查看和管理正在运行的服务器，不需要重启服务器或查看日志
+func runAdmin(args []string) {
+	fs := flag.NewFlagSet("admin", flag.ExitOnError)
+	controlToken := fs.String("control-token", "", "服务器的控制请求令牌")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: gorsync admin --control-token <token> <host[:port]> <command>\n")
+		fmt.Fprintf(os.Stderr, "Commands:\n")
+		fmt.Fprintf(os.Stderr, "  status       show the server's state and load\n")
+		fmt.Fprintf(os.Stderr, "  connections  list the connections being served\n")
+		fmt.Fprintf(os.Stderr, "  transfers    list the connections listing or transferring files\n")
+		fmt.Fprintf(os.Stderr, "  kick <id>    close a connection\n")
+		fmt.Fprintf(os.Stderr, "  reload       re-read the server's config file\n")
+		fmt.Fprintf(os.Stderr, "  stats        show totals since the server started\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *controlToken == "" || fs.NArg() < 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	host, port, err := parseHostAddr(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("Invalid address: %v", err)
+	}
+	client := net.NewClient(host, port)
+
+	command := fs.Arg(1)
+	if command == "kick" && fs.NArg() != 3 || command != "kick" && fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	switch command {
+	case "status":
+		status, err := client.ServerStatus(*controlToken)
+		if err != nil {
+			log.Fatalf("Status failed: %v", err)
+		}
+		printServerStatus(status)
+	case "connections", "transfers":
+		conns, err := client.Connections(*controlToken)
+		if err != nil {
+			log.Fatalf("Listing connections failed: %v", err)
+		}
+		printConnections(conns, command == "transfers")
+	case "kick":
+		id, err := strconv.ParseUint(fs.Arg(2), 10, 64)
+		if err != nil {
+			log.Fatalf("Invalid connection ID: %s", fs.Arg(2))
+		}
+		if err := client.Kick(*controlToken, id); err != nil {
+			log.Fatalf("Kick failed: %v", err)
+		}
+		fmt.Printf("Connection %d closed\n", id)
+	case "reload":
+		if err := client.RequestReload(*controlToken); err != nil {
+			log.Fatalf("Reload failed: %v", err)
+		}
+		fmt.Println("Config reloaded successfully!")
+	case "stats":
+		stats, err := client.ServerStats(*controlToken)
+		if err != nil {
+			log.Fatalf("Stats failed: %v", err)
+		}
+		fmt.Printf("Connections: %d\n", stats.Connections)
+		fmt.Printf("Errors:      %d\n", stats.Errors)
+		fmt.Printf("Kicked:      %d\n", stats.Kicked)
+		fmt.Printf("Sent:        %s\n", utils.FormatSize(stats.Sent))
+		fmt.Printf("Received:    %s\n", utils.FormatSize(stats.Received))
+		fmt.Printf("Requests:\n")
+		for _, t := range slices.Sorted(maps.Keys(stats.Requests)) {
+			fmt.Printf("  %-10s %d\n", t, stats.Requests[t])
+		}
+	default:
+		fs.Usage()
+		os.Exit(1)
+	}
+}
+
+// runStatus 通过本机的状态管道查看服务器的状态和正在处理的连接，不需要控制令牌，
+// 用于查看作为 Windows 服务运行、没有控制台输出的服务器
+func runStatus(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	port := fs.Int("port", 8730, "服务器的监听端口")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: gorsync status [--port <port>]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	local, err := net.ReadStatusPipe(*port)
+	if err != nil {
+		log.Fatalf("Status failed: %v", err)
+	}
+	fmt.Printf("Server process:    %d (port %d)\n", local.PID, local.Port)
+	printServerStatus(local.Status)
+	if len(local.Connections) > 0 {
+		fmt.Println()
+		printConnections(local.Connections, false)
+	}
+}
+
+// printServerStatus 打印 admin status 和 status 命令返回的服务器概况
+func printServerStatus(status *net.ServerStatus) {
+	fmt.Printf("Protocol version:  %d\n", status.Version)
+	fmt.Printf("Uptime:            %s\n", time.Duration(status.Uptime)*time.Second)
+	if status.Root != "" {
+		fmt.Printf("Root:              %s\n", status.Root)
+	}
+	if status.Config != "" {
+		fmt.Printf("Config file:       %s\n", status.Config)
+	}
+	fmt.Printf("Connections:       %d\n", status.Connections)
+	fmt.Printf("Queued transfers:  %d\n", status.Queued)
+	if status.MaxTransfers > 0 {
+		fmt.Printf("Max transfers:     %d\n", status.MaxTransfers)
+	} else {
+		fmt.Printf("Max transfers:     unlimited\n")
+	}
+	fmt.Printf("Open pushes:       %d\n", status.Transactions)
+	fmt.Printf("Client identities: %d\n", status.Clients)
+	if status.MaxOpenFiles > 0 {
+		fmt.Printf("Open files:        %d of %d\n", status.OpenFiles, status.MaxOpenFiles)
+	} else {
+		fmt.Printf("Open files:        %d (unlimited)\n", status.OpenFiles)
+	}
+	if status.MaxBufferMemory > 0 {
+		fmt.Printf("Buffer memory:     %s of %s\n", utils.FormatSize(status.BufferMemory), utils.FormatSize(status.MaxBufferMemory))
+	} else {
+		fmt.Printf("Buffer memory:     %s (unlimited)\n", utils.FormatSize(status.BufferMemory))
+	}
+	fmt.Printf("Snapshots:         %s\n", enabledString(status.Snapshots))
+}
+
+// printConnections 打印服务器上的连接，transfers 为 true 时只打印占用传输名额的请求
+func printConnections(conns []net.ConnectionInfo, transfers bool) {
+	fmt.Printf("%-6s %-21s %-8s %-9s %-10s %-10s %-12s %s\n", "ID", "REMOTE", "AGE", "TYPE", "SENT", "RECEIVED", "CLIENT", "PATH")
+	for _, c := range conns {
+		if transfers && c.Type != "list" && c.Type != "file" && c.Type != "upload" {
+			continue
+		}
+		state := c.Type
+		if c.Queued {
+			state += "*"
+		}
+		client := c.Client
+		if client == "" {
+			client = c.Session
+		}
+		age := time.Since(time.Unix(c.Started, 0)).Truncate(time.Second)
+		fmt.Printf("%-6d %-21s %-8s %-9s %-10s %-10s %-12s %s\n", c.ID, c.Remote, age, state,
+			utils.FormatSize(c.Sent), utils.FormatSize(c.Received), client, c.Path)
+	}
+}
+
+// runPing 检查服务器是否在线，--healthcheck 时不输出，只通过退出码表示结果
+func runPing(args []string) {
+	fs := flag.NewFlagSet("ping", flag.ExitOnError)
+	healthcheck := fs.Bool("healthcheck", false, "不输出任何内容，服务器正常时退出码为 0，否则为 1")
+	timeout := fs.Duration("timeout", 5*time.Second, "连接和等待响应的超时时间")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: gorsync ping [--healthcheck] [--timeout <duration>] <host[:port]>\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		if *healthcheck {
+			os.Exit(1)
+		}
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	host, port, err := parseHostAddr(fs.Arg(0))
+	if err != nil {
+		if *healthcheck {
+			os.Exit(1)
+		}
+		log.Fatalf("Invalid address: %v", err)
+	}
+
+	start := time.Now()
+	health, err := net.NewClient(host, port).Ping(*timeout)
+	if *healthcheck {
+		if err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+	if err != nil {
+		log.Fatalf("Ping failed: %v", err)
+	}
+
+	rtt := time.Since(start)
+	fmt.Printf("%s: protocol version %d, up %s, %d active connection(s), time %s\n",
+		fs.Arg(0), health.Version, time.Duration(health.Uptime)*time.Second,
+		health.Connections, rtt.Round(time.Microsecond))
+	if health.Time != 0 {
+		skew := time.UnixMilli(health.Time).Sub(start.Add(rtt / 2))
+		fmt.Printf("Clock skew: %s (server minus local)\n", skew.Round(time.Millisecond))
+	}
+}
+
+// enabledString 把开关状态转换为显示用的文字
+func enabledString(enabled bool) string {
+	if enabled {
+		return "enabled"
+	}
+	return "disabled"
 }
 
 func parseRemoteAddr(remote string) (host string, port int, path string, err error) {
@@ -125,79 +1305,121 @@ func parseRemoteAddr(remote string) (host string, port int, path string, err err
 	return
 }
 
-// 全局变量，用于存储服务器实例
-var (
-	serverInstance *net.Server
-	serverMutex    = &stdsync.Mutex{}
-)
+// splitSubdirs 展开路径末尾的 {a,b} 形式，返回父路径和子目录列表
+func splitSubdirs(path string) (string, []string) {
+	if !strings.HasSuffix(path, "}") {
+		return path, nil
+	}
+	open := strings.LastIndex(path, "{")
+	if open < 0 {
+		return path, nil
+	}
 
-// StartServer 启动服务
-//
-//export StartServer
-func StartServer() C.int {
-	// 检查是否已经有服务器实例在运行
-	serverMutex.Lock()
-	defer serverMutex.Unlock()
+	var subdirs []string
+	for _, subdir := range strings.Split(path[open+1:len(path)-1], ",") {
+		if subdir = strings.TrimSpace(subdir); subdir != "" {
+			subdirs = append(subdirs, subdir)
+		}
+	}
 
-	if serverInstance != nil {
-		fmt.Printf("Server already running\n")
-		return 1 // 失败，服务器已在运行
+	base := path[:open]
+	if base == "" {
+		base = "."
 	}
+	return base, subdirs
+}
 
-	// 使用默认的当前目录和 8730 端口
-	rootDir := "."
-	port := 8730
+// stringList 可重复指定的字符串参数
+type stringList []string
 
-	// 创建并启动服务器
-	serverInstance = net.NewServer(rootDir, port)
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
 
-	// 在后台启动服务器
-	go func() {
-		if err := serverInstance.Start(); err != nil {
-			fmt.Printf("Failed to start server: %v\n", err)
-			// 清理服务器实例
-			serverMutex.Lock()
-			serverInstance = nil
-			serverMutex.Unlock()
-		}
-	}()
-	return 0 // 成功
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
 }
 
-// SyncFiles 同步文件
-//
-//export SyncFiles
-func SyncFiles(localPath *C.char, remotePath *C.char) C.int {
-	// 将 C 字符串转换为 Go 字符串
-	goLocalPath := C.GoString(localPath)
-	goRemotePath := C.GoString(remotePath)
-	host, port, path, err := parseRemoteAddr(goRemotePath)
+func parseHostAddr(addr string) (host string, port int, err error) {
+	parts := strings.Split(addr, ":")
+	if len(parts) > 2 || parts[0] == "" {
+		err = fmt.Errorf("invalid address format, expected host[:port]")
+		return
+	}
 
-	if err != nil {
-		fmt.Printf("Invalid remote address: %v", err)
-		return 1 // 失败
+	host = parts[0]
+	port = 8730
+	if len(parts) == 2 {
+		if _, pErr := fmt.Sscanf(parts[1], "%d", &port); pErr != nil {
+			err = fmt.Errorf("invalid port: %s", parts[1])
+		}
 	}
 
-	// 创建同步器并执行同步操作
-	syncer := sync.NewPeerSyncer(goLocalPath, host, path, port)
+	return
+}
 
-	if err := syncer.Sync(); err != nil {
-		fmt.Printf("Sync failed: %v\n", err)
-		return 1 // 失败
+// 同步或推送失败时的退出状态，脚本可以据此区分失败的类别
+const (
+	exitFailed   = 1  // 其他错误
+	exitConnect  = 10 // 无法连接到服务器或连接断开
+	exitAuth     = 11 // 服务器拒绝了令牌或客户端身份
+	exitNotFound = 12 // 远程路径不存在
+	exitChecksum = 13 // 下载的内容校验失败或无法校验
+	exitNoSpace  = 14 // 服务器磁盘空间不足，推送的文件没有写入
+	exitPartial  = 23 // 同步完成，但有路径被跳过，与 rsync 相同
+)
+
+// exitCode 按错误的类别返回退出状态
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, sync.ErrPartial):
+		return exitPartial
+	case errors.Is(err, net.ErrAuth):
+		return exitAuth
+	case errors.Is(err, net.ErrNotFound):
+		return exitNotFound
+	case errors.Is(err, net.ErrChecksumMismatch), errors.Is(err, net.ErrNoChecksum):
+		return exitChecksum
+	case errors.Is(err, net.ErrNoSpace):
+		return exitNoSpace
+	case errors.Is(err, net.ErrConnect), errors.Is(err, net.ErrConnectionLost), errors.Is(err, net.ErrPeerUnreachable):
+		return exitConnect
 	}
+	return exitFailed
+}
 
-	return 0 // 成功
+// isHTTPSource 判断远程地址是否为 HTTP(S) 静态源的URL
+func isHTTPSource(remote string) bool {
+	return strings.HasPrefix(remote, "http://") || strings.HasPrefix(remote, "https://")
 }
 
-// StopServer 停止所有服务器
-//
-//export StopServer
-func StopServer() C.int {
-	// 清理服务器实例
-	serverMutex.Lock()
-	serverInstance.Stop()
-	serverInstance = nil
-	serverMutex.Unlock()
+// parsePullPeers 解析 --peer 参数，对端以其地址命名
+func parsePullPeers(values []string) ([]net.PullPeer, error) {
+	var peers []net.PullPeer
+	for _, value := range values {
+		remote, localPath, ok := strings.Cut(value, "=")
+		if !ok || localPath == "" {
+			return nil, fmt.Errorf("%q, expected host[:port]:path=<local dir>", value)
+		}
+		if _, _, _, err := parseRemoteAddr(remote); err != nil {
+			return nil, fmt.Errorf("%q: %v", value, err)
+		}
+		peers = append(peers, net.PullPeer{Name: remote, Remote: remote, Path: localPath})
+	}
+	return peers, nil
+}
 
-	return 0 // 成功
+// pullFromPeer 服务器收到控制请求后，从 remote 拉取文件到 localPath
+func pullFromPeer(localPath, remote, integrityKey string) error {
+	host, port, path, err := parseRemoteAddr(remote)
+	if err != nil {
+		return fmt.Errorf("invalid remote address: %v", err)
+	}
+
+	syncer := sync.NewPeerSyncer(localPath, host, path, port)
+	if err := syncer.SetOptions(sync.Options{IntegrityKey: integrityKey}); err != nil {
+		return err
+	}
+	return syncer.Sync()
 }
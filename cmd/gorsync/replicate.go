@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"gorsync/pkg/net"
+	"gorsync/pkg/sync"
+)
+
+// --relay 的取值
+const (
+	relayAuto   = "auto"   // 目标服务器不能拉取或连接不到源服务器时经本机中转
+	relayNever  = "never"  // 只让目标服务器直接拉取
+	relayAlways = "always" // 总是经本机中转
+)
+
+// runReplicate 在两台服务器之间复制目录：优先让目标服务器直接从源服务器拉取，数据不经过本机；
+// 目标服务器不支持拉取或连接不到源服务器时，由本机从源服务器同步到暂存目录后再推送到目标服务器
+func runReplicate(args []string) {
+	fs := flag.NewFlagSet("replicate", flag.ExitOnError)
+	controlToken := fs.String("control-token", "", "目标服务器的控制请求令牌，用于请求拉取或推送")
+	relay := fs.String("relay", relayAuto, "目标服务器无法直接拉取时的处理：auto 经本机中转，never 直接失败，always 总是经本机中转")
+	staging := fs.String("staging", "", "经本机中转时暂存文件的本地目录，保留下来时下次中转只下载变化的文件；为空时使用临时目录，结束后删除")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: gorsync replicate --control-token <token> [--relay auto|never|always] [--staging <dir>] <source host[:port]:path> <dest host[:port]:path>\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *controlToken == "" || fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	switch *relay {
+	case relayAuto, relayNever, relayAlways:
+	default:
+		log.Fatalf("Invalid relay mode: %s, expected auto, never or always", *relay)
+	}
+	source, dest := fs.Arg(0), fs.Arg(1)
+	if _, _, _, err := parseRemoteAddr(source); err != nil {
+		log.Fatalf("Invalid source address: %v", err)
+	}
+	host, port, destPath, err := parseRemoteAddr(dest)
+	if err != nil {
+		log.Fatalf("Invalid destination address: %v", err)
+	}
+
+	if *relay != relayAlways {
+		err := replicateDirect(host, port, destPath, source, *controlToken)
+		if err == nil {
+			fmt.Println("Replication completed successfully!")
+			return
+		}
+		if *relay == relayNever || !errors.Is(err, errNoDirectPull) && !errors.Is(err, net.ErrPeerUnreachable) {
+			log.Printf("Replication failed: %v", err)
+			os.Exit(exitCode(err))
+		}
+		fmt.Printf("Direct pull not possible (%v), relaying through this host\n", err)
+	}
+
+	if err := replicateRelay(source, dest, *staging, *controlToken); err != nil {
+		log.Printf("Replication failed: %v", err)
+		os.Exit(exitCode(err))
+	}
+	fmt.Println("Replication completed successfully (relayed)!")
+}
+
+// errNoDirectPull 目标服务器没有启用拉取（没有控制令牌或禁用了拉取）
+var errNoDirectPull = errors.New("destination server does not accept pull requests")
+
+// replicateDirect 请求目标服务器从 source 拉取到 destPath，拉取完成后才返回
+func replicateDirect(host string, port int, destPath, source, token string) error {
+	client := net.NewClient(host, port)
+	info, err := client.Probe()
+	if err != nil {
+		return err
+	}
+	if !info.Pull {
+		return errNoDirectPull
+	}
+	fmt.Printf("Requesting %s:%d to pull %s into %s\n", host, port, source, destPath)
+	return client.RequestPull(token, destPath, source)
+}
+
+// replicateRelay 经本机中转：先把 source 同步到暂存目录，再把暂存目录推送到 dest
+func replicateRelay(source, dest, staging, token string) error {
+	if staging == "" {
+		dir, err := os.MkdirTemp("", "gorsync-relay-")
+		if err != nil {
+			return fmt.Errorf("failed to create staging directory: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		staging = dir
+	} else {
+		dir, err := filepath.Abs(staging)
+		if err != nil {
+			return fmt.Errorf("invalid staging directory: %v", err)
+		}
+		staging = dir
+	}
+
+	host, port, path, _ := parseRemoteAddr(source)
+	fmt.Printf("Relaying %s through %s\n", source, staging)
+	puller := sync.NewPeerSyncer(staging, host, path, port)
+	if err := puller.SetOptions(sync.Options{ConnPool: net.DefaultPoolSize}); err != nil {
+		return err
+	}
+	if err := puller.Sync(); err != nil {
+		return err
+	}
+
+	host, port, path, _ = parseRemoteAddr(dest)
+	pusher := sync.NewPeerSyncer(staging, host, path, port)
+	if err := pusher.SetOptions(sync.Options{ConnPool: net.DefaultPoolSize}); err != nil {
+		return err
+	}
+	return pusher.Push(token, false)
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gorsync/pkg/net"
+	"gorsync/pkg/sync"
+	"gorsync/pkg/utils"
+)
+
+// selftestFile 自检用的测试目录中的一个文件，size 为 0 的文件内容为空
+type selftestFile struct {
+	path string
+	size int
+	text bool
+}
+
+// selftestFixture 自检用的测试目录：空文件、文本、多级目录、特殊字符的文件名、
+// 跨多个数据块的大文件和大量小文件
+var selftestFixture = []selftestFile{
+	{path: "README.txt", size: 2048, text: true},
+	{path: "empty", size: 0},
+	{path: "big.bin", size: 3 << 20},
+	{path: "dir/sub/deep.bin", size: 200 << 10},
+	{path: "name with spaces.txt", size: 100, text: true},
+	{path: "ünïcode-名前.txt", size: 100, text: true},
+}
+
+// selftestSmallFiles many 目录中小文件的数量
+const selftestSmallFiles = 50
+
+// runSelftest 在随机端口上启动临时服务器，通过完整的网络协议拉取和推送一个生成的测试目录，
+// 比较两端的MD5并报告结果，用于验证安装或新平台的构建
+func runSelftest(args []string) {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	verbose := fs.Bool("verbose", false, "显示服务器和同步过程的输出")
+	keep := fs.Bool("keep", false, "保留临时目录以便检查")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: gorsync selftest [--verbose] [--keep]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	tmp, err := os.MkdirTemp("", "gorsync-selftest-")
+	if err != nil {
+		log.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	// 自检过程的输出暂存起来，失败时才显示
+	out := os.Stdout
+	var captured bytes.Buffer
+	restore := func() {}
+	if !*verbose {
+		restore, err = captureOutput(&captured)
+		if err != nil {
+			log.Fatalf("Failed to capture output: %v", err)
+		}
+	}
+
+	start := time.Now()
+	failed := false
+	report := func(step string, err error) {
+		if err != nil {
+			failed = true
+			fmt.Fprintf(out, "FAIL  %s: %v\n", step, err)
+		} else {
+			fmt.Fprintf(out, "PASS  %s\n", step)
+		}
+	}
+
+	fmt.Fprintf(out, "gorsync selftest (protocol %d, %s)\n", net.ProtocolVersion, tmp)
+	if err := runSelftestSteps(tmp, report); err != nil {
+		report("setup", err)
+	}
+
+	restore()
+	if *keep {
+		fmt.Printf("Temporary directory kept: %s\n", tmp)
+	} else {
+		os.RemoveAll(tmp)
+	}
+	if failed {
+		if !*verbose {
+			fmt.Printf("\nOutput:\n%s", captured.String())
+		}
+		fmt.Printf("Selftest FAILED after %s\n", time.Since(start).Round(time.Millisecond))
+		os.Exit(1)
+	}
+	fmt.Printf("Selftest passed in %s\n", time.Since(start).Round(time.Millisecond))
+}
+
+// runSelftestSteps 生成测试目录，启动服务器，依次拉取和推送并校验，每一步的结果交给 report
+func runSelftestSteps(tmp string, report func(step string, err error)) error {
+	source := filepath.Join(tmp, "source")
+	pulled := filepath.Join(tmp, "pulled")
+	pushed := filepath.Join(tmp, "pushed")
+
+	files, err := writeSelftestFixture(source)
+	if err != nil {
+		return fmt.Errorf("failed to generate fixture: %v", err)
+	}
+	// 目标中预先放入内容不同的同名大文件，让拉取和推送都经过增量传输
+	for _, dir := range []string{pulled, pushed} {
+		if err := writeSelftestVariant(source, dir, "big.bin"); err != nil {
+			return fmt.Errorf("failed to prepare %s: %v", dir, err)
+		}
+	}
+	report(fmt.Sprintf("generate fixture (%d files)", files), nil)
+
+	token := utils.NewSessionID()
+	server := net.NewServer("", 0)
+	server.SetControl(token, nil)
+	ready := make(chan struct{})
+	server.SetReadyHandler(func() { close(ready) })
+	started := make(chan error, 1)
+	go func() {
+		started <- server.Start()
+	}()
+	select {
+	case <-ready:
+	case err := <-started:
+		return fmt.Errorf("failed to start server: %v", err)
+	}
+	defer func() {
+		server.Stop()
+		<-started
+	}()
+	report(fmt.Sprintf("start server on 127.0.0.1:%d", server.Port()), nil)
+
+	health, err := net.NewClient("127.0.0.1", server.Port()).Ping(5 * time.Second)
+	if err == nil && health.Version != net.ProtocolVersion {
+		err = fmt.Errorf("server reports protocol version %d, expected %d", health.Version, net.ProtocolVersion)
+	}
+	report("ping", err)
+
+	puller := sync.NewPeerSyncer(pulled, "127.0.0.1", filepath.ToSlash(source), server.Port())
+	err = puller.SetOptions(sync.Options{Quiet: true})
+	if err == nil {
+		err = puller.Sync()
+	}
+	if err == nil {
+		err = compareTrees(source, pulled, true)
+	}
+	report("pull and verify MD5s", err)
+
+	pusher := sync.NewPeerSyncer(source, "127.0.0.1", filepath.ToSlash(pushed), server.Port())
+	err = pusher.SetOptions(sync.Options{Quiet: true})
+	if err == nil {
+		err = pusher.Push(token, true)
+	}
+	if err == nil {
+		// push 只上传文件，空目录不会在服务器上创建
+		err = compareTrees(source, pushed, false)
+	}
+	report("push and verify MD5s", err)
+
+	return nil
+}
+
+// writeSelftestFixture 生成测试目录，内容由固定的种子生成，返回文件数
+func writeSelftestFixture(root string) (int, error) {
+	random := rand.New(rand.NewPCG(1, 2))
+	files := selftestFixture
+	for i := range selftestSmallFiles {
+		files = append(files, selftestFile{path: fmt.Sprintf("many/file-%03d.dat", i), size: 1 + random.IntN(8192)})
+	}
+
+	for _, f := range files {
+		path := filepath.Join(root, filepath.FromSlash(f.path))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return 0, err
+		}
+		data := make([]byte, f.size)
+		for i := range data {
+			if f.text {
+				// 每 64 个字符换一行
+				if i%64 == 63 {
+					data[i] = '\n'
+				} else {
+					data[i] = byte('a' + random.IntN(26))
+				}
+			} else {
+				data[i] = byte(random.Uint32())
+			}
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return 0, err
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(root, "emptydir"), 0755); err != nil {
+		return 0, err
+	}
+	return len(files), nil
+}
+
+// writeSelftestVariant 把源文件复制到目标目录并改动中间的一段，大小不变
+func writeSelftestVariant(source, dir, name string) error {
+	data, err := os.ReadFile(filepath.Join(source, name))
+	if err != nil {
+		return err
+	}
+	for i := len(data) / 2; i < len(data)/2+4096 && i < len(data); i++ {
+		data[i] ^= 0xff
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, name), data, 0644)
+}
+
+// compareTrees 比较两个目录中的路径、类型、大小和MD5，忽略 gorsync 自己的状态文件，dirs 为 false 时不比较目录
+func compareTrees(want, got string, dirs bool) error {
+	wantFiles, err := treeDigest(want, dirs)
+	if err != nil {
+		return err
+	}
+	gotFiles, err := treeDigest(got, dirs)
+	if err != nil {
+		return err
+	}
+
+	for path, digest := range wantFiles {
+		other, ok := gotFiles[path]
+		if !ok {
+			return fmt.Errorf("missing %s", path)
+		}
+		if other != digest {
+			return fmt.Errorf("%s differs: %s, expected %s", path, other, digest)
+		}
+	}
+	for path := range gotFiles {
+		if _, ok := wantFiles[path]; !ok {
+			return fmt.Errorf("unexpected %s", path)
+		}
+	}
+	return nil
+}
+
+// treeDigest 返回目录中每个路径的摘要：目录为 dir，文件为大小和MD5，dirs 为 false 时只包括文件
+func treeDigest(root string, dirs bool) (map[string]string, error) {
+	digests := make(map[string]string)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if strings.HasPrefix(d.Name(), ".gorsync-") {
+			return nil
+		}
+		if d.IsDir() {
+			if dirs {
+				digests[rel] = "dir"
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		sum, err := utils.CalculateMD5(path)
+		if err != nil {
+			return err
+		}
+		digests[rel] = fmt.Sprintf("%d bytes, md5 %s", info.Size(), sum)
+		return nil
+	})
+	return digests, err
+}
+
+// captureOutput 把标准输出、标准错误和 log 包的输出暂存到 w，返回的函数恢复原来的输出
+func captureOutput(w io.Writer) (func(), error) {
+	stdout, stderr, logOutput := os.Stdout, os.Stderr, log.Writer()
+	flush, err := utils.RedirectOutput(w)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		flush()
+		os.Stdout, os.Stderr = stdout, stderr
+		log.SetOutput(logOutput)
+	}, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+
+	"gorsync/pkg/utils"
+)
+
+// runService 把监听模式注册为 systemd 单元或 Windows 服务，以及卸载、启动和停止已注册的服务
+func runService(args []string) {
+	fs := flag.NewFlagSet("service", flag.ExitOnError)
+	name := fs.String("name", "gorsync", "systemd 单元名或 Windows 服务名")
+	port := fs.Int("port", 8730, "install: 服务器的监听端口")
+	config := fs.String("config", "", "install: 服务器的 JSON 配置文件")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: gorsync service <command> [--name <name>]\n")
+		fmt.Fprintf(os.Stderr, "Commands:\n")
+		fmt.Fprintf(os.Stderr, "  install [--port <port>] [--config <file>] [-- <listener flags>]\n")
+		fmt.Fprintf(os.Stderr, "             register the listener to start at boot (not started now)\n")
+		fmt.Fprintf(os.Stderr, "  uninstall  stop the service and remove it\n")
+		fmt.Fprintf(os.Stderr, "  start      start the installed service\n")
+		fmt.Fprintf(os.Stderr, "  stop       stop the running service\n")
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	command := args[0]
+	fs.Parse(args[1:])
+
+	var err error
+	switch command {
+	case "install":
+		err = installService(*name, *port, *config, fs.Args())
+	case "uninstall", "start", "stop":
+		if fs.NArg() != 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		switch command {
+		case "uninstall":
+			err = utils.UninstallService(*name)
+		case "start":
+			err = utils.StartService(*name)
+		case "stop":
+			err = utils.StopService(*name)
+		}
+	default:
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Fatalf("Service %s failed: %v", command, err)
+	}
+
+	switch command {
+	case "install":
+		fmt.Printf("Service %s installed; start it with: gorsync service start --name %s\n", *name, *name)
+	case "uninstall":
+		fmt.Printf("Service %s removed\n", *name)
+	case "start":
+		fmt.Printf("Service %s started\n", *name)
+	case "stop":
+		fmt.Printf("Service %s stopped\n", *name)
+	}
+}
+
+// installService 以当前的可执行文件和绝对路径的配置文件注册监听模式，extra 为附加的监听模式参数。
+// Windows 服务没有控制台，输出写入事件日志
+func installService(name string, port int, config string, extra []string) error {
+	if port <= 0 || port > 65535 {
+		return fmt.Errorf("invalid port: %d", port)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate gorsync: %v", err)
+	}
+
+	args := []string{"-listen", strconv.Itoa(port)}
+	if config != "" {
+		config, err = filepath.Abs(config)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(config); err != nil {
+			return fmt.Errorf("config file: %v", err)
+		}
+		args = append(args, "-config", config)
+	}
+	if runtime.GOOS == "windows" {
+		args = append(args, "-event-log", "-service-name", name)
+	}
+	args = append(args, extra...)
+
+	return utils.InstallService(utils.ServiceConfig{
+		Name:        name,
+		Description: fmt.Sprintf("gorsync server on port %d", port),
+		Executable:  executable,
+		Args:        args,
+	})
+}
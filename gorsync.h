@@ -96,6 +96,14 @@ extern int StartServer(void);
 //
 extern int SyncFiles(char* localPath, char* remotePath);
 
+// PauseSync 暂停所有传输
+//
+extern int PauseSync(void);
+
+// ResumeSync 恢复所有传输
+//
+extern int ResumeSync(void);
+
 // StopServer 停止所有服务器
 //
 extern int StopServer(void);
@@ -0,0 +1,62 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// 接受连接遇到临时错误（例如文件描述符耗尽）时的重试间隔，从 acceptMinDelay 开始每次翻倍，最长 acceptMaxDelay
+const (
+	acceptMinDelay = 5 * time.Millisecond
+	acceptMaxDelay = time.Second
+)
+
+// acceptLogInterval 连续的接受错误最多这么久打印一次，其间的错误只计数
+const acceptLogInterval = 10 * time.Second
+
+// acceptBackoff 接受连接失败后的退避和限速日志，只在 Start 的 goroutine 中使用
+type acceptBackoff struct {
+	delay      time.Duration
+	lastLog    time.Time
+	suppressed int // 上次打印后未打印的错误数
+}
+
+// wait 记录一次临时错误，必要时打印日志，然后等待退避间隔
+func (b *acceptBackoff) wait(err error) {
+	if b.delay == 0 {
+		b.delay = acceptMinDelay
+	} else {
+		b.delay = min(b.delay*2, acceptMaxDelay)
+	}
+
+	if time.Since(b.lastLog) < acceptLogInterval {
+		b.suppressed++
+	} else {
+		if b.suppressed > 0 {
+			fmt.Printf("Failed to accept connection: %v, retrying in %s (%d similar errors suppressed)\n", err, b.delay, b.suppressed)
+		} else {
+			fmt.Printf("Failed to accept connection: %v, retrying in %s\n", err, b.delay)
+		}
+		b.lastLog = time.Now()
+		b.suppressed = 0
+	}
+	time.Sleep(b.delay)
+}
+
+// reset 成功接受连接后重置退避间隔，之前被省略的错误在下次出错时一并报告
+func (b *acceptBackoff) reset() {
+	b.delay = 0
+}
+
+// isTemporaryAccept 检查接受连接的错误是否可以重试，例如文件描述符耗尽（EMFILE/ENFILE）或连接在握手时被重置
+func isTemporaryAccept(err error) bool {
+	var netErr interface{ Temporary() bool }
+	return errors.As(err, &netErr) && netErr.Temporary()
+}
+
+// isListenerClosed 检查接受连接的错误是否因为监听器已被 Stop 关闭
+func isListenerClosed(err error) bool {
+	return errors.Is(err, net.ErrClosed)
+}
@@ -0,0 +1,362 @@
+package net
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorsync/pkg/utils"
+)
+
+// admin 请求的操作
+const (
+	AdminStatus      = "status"
+	AdminConnections = "connections"
+	AdminStats       = "stats"
+	AdminKick        = "kick"
+)
+
+// ServerStatus admin status 返回的服务器概况
+type ServerStatus struct {
+	Version         int    `json:"version"`             // 协议版本
+	Uptime          int64  `json:"uptime"`              // 开始监听后经过的时间（秒）
+	Root            string `json:"root,omitempty"`      // 服务器根目录
+	Config          string `json:"config,omitempty"`    // 配置文件路径
+	Connections     int    `json:"connections"`         // 正在处理的连接数（包括本次请求）
+	Queued          int    `json:"queued"`              // 等待传输名额的请求数
+	MaxTransfers    int    `json:"maxTransfers"`        // 同时处理的列表和传输请求的上限，0 表示不限制
+	Transactions    int    `json:"transactions"`        // 未提交的推送数
+	OpenFiles       int    `json:"openFiles"`           // 占用的文件描述符名额
+	MaxOpenFiles    int    `json:"maxOpenFiles"`        // 文件描述符名额的上限，0 表示不限制
+	BufferMemory    int64  `json:"bufferMemory"`        // 正在使用的传输缓冲区的总大小（字节）
+	MaxBufferMemory int64  `json:"maxBufferMemory"`     // 传输缓冲区的上限，0 表示不限制
+	Clients         int    `json:"clients"`             // 配置的客户端身份数
+	Control         bool   `json:"control"`             // 是否接受控制请求
+	Snapshots       bool   `json:"snapshots,omitempty"` // 是否配置了快照命令
+}
+
+// ConnectionInfo admin connections 返回的一个正在处理的连接
+type ConnectionInfo struct {
+	ID       uint64 `json:"id"`
+	Remote   string `json:"remote"`
+	Started  int64  `json:"started"` // 连接建立的时间（Unix 秒）
+	Session  string `json:"session,omitempty"`
+	Client   string `json:"client,omitempty"` // 客户端身份的名称
+	Type     string `json:"type,omitempty"`   // 请求类型，还未收到请求时为空
+	Path     string `json:"path,omitempty"`
+	Priority string `json:"priority,omitempty"`
+	Queued   bool   `json:"queued,omitempty"` // 正在等待传输名额
+	Sent     int64  `json:"sent"`             // 已发送的字节数
+	Received int64  `json:"received"`         // 已接收的字节数
+}
+
+// ServerStats admin stats 返回的服务器启动以来的累计数据
+type ServerStats struct {
+	Connections int64            `json:"connections"` // 接受的连接数
+	Requests    map[string]int64 `json:"requests"`    // 各类型的请求数
+	Errors      int64            `json:"errors"`      // 返回错误的请求数
+	Kicked      int64            `json:"kicked"`      // 被管理员断开的连接数
+	Sent        int64            `json:"sent"`        // 发送的字节数
+	Received    int64            `json:"received"`    // 接收的字节数
+}
+
+// trackedConn 记录在连接表中的连接，统计收发的字节数
+type trackedConn struct {
+	net.Conn
+	table    *connTable
+	id       uint64
+	started  time.Time
+	sent     atomic.Int64
+	received atomic.Int64
+
+	// 以下字段由连接表的锁保护
+	session  string
+	client   string
+	reqType  string
+	path     string
+	priority string
+	queued   bool
+}
+
+func (c *trackedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.received.Add(int64(n))
+	c.table.received.Add(int64(n))
+	return n, err
+}
+
+func (c *trackedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.sent.Add(int64(n))
+	c.table.sent.Add(int64(n))
+	return n, err
+}
+
+// connTable 正在处理的连接和累计的统计数据
+type connTable struct {
+	mutex    sync.Mutex
+	nextID   uint64
+	conns    map[uint64]*trackedConn
+	requests map[string]int64
+
+	total    atomic.Int64
+	errors   atomic.Int64
+	kicked   atomic.Int64
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+// add 把新连接加入表中
+func (t *connTable) add(conn net.Conn) *trackedConn {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.conns == nil {
+		t.conns = make(map[uint64]*trackedConn)
+	}
+	t.nextID++
+	c := &trackedConn{Conn: conn, table: t, id: t.nextID, started: time.Now()}
+	t.conns[c.id] = c
+	t.total.Add(1)
+	return c
+}
+
+// remove 连接结束时从表中移除
+func (t *connTable) remove(c *trackedConn) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	delete(t.conns, c.id)
+}
+
+// describe 记录连接收到的请求，未知的请求类型统一计为 unknown
+func (t *connTable) describe(c *trackedConn, req Request) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	c.session = req.Session
+	if req.identity != nil {
+		c.client = req.identity.Name
+	}
+	c.reqType, c.path, c.priority = req.Type, req.Path, req.Priority
+
+	if t.requests == nil {
+		t.requests = make(map[string]int64)
+	}
+	if slices.Contains(requestTypes, req.Type) {
+		t.requests[req.Type]++
+	} else {
+		t.requests["unknown"]++
+	}
+}
+
+// setQueued 标记连接是否在等待传输名额
+func (t *connTable) setQueued(c *trackedConn, queued bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	c.queued = queued
+}
+
+// list 返回正在处理的连接，按连接顺序排列
+func (t *connTable) list() []ConnectionInfo {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	conns := make([]ConnectionInfo, 0, len(t.conns))
+	for _, c := range t.conns {
+		conns = append(conns, ConnectionInfo{
+			ID:       c.id,
+			Remote:   c.RemoteAddr().String(),
+			Started:  c.started.Unix(),
+			Session:  c.session,
+			Client:   c.client,
+			Type:     c.reqType,
+			Path:     c.path,
+			Priority: c.priority,
+			Queued:   c.queued,
+			Sent:     c.sent.Load(),
+			Received: c.received.Load(),
+		})
+	}
+	slices.SortFunc(conns, func(a, b ConnectionInfo) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return conns
+}
+
+// counts 返回正在处理和等待传输名额的连接数
+func (t *connTable) counts() (active, queued int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, c := range t.conns {
+		if c.queued {
+			queued++
+		}
+	}
+	return len(t.conns), queued
+}
+
+// stats 返回累计的统计数据
+func (t *connTable) stats() *ServerStats {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	requests := make(map[string]int64, len(t.requests))
+	for k, v := range t.requests {
+		requests[k] = v
+	}
+	return &ServerStats{
+		Connections: t.total.Load(),
+		Requests:    requests,
+		Errors:      t.errors.Load(),
+		Kicked:      t.kicked.Load(),
+		Sent:        t.sent.Load(),
+		Received:    t.received.Load(),
+	}
+}
+
+// kick 关闭指定的连接，处理它的请求在下一次读写时出错退出
+func (t *connTable) kick(id uint64) bool {
+	t.mutex.Lock()
+	c := t.conns[id]
+	t.mutex.Unlock()
+
+	if c == nil {
+		return false
+	}
+	t.kicked.Add(1)
+	c.Conn.Close()
+	return true
+}
+
+// status 返回服务器的概况，供 admin status 和本机的状态管道使用
+func (s *Server) status() *ServerStatus {
+	active, queued := s.conns.counts()
+	s.configMutex.RLock()
+	status := &ServerStatus{
+		Version:      ProtocolVersion,
+		Uptime:       int64(time.Since(s.started).Seconds()),
+		Root:         s.rootDir,
+		Config:       s.configPath,
+		Connections:  active,
+		Queued:       queued,
+		Transactions: s.txns.count(),
+		Clients:      len(s.clients),
+		Control:      s.controlToken != "",
+	}
+	s.configMutex.RUnlock()
+	status.MaxTransfers = s.MaxTransfers()
+	status.Snapshots = s.snapshotHooks() != nil
+	status.OpenFiles = utils.OpenFiles()
+	status.MaxOpenFiles = utils.MaxOpenFiles()
+	status.BufferMemory, status.MaxBufferMemory = utils.BufferMemory()
+	return status
+}
+
+// handleAdminRequest 处理控制请求：查看服务器状态、连接和统计数据，或断开指定的连接
+func (s *Server) handleAdminRequest(conn net.Conn, req Request) {
+	if !s.authorizeControl(conn, req) {
+		return
+	}
+
+	resp := Response{
+		Status: "ok",
+	}
+	switch req.Action {
+	case AdminStatus:
+		resp.Admin = s.status()
+	case AdminConnections:
+		resp.Connections = s.conns.list()
+	case AdminStats:
+		resp.Stats = s.conns.stats()
+	case AdminKick:
+		if !s.conns.kick(req.Conn) {
+			s.sendError(conn, fmt.Sprintf("No such connection: %d", req.Conn))
+			return
+		}
+		logf(conn, "Connection %d kicked by %s\n", req.Conn, conn.RemoteAddr())
+	default:
+		s.sendError(conn, fmt.Sprintf("Unknown admin action: %s", req.Action))
+		return
+	}
+
+	if err := json.NewEncoder(conn).Encode(&resp); err != nil {
+		logf(conn, "Failed to send response: %v\n", err)
+	}
+}
+
+// admin 发送 admin 请求并返回响应
+func (c *Client) admin(token, action string, id uint64) (*Response, error) {
+	conn, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	req := Request{
+		Type:   "admin",
+		Token:  token,
+		Action: action,
+		Conn:   id,
+	}
+	if err := c.send(conn, &req); err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	if resp.Status != "ok" {
+		return nil, responseError(&resp)
+	}
+
+	return &resp, nil
+}
+
+// ServerStatus 返回服务器的概况，需要控制令牌
+func (c *Client) ServerStatus(token string) (*ServerStatus, error) {
+	resp, err := c.admin(token, AdminStatus, 0)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Admin == nil {
+		return nil, fmt.Errorf("no status in response")
+	}
+	return resp.Admin, nil
+}
+
+// Connections 返回服务器正在处理的连接（包括本次请求），需要控制令牌
+func (c *Client) Connections(token string) ([]ConnectionInfo, error) {
+	resp, err := c.admin(token, AdminConnections, 0)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Connections, nil
+}
+
+// ServerStats 返回服务器启动以来的累计数据，需要控制令牌
+func (c *Client) ServerStats(token string) (*ServerStats, error) {
+	resp, err := c.admin(token, AdminStats, 0)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Stats == nil {
+		return nil, fmt.Errorf("no stats in response")
+	}
+	return resp.Stats, nil
+}
+
+// Kick 断开服务器上的指定连接，需要控制令牌
+func (c *Client) Kick(token string, id uint64) error {
+	_, err := c.admin(token, AdminKick, id)
+	return err
+}
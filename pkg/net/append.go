@@ -0,0 +1,198 @@
+package net
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"gorsync/pkg/utils"
+)
+
+// statusMismatch 追加下载时服务器文件的开头与客户端的本地内容不同
+const statusMismatch = "mismatch"
+
+// ErrPrefixMismatch 远程文件不是在本地文件内容之后追加得到的，需要完整下载
+var ErrPrefixMismatch = errors.New("remote file does not start with the local content")
+
+// checkPrefix 检查文件前 size 字节的MD5是否为 expected
+func checkPrefix(file *os.File, size int64, expected string) (bool, error) {
+	h, err := prefixMD5(file, size)
+	if err != nil {
+		return false, err
+	}
+	return h == expected, nil
+}
+
+// prefixMD5 计算文件前 size 字节的MD5
+func prefixMD5(r io.ReaderAt, size int64) (string, error) {
+	h := md5.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, 0, size)); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// AppendFile 只下载远程文件比本地文件多出的尾部并追加到本地文件。localMD5 为本地文件当前内容的MD5，
+// 服务器确认其文件开头与之相同后才发送尾部，否则返回 ErrPrefixMismatch
+func (c *Client) AppendFile(remotePath, localPath, localMD5 string, index int) (err error) {
+	if c.source != nil {
+		return c.appendHTTP(remotePath, localPath, index)
+	}
+	local, err := os.OpenFile(localPath, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open destination file: %v", err)
+	}
+	defer local.Close()
+
+	info, err := local.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat destination file: %v", err)
+	}
+	offset := info.Size()
+
+	conn, err := c.connect()
+	if err != nil {
+		return err
+	}
+	// 续传时会换成新的连接
+	defer func() { conn.Close() }()
+	defer func() { checkLost(conn, &err) }()
+
+	req := Request{
+		Type:      "file",
+		Path:      remotePath,
+		Offset:    offset,
+		PrefixMD5: localMD5,
+	}
+	if err := c.send(conn, &req); err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	jsonData, err := reader.ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read response: %v", err)
+	}
+
+	var resp Response
+	if err := json.Unmarshal(jsonData, &resp); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+	switch resp.Status {
+	case "ok":
+	case statusMismatch:
+		return ErrPrefixMismatch
+	default:
+		return responseError(&resp)
+	}
+	if resp.File == nil {
+		return fmt.Errorf("no file info in response")
+	}
+	if ret, err := reader.ReadByte(); err != nil || ret != '\n' {
+		return fmt.Errorf("failed to parse the \n : %v", err)
+	}
+
+	// 完整性模式下 HMAC 覆盖整个文件，先计入本地已有的内容
+	var integrity hash.Hash
+	if len(c.integrityKey) > 0 {
+		if resp.File.HMAC == "" {
+			return fmt.Errorf("integrity check failed: server did not send an HMAC")
+		}
+		integrity = newIntegrityHash(c.integrityKey, resp.File)
+		if _, err := io.Copy(integrity, io.NewSectionReader(local, 0, offset)); err != nil {
+			return fmt.Errorf("failed to read destination file: %v", err)
+		}
+	}
+
+	tailSize := resp.File.Size - offset
+	if !c.quiet {
+		c.printf("%d. Appending %s to %s\n", index, utils.FormatSize(tailSize), remotePath)
+	}
+	if c.progress != nil {
+		c.progress.begin(remotePath, tailSize)
+		defer c.progress.end(remotePath)
+	}
+
+	// 追加失败时截断回原来的大小
+	ok := false
+	defer func() {
+		if !ok {
+			local.Truncate(offset)
+		}
+	}()
+
+	if _, err := local.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek destination file: %v", err)
+	}
+
+	pooled := utils.GetBuffer()
+	defer utils.PutBuffer(pooled)
+	buffer := *pooled
+	transferred := int64(0)
+	resumes := 0
+	var data io.Reader = reader
+	for transferred < tailSize {
+		if err := c.waitIfPaused(); err != nil {
+			return err
+		}
+
+		n, readErr := data.Read(buffer[:min(int64(len(buffer)), tailSize-transferred)])
+		if n == 0 || readErr != nil && readErr != io.EOF {
+			resumed, resumedData, err := c.resumeShortRead(conn, remotePath, &resp, offset+transferred, &resumes, readErr)
+			if err != nil {
+				return err
+			}
+			conn.Close()
+			conn, data = resumed, resumedData
+			if !c.quiet {
+				c.printf("%d. Connection lost after %d of %d bytes, resuming %s\n", index, transferred, tailSize, remotePath)
+			}
+			continue
+		}
+
+		if _, err := local.Write(buffer[:n]); err != nil {
+			return fmt.Errorf("failed to write destination file: %v", err)
+		}
+		if integrity != nil {
+			integrity.Write(buffer[:n])
+		}
+		transferred += int64(n)
+
+		if c.limiter != nil {
+			c.limiter.Wait(n)
+		}
+		if c.progress != nil {
+			c.progress.add(remotePath, n)
+		}
+	}
+
+	if integrity != nil {
+		if err := checkIntegrity(integrity, resp.File.HMAC); err != nil {
+			return err
+		}
+	}
+
+	// 与列表中的MD5比较整个文件，--no-verify 或未被抽中时跳过
+	if f, cached := c.cachedFile(remotePath); cached && !c.skipVerify() && f.Size == resp.File.Size && f.MD5 != "" {
+		if matched, err := checkPrefix(local, resp.File.Size, f.MD5); err != nil {
+			return fmt.Errorf("failed to calculate destination file MD5: %v", err)
+		} else if !matched {
+			return fmt.Errorf("%w after append: %s", ErrChecksumMismatch, remotePath)
+		}
+	}
+
+	if err := local.Sync(); err != nil {
+		return fmt.Errorf("failed to sync destination file: %v", err)
+	}
+	ok = true
+
+	if !c.quiet {
+		c.printf("%d. Append completed: %s (%d bytes)\n", index, remotePath, transferred)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package net
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// handleBrowseRequest 返回一个目录下的子目录名，路径为空时返回服务器根目录（或客户端身份的根目录）下的顶层目录，
+// 供客户端补全远程路径，以及在请求的路径不存在时提示可用的目录
+func (s *Server) handleBrowseRequest(conn net.Conn, req Request) {
+	path := req.Path
+	if path == "" {
+		path = "/"
+	}
+	var fullPath string
+	if s.rootDir == "" {
+		fullPath = path
+	} else {
+		fullPath = LocalPath(s.rootDir, path)
+	}
+
+	entries, err := os.ReadDir(fullPath)
+	if err != nil {
+		s.sendErrorCode(conn, errorCode(err), fmt.Sprintf("Failed to read directory: %v", err))
+		return
+	}
+	dirs := []string{}
+	for _, entry := range entries {
+		// 指向目录的符号链接也可以作为同步的路径
+		if entry.IsDir() {
+			dirs = append(dirs, entry.Name())
+		} else if entry.Type()&os.ModeSymlink != 0 {
+			if info, err := os.Stat(filepath.Join(fullPath, entry.Name())); err == nil && info.IsDir() {
+				dirs = append(dirs, entry.Name())
+			}
+		}
+	}
+	sort.Strings(dirs)
+
+	resp := Response{
+		Status: "ok",
+		Dirs:   dirs,
+	}
+	if err := json.NewEncoder(conn).Encode(&resp); err != nil {
+		logf(conn, "Failed to send response: %v\n", err)
+	}
+}
+
+// Browse 返回服务器上 path 目录下的子目录名，path 为空时返回服务器的顶层目录
+func (c *Client) Browse(path string) (dirs []string, err error) {
+	conn, err := c.pooledConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { c.release(conn, err) }()
+
+	req := Request{
+		Type: "browse",
+		Path: path,
+	}
+	if err := c.send(conn, &req); err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+	if resp.Status != "ok" {
+		return nil, responseError(&resp)
+	}
+	return resp.Dirs, nil
+}
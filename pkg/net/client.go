@@ -2,21 +2,98 @@ package net
 
 import (
 	"bufio"
+	"crypto/md5"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"gorsync/pkg/utils"
+	"hash"
 	"io"
+	"math/rand/v2"
 	"net"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// ErrRemoteChanged 远程文件在传输过程中被截断，收到的内容少于响应中的大小
+var ErrRemoteChanged = errors.New("remote file changed during transfer")
+
 // Client TCP客户端结构体
 type Client struct {
-	addr string
-	port int
+	addr    string
+	port    int
+	limiter *utils.RateLimiter
+	// verifyReadback 下载完成后从磁盘重新读取目标文件并校验MD5
+	verifyReadback bool
+	// noVerify 下载完成后不再读取整个文件与服务器的MD5比较
+	noVerify bool
+	// verifySample 只对这个百分比的随机抽样比较整个文件的MD5，0 表示全部比较
+	verifySample int
+	// requireChecksum 服务器没有发送MD5时下载失败，而不是不经校验保存
+	requireChecksum bool
+	// integrityKey 完整性模式的共享密钥，设置后要求文件响应附带正确的 HMAC
+	integrityKey []byte
+	// dialLatency 最近一次建立连接的耗时（纳秒），近似于往返延迟
+	dialLatency atomic.Int64
+	// connected 是否曾经成功连接过服务器
+	connected atomic.Bool
+	// cache 本次会话中列表返回的文件信息，以远程完整路径为键
+	cache      map[string]FileInfo
+	cacheMutex sync.Mutex
+	// progress 下载进度汇总，为 nil 时不显示进度
+	progress *Progress
+	// quiet 不打印每个文件的开始和完成信息
+	quiet bool
+	// output 每个文件的开始和完成等信息的输出，为 nil 时写到标准输出
+	output io.Writer
+	// cancel 关闭后不再建立新连接，并断开正在进行的请求
+	cancel <-chan struct{}
+	// pause 客户端自己的暂停状态，nil 表示只受 PauseTransfers 控制
+	pause *Pause
+	// transforms 按文件名选择的传输变换
+	transforms []TransformRule
+	// dict 本次会话的压缩字典，见 FetchDictionary，服务器不再有该字典时清空
+	dict atomic.Pointer[Dictionary]
+	// policies 按文件名选择的传输策略
+	policies []TransferPolicy
+	// textFilter 按文本处理的文件，下载时转换为本机换行符
+	textFilter *utils.TextFilter
+	// freshListing 获取列表时要求服务器重新遍历目录
+	freshListing bool
+	// glob 获取列表时只返回匹配的文件及其上级目录，见 SplitGlob
+	glob string
+	// security 获取列表时要求服务器返回 NTFS 安全描述符
+	security bool
+	// specialPerms 下载的文件保留 setuid 和 setgid 位
+	specialPerms bool
+	// skipPerms 下载的文件不设置权限，用于不支持权限的目标文件系统
+	skipPerms bool
+	// streams 获取列表时要求服务器返回小的备用数据流的内容
+	streams bool
+	// xattrs 获取列表时要求服务器返回 macOS 扩展属性的内容
+	xattrs bool
+	// session 随每个请求发送的会话ID，用于关联客户端和服务器的日志
+	session string
+	// priority 随每个请求发送的优先级
+	priority string
+	// source 不为 nil 时从普通 HTTP(S) 服务器镜像，见 NewHTTPClient
+	source *httpSource
+	// txn 上传所属的事务ID，为空时不使用事务
+	txn string
+	// staged 事务中的上传只暂存，提交时才替换目标文件
+	staged bool
+	// identity 客户端身份令牌，随没有指定令牌的请求发送
+	identity string
+	// pool 列表、stat、checksum 和签名请求复用的连接
+	pool connPool
+	// partial 下载中断时保留已下载的部分，下次续传
+	partial bool
 }
 
 // NewClient 创建新的客户端
@@ -31,60 +108,326 @@ func NewClient(addr string, port int) *Client {
 	}
 }
 
-// ListFiles 获取文件列表
-func (c *Client) ListFiles(path string) ([]FileInfo, error) {
-	conn, err := c.connect()
+// SetRateLimiter 设置下载限速器
+func (c *Client) SetRateLimiter(limiter *utils.RateLimiter) {
+	c.limiter = limiter
+}
+
+// SetNoVerify 设置是否跳过下载完成后的整文件MD5比较，只依靠 TCP 和完整性模式的校验，
+// 用于可信的高速局域网，省去每个大文件的再次完整读取
+func (c *Client) SetNoVerify(enabled bool) {
+	c.noVerify = enabled
+}
+
+// SetRequireChecksum 设置服务器没有发送文件的MD5（服务器计算失败）时是否以 ErrNoChecksum 失败，
+// 默认打印警告后不经校验保存下载的内容
+func (c *Client) SetRequireChecksum(enabled bool) {
+	c.requireChecksum = enabled
+}
+
+// SetVerifySample 设置下载完成后比较整个文件MD5的随机抽样百分比，0 表示全部比较
+func (c *Client) SetVerifySample(percent int) {
+	c.verifySample = percent
+}
+
+// skipVerify 返回本次下载是否跳过整文件MD5比较：--no-verify 时总是跳过，抽样时只比较抽中的文件
+func (c *Client) skipVerify() bool {
+	if c.noVerify {
+		return true
+	}
+	return c.verifySample > 0 && rand.IntN(100) >= c.verifySample
+}
+
+// SetVerifyReadback 设置是否在下载完成后从磁盘读回校验
+func (c *Client) SetVerifyReadback(enabled bool) {
+	c.verifyReadback = enabled
+}
+
+// SetFreshListing 设置获取列表时是否跳过服务器缓存的遍历结果
+func (c *Client) SetFreshListing(enabled bool) {
+	c.freshListing = enabled
+}
+
+// SetGlob 设置获取列表时的相对模式，只返回匹配的文件及其上级目录，为空时返回整个目录
+func (c *Client) SetGlob(pattern string) {
+	c.glob = pattern
+}
+
+// SetSession 设置随每个请求发送的会话ID
+func (c *Client) SetSession(id string) {
+	c.session = id
+}
+
+// SetPriority 设置请求的优先级，PriorityBackground 的请求在服务器繁忙时排在交互式请求之后
+func (c *Client) SetPriority(priority string) {
+	c.priority = priority
+}
+
+// SetIdentity 设置客户端身份令牌，服务器据此把请求映射到该客户端的目录和权限
+func (c *Client) SetIdentity(token string) {
+	c.identity = token
+}
+
+// DialLatency 返回最近一次建立连接的耗时
+func (c *Client) DialLatency() time.Duration {
+	return time.Duration(c.dialLatency.Load())
+}
+
+// SetSecurity 设置获取列表时是否要求服务器返回每个路径的 NTFS 安全描述符
+func (c *Client) SetSecurity(enabled bool) {
+	c.security = enabled
+}
+
+// SetStreams 设置获取列表时是否要求服务器返回不超过 MaxInlineStream 的 NTFS 备用数据流的内容
+func (c *Client) SetStreams(enabled bool) {
+	c.streams = enabled
+}
+
+// SetXattrs 设置获取列表时是否要求服务器返回不超过 MaxInlineXattr 的 macOS 扩展属性的内容
+func (c *Client) SetXattrs(enabled bool) {
+	c.xattrs = enabled
+}
+
+// SetSpecialPerms 设置下载的文件是否保留远程的 setuid 和 setgid 位，默认去掉
+func (c *Client) SetSpecialPerms(enabled bool) {
+	c.specialPerms = enabled
+}
+
+// SetKeepPerms 设置下载的文件是否应用远程的权限，目标文件系统不支持权限时关闭
+func (c *Client) SetKeepPerms(enabled bool) {
+	c.skipPerms = !enabled
+}
+
+// SetIntegrityKey 设置完整性模式的共享密钥
+func (c *Client) SetIntegrityKey(key string) {
+	c.integrityKey = []byte(key)
+}
+
+// ListFiles 获取文件列表，同时返回服务器因访问错误跳过的路径
+func (c *Client) ListFiles(path string) (files []FileInfo, skipped []SkippedPath, err error) {
+	if c.source != nil {
+		return c.listHTTP(path)
+	}
+	skipped, err = c.WalkFiles(path, func(f FileInfo) error {
+		files = append(files, f)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.glob != "" {
+		files = FilterGlob(files, c.glob)
+	}
+
+	c.cacheFiles(path, files)
+	return files, skipped, nil
+}
+
+// WalkFiles 逐条获取文件列表交给 fn，不在内存中保留完整的列表，也不缓存文件信息（见 CacheListing），
+// 返回服务器因访问错误跳过的路径。设置了通配符模式时只跳过既不匹配也不是上级目录的路径，不支持 HTTP 源
+func (c *Client) WalkFiles(path string, fn func(FileInfo) error) (skipped []SkippedPath, err error) {
+	if c.source != nil {
+		return nil, fmt.Errorf("streamed listings are not supported for HTTP sources")
+	}
+	conn, err := c.pooledConnect()
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Close()
+	defer func() { c.release(conn, err) }()
+	defer checkLost(conn, &err)
 
 	// 发送请求
 	req := Request{
-		Type: "list",
-		Path: path,
+		Type:         "list",
+		Path:         path,
+		Capabilities: []string{CapListCompress},
+		Fresh:        c.freshListing,
+		Glob:         c.glob,
+		Security:     c.security,
+		Streams:      c.streams,
+		Xattrs:       c.xattrs,
 	}
-	if err := json.NewEncoder(conn).Encode(&req); err != nil {
+	if c.textFilter != nil {
+		req.TextMode = c.textFilter.String()
+	}
+	if err := c.send(conn, &req); err != nil {
 		return nil, fmt.Errorf("failed to send request: %v", err)
 	}
 
 	// 接收响应
 	var resp Response
-	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+	dec := json.NewDecoder(conn)
+	if err := dec.Decode(&resp); err != nil {
 		return nil, fmt.Errorf("failed to decode response: %v", err)
 	}
 
 	if resp.Status != "ok" {
-		return nil, fmt.Errorf("server error: %s", resp.Message)
+		return nil, responseError(&resp)
+	}
+	for _, p := range resp.Skipped {
+		if err := CheckWirePath(p.Path); err != nil {
+			return nil, fmt.Errorf("server sent an unsafe path: %v", err)
+		}
+	}
+
+	// 客户端会把这些路径拼接到本地目录下，不能信任服务器
+	check := func(f FileInfo) error {
+		upgradeLegacyMode(&f)
+		if err := CheckWirePath(f.Path); err != nil {
+			return fmt.Errorf("server sent an unsafe path: %v", err)
+		}
+		if err := ValidateMode(f.Mode, f.Type); err != nil {
+			return fmt.Errorf("server sent invalid metadata for %s: %v", f.Path, err)
+		}
+		if err := checkStreams(&f); err != nil {
+			return err
+		}
+		if c.glob != "" {
+			if matched, parent := MatchGlob(c.glob, f.Path); !matched && !parent {
+				return nil
+			}
+		}
+		return fn(f)
+	}
+	switch resp.Encoding {
+	case "":
+		for _, f := range resp.Files {
+			if err := check(f); err != nil {
+				return nil, err
+			}
+		}
+	case listEncodingGzipDelta:
+		// 解码器可能已缓存了压缩数据的开头部分
+		if err := walkCompressedListing(io.MultiReader(dec.Buffered(), conn), resp.Count, check); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported listing encoding: %s", resp.Encoding)
+	}
+	return resp.Skipped, nil
+}
+
+// SetProgress 设置下载进度汇总，多个客户端可共享同一个 Progress
+func (c *Client) SetProgress(progress *Progress) {
+	c.progress = progress
+}
+
+// SetQuiet 设置是否省略每个文件的开始和完成信息
+func (c *Client) SetQuiet(quiet bool) {
+	c.quiet = quiet
+}
+
+// SetOutput 设置每个文件的开始和完成等信息的输出，nil 表示标准输出
+func (c *Client) SetOutput(w io.Writer) {
+	c.output = w
+}
+
+// printf 向 SetOutput 设置的输出打印信息
+func (c *Client) printf(format string, args ...any) {
+	w := c.output
+	if w == nil {
+		w = os.Stdout
 	}
+	fmt.Fprintf(w, format, args...)
+}
+
+// SetCancel 设置取消信号：cancel 关闭后新的请求以 ErrCanceled 失败，正在进行的请求的连接被断开
+func (c *Client) SetCancel(cancel <-chan struct{}) {
+	c.cancel = cancel
+}
+
+// SetTransforms 设置传输变换规则，按顺序使用第一条匹配的规则
+func (c *Client) SetTransforms(rules []TransformRule) {
+	c.transforms = rules
+}
+
+// SetTextFilter 设置文本模式，列表中附带统一换行符后的MD5，下载的文本文件转换为本机换行符
+func (c *Client) SetTextFilter(filter *utils.TextFilter) {
+	c.textFilter = filter
+}
+
+// cacheFiles 缓存列表中的文件信息，下载时服务器可直接使用而无需重新计算哈希
+func (c *Client) cacheFiles(root string, files []FileInfo) {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+
+	if c.cache == nil {
+		c.cache = make(map[string]FileInfo)
+	}
+	for _, f := range files {
+		if !f.IsDir && f.MD5 != "" {
+			c.cache[JoinWire(root, f.Path)] = f
+		}
+	}
+}
+
+// CacheListing 用 files 替换缓存的文件信息。WalkFiles 不缓存，分批同步时每批下载前只缓存该批的远程文件，
+// 下载时仍可让服务器直接使用已知的MD5并校验下载的内容
+func (c *Client) CacheListing(root string, files []FileInfo) {
+	c.cacheMutex.Lock()
+	c.cache = nil
+	c.cacheMutex.Unlock()
 
-	return resp.Files, nil
+	c.cacheFiles(root, files)
+}
+
+// cachedFile 返回本次会话中列表里的文件信息
+func (c *Client) cachedFile(remotePath string) (FileInfo, bool) {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+
+	f, ok := c.cache[remotePath]
+	return f, ok
 }
 
 // getFileSequential 顺序获取文件
-func (c *Client) DownloadFile(remotePath, localPath string, index int) error {
+func (c *Client) DownloadFile(remotePath, localPath string, index int) (err error) {
+	if c.source != nil {
+		return c.downloadHTTP(remotePath, localPath, index)
+	}
 	conn, err := c.connect()
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
+	// 续传时会换成新的连接
+	defer func() { conn.Close() }()
+	defer func() { checkLost(conn, &err) }()
 
 	prefix := strings.Repeat(" ", len(strconv.Itoa(index))+2)
 	// 发送请求
 	req := Request{
-		Type:   "file",
-		Path:   remotePath,
-		Offset: 0,
+		Type: "file",
+		Path: remotePath,
 	}
-	if err := json.NewEncoder(conn).Encode(&req); err != nil {
+	// 文本模式下服务器为文本文件计算了 TextMD5
+	var textMD5, expectedMD5 string
+	if f, ok := c.cachedFile(remotePath); ok {
+		req.Known = &FileInfo{Size: f.Size, ModTime: f.ModTime, MD5: f.MD5}
+		expectedMD5 = f.MD5
+		if c.textFilter != nil {
+			textMD5 = f.TextMD5
+		}
+	}
+	// 有上次保留的部分下载时，请求服务器确认文件开头与之相同后只发送剩余部分
+	offset, prefixMD5 := c.partialOffset(remotePath, localPath, textMD5 != "")
+	if offset > 0 {
+		req.Offset = offset
+		req.PrefixMD5 = prefixMD5
+	}
+	req.Transforms = c.dictTransforms(remotePath, applyCompression(matchTransforms(c.transforms, remotePath), matchPolicy(c.policies, remotePath).Compress))
+	var dict []byte
+	req.DictID, dict = c.dictFor(req.Transforms)
+	if err := checkTransforms(req.Transforms, c.integrityKey, dict); err != nil {
+		return err
+	}
+	if err := c.send(conn, &req); err != nil {
 		return fmt.Errorf("failed to send request: %v", err)
 	}
 
 	reader := bufio.NewReader(conn)
 	jsonData, err := reader.ReadBytes('\n')
-	ret, err := reader.ReadByte()
-	if err != nil || ret != '\n' {
-		return fmt.Errorf("failed to parse the \n : %v", err)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %v", err)
 	}
 
 	// 接收响应
@@ -93,16 +436,84 @@ func (c *Client) DownloadFile(remotePath, localPath string, index int) error {
 		return fmt.Errorf("failed to decode response: %v", err)
 	}
 
+	// 服务器上的文件开头已变化时丢弃部分下载重新开始。续传的响应不带整个文件的MD5，
+	// 文件在列表之后有变化时无法校验，也重新开始
+	if offset > 0 && (resp.Status == statusMismatch ||
+		resp.Status == "ok" && resp.File != nil && (resp.File.Size != req.Known.Size || resp.File.ModTime != req.Known.ModTime)) {
+		conn.Close()
+		os.Remove(PartialName(localPath))
+		if !c.quiet {
+			c.printf("%sPartial download of %s no longer matches the server, downloading from the start\n", prefix, remotePath)
+		}
+		return c.DownloadFile(remotePath, localPath, index)
+	}
+	// 服务器重启或字典过期后不再使用字典，重新请求
+	if resp.Status != "ok" && resp.Code == ErrorCodeNoDict && req.DictID != "" {
+		conn.Close()
+		c.dropDictionary()
+		if !c.quiet {
+			c.printf("%sServer no longer has the compression dictionary, compressing files individually\n", prefix)
+		}
+		return c.DownloadFile(remotePath, localPath, index)
+	}
 	if resp.Status != "ok" {
-		return fmt.Errorf("server error: %s", resp.Message)
+		return responseError(&resp)
+	}
+	if ret, err := reader.ReadByte(); err != nil || ret != '\n' {
+		return fmt.Errorf("failed to parse the \n : %v", err)
 	}
 
 	if resp.File == nil {
 		return fmt.Errorf("no file info in response")
 	}
+	upgradeLegacyMode(resp.File)
+	if err := ValidateMode(resp.File.Mode, resp.File.Type); err != nil {
+		return fmt.Errorf("server sent invalid metadata: %v", err)
+	}
+
+	// 完整性模式下拒绝没有 HMAC 的响应，防止中间人降级
+	var integrity hash.Hash
+	if len(c.integrityKey) > 0 {
+		if resp.File.HMAC == "" {
+			return fmt.Errorf("integrity check failed: server did not send an HMAC")
+		}
+		integrity = newIntegrityHash(c.integrityKey, resp.File)
+	}
+	if offset == 0 {
+		expectedMD5 = resp.File.MD5
+	}
+
+	// 服务器应用了变换时先解码，旧版本服务器不支持变换时发送原始内容
+	var data io.Reader = reader
+	if len(resp.Transforms) > 0 {
+		if strings.Join(resp.Transforms, ",") != strings.Join(req.Transforms, ",") {
+			return fmt.Errorf("server applied unexpected transforms: %s", strings.Join(resp.Transforms, ","))
+		}
+		data, err = decodeTransforms(reader, resp.Transforms, c.integrityKey, dict)
+		if err != nil {
+			return err
+		}
+	} else if len(req.Transforms) > 0 {
+		// 要求加密时拒绝未加密的内容，防止降级
+		for _, name := range req.Transforms {
+			if name == TransformAES {
+				return fmt.Errorf("server did not encrypt the transfer of %s", remotePath)
+			}
+		}
+		if !c.quiet {
+			c.printf("%sServer does not support transforms, receiving %s unencoded\n", prefix, remotePath)
+		}
+	}
+
+	// 服务器按请求时的文件发送，大小可能与列表不同
+	if req.Known != nil && req.Known.Size != resp.File.Size && !c.quiet {
+		c.printf("%sRemote file changed since listing: %s (%d -> %d bytes)\n", prefix, remotePath, req.Known.Size, resp.File.Size)
+	}
 
 	// 打印传输开始信息
-	fmt.Printf("%d. Starting download (%.2f MB): %s\n", index, float64(resp.File.Size)/1024/1024, remotePath)
+	if !c.quiet {
+		c.printf("%d. Starting download (%.2f MB): %s\n", index, float64(resp.File.Size)/1024/1024, remotePath)
+	}
 
 	// 确保目标目录存在
 	destDir := filepath.Dir(localPath)
@@ -110,56 +521,118 @@ func (c *Client) DownloadFile(remotePath, localPath string, index int) error {
 		return fmt.Errorf("failed to create destination directory: %v", err)
 	}
 
-	// 创建临时文件路径
+	// 创建临时文件路径，续传时接着写入部分下载
 	tempPath := utils.MakeTempName(localPath)
+	if offset > 0 {
+		tempPath = PartialName(localPath)
+	}
 
-	// 确保函数结束时清理临时文件
+	// 确保函数结束时清理临时文件，开启 SetPartial 时连接断开或取消后保留已下载的部分
 	defer func() {
+		if err != nil && c.partial && textMD5 == "" && resumable(conn) &&
+			!errors.Is(err, ErrChecksumMismatch) && !errors.Is(err, ErrRemoteChanged) {
+			if info, statErr := os.Stat(tempPath); statErr == nil && info.Size() > 0 {
+				if tempPath == PartialName(localPath) || os.Rename(tempPath, PartialName(localPath)) == nil {
+					return
+				}
+			}
+		}
 		os.Remove(tempPath)
 	}()
 
 	// 打开目标文件
-	tempFile, err := os.OpenFile(tempPath, os.O_RDWR|os.O_CREATE, os.FileMode(resp.File.Mode))
+	tempFile, err := os.OpenFile(tempPath, os.O_RDWR|os.O_CREATE, FileMode(resp.File.Mode, false).Perm())
 	if err != nil {
 		return fmt.Errorf("failed to open destination file: %v", err)
 	}
 	defer tempFile.Close()
 
-	// 移动文件指针到指定偏移量
-	if _, err := tempFile.Seek(0, io.SeekStart); err != nil {
+	// 移动文件指针到指定偏移量，续传时完整性模式的 HMAC 先计入已有的部分
+	if _, err := tempFile.Seek(offset, io.SeekStart); err != nil {
 		return fmt.Errorf("failed to seek destination file: %v", err)
 	}
+	if offset > 0 {
+		if err := tempFile.Truncate(offset); err != nil {
+			return fmt.Errorf("failed to truncate destination file: %v", err)
+		}
+		if integrity != nil {
+			if _, err := io.Copy(integrity, io.NewSectionReader(tempFile, 0, offset)); err != nil {
+				return fmt.Errorf("failed to read destination file: %v", err)
+			}
+		}
+		if !c.quiet {
+			c.printf("%sResuming %s after %d bytes already received\n", prefix, remotePath, offset)
+		}
+	}
 
 	// 接收文件数据
-	buffer := make([]byte, 64*1024)
+	pooled := utils.GetBuffer()
+	defer utils.PutBuffer(pooled)
+	buffer := *pooled
 	transferred := int64(0)
-	lastProgress := float64(0)
-	totalSize := resp.File.Size
+	totalSize := resp.File.Size - offset
 
-	fmt.Printf("%s>>> Starting download: %s (total size: %d bytes)\n", prefix, remotePath, totalSize)
+	if !c.quiet {
+		c.printf("%s>>> Starting download: %s (total size: %d bytes)\n", prefix, remotePath, totalSize)
+	}
+	if c.progress != nil {
+		c.progress.begin(remotePath, totalSize)
+		defer c.progress.end(remotePath)
+	}
+
+	// 文本文件转换为本机换行符后写入，原始内容的MD5在接收时计算
+	var dest io.Writer = tempFile
+	var textOut io.WriteCloser
+	var rawHash hash.Hash
+	if textMD5 != "" {
+		textOut = utils.NewTextWriter(tempFile)
+		rawHash = md5.New()
+		dest = textOut
+	}
 
+	resumes := 0
 	for transferred < totalSize {
-		n, err := reader.Read(buffer)
-		if err != nil && err != io.EOF {
-			return fmt.Errorf("failed to read file data: %v", err)
+		// 暂停时在数据块之间等待
+		if err := c.waitIfPaused(); err != nil {
+			return err
 		}
 
-		if n == 0 {
-			break
+		n, readErr := data.Read(buffer[:min(int64(len(buffer)), totalSize-transferred)])
+		if n == 0 || readErr != nil && readErr != io.EOF {
+			// 数据在 totalSize 之前结束：连接断开时在新连接上只请求剩余的部分
+			resumed, resumedData, err := c.resumeShortRead(conn, remotePath, &resp, offset+transferred, &resumes, readErr)
+			if err != nil {
+				return err
+			}
+			conn.Close()
+			conn, data = resumed, resumedData
+			if !c.quiet {
+				c.printf("%sConnection lost after %d of %d bytes, resuming %s\n", prefix, transferred, totalSize, remotePath)
+			}
+			continue
 		}
 
 		// 写入目标文件
-		if _, err := tempFile.Write(buffer[:n]); err != nil {
+		if _, err := dest.Write(buffer[:n]); err != nil {
 			return fmt.Errorf("failed to write destination file: %v", err)
 		}
+		if rawHash != nil {
+			rawHash.Write(buffer[:n])
+		}
+		if integrity != nil {
+			integrity.Write(buffer[:n])
+		}
 
 		transferred += int64(n)
 
-		// 计算进度并打印
-		progress := float64(transferred) / float64(totalSize) * 100
-		if progress-lastProgress >= 10 {
-			fmt.Printf("%sSequential download progress: %s %.1f%%\n", prefix, remotePath, progress)
-			lastProgress = progress
+		// 按带宽限制等待
+		if c.limiter != nil {
+			c.limiter.Wait(n)
+		}
+
+		// 进度统一由 Progress 按间隔打印
+		if c.progress != nil {
+			c.progress.add(remotePath, n)
 		}
 
 		// 刷新缓冲区
@@ -168,31 +641,326 @@ func (c *Client) DownloadFile(remotePath, localPath string, index int) error {
 		}
 	}
 
-	fmt.Printf("%sSequential download completed: %s (transferred: %d bytes)\n", prefix, remotePath, transferred)
+	if textOut != nil {
+		if err := textOut.Close(); err != nil {
+			return fmt.Errorf("failed to write destination file: %v", err)
+		}
+	}
+
+	if !c.quiet {
+		c.printf("%sSequential download completed: %s (transferred: %d bytes)\n", prefix, remotePath, transferred)
+	}
+
+	if integrity != nil {
+		if err := checkIntegrity(integrity, resp.File.HMAC); err != nil {
+			return err
+		}
+	}
 
 	// 确保文件权限正确
-	if err := os.Chmod(tempPath, os.FileMode(resp.File.Mode)); err != nil {
-		return fmt.Errorf("failed to set destination file mode: %v", err)
+	if !c.skipPerms {
+		if err := os.Chmod(tempPath, FileMode(resp.File.Mode, c.specialPerms)); err != nil {
+			return fmt.Errorf("failed to set destination file mode: %v", err)
+		}
 	}
 
-	// 计算目标文件的MD5哈希值并与服务器发送的MD5哈希值进行比较
-	if resp.File.MD5 != "" {
-		destMD5, err := utils.CalculateMD5(tempPath)
-		if err != nil {
+	// 计算目标文件的MD5哈希值并与服务器发送的MD5哈希值进行比较，--no-verify 或未被抽中时跳过。
+	// 服务器没有发送MD5时无法校验，--require-checksum 时视为失败
+	switch {
+	case expectedMD5 == "" && c.requireChecksum:
+		return fmt.Errorf("%w: server sent no MD5 for %s", ErrNoChecksum, remotePath)
+	case expectedMD5 == "":
+		c.printf("%sWarning: server sent no MD5 for %s, content not verified\n", prefix, remotePath)
+	case !c.skipVerify():
+		var destMD5 string
+		if rawHash != nil {
+			destMD5 = hex.EncodeToString(rawHash.Sum(nil))
+		} else if destMD5, err = utils.CalculateMD5(tempPath); err != nil {
 			return fmt.Errorf("failed to calculate destination file MD5: %v", err)
 		}
 
-		if resp.File.MD5 != destMD5 {
-			return fmt.Errorf("file content mismatch: server MD5 %s, local MD5 %s", resp.File.MD5, destMD5)
+		if expectedMD5 != destMD5 {
+			return fmt.Errorf("%w: server MD5 %s, local MD5 %s", ErrChecksumMismatch, expectedMD5, destMD5)
 		}
+	}
+
+	// 将临时文件重命名为目标文件
+	tempFile.Close()
+	if err := utils.Saferename(tempPath, localPath); err != nil {
+		return fmt.Errorf("failed to rename temporary file: %w", err)
+	}
 
-		// 将临时文件重命名为目标文件
-		tempFile.Close()
-		if err := utils.Saferename(tempPath, localPath); err != nil {
-			return fmt.Errorf("failed to rename temporary file: %v", err)
+	// 丢弃页缓存后重新读取，检查写入磁盘的数据是否损坏
+	if c.verifyReadback && expectedMD5 != "" {
+		if err := utils.DropFileCache(localPath); err != nil {
+			c.printf("%sFailed to drop page cache for %s: %v\n", prefix, localPath, err)
+		}
+		// 转换过换行符的文本文件比较统一换行符后的MD5
+		readback, expected := utils.CalculateMD5, expectedMD5
+		if textMD5 != "" {
+			readback, expected = utils.NormalizedMD5, textMD5
+		}
+		readbackMD5, err := readback(localPath)
+		if err != nil {
+			return fmt.Errorf("failed to read back destination file: %v", err)
+		}
+		if readbackMD5 != expected {
+			return fmt.Errorf("read-back verification failed: %w: server MD5 %s, on-disk MD5 %s", ErrChecksumMismatch, expected, readbackMD5)
 		}
+		if !c.quiet {
+			c.printf("%sRead-back verified: %s\n", prefix, localPath)
+		}
+	}
 
-		fmt.Printf("%s<<< Download completed: %s\n", prefix, remotePath)
+	if !c.quiet {
+		c.printf("%s<<< Download completed: %s\n", prefix, remotePath)
+	}
+
+	return nil
+}
+
+// Stat 查询服务器上单个路径的元数据
+func (c *Client) Stat(path string) (info *FileInfo, err error) {
+	if c.source != nil {
+		return c.statHTTP(path)
+	}
+	conn, err := c.pooledConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { c.release(conn, err) }()
+
+	req := Request{
+		Type: "stat",
+		Path: path,
+	}
+	if err := c.send(conn, &req); err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	if resp.Status != "ok" {
+		return nil, responseError(&resp)
+	}
+	if resp.File == nil {
+		return nil, fmt.Errorf("no file info in response")
+	}
+	upgradeLegacyMode(resp.File)
+	if err := ValidateMode(resp.File.Mode, resp.File.Type); err != nil {
+		return nil, fmt.Errorf("server sent invalid metadata: %v", err)
+	}
+	if err := checkStreams(resp.File); err != nil {
+		return nil, err
+	}
+
+	return resp.File, nil
+}
+
+// Checksum 请求服务器计算单个文件的校验和，algorithm 为 md5、sha1 或 sha256，为空时使用 md5
+func (c *Client) Checksum(path, algorithm string) (sum string, err error) {
+	conn, err := c.pooledConnect()
+	if err != nil {
+		return "", err
+	}
+	defer func() { c.release(conn, err) }()
+
+	req := Request{
+		Type:      "checksum",
+		Path:      path,
+		Algorithm: algorithm,
+	}
+	if err := c.send(conn, &req); err != nil {
+		return "", fmt.Errorf("failed to send request: %v", err)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return "", fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	if resp.Status != "ok" {
+		return "", responseError(&resp)
+	}
+
+	return resp.Checksum, nil
+}
+
+// ReleaseSnapshot 通知服务器本次同步已结束，可以释放为 path 创建的快照
+func (c *Client) ReleaseSnapshot(path string) error {
+	conn, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	req := Request{
+		Type: "release",
+		Path: path,
+	}
+	if err := c.send(conn, &req); err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	if resp.Status != "ok" {
+		return responseError(&resp)
+	}
+
+	return nil
+}
+
+// RequestPull 请求服务器从 remote 拉取文件到服务器上的 path
+func (c *Client) RequestPull(token, path, remote string) error {
+	conn, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// 发送请求
+	req := Request{
+		Type:   "pull",
+		Path:   path,
+		Remote: remote,
+		Token:  token,
+	}
+	if err := c.send(conn, &req); err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+
+	// 接收响应，拉取完成后服务器才会返回
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	if resp.Status != "ok" {
+		return responseError(&resp)
+	}
+
+	return nil
+}
+
+// RequestReload 请求服务器重新加载配置文件
+func (c *Client) RequestReload(token string) error {
+	conn, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	req := Request{
+		Type:  "reload",
+		Token: token,
+	}
+	if err := c.send(conn, &req); err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	if resp.Status != "ok" {
+		return responseError(&resp)
+	}
+
+	return nil
+}
+
+// RequestDelete 请求服务器删除 path 处的文件或目录（递归）
+func (c *Client) RequestDelete(token, path string) error {
+	conn, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	req := Request{
+		Type:  "delete",
+		Path:  path,
+		Token: token,
+	}
+	if err := c.send(conn, &req); err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	if resp.Status != "ok" {
+		return responseError(&resp)
+	}
+
+	return nil
+}
+
+// RequestMkdir 请求服务器创建目录 path（包括不存在的父目录），mode 为 0 时使用 0755
+func (c *Client) RequestMkdir(token, path string, mode os.FileMode) error {
+	conn, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	req := Request{
+		Type:  "mkdir",
+		Path:  path,
+		Token: token,
+		Mode:  int(mode.Perm()),
+	}
+	if err := c.send(conn, &req); err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	if resp.Status != "ok" {
+		return responseError(&resp)
+	}
+
+	return nil
+}
+
+// Move 请求服务器将 path 改名为 target（必要时创建目标的父目录），目标已存在时失败
+func (c *Client) Move(token, path, target string) error {
+	conn, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	req := Request{
+		Type:   "move",
+		Path:   path,
+		Target: target,
+		Token:  token,
+	}
+	if err := c.send(conn, &req); err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	if resp.Status != "ok" {
+		return responseError(&resp)
 	}
 
 	return nil
@@ -200,11 +968,65 @@ func (c *Client) DownloadFile(remotePath, localPath string, index int) error {
 
 // connect 连接到服务器
 func (c *Client) connect() (net.Conn, error) {
+	if c.source != nil {
+		return nil, errHTTPUnsupported
+	}
+	if c.canceled() {
+		return nil, ErrCanceled
+	}
 	addr := net.JoinHostPort(c.addr, fmt.Sprintf("%d", c.port))
+	start := time.Now()
+	if !utils.TryAcquireFiles(connFiles) {
+		// 池中的空闲连接一直占用名额，先关闭它们，避免等待永远不会归还的名额
+		c.CloseIdleConnections()
+		utils.AcquireFiles(connFiles)
+	}
 	conn, err := net.Dial("tcp", addr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to server: %v", err)
+		utils.ReleaseFiles(connFiles)
+		// 曾经连接成功的服务器拒绝连接时多半正在重启
+		if c.connected.Load() {
+			return nil, fmt.Errorf("%w: %w: %v", ErrConnectionLost, ErrConnect, err)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrConnect, err)
+	}
+	c.dialLatency.Store(int64(time.Since(start)))
+	c.connected.Store(true)
+
+	wc := &watchedConn{Conn: conn, done: make(chan struct{})}
+	if c.cancel != nil {
+		// 取消时断开连接，使阻塞在读写上的请求立即返回
+		go func() {
+			select {
+			case <-c.cancel:
+				wc.canceled.Store(true)
+				conn.Close()
+			case <-wc.done:
+			}
+		}()
+	}
+	return wc, nil
+}
+
+// canceled 检查 SetCancel 设置的取消信号是否已关闭
+func (c *Client) canceled() bool {
+	select {
+	case <-c.cancel:
+		return true
+	default:
+		return false
 	}
+}
 
-	return conn, nil
+// send 附上会话ID和客户端身份后发送请求
+func (c *Client) send(conn net.Conn, req *Request) error {
+	req.Session = c.session
+	req.Priority = c.priority
+	if req.Token == "" {
+		req.Token = c.identity
+	}
+	if wc, ok := conn.(*watchedConn); ok && wc.pooled {
+		req.KeepAlive = true
+	}
+	return json.NewEncoder(conn).Encode(req)
 }
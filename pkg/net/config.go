@@ -0,0 +1,128 @@
+package net
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+)
+
+// ServerConfig 服务器配置文件中可在运行时重新加载的设置
+type ServerConfig struct {
+	ControlToken       string `json:"controlToken,omitempty"`
+	IntegrityKey       string `json:"integrityKey,omitempty"`
+	SnapshotCmd        string `json:"snapshotCmd,omitempty"`
+	SnapshotReleaseCmd string `json:"snapshotReleaseCmd,omitempty"`
+	PostReceiveCmd     string `json:"postReceiveCmd,omitempty"`
+	MaxTransfers       int    `json:"maxTransfers,omitempty"`
+	// Clients 客户端身份，按令牌把客户端映射到各自的目录和权限
+	Clients []ClientIdentity `json:"clients,omitempty"`
+	// Peers 允许 pull 请求拉取的对端
+	Peers []PullPeer `json:"peers,omitempty"`
+}
+
+// LoadServerConfig 读取 JSON 格式的服务器配置文件
+func LoadServerConfig(path string) (ServerConfig, error) {
+	var cfg ServerConfig
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read config: %v", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse config %s: %v", path, err)
+	}
+
+	return cfg, nil
+}
+
+// SetConfigFile 设置配置文件并立即加载，base 为命令行指定的设置，配置文件中非空的项覆盖 base
+func (s *Server) SetConfigFile(path string, base ServerConfig) error {
+	s.configMutex.Lock()
+	s.configPath = path
+	s.configBase = base
+	s.configMutex.Unlock()
+
+	return s.Reload()
+}
+
+// Reload 重新读取配置文件并应用，正在进行的传输不受影响
+func (s *Server) Reload() error {
+	s.configMutex.RLock()
+	path := s.configPath
+	cfg := s.configBase
+	s.configMutex.RUnlock()
+
+	if path == "" {
+		return fmt.Errorf("no config file set")
+	}
+
+	loaded, err := LoadServerConfig(path)
+	if err != nil {
+		return err
+	}
+	if loaded.ControlToken != "" {
+		cfg.ControlToken = loaded.ControlToken
+	}
+	if loaded.IntegrityKey != "" {
+		cfg.IntegrityKey = loaded.IntegrityKey
+	}
+	if loaded.SnapshotCmd != "" {
+		cfg.SnapshotCmd = loaded.SnapshotCmd
+		cfg.SnapshotReleaseCmd = loaded.SnapshotReleaseCmd
+	}
+	if loaded.PostReceiveCmd != "" {
+		cfg.PostReceiveCmd = loaded.PostReceiveCmd
+	}
+	if loaded.MaxTransfers != 0 {
+		cfg.MaxTransfers = loaded.MaxTransfers
+	}
+	if len(loaded.Clients) > 0 {
+		cfg.Clients = loaded.Clients
+	}
+	if len(loaded.Peers) > 0 {
+		cfg.Peers = loaded.Peers
+	}
+	if err := checkIdentities(cfg.Clients); err != nil {
+		return err
+	}
+	if err := checkPeers(cfg.Peers); err != nil {
+		return err
+	}
+
+	s.configMutex.Lock()
+	s.controlToken = cfg.ControlToken
+	s.integrityKey = []byte(cfg.IntegrityKey)
+	s.postReceive = cfg.PostReceiveCmd
+	s.clients = cfg.Clients
+	s.peers = cfg.Peers
+	s.configMutex.Unlock()
+
+	s.SetMaxTransfers(cfg.MaxTransfers)
+	if cfg.SnapshotCmd != "" {
+		s.SetSnapshotHooks(cfg.SnapshotCmd, cfg.SnapshotReleaseCmd)
+	}
+
+	fmt.Printf("Loaded config: %s\n", path)
+	return nil
+}
+
+// handleReloadRequest 处理控制请求：重新加载配置文件
+func (s *Server) handleReloadRequest(conn net.Conn, req Request) {
+	if !s.authorizeControl(conn, req) {
+		return
+	}
+
+	logf(conn, "Config reload requested by %s\n", conn.RemoteAddr())
+	if err := s.Reload(); err != nil {
+		s.sendError(conn, fmt.Sprintf("Reload failed: %v", err))
+		return
+	}
+
+	resp := Response{
+		Status: "ok",
+	}
+	if err := json.NewEncoder(conn).Encode(&resp); err != nil {
+		logf(conn, "Failed to send response: %v\n", err)
+	}
+}
@@ -0,0 +1,217 @@
+package net
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// CapKeepAlive 服务器在同一个连接上依次处理多个请求的能力，客户端只在 probe 返回这个能力后复用连接
+const CapKeepAlive = "keepalive"
+
+// keepAliveRequests 可以在保持的连接上处理的请求：响应只有 JSON（和压缩列表），客户端总能读完整个响应
+var keepAliveRequests = map[string]bool{"list": true, "stat": true, "checksum": true, "signature": true, "ping": true, "browse": true, "find": true, "dict": true}
+
+// keepAliveTimeout 服务器等待保持的连接上下一个请求的最长时间，需要比客户端的空闲超时长
+const keepAliveTimeout = 2 * time.Minute
+
+// 客户端连接池的默认值
+const (
+	DefaultPoolSize = 4                // 命令行默认保留的空闲连接数
+	DefaultPoolIdle = 30 * time.Second // 空闲连接保留的时间
+)
+
+// 从池中取出空闲超过 poolPingAfter 的连接时先发送 ping，确认连接仍然可用（例如没有被中间的防火墙丢弃）
+const (
+	poolPingAfter   = 5 * time.Second
+	poolPingTimeout = 2 * time.Second
+)
+
+// idleConn 池中的空闲连接
+type idleConn struct {
+	conn  *watchedConn
+	since time.Time
+}
+
+// connPool 列表、stat、checksum 和签名请求复用的连接，避免每个请求都建立新连接，
+// 减少并发请求时的连接风暴和客户端的 TIME_WAIT 连接
+type connPool struct {
+	mutex     sync.Mutex
+	size      int           // 最多保留的空闲连接数，0 表示不复用
+	idle      time.Duration // 空闲连接保留的时间
+	supported bool          // 服务器在 probe 中声明了 CapKeepAlive
+	conns     []idleConn    // 最近归还的在最后
+	timer     *time.Timer   // 最早归还的连接到期时调用 expire，池为空时为 nil
+}
+
+// SetConnPool 设置最多保留的空闲连接数和保留时间，size 为 0 时每个请求新建连接，idle 为 0 时使用 DefaultPoolIdle。
+// 服务器在 probe 响应中声明 CapKeepAlive 之后才会复用连接
+func (c *Client) SetConnPool(size int, idle time.Duration) {
+	if idle <= 0 {
+		idle = DefaultPoolIdle
+	}
+	c.pool.mutex.Lock()
+	c.pool.size = size
+	c.pool.idle = idle
+	c.pool.mutex.Unlock()
+}
+
+// setKeepAlive 记录服务器是否支持在同一个连接上处理多个请求
+func (p *connPool) setKeepAlive(supported bool) {
+	p.mutex.Lock()
+	p.supported = supported
+	p.mutex.Unlock()
+}
+
+// take 取出最近归还的未过期连接，没有时返回 nil，enabled 表示是否使用连接池
+func (p *connPool) take() (conn *watchedConn, since time.Time, enabled bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.size <= 0 || !p.supported {
+		return nil, time.Time{}, false
+	}
+	for len(p.conns) > 0 {
+		last := p.conns[len(p.conns)-1]
+		p.conns = p.conns[:len(p.conns)-1]
+		if time.Since(last.since) > p.idle {
+			last.conn.Close()
+			continue
+		}
+		return last.conn, last.since, true
+	}
+	return nil, time.Time{}, true
+}
+
+// put 归还连接，池已满时关闭最早归还的连接
+func (p *connPool) put(conn *watchedConn) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.size <= 0 || !p.supported {
+		conn.Close()
+		return
+	}
+	if len(p.conns) >= p.size {
+		p.conns[0].conn.Close()
+		p.conns = p.conns[1:]
+	}
+	p.conns = append(p.conns, idleConn{conn: conn, since: time.Now()})
+	p.schedule()
+}
+
+// schedule 池中有连接且还没有定时器时，在最早归还的连接到期后调用 expire，调用时需持有锁
+func (p *connPool) schedule() {
+	if p.timer != nil || len(p.conns) == 0 {
+		return
+	}
+	p.timer = time.AfterFunc(time.Until(p.conns[0].since.Add(p.idle)), p.expire)
+}
+
+// expire 关闭空闲超时的连接，归还它们占用的文件描述符名额。
+// 不等到下一次 take 或 put，否则不再发出请求的客户端会一直占用名额，使其他连接在 AcquireFiles 中等待
+func (p *connPool) expire() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.timer = nil
+	for len(p.conns) > 0 && time.Since(p.conns[0].since) >= p.idle {
+		p.conns[0].conn.Close()
+		p.conns = p.conns[1:]
+	}
+	p.schedule()
+}
+
+// CloseIdleConnections 关闭池中的空闲连接，同步或推送结束后调用
+func (c *Client) CloseIdleConnections() {
+	c.pool.mutex.Lock()
+	conns := c.pool.conns
+	c.pool.conns = nil
+	if c.pool.timer != nil {
+		c.pool.timer.Stop()
+		c.pool.timer = nil
+	}
+	c.pool.mutex.Unlock()
+
+	for _, idle := range conns {
+		idle.conn.Close()
+	}
+}
+
+// pooledConnect 为可以保持连接的请求取得连接：优先使用池中的空闲连接，空闲较久的先用 ping 检查，
+// 不可用时关闭并建立新连接。通过 send 发送的请求会带上 KeepAlive，完成后用 release 归还
+func (c *Client) pooledConnect() (net.Conn, error) {
+	for {
+		if c.canceled() {
+			return nil, ErrCanceled
+		}
+		conn, since, enabled := c.pool.take()
+		if !enabled {
+			return c.connect()
+		}
+		if conn == nil {
+			conn, err := c.connect()
+			if err != nil {
+				return nil, err
+			}
+			wc := conn.(*watchedConn)
+			wc.pooled = true
+			return wc, nil
+		}
+		if !peerClosed(conn) && (time.Since(since) < poolPingAfter || c.pingPooled(conn) == nil) {
+			return conn, nil
+		}
+		conn.Close()
+	}
+}
+
+// peerClosed 用很短的读取超时检查服务器是否已关闭空闲连接（超时或重启），不发送数据。
+// 空闲连接上不应有可读的数据，读到数据也视为不可用
+func peerClosed(conn *watchedConn) bool {
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	defer conn.SetReadDeadline(time.Time{})
+
+	var b [1]byte
+	_, err := conn.Conn.Read(b[:])
+	var netErr net.Error
+	return !errors.As(err, &netErr) || !netErr.Timeout()
+}
+
+// pingPooled 在空闲连接上发送 ping，确认服务器没有因超时或重启关闭连接
+func (c *Client) pingPooled(conn *watchedConn) error {
+	conn.SetDeadline(time.Now().Add(poolPingTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	req := Request{
+		Type: "ping",
+	}
+	if err := c.send(conn, &req); err != nil {
+		return err
+	}
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return err
+	}
+	if resp.Status != "ok" {
+		return responseError(&resp)
+	}
+	return nil
+}
+
+// release 请求结束后归还 pooledConnect 取得的连接。请求成功或服务器返回了完整的错误响应时连接可以复用，
+// 其他错误（读写失败、响应不完整）时关闭连接
+func (c *Client) release(conn net.Conn, err error) {
+	wc, ok := conn.(*watchedConn)
+	if !ok || !wc.pooled || wc.broken.Load() || c.canceled() {
+		conn.Close()
+		return
+	}
+	var serverErr *ServerError
+	if err != nil && !errors.As(err, &serverErr) {
+		conn.Close()
+		return
+	}
+	c.pool.put(wc)
+}
@@ -0,0 +1,572 @@
+package net
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gorsync/pkg/utils"
+)
+
+// 增量上传的块大小范围，实际大小约为文件大小的平方根
+const (
+	minDeltaBlock = 2 * 1024
+	maxDeltaBlock = 128 * 1024
+)
+
+// Signature 服务器上目标文件的块签名，客户端据此找出本地文件中服务器已有的块
+type Signature struct {
+	BlockSize int        `json:"blockSize"`
+	Size      int64      `json:"size"`             // 目标文件大小，文件不存在时为 0
+	Blocks    []BlockSum `json:"blocks,omitempty"` // 每个完整块的校验和，末尾不足一块的部分不参与匹配
+}
+
+// BlockSum 单个块的弱校验和（可滚动计算）与强校验和（MD5）
+type BlockSum struct {
+	Weak   uint32 `json:"weak"`
+	Strong string `json:"strong"`
+}
+
+// Delta 上传请求附带的补丁脚本，按顺序执行 Ops 得到新文件
+type Delta struct {
+	BlockSize int       `json:"blockSize"`
+	Ops       []DeltaOp `json:"ops"`
+}
+
+// DeltaOp 补丁脚本中的一步：Count 大于 0 时复制目标文件从第 Block 块开始的 Count 块，
+// 否则写入紧跟在请求之后发送的 Length 字节新数据
+type DeltaOp struct {
+	Block  int   `json:"block,omitempty"`
+	Count  int   `json:"count,omitempty"`
+	Length int64 `json:"length,omitempty"`
+	offset int64 // 新数据在本地文件中的位置，只在客户端使用
+}
+
+// deltaBlockSize 按文件大小选择块大小
+func deltaBlockSize(size int64) int {
+	block := int(math.Sqrt(float64(size)))
+	return min(max(block, minDeltaBlock), maxDeltaBlock)
+}
+
+// weakSum rsync 式的滚动校验和，a 为字节之和，b 为按位置加权的和
+type weakSum struct {
+	a, b uint32
+	n    uint32
+}
+
+// newWeakSum 计算 block 的弱校验和
+func newWeakSum(block []byte) weakSum {
+	w := weakSum{n: uint32(len(block))}
+	for i, c := range block {
+		w.a += uint32(c)
+		w.b += uint32(len(block)-i) * uint32(c)
+	}
+	return w
+}
+
+// roll 窗口向后移动一个字节：移出 out，移入 in
+func (w *weakSum) roll(out, in byte) {
+	w.a += uint32(in) - uint32(out)
+	w.b += w.a - w.n*uint32(out)
+}
+
+// sum 返回32位的弱校验和
+func (w *weakSum) sum() uint32 {
+	return w.a&0xffff | w.b<<16
+}
+
+// strongSum 返回块的强校验和
+func strongSum(block []byte) string {
+	sum := md5.Sum(block)
+	return hex.EncodeToString(sum[:])
+}
+
+// computeSignature 计算文件每个完整块的校验和，blockSize 为 0 时按文件大小选择块大小
+func computeSignature(path string, blockSize int) (*Signature, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if blockSize == 0 {
+		blockSize = deltaBlockSize(info.Size())
+	}
+	sig := &Signature{
+		BlockSize: blockSize,
+		Size:      info.Size(),
+	}
+	reader := bufio.NewReader(file)
+	block := make([]byte, sig.BlockSize)
+	for i := int64(0); i+int64(sig.BlockSize) <= info.Size(); i += int64(sig.BlockSize) {
+		if _, err := io.ReadFull(reader, block); err != nil {
+			return nil, err
+		}
+		weak := newWeakSum(block)
+		sig.Blocks = append(sig.Blocks, BlockSum{Weak: weak.sum(), Strong: strongSum(block)})
+	}
+	return sig, nil
+}
+
+// computeDelta 在本地文件中滚动查找签名里的块，生成补丁脚本，返回脚本和需要发送的新数据字节数
+func computeDelta(file io.ReaderAt, size int64, sig *Signature) ([]DeltaOp, int64, error) {
+	blockSize := sig.BlockSize
+	table := make(map[uint32][]int, len(sig.Blocks))
+	for i, b := range sig.Blocks {
+		table[b.Weak] = append(table[b.Weak], i)
+	}
+
+	var ops []DeltaOp
+	var literal int64
+	addLiteral := func(from, to int64) {
+		if to > from {
+			ops = append(ops, DeltaOp{Length: to - from, offset: from})
+			literal += to - from
+		}
+	}
+
+	// buf 保存从 bufStart 开始读入的数据，窗口为 [pos, pos+blockSize)
+	buf := make([]byte, 0, 4*blockSize)
+	bufStart := int64(0)
+	window := func(pos int64) ([]byte, error) {
+		end := pos + int64(blockSize)
+		if end > bufStart+int64(len(buf)) {
+			// 丢弃窗口之前的数据后继续读入
+			n := copy(buf[:cap(buf)], buf[pos-bufStart:])
+			buf = buf[:cap(buf)]
+			bufStart = pos
+			read, err := file.ReadAt(buf[n:], bufStart+int64(n))
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			buf = buf[:n+read]
+		}
+		return buf[pos-bufStart : end-bufStart], nil
+	}
+
+	literalStart := int64(0)
+	var weak weakSum
+	rolling := false
+	for pos := int64(0); len(sig.Blocks) > 0 && pos+int64(blockSize) <= size; {
+		block, err := window(pos)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !rolling {
+			weak = newWeakSum(block)
+			rolling = true
+		}
+
+		match := -1
+		if candidates, ok := table[weak.sum()]; ok {
+			strong := strongSum(block)
+			for _, i := range candidates {
+				if sig.Blocks[i].Strong == strong {
+					match = i
+					break
+				}
+			}
+		}
+
+		if match >= 0 {
+			addLiteral(literalStart, pos)
+			if last := len(ops) - 1; last >= 0 && ops[last].Count > 0 && ops[last].Block+ops[last].Count == match {
+				ops[last].Count++
+			} else {
+				ops = append(ops, DeltaOp{Block: match, Count: 1})
+			}
+			pos += int64(blockSize)
+			literalStart = pos
+			rolling = false
+			continue
+		}
+
+		if pos+int64(blockSize) == size {
+			break
+		}
+		// 读入下一个窗口可能移动缓冲区，先取出移出窗口的字节
+		out := block[0]
+		next, err := window(pos + 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		weak.roll(out, next[blockSize-1])
+		pos++
+	}
+	addLiteral(literalStart, size)
+
+	return ops, literal, nil
+}
+
+// handleSignatureRequest 处理控制请求：返回目标文件的块签名，文件不存在时返回空签名
+func (s *Server) handleSignatureRequest(conn net.Conn, req Request) {
+	if !s.authorizeWrite(conn, req) {
+		return
+	}
+
+	fullPath, err := s.managedPath(req.Path)
+	if err != nil {
+		s.sendError(conn, err.Error())
+		return
+	}
+
+	// 客户端按策略指定的块大小限制在允许的范围内
+	blockSize := 0
+	if req.BlockSize > 0 {
+		blockSize = min(max(req.BlockSize, minPolicyBlock), maxPolicyBlock)
+	}
+	sig := &Signature{BlockSize: minDeltaBlock}
+	if info, err := os.Stat(fullPath); err == nil {
+		if info.IsDir() {
+			s.sendError(conn, "Path is a directory")
+			return
+		}
+		if sig, err = computeSignature(fullPath, blockSize); err != nil {
+			s.sendError(conn, fmt.Sprintf("Failed to read file: %v", err))
+			return
+		}
+	} else if !os.IsNotExist(err) {
+		s.sendError(conn, fmt.Sprintf("Failed to stat file: %v", err))
+		return
+	}
+
+	resp := Response{
+		Status:    "ok",
+		Signature: sig,
+	}
+	if err := json.NewEncoder(conn).Encode(&resp); err != nil {
+		logf(conn, "Failed to send response: %v\n", err)
+	}
+}
+
+// handleUploadRequest 处理控制请求：按补丁脚本用目标文件已有的块和 body 中的新数据组装新文件，
+// 校验大小和MD5后原子地替换目标文件
+func (s *Server) handleUploadRequest(conn net.Conn, body io.Reader, req Request) {
+	if !s.authorizeWrite(conn, req) {
+		return
+	}
+
+	fullPath, err := s.managedPath(req.Path)
+	if err != nil {
+		s.sendError(conn, err.Error())
+		return
+	}
+	if req.Upload == nil || req.Delta == nil || req.Delta.BlockSize <= 0 {
+		s.sendError(conn, "upload request requires file info and a delta")
+		return
+	}
+	if req.Txn != "" && !validSession(req.Txn) {
+		s.sendError(conn, "invalid transaction ID")
+		return
+	}
+	if err := ValidateMode(req.Upload.Mode, ""); err != nil {
+		s.sendError(conn, err.Error())
+		return
+	}
+
+	// 请求以换行结束，新数据从换行之后开始
+	var newline [1]byte
+	if _, err := io.ReadFull(body, newline[:]); err != nil || newline[0] != '\n' {
+		s.sendError(conn, "malformed upload request")
+		return
+	}
+
+	logf(conn, "Upload requested by %s: %s (%d bytes)\n", conn.RemoteAddr(), fullPath, req.Upload.Size)
+	tempPath, err := s.assembleDelta(fullPath, body, req)
+	if err != nil {
+		logf(conn, "Upload of %s failed: %v\n", fullPath, err)
+		s.sendErrorCode(conn, errorCode(err), fmt.Sprintf("Upload failed: %v", err))
+		return
+	}
+	if req.Txn != "" && req.Staged {
+		// 事务上传只暂存，提交时才替换目标文件
+		s.txns.stage(req.Txn, tempPath, fullPath, req.Path)
+	} else {
+		if err := utils.Saferename(tempPath, fullPath); err != nil {
+			os.Remove(tempPath)
+			s.sendErrorCode(conn, errorCode(err), fmt.Sprintf("Upload failed: %v", err))
+			return
+		}
+		if req.Txn != "" {
+			s.txns.stage(req.Txn, "", fullPath, req.Path)
+		}
+	}
+
+	resp := Response{
+		Status: "ok",
+	}
+	if err := json.NewEncoder(conn).Encode(&resp); err != nil {
+		logf(conn, "Failed to send response: %v\n", err)
+	}
+}
+
+// assembleDelta 在目标文件旁的临时文件中执行补丁脚本，校验通过后返回临时文件的路径。
+// 临时文件与目标在同一目录（同一文件系统），替换时不会跨设备复制；写入失败或客户端断开时删除临时文件，
+// 目标文件保持不变
+func (s *Server) assembleDelta(fullPath string, body io.Reader, req Request) (string, error) {
+	dir := filepath.Dir(fullPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	// 替换前新旧文件同时存在，需要完整的新文件大小；无法取得可用空间时不检查，写满时仍会得到 ENOSPC
+	if free, err := utils.FreeSpace(dir); err == nil && free < uint64(req.Upload.Size) {
+		return "", fmt.Errorf("%w: %s needed, %s available", ErrNoSpace,
+			utils.FormatSize(req.Upload.Size), utils.FormatSize(int64(free)))
+	}
+
+	var base *os.File
+	if f, err := os.Open(fullPath); err == nil {
+		base = f
+		defer base.Close()
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	tempPath := utils.MakeTempName(fullPath)
+	temp, err := os.OpenFile(tempPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer temp.Close()
+
+	if err := writeDelta(temp, base, body, req); err != nil {
+		temp.Close()
+		os.Remove(tempPath)
+		return "", err
+	}
+	return tempPath, nil
+}
+
+// writeDelta 执行补丁脚本写入 temp，校验大小和MD5并设置权限和修改时间
+func writeDelta(temp, base *os.File, body io.Reader, req Request) error {
+	sum := md5.New()
+	out := io.MultiWriter(temp, sum)
+	blockSize := int64(req.Delta.BlockSize)
+	for _, op := range req.Delta.Ops {
+		if op.Count > 0 {
+			if base == nil {
+				return fmt.Errorf("delta refers to blocks of a file that does not exist")
+			}
+			length := int64(op.Count) * blockSize
+			n, err := io.Copy(out, io.NewSectionReader(base, int64(op.Block)*blockSize, length))
+			if err != nil {
+				return err
+			}
+			if n != length {
+				return fmt.Errorf("file changed during upload: block %d is past the end", op.Block)
+			}
+			continue
+		}
+		if _, err := io.CopyN(out, body, op.Length); err != nil {
+			return fmt.Errorf("failed to receive file data: %w", err)
+		}
+	}
+
+	info, err := temp.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() != req.Upload.Size {
+		return fmt.Errorf("size mismatch: expected %d bytes, assembled %d", req.Upload.Size, info.Size())
+	}
+	if got := hex.EncodeToString(sum.Sum(nil)); got != req.Upload.MD5 {
+		return fmt.Errorf("file content mismatch: client MD5 %s, assembled MD5 %s", req.Upload.MD5, got)
+	}
+
+	// 服务器不接受客户端上传的 setuid 和 setgid
+	mode := FileMode(req.Upload.Mode, false)
+	if mode == 0 {
+		mode = 0644
+	}
+	if err := temp.Chmod(mode); err != nil {
+		return err
+	}
+	temp.Close()
+	if req.Upload.ModTime > 0 {
+		modTime := req.Upload.Modified()
+		if err := os.Chtimes(temp.Name(), modTime, modTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Signature 请求服务器返回目标文件的块签名，blockSize 为 0 时由服务器按文件大小选择块大小
+func (c *Client) Signature(token, path string, blockSize int) (sig *Signature, err error) {
+	conn, err := c.pooledConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { c.release(conn, err) }()
+
+	req := Request{
+		Type:      "signature",
+		Path:      path,
+		Token:     token,
+		BlockSize: blockSize,
+	}
+	if err := c.send(conn, &req); err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	if resp.Status != "ok" {
+		return nil, responseError(&resp)
+	}
+	if resp.Signature == nil || resp.Signature.BlockSize <= 0 {
+		return nil, fmt.Errorf("no signature in response")
+	}
+
+	return resp.Signature, nil
+}
+
+// UploadFile 把本地文件增量上传到服务器的 remotePath：先获取目标文件的块签名，
+// 只发送服务器没有的数据和补丁脚本，返回实际发送的新数据字节数
+func (c *Client) UploadFile(token, localPath, remotePath string, index int) (int64, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open source file: %v", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat source file: %v", err)
+	}
+	localMD5, err := utils.CalculateMD5(localPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to calculate source file MD5: %v", err)
+	}
+
+	// 策略关闭增量时不请求签名，整个文件作为新数据发送
+	policy := matchPolicy(c.policies, remotePath)
+	var sig *Signature
+	var ops []DeltaOp
+	literal := info.Size()
+	if policy.Delta != nil && !*policy.Delta {
+		sig = &Signature{BlockSize: minDeltaBlock}
+		if literal > 0 {
+			ops = []DeltaOp{{Length: literal}}
+		}
+	} else {
+		sig, err = c.Signature(token, remotePath, policy.BlockSize)
+		if err != nil {
+			return 0, err
+		}
+		ops, literal, err = computeDelta(file, info.Size(), sig)
+		if err != nil {
+			return 0, fmt.Errorf("failed to compute delta: %v", err)
+		}
+	}
+	if !c.quiet {
+		c.printf("%d. Uploading %s: sending %s of %s\n", index, remotePath, utils.FormatSize(literal), utils.FormatSize(info.Size()))
+	}
+
+	conn, err := c.connect()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	req := Request{
+		Type:  "upload",
+		Path:  remotePath,
+		Token: token,
+		Upload: &FileInfo{
+			Size:     info.Size(),
+			ModTime:  info.ModTime().Unix(),
+			ModNanos: info.ModTime().Nanosecond(),
+			Mode:     EncodeMode(info.Mode()),
+			MD5:      localMD5,
+		},
+		Delta:  &Delta{BlockSize: sig.BlockSize, Ops: ops},
+		Txn:    c.txn,
+		Staged: c.staged,
+	}
+	if err := c.send(conn, &req); err != nil {
+		return 0, fmt.Errorf("failed to send request: %v", err)
+	}
+	// 服务器可能不读完新数据就拒绝上传（例如空间不足）并关闭连接，此时发送失败，优先返回服务器的错误响应
+	sendFailed := func(err error) error {
+		if respErr := earlyResponse(conn); respErr != nil {
+			return respErr
+		}
+		return fmt.Errorf("failed to send file data: %v", err)
+	}
+
+	// 新数据按脚本顺序紧跟在请求之后
+	writer := bufio.NewWriter(conn)
+	pooled := utils.GetBuffer()
+	defer utils.PutBuffer(pooled)
+	buffer := *pooled
+	for _, op := range ops {
+		if op.Count > 0 {
+			continue
+		}
+		section := io.NewSectionReader(file, op.offset, op.Length)
+		for {
+			if err := c.waitIfPaused(); err != nil {
+				return 0, err
+			}
+
+			n, err := section.Read(buffer)
+			if n > 0 {
+				if _, err := writer.Write(buffer[:n]); err != nil {
+					return 0, sendFailed(err)
+				}
+				if c.limiter != nil {
+					c.limiter.Wait(n)
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return 0, fmt.Errorf("failed to read source file: %v", err)
+			}
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return 0, sendFailed(err)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return 0, fmt.Errorf("failed to decode response: %v", err)
+	}
+	if resp.Status != "ok" {
+		return 0, responseError(&resp)
+	}
+
+	return literal, nil
+}
+
+// earlyResponseTimeout 发送失败后等待服务器错误响应的时间
+const earlyResponseTimeout = 2 * time.Second
+
+// earlyResponse 读取服务器在上传数据发送完之前返回的错误响应，没有时返回 nil
+func earlyResponse(conn net.Conn) error {
+	conn.SetReadDeadline(time.Now().Add(earlyResponseTimeout))
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil || resp.Status == "ok" {
+		return nil
+	}
+	return responseError(&resp)
+}
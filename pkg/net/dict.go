@@ -0,0 +1,419 @@
+package net
+
+import (
+	"compress/flate"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxDictSize deflate 只能引用之前 32KB 内的数据，更大的字典没有作用
+	MaxDictSize = 32 * 1024
+	// DictMaxFileSize 不超过这个大小的文件才用字典压缩，大文件自身的内容就足以压缩
+	DictMaxFileSize = 64 * 1024
+	// dictSampleBytes 训练时最多读取的样本总量
+	dictSampleBytes = 4 << 20
+	// dictExpiry 字典最后一次使用后保留的时间
+	dictExpiry = time.Hour
+	// maxDicts 服务器最多保留的字典数，超出时丢弃最久未使用的
+	maxDicts = 32
+)
+
+// 训练字典的参数：统计 dictShingle 字节的片段出现在多少个样本中，按 dictSegment 字节的段选取内容
+const (
+	dictShingle = 8
+	dictSegment = 64
+	dictBuckets = 1 << 20
+)
+
+// Dictionary 会话的压缩字典，ID 为内容的 SHA-256 前缀
+type Dictionary struct {
+	ID   string `json:"id"`
+	Data []byte `json:"data"`
+}
+
+// dictID 返回字典内容的ID
+func dictID(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+// shingleBucket 返回片段的哈希桶
+func shingleBucket(b []byte) uint32 {
+	return uint32(binary.LittleEndian.Uint64(b) * 0x9E3779B97F4A7C15 >> (64 - 20))
+}
+
+// TrainDictionary 从样本中选出在多个样本中反复出现的内容组成字典，样本少于两个或没有共同内容时返回 nil。
+// 每个段按其中的片段出现在多少个样本中评分，选取得分最高且不重复的段直到 MaxDictSize
+func TrainDictionary(samples [][]byte) []byte {
+	if len(samples) < 2 {
+		return nil
+	}
+
+	// 每个片段出现在多少个样本中，同一样本中重复出现只计一次
+	counts := make([]uint16, dictBuckets)
+	last := make([]int32, dictBuckets)
+	for i, sample := range samples {
+		for j := 0; j+dictShingle <= len(sample); j++ {
+			b := shingleBucket(sample[j : j+dictShingle])
+			if last[b] != int32(i+1) {
+				last[b] = int32(i + 1)
+				if counts[b] < math.MaxUint16 {
+					counts[b]++
+				}
+			}
+		}
+	}
+
+	type segment struct {
+		data  []byte
+		score int
+	}
+	var segments []segment
+	for _, sample := range samples {
+		for start := 0; start+dictShingle <= len(sample); start += dictSegment {
+			end := min(start+dictSegment, len(sample))
+			score := 0
+			for j := start; j+dictShingle <= end; j++ {
+				if c := counts[shingleBucket(sample[j:j+dictShingle])]; c >= 2 {
+					score += int(c)
+				}
+			}
+			if score > 0 {
+				segments = append(segments, segment{data: sample[start:end], score: score})
+			}
+		}
+	}
+	sort.SliceStable(segments, func(a, b int) bool {
+		return segments[a].score > segments[b].score
+	})
+
+	seen := make(map[string]bool)
+	var chosen [][]byte
+	size := 0
+	for _, seg := range segments {
+		if size+len(seg.data) > MaxDictSize || seen[string(seg.data)] {
+			continue
+		}
+		seen[string(seg.data)] = true
+		chosen = append(chosen, seg.data)
+		size += len(seg.data)
+	}
+	if size == 0 {
+		return nil
+	}
+
+	// 引用越近的数据编码越短，得分最高的段放在字典末尾
+	dict := make([]byte, 0, size)
+	for i := len(chosen) - 1; i >= 0; i-- {
+		dict = append(dict, chosen[i]...)
+	}
+	return dict
+}
+
+// storedDict 服务器保留的字典
+type storedDict struct {
+	data []byte
+	used time.Time
+}
+
+// pathDict 从一个目录训练的结果
+type pathDict struct {
+	id      string    // 字典ID，样本不足时为空
+	trained time.Time // 训练的时间
+}
+
+// dictTable 服务器训练的字典，以ID为键
+type dictTable struct {
+	mutex    sync.Mutex
+	dicts    map[string]*storedDict
+	paths    map[string]pathDict // 样本目录 -> 训练结果，同一目录的请求复用字典而不是重新训练
+	training sync.Mutex          // 同时只训练一个字典
+}
+
+// lookup 返回从 root 训练且仍保留的字典，found 为 false 时需要训练。
+// 样本不足的结果在 dictExpiry 内也算找到，此时返回的字典为 nil
+func (t *dictTable) lookup(root string) (dict *Dictionary, found bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.expire()
+	entry, ok := t.paths[root]
+	if !ok {
+		return nil, false
+	}
+	if entry.id == "" {
+		return nil, true
+	}
+	d, ok := t.dicts[entry.id]
+	if !ok {
+		delete(t.paths, root)
+		return nil, false
+	}
+	d.used = time.Now()
+	return &Dictionary{ID: entry.id, Data: d.data}, true
+}
+
+// train 返回从 root 训练的字典，没有保留的结果时调用 train 训练并保留结果，cached 表示复用了之前的结果。
+// 同时只训练一个字典，等待的请求在前一个训练完成后先检查能否复用；
+// train 返回 nil（样本不足）的结果也保留 dictExpiry，期间不再遍历该目录
+func (t *dictTable) train(root string, train func() ([]byte, error)) (dict *Dictionary, cached bool, err error) {
+	if dict, found := t.lookup(root); found {
+		return dict, true, nil
+	}
+
+	t.training.Lock()
+	defer t.training.Unlock()
+
+	if dict, found := t.lookup(root); found {
+		return dict, true, nil
+	}
+	data, err := train()
+	if err != nil {
+		return nil, false, err
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.paths == nil {
+		t.paths = make(map[string]pathDict)
+	}
+	if data == nil {
+		t.paths[root] = pathDict{trained: time.Now()}
+		return nil, false, nil
+	}
+	id := dictID(data)
+	t.put(id, data)
+	t.paths[root] = pathDict{id: id, trained: time.Now()}
+	return &Dictionary{ID: id, Data: data}, false, nil
+}
+
+// put 保存字典，超出 maxDicts 时丢弃最久未使用的字典，调用时需持有锁
+func (t *dictTable) put(id string, data []byte) {
+	t.expire()
+	if t.dicts == nil {
+		t.dicts = make(map[string]*storedDict)
+	}
+	if d, ok := t.dicts[id]; ok {
+		d.used = time.Now()
+		return
+	}
+	if len(t.dicts) >= maxDicts {
+		oldest := ""
+		for id, d := range t.dicts {
+			if oldest == "" || d.used.Before(t.dicts[oldest].used) {
+				oldest = id
+			}
+		}
+		delete(t.dicts, oldest)
+	}
+	t.dicts[id] = &storedDict{data: data, used: time.Now()}
+}
+
+// get 返回字典的内容，不存在时返回 nil
+func (t *dictTable) get(id string) []byte {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.expire()
+	d, ok := t.dicts[id]
+	if !ok {
+		return nil
+	}
+	d.used = time.Now()
+	return d.data
+}
+
+// expire 丢弃超过 dictExpiry 未使用的字典，以及字典已被丢弃或样本不足超过 dictExpiry 的目录，调用时需持有锁
+func (t *dictTable) expire() {
+	for id, d := range t.dicts {
+		if time.Since(d.used) > dictExpiry {
+			delete(t.dicts, id)
+		}
+	}
+	for root, entry := range t.paths {
+		if _, ok := t.dicts[entry.id]; !ok && (entry.id != "" || time.Since(entry.trained) > dictExpiry) {
+			delete(t.paths, root)
+		}
+	}
+}
+
+// readDictSamples 读取 root 下不超过 DictMaxFileSize 的文件作为训练样本，总量达到 dictSampleBytes 后停止
+func readDictSamples(root string) ([][]byte, error) {
+	var samples [][]byte
+	total := 0
+	err := filepath.Walk(root, func(walkPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			// 根目录本身无法访问时直接失败，其余路径跳过
+			if walkPath == root && info == nil {
+				return err
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || info.Size() == 0 || info.Size() > DictMaxFileSize {
+			return nil
+		}
+		data, err := os.ReadFile(walkPath)
+		if err != nil {
+			return nil
+		}
+		samples = append(samples, data)
+		total += len(data)
+		if total >= dictSampleBytes {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return samples, err
+}
+
+// handleDictRequest 返回从请求路径下的小文件训练的压缩字典的ID和内容，同一目录之前训练的字典仍保留时直接复用；
+// 样本不足时返回的响应不带字典
+func (s *Server) handleDictRequest(conn net.Conn, req Request) {
+	if !s.authorizeDict(conn, req) {
+		return
+	}
+
+	var fullPath string
+	if s.rootDir == "" {
+		fullPath = req.Path
+	} else {
+		fullPath = LocalPath(s.rootDir, req.Path)
+	}
+
+	dict, cached, err := s.dicts.train(fullPath, func() ([]byte, error) {
+		samples, err := readDictSamples(fullPath)
+		if err != nil {
+			return nil, err
+		}
+		data := TrainDictionary(samples)
+		if data != nil {
+			logf(conn, "Trained a %d-byte dictionary %s from %d file(s) in %s\n", len(data), dictID(data), len(samples), req.Path)
+		}
+		return data, nil
+	})
+	if err != nil {
+		s.sendErrorCode(conn, errorCode(err), fmt.Sprintf("Failed to read samples: %v", err))
+		return
+	}
+	if dict != nil && cached {
+		logf(conn, "Reusing dictionary %s for %s\n", dict.ID, req.Path)
+	}
+	resp := Response{
+		Status: "ok",
+		Dict:   dict,
+	}
+	if err := json.NewEncoder(conn).Encode(&resp); err != nil {
+		logf(conn, "Failed to send response: %v\n", err)
+	}
+}
+
+// authorizeDict 训练字典需要读取请求路径下的大量文件，保留的字典还会挤掉其他会话的字典。
+// 服务器配置了控制令牌或客户端身份时只接受带有客户端身份或控制令牌的请求，都没有配置时服务器对所有客户端开放
+func (s *Server) authorizeDict(conn net.Conn, req Request) bool {
+	if req.identity != nil {
+		return true
+	}
+
+	s.configMutex.RLock()
+	token := s.controlToken
+	open := token == "" && len(s.clients) == 0
+	s.configMutex.RUnlock()
+
+	if open || token != "" && subtle.ConstantTimeCompare([]byte(req.Token), []byte(token)) == 1 {
+		return true
+	}
+	s.sendErrorCode(conn, ErrorCodeAuth, "Dictionary requests require a client identity or the control token")
+	logf(conn, "Rejected %s request from %s: no client identity\n", req.Type, conn.RemoteAddr())
+	return false
+}
+
+// FetchDictionary 请求服务器用 path 下的小文件训练压缩字典，之后不超过 DictMaxFileSize 的文件用字典压缩传输。
+// 服务器找不到足够的相似样本时返回 nil，继续逐个文件压缩
+func (c *Client) FetchDictionary(path string) (dict *Dictionary, err error) {
+	conn, err := c.pooledConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { c.release(conn, err) }()
+
+	req := Request{
+		Type: "dict",
+		Path: path,
+	}
+	if err := c.send(conn, &req); err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+	if resp.Status != "ok" {
+		return nil, responseError(&resp)
+	}
+	if resp.Dict == nil {
+		return nil, nil
+	}
+	if len(resp.Dict.Data) > MaxDictSize || dictID(resp.Dict.Data) != resp.Dict.ID {
+		return nil, fmt.Errorf("server sent an invalid dictionary")
+	}
+	c.dict.Store(resp.Dict)
+	return resp.Dict, nil
+}
+
+// dictTransforms 已取得字典时，不超过 DictMaxFileSize 的文件用字典压缩代替逐个文件的 gzip 压缩，
+// 策略明确不压缩的文件除外
+func (c *Client) dictTransforms(remotePath string, names []string) []string {
+	if c.dict.Load() == nil || slices.Contains(names, TransformDict) {
+		return names
+	}
+	if compress := matchPolicy(c.policies, remotePath).Compress; compress != nil && !*compress {
+		return names
+	}
+	if f, ok := c.cachedFile(remotePath); !ok || f.Size > DictMaxFileSize {
+		return names
+	}
+	names = slices.DeleteFunc(slices.Clone(names), func(name string) bool {
+		return name == TransformGzip
+	})
+	return append([]string{TransformDict}, names...)
+}
+
+// dictFor 返回 names 中的字典变换使用的字典ID和内容，不使用字典时返回空
+func (c *Client) dictFor(names []string) (string, []byte) {
+	if !slices.Contains(names, TransformDict) {
+		return "", nil
+	}
+	if dict := c.dict.Load(); dict != nil {
+		return dict.ID, dict.Data
+	}
+	return "", nil
+}
+
+// dropDictionary 服务器不再有字典时停止使用，之后的文件逐个压缩
+func (c *Client) dropDictionary() {
+	c.dict.Store(nil)
+}
+
+func newDictWriter(w io.Writer, dict []byte) (io.WriteCloser, error) {
+	return flate.NewWriterDict(w, flate.DefaultCompression, dict)
+}
+
+func newDictReader(r io.Reader, dict []byte) (io.Reader, error) {
+	return flate.NewReaderDict(r, dict), nil
+}
@@ -0,0 +1,96 @@
+package net
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+
+	"gorsync/pkg/utils"
+)
+
+// 库的调用方可以用 errors.Is 区分的失败类别，具体的错误以 %w 包装这些错误，错误信息保持不变
+var (
+	// ErrConnect 无法连接到服务器
+	ErrConnect = errors.New("failed to connect to server")
+	// ErrAuth 服务器拒绝了请求携带的令牌或客户端身份
+	ErrAuth = errors.New("authentication failed")
+	// ErrNotFound 请求的路径在服务器上不存在
+	ErrNotFound = errors.New("not found")
+	// ErrChecksumMismatch 下载的内容与服务器发送的 MD5 或 HMAC 不一致
+	ErrChecksumMismatch = errors.New("file content mismatch")
+	// ErrNoChecksum 服务器没有发送文件的MD5（计算失败），要求校验时无法确认下载的内容
+	ErrNoChecksum = errors.New("checksum unavailable")
+	// ErrNoSpace 服务器磁盘空间不足，上传的文件没有写入
+	ErrNoSpace = errors.New("not enough disk space")
+	// ErrPeerUnreachable 服务器端拉取时服务器连接不到请求中指定的另一台服务器
+	ErrPeerUnreachable = errors.New("server cannot reach the peer")
+	// ErrCanceled 客户端的取消信号已关闭，见 Client.SetCancel
+	ErrCanceled = errors.New("canceled")
+)
+
+// 错误响应的类别，旧版本服务器不发送，此时只能得到普通的 *ServerError
+const (
+	ErrorCodeAuth     = "auth"
+	ErrorCodeNotFound = "not-found"
+	ErrorCodeNoSpace  = "no-space"
+	// ErrorCodePeerUnreachable 服务器作为客户端拉取时无法连接到另一台服务器
+	ErrorCodePeerUnreachable = "peer-unreachable"
+	// ErrorCodeNoDict 文件请求中的字典ID在服务器上不存在（已过期或服务器重启），客户端不用字典重新请求
+	ErrorCodeNoDict = "no-dict"
+)
+
+// ServerError 服务器返回的错误响应，errors.Is 按 Code 匹配 ErrAuth、ErrNotFound、ErrNoSpace 或 ErrPeerUnreachable
+type ServerError struct {
+	Code    string // 见 ErrorCodeAuth/ErrorCodeNotFound/ErrorCodeNoSpace/ErrorCodePeerUnreachable，为空表示其他错误
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return "server error: " + e.Message
+}
+
+func (e *ServerError) Is(target error) bool {
+	switch target {
+	case ErrAuth:
+		return e.Code == ErrorCodeAuth
+	case ErrNotFound:
+		return e.Code == ErrorCodeNotFound
+	case ErrNoSpace:
+		return e.Code == ErrorCodeNoSpace
+	case ErrPeerUnreachable:
+		return e.Code == ErrorCodePeerUnreachable
+	}
+	return false
+}
+
+// responseError 把错误响应转换为 *ServerError
+func responseError(resp *Response) error {
+	return &ServerError{Code: resp.Code, Message: resp.Message}
+}
+
+// httpError 把 HTTP 源的错误状态转换为 *ServerError
+func httpError(resp *http.Response) error {
+	code := ""
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		code = ErrorCodeAuth
+	case http.StatusNotFound, http.StatusGone:
+		code = ErrorCodeNotFound
+	}
+	return &ServerError{Code: code, Message: resp.Status}
+}
+
+// errorCode 按处理请求时遇到的错误选择错误响应的类别
+func errorCode(err error) string {
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrorCodeNotFound
+	}
+	if errors.Is(err, ErrNoSpace) || utils.IsNoSpace(err) {
+		return ErrorCodeNoSpace
+	}
+	// 服务器作为客户端拉取时连接不到另一台服务器
+	if errors.Is(err, ErrConnect) {
+		return ErrorCodePeerUnreachable
+	}
+	return ""
+}
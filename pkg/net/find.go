@@ -0,0 +1,195 @@
+package net
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+
+	"gorsync/pkg/utils"
+)
+
+// CapFind 服务器接受 find 请求的能力，管理员可以用 SetFind 关闭
+const CapFind = "find"
+
+// 一次 find 请求最多返回的结果数
+const (
+	DefaultFindLimit = 1000
+	MaxFindLimit     = 100000
+)
+
+// FindQuery find 请求的条件，设置的条件都满足时路径才匹配，为零值的条件不限制
+type FindQuery struct {
+	Name    string `json:"name,omitempty"`    // 文件名模式，使用 path.Match 的语法，只匹配最后一级
+	Larger  int64  `json:"larger,omitempty"`  // 大小大于这个字节数
+	Smaller int64  `json:"smaller,omitempty"` // 大小小于这个字节数
+	Newer   int64  `json:"newer,omitempty"`   // 修改时间晚于这个 Unix 时间
+	Older   int64  `json:"older,omitempty"`   // 修改时间早于这个 Unix 时间
+	Type    string `json:"type,omitempty"`    // "f" 只匹配文件，"d" 只匹配目录，为空时都匹配
+	Limit   int    `json:"limit,omitempty"`   // 最多返回的结果数，0 表示 DefaultFindLimit
+}
+
+// Validate 检查查询条件
+func (q *FindQuery) Validate() error {
+	if q.Name != "" {
+		if _, err := path.Match(q.Name, ""); err != nil {
+			return fmt.Errorf("invalid name pattern %q: %v", q.Name, err)
+		}
+	}
+	if q.Larger < 0 || q.Smaller < 0 {
+		return fmt.Errorf("size limits must not be negative")
+	}
+	switch q.Type {
+	case "", "f", "d":
+	default:
+		return fmt.Errorf("invalid type %q, expected f or d", q.Type)
+	}
+	if q.Limit < 0 || q.Limit > MaxFindLimit {
+		return fmt.Errorf("invalid limit %d, expected at most %d", q.Limit, MaxFindLimit)
+	}
+	return nil
+}
+
+// matches 检查路径是否满足查询条件
+func (q *FindQuery) matches(name string, info os.FileInfo) bool {
+	if q.Type == "f" && info.IsDir() || q.Type == "d" && !info.IsDir() {
+		return false
+	}
+	if q.Name != "" {
+		if ok, _ := path.Match(q.Name, name); !ok {
+			return false
+		}
+	}
+	if q.Larger > 0 && info.Size() <= q.Larger || q.Smaller > 0 && info.Size() >= q.Smaller {
+		return false
+	}
+	mtime := info.ModTime().Unix()
+	if q.Newer != 0 && mtime <= q.Newer || q.Older != 0 && mtime >= q.Older {
+		return false
+	}
+	return true
+}
+
+// SetFind 设置是否接受 find 请求，默认接受。find 请求在服务器上遍历整个目录，目录很大时开销较高
+func (s *Server) SetFind(enabled bool) {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+
+	s.noFind = !enabled
+}
+
+// findEnabled 检查是否接受 find 请求
+func (s *Server) findEnabled() bool {
+	s.configMutex.RLock()
+	defer s.configMutex.RUnlock()
+
+	return !s.noFind
+}
+
+// handleFindRequest 在服务器上遍历目录，只返回满足条件的路径（相对于请求的路径，不计算MD5），
+// 客户端不需要下载整个列表就能找到文件。结果达到上限时停止遍历并标记 Truncated
+func (s *Server) handleFindRequest(conn net.Conn, req Request) {
+	if !s.findEnabled() {
+		s.sendError(conn, "find requests are disabled on this server")
+		return
+	}
+	var query FindQuery
+	if req.Find != nil {
+		query = *req.Find
+	}
+	if err := query.Validate(); err != nil {
+		s.sendError(conn, err.Error())
+		return
+	}
+	limit := query.Limit
+	if limit == 0 {
+		limit = DefaultFindLimit
+	}
+
+	var fullPath string
+	if s.rootDir == "" {
+		fullPath = req.Path
+	} else {
+		fullPath = LocalPath(s.rootDir, req.Path)
+	}
+
+	files := []FileInfo{}
+	truncated := false
+	err := filepath.Walk(fullPath, func(walkPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			// 根目录本身无法访问时直接失败，其余路径跳过
+			if walkPath == fullPath && info == nil {
+				return err
+			}
+			return nil
+		}
+		if walkPath == fullPath || !info.IsDir() && utils.IsTempName(info.Name()) || !query.matches(info.Name(), info) {
+			return nil
+		}
+		if len(files) >= limit {
+			truncated = true
+			return filepath.SkipAll
+		}
+		relPath, err := filepath.Rel(fullPath, walkPath)
+		if err != nil {
+			return err
+		}
+		files = append(files, FileInfo{
+			Path:     WirePath(relPath),
+			Size:     info.Size(),
+			ModTime:  info.ModTime().Unix(),
+			ModNanos: info.ModTime().Nanosecond(),
+			IsDir:    info.IsDir(),
+			Mode:     EncodeMode(info.Mode()),
+			Type:     FileType(info.Mode()),
+		})
+		return nil
+	})
+	if err != nil {
+		s.sendErrorCode(conn, errorCode(err), fmt.Sprintf("Failed to walk directory: %v", err))
+		return
+	}
+
+	resp := Response{
+		Status:    "ok",
+		Files:     files,
+		Truncated: truncated,
+	}
+	if err := json.NewEncoder(conn).Encode(&resp); err != nil {
+		logf(conn, "Failed to send response: %v\n", err)
+	}
+}
+
+// Find 在服务器上查找 path 下满足条件的路径，truncated 表示结果达到上限，还有未返回的匹配项
+func (c *Client) Find(path string, query FindQuery) (files []FileInfo, truncated bool, err error) {
+	conn, err := c.pooledConnect()
+	if err != nil {
+		return nil, false, err
+	}
+	defer func() { c.release(conn, err) }()
+
+	req := Request{
+		Type: "find",
+		Path: path,
+		Find: &query,
+	}
+	if err := c.send(conn, &req); err != nil {
+		return nil, false, fmt.Errorf("failed to send request: %v", err)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, false, fmt.Errorf("failed to decode response: %v", err)
+	}
+	if resp.Status != "ok" {
+		return nil, false, responseError(&resp)
+	}
+	for _, f := range resp.Files {
+		if err := CheckWirePath(f.Path); err != nil {
+			return nil, false, fmt.Errorf("server sent an unsafe path: %v", err)
+		}
+	}
+	return resp.Files, resp.Truncated, nil
+}
@@ -0,0 +1,93 @@
+package net
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// 远程路径中含通配符时（例如 host:port:/logs/*.gz），通配符之前的部分作为列表的目录，
+// 其余部分作为相对于该目录的模式，由服务器在遍历时只返回匹配的文件及其上级目录。
+// 模式按 / 分段，每段使用 path.Match 的语法，匹配到目录时包含目录下的全部内容
+
+// HasGlob 检查协议格式的路径中是否含有通配符
+func HasGlob(wirePath string) bool {
+	return strings.ContainsAny(wirePath, "*?[")
+}
+
+// SplitGlob 把含通配符的远程路径拆分为不含通配符的目录和相对于该目录的模式
+func SplitGlob(wirePath string) (dir, pattern string) {
+	elems := strings.Split(wirePath, "/")
+	for i, elem := range elems {
+		if HasGlob(elem) {
+			dir = strings.Join(elems[:i], "/")
+			if dir == "" && strings.HasPrefix(wirePath, "/") {
+				dir = "/"
+			} else if dir == "" {
+				dir = "."
+			}
+			return dir, strings.Join(elems[i:], "/")
+		}
+	}
+	return wirePath, ""
+}
+
+// ValidateGlob 检查相对模式，拒绝绝对路径、空段、.. 和语法错误的模式
+func ValidateGlob(pattern string) error {
+	if pattern == "" || strings.HasPrefix(pattern, "/") {
+		return fmt.Errorf("invalid glob pattern %q", pattern)
+	}
+	for _, elem := range strings.Split(pattern, "/") {
+		if elem == "" || elem == "." || elem == ".." {
+			return fmt.Errorf("invalid glob pattern %q", pattern)
+		}
+		if _, err := path.Match(elem, ""); err != nil {
+			return fmt.Errorf("invalid glob pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// MatchGlob 检查相对路径与模式的关系：matched 表示路径匹配模式或在匹配的目录之下，
+// parent 表示路径是可能包含匹配项的上级目录（遍历需要进入）。模式须已通过 ValidateGlob
+func MatchGlob(pattern, relPath string) (matched, parent bool) {
+	if relPath == "." || relPath == "" {
+		return false, true
+	}
+	patElems := strings.Split(pattern, "/")
+	elems := strings.Split(relPath, "/")
+	for i, elem := range elems {
+		if i >= len(patElems) {
+			return true, false
+		}
+		if ok, _ := path.Match(patElems[i], elem); !ok {
+			return false, false
+		}
+	}
+	if len(elems) == len(patElems) {
+		return true, false
+	}
+	return false, true
+}
+
+// FilterGlob 返回匹配模式的文件和包含匹配项的上级目录，不含匹配项的上级目录不返回，避免在本地创建空目录。
+// 服务器遍历时已按模式跳过不匹配的路径，客户端再过滤一次，旧版本服务器忽略模式时也只同步匹配的文件
+func FilterGlob(files []FileInfo, pattern string) []FileInfo {
+	needed := make(map[string]bool)
+	for _, f := range files {
+		if matched, _ := MatchGlob(pattern, f.Path); matched {
+			needed[f.Path] = true
+			for dir := path.Dir(f.Path); dir != "."; dir = path.Dir(dir) {
+				needed[dir] = true
+			}
+		}
+	}
+
+	var filtered []FileInfo
+	for _, f := range files {
+		if needed[f.Path] {
+			filtered = append(filtered, f)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,85 @@
+package net
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"gorsync/pkg/utils"
+)
+
+// maxHashCacheEntries 哈希缓存的最大条目数，超过后清空重新缓存
+const maxHashCacheEntries = 100000
+
+// hashEntry 文件在计算哈希时的大小和修改时间
+type hashEntry struct {
+	size    int64
+	modTime int64 // 纳秒
+	md5     string
+}
+
+// hashCache 按 (路径, 大小, 修改时间) 缓存文件的MD5，避免同一文件被重复读取计算
+type hashCache struct {
+	mutex   sync.Mutex
+	entries map[string]hashEntry
+	seeds   map[string]hashEntry // 预先计算的哈希，不受 maxHashCacheEntries 限制
+}
+
+// SeedHash 预先计算的文件哈希，文件的大小和修改时间（纳秒）都与之相同时才使用
+type SeedHash struct {
+	Size    int64
+	ModTime int64
+	MD5     string
+}
+
+// md5 返回文件的MD5，文件自上次计算后大小和修改时间未变时直接使用缓存
+func (c *hashCache) md5(path string, info os.FileInfo) (string, error) {
+	size, modTime := info.Size(), info.ModTime().UnixNano()
+
+	c.mutex.Lock()
+	entry, ok := c.entries[path]
+	if !ok {
+		entry, ok = c.seeds[path]
+	}
+	c.mutex.Unlock()
+	if ok && entry.size == size && entry.modTime == modTime {
+		return entry.md5, nil
+	}
+
+	md5, err := utils.CalculateMD5(path)
+	if err != nil {
+		return "", err
+	}
+	c.put(path, hashEntry{size: size, modTime: modTime, md5: md5})
+	return md5, nil
+}
+
+// put 记录文件的MD5
+func (c *hashCache) put(path string, entry hashEntry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.entries == nil || len(c.entries) >= maxHashCacheEntries {
+		c.entries = make(map[string]hashEntry)
+	}
+	c.entries[path] = entry
+}
+
+// seed 替换预先计算的哈希
+func (c *hashCache) seed(hashes map[string]SeedHash) {
+	seeds := make(map[string]hashEntry, len(hashes))
+	for path, h := range hashes {
+		seeds[path] = hashEntry{size: h.Size, modTime: h.ModTime, md5: h.MD5}
+	}
+
+	c.mutex.Lock()
+	c.seeds = seeds
+	c.mutex.Unlock()
+}
+
+// SeedHashes 使用预先计算的哈希（以完整路径为键），列表请求遇到大小和修改时间未变的文件时不再读取计算，
+// 适合静态数据集的只读镜像
+func (s *Server) SeedHashes(hashes map[string]SeedHash) {
+	s.hashes.seed(hashes)
+	fmt.Printf("Loaded %d precomputed hashes\n", len(hashes))
+}
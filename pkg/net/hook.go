@@ -0,0 +1,58 @@
+package net
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// SetPostReceiveCmd 设置推送提交后执行的命令，为空时不执行
+func (s *Server) SetPostReceiveCmd(command string) {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+
+	s.postReceive = command
+}
+
+// PostReceiveCmd 返回推送提交后执行的命令
+func (s *Server) PostReceiveCmd() string {
+	s.configMutex.RLock()
+	defer s.configMutex.RUnlock()
+
+	return s.postReceive
+}
+
+// runPostReceive 通过系统 shell 执行 post-receive 钩子。变更的路径每行一个写入临时文件，
+// 环境变量 GORSYNC_CHANGED_FILE 为该文件，GORSYNC_CHANGED_COUNT 为路径数，
+// GORSYNC_PUSH 为推送的事务ID，GORSYNC_ROOT 为服务器的根目录（未设置时路径为绝对路径）
+func (s *Server) runPostReceive(conn net.Conn, command, txn string, paths []string) {
+	list, err := os.CreateTemp("", "gorsync-changed-*.txt")
+	if err != nil {
+		logf(conn, "Post-receive hook failed: %v\n", err)
+		return
+	}
+	defer os.Remove(list.Name())
+
+	_, err = list.WriteString(strings.Join(paths, "\n") + "\n")
+	if closeErr := list.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		logf(conn, "Post-receive hook failed: %v\n", err)
+		return
+	}
+
+	logf(conn, "Running post-receive hook for transaction %s (%d paths)\n", txn, len(paths))
+	output, err := runHook(command,
+		"GORSYNC_PUSH="+txn,
+		"GORSYNC_ROOT="+s.rootDir,
+		"GORSYNC_CHANGED_FILE="+list.Name(),
+		fmt.Sprintf("GORSYNC_CHANGED_COUNT=%d", len(paths)))
+	if output = strings.TrimSpace(output); output != "" {
+		logf(conn, "Post-receive hook output:\n%s\n", output)
+	}
+	if err != nil {
+		logf(conn, "Post-receive hook failed: %v\n", err)
+	}
+}
@@ -0,0 +1,364 @@
+package net
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorsync/pkg/utils"
+)
+
+// DefaultHTTPManifest HTTP 源上清单文件的默认名称，相对于基础URL
+const DefaultHTTPManifest = "gorsync-manifest.json"
+
+// errHTTPUnsupported HTTP 源不支持需要 gorsync 服务器的请求
+var errHTTPUnsupported = errors.New("not supported by HTTP sources")
+
+// httpSource 普通 HTTP(S) 服务器上的静态源：由 gorsync manifest 生成的清单提供文件列表，
+// 文件内容通过 GET 和范围请求获取，源端不需要运行 gorsync 服务器
+type httpSource struct {
+	base     *url.URL
+	manifest string
+	client   *http.Client
+}
+
+// httpManifest gorsync manifest 生成的清单格式
+type httpManifest struct {
+	Files map[string]struct {
+		Size    int64  `json:"size"`
+		ModTime int64  `json:"modTime"` // 纳秒
+		MD5     string `json:"md5"`
+	} `json:"files"`
+}
+
+// NewHTTPClient 创建从 HTTP(S) 静态源镜像的客户端，manifest 为清单相对于 baseURL 的路径，为空时使用默认名称
+func NewHTTPClient(baseURL, manifest string) (*Client, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source URL: %v", err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported source URL scheme: %s", base.Scheme)
+	}
+	if manifest == "" {
+		manifest = DefaultHTTPManifest
+	}
+	return &Client{
+		source: &httpSource{
+			base:     base,
+			manifest: manifest,
+			client:   &http.Client{},
+		},
+	}, nil
+}
+
+// fileURL 返回线上路径对应的URL，每一段分别转义
+func (h *httpSource) fileURL(wirePath string) string {
+	if wirePath == "" || wirePath == "." {
+		return h.base.String()
+	}
+	return h.base.JoinPath(strings.Split(wirePath, "/")...).String()
+}
+
+// get 发送 GET 请求，offset 大于 0 时只请求从 offset 开始的部分，服务器可能忽略范围返回整个文件
+func (h *httpSource) get(wirePath string, offset int64) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, h.fileURL(wirePath), nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrConnect, err)
+	}
+	// 不支持范围请求的服务器返回完整内容，由调用者跳过开头
+	if resp.StatusCode != http.StatusOK && (offset == 0 || resp.StatusCode != http.StatusPartialContent) {
+		resp.Body.Close()
+		return nil, httpError(resp)
+	}
+	return resp, nil
+}
+
+// listHTTP 下载清单并转换为 path 目录下的文件列表，目录项根据文件路径推导
+func (c *Client) listHTTP(root string) ([]FileInfo, []SkippedPath, error) {
+	resp, err := c.source.get(c.source.manifest, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get manifest: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var m httpManifest
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode manifest: %v", err)
+	}
+
+	prefix := ""
+	if root != "" && root != "." {
+		prefix = root + "/"
+	}
+	dirs := map[string]int64{".": 0}
+	var files []FileInfo
+	for wirePath, entry := range m.Files {
+		// 清单来自源端，与服务器列表一样不能信任
+		if err := CheckWirePath(wirePath); err != nil {
+			return nil, nil, fmt.Errorf("manifest contains an unsafe path: %v", err)
+		}
+		relPath, ok := strings.CutPrefix(wirePath, prefix)
+		if !ok {
+			continue
+		}
+		modTime := time.Unix(0, entry.ModTime).Unix()
+		files = append(files, FileInfo{
+			Path:     relPath,
+			Size:     entry.Size,
+			ModTime:  modTime,
+			ModNanos: time.Unix(0, entry.ModTime).Nanosecond(),
+			Mode:     0644,
+			MD5:      entry.MD5,
+		})
+		for dir := relPath; dir != "."; {
+			dir = path.Dir(dir)
+			dirs[dir] = max(dirs[dir], modTime)
+		}
+	}
+	if prefix != "" && len(files) == 0 {
+		return nil, nil, &ServerError{Code: ErrorCodeNotFound, Message: root + " not found in manifest"}
+	}
+	for dir, modTime := range dirs {
+		files = append(files, FileInfo{
+			Path:    dir,
+			ModTime: modTime,
+			IsDir:   true,
+			Mode:    0755,
+			Type:    TypeDir,
+		})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+
+	c.cacheFiles(root, files)
+	return files, nil, nil
+}
+
+// statHTTP 通过 HEAD 请求查询单个文件的大小和修改时间
+func (c *Client) statHTTP(wirePath string) (*FileInfo, error) {
+	resp, err := c.source.client.Head(c.source.fileURL(wirePath))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrConnect, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpError(resp)
+	}
+	if resp.ContentLength < 0 {
+		return nil, fmt.Errorf("server did not report the size of %s", wirePath)
+	}
+
+	info := &FileInfo{
+		Path: path.Base(wirePath),
+		Size: resp.ContentLength,
+		Mode: 0644,
+	}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modTime.Unix()
+	}
+	return info, nil
+}
+
+// downloadHTTP 通过 GET 下载整个文件，写入临时文件并按清单中的MD5校验后重命名为目标文件
+func (c *Client) downloadHTTP(remotePath, localPath string, index int) error {
+	file, ok := c.cachedFile(remotePath)
+	if !ok {
+		return fmt.Errorf("%s is not in the manifest", remotePath)
+	}
+	prefix := strings.Repeat(" ", len(strconv.Itoa(index))+2)
+
+	resp, err := c.source.get(remotePath, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if !c.quiet {
+		c.printf("%d. Starting download (%.2f MB): %s\n", index, float64(file.Size)/1024/1024, remotePath)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return fmt.Errorf("failed to create destination directory: %v", err)
+	}
+	tempPath := utils.MakeTempName(localPath)
+	defer os.Remove(tempPath)
+
+	tempFile, err := os.OpenFile(tempPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, FileMode(file.Mode, false).Perm())
+	if err != nil {
+		return fmt.Errorf("failed to open destination file: %v", err)
+	}
+	defer tempFile.Close()
+
+	sum := md5.New()
+	transferred, err := c.receiveHTTP(resp.Body, tempFile, sum, remotePath, file.Size)
+	if err != nil {
+		return err
+	}
+	if transferred != file.Size {
+		return fmt.Errorf("%w: received %d of %d bytes", ErrRemoteChanged, transferred, file.Size)
+	}
+
+	destMD5 := hex.EncodeToString(sum.Sum(nil))
+	if file.MD5 != destMD5 {
+		return fmt.Errorf("%w: manifest MD5 %s, local MD5 %s", ErrChecksumMismatch, file.MD5, destMD5)
+	}
+
+	tempFile.Close()
+	if err := utils.Saferename(tempPath, localPath); err != nil {
+		return fmt.Errorf("failed to rename temporary file: %w", err)
+	}
+
+	if c.verifyReadback {
+		if err := utils.DropFileCache(localPath); err != nil {
+			c.printf("%sFailed to drop page cache for %s: %v\n", prefix, localPath, err)
+		}
+		readbackMD5, err := utils.CalculateMD5(localPath)
+		if err != nil {
+			return fmt.Errorf("failed to read back destination file: %v", err)
+		}
+		if readbackMD5 != file.MD5 {
+			return fmt.Errorf("read-back verification failed: manifest MD5 %s, on-disk MD5 %s", file.MD5, readbackMD5)
+		}
+	}
+
+	if !c.quiet {
+		c.printf("%s<<< Download completed: %s\n", prefix, remotePath)
+	}
+	return nil
+}
+
+// appendHTTP 通过范围请求下载远程文件超出本地大小的部分。HTTP 源无法在服务器端比较前缀，
+// 追加后整个文件与清单中的MD5不一致时截断回原来的大小并返回 ErrPrefixMismatch
+func (c *Client) appendHTTP(remotePath, localPath string, index int) error {
+	file, ok := c.cachedFile(remotePath)
+	if !ok {
+		return fmt.Errorf("%s is not in the manifest", remotePath)
+	}
+
+	local, err := os.OpenFile(localPath, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open destination file: %v", err)
+	}
+	defer local.Close()
+
+	info, err := local.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat destination file: %v", err)
+	}
+	offset := info.Size()
+	if offset >= file.Size {
+		return ErrPrefixMismatch
+	}
+
+	sum := md5.New()
+	if _, err := io.Copy(sum, io.NewSectionReader(local, 0, offset)); err != nil {
+		return fmt.Errorf("failed to read destination file: %v", err)
+	}
+
+	resp, err := c.source.get(remotePath, offset)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		if !c.quiet {
+			c.printf("%d. Server does not support range requests, skipping the first %s of %s\n", index, utils.FormatSize(offset), remotePath)
+		}
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			return fmt.Errorf("failed to read file data: %v", err)
+		}
+	}
+
+	tailSize := file.Size - offset
+	if !c.quiet {
+		c.printf("%d. Appending %s to %s\n", index, utils.FormatSize(tailSize), remotePath)
+	}
+
+	// 追加失败时截断回原来的大小
+	ok = false
+	defer func() {
+		if !ok {
+			local.Truncate(offset)
+		}
+	}()
+
+	if _, err := local.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek destination file: %v", err)
+	}
+	transferred, err := c.receiveHTTP(resp.Body, local, sum, remotePath, tailSize)
+	if err != nil {
+		return err
+	}
+	if transferred != tailSize {
+		return fmt.Errorf("%w: received %d of %d bytes", ErrRemoteChanged, transferred, tailSize)
+	}
+	if hex.EncodeToString(sum.Sum(nil)) != file.MD5 {
+		return ErrPrefixMismatch
+	}
+
+	ok = true
+	return nil
+}
+
+// receiveHTTP 把响应内容写入 dest 并计入 sum，最多接收 size 字节，按限速等待并更新进度
+func (c *Client) receiveHTTP(body io.Reader, dest io.Writer, sum hash.Hash, remotePath string, size int64) (int64, error) {
+	if c.progress != nil {
+		c.progress.begin(remotePath, size)
+		defer c.progress.end(remotePath)
+	}
+
+	data := io.LimitReader(body, size)
+	pooled := utils.GetBuffer()
+	defer utils.PutBuffer(pooled)
+	buffer := *pooled
+	transferred := int64(0)
+	for {
+		if err := c.waitIfPaused(); err != nil {
+			return transferred, err
+		}
+
+		n, err := data.Read(buffer)
+		if n > 0 {
+			if _, err := dest.Write(buffer[:n]); err != nil {
+				return transferred, fmt.Errorf("failed to write destination file: %v", err)
+			}
+			sum.Write(buffer[:n])
+			transferred += int64(n)
+
+			if c.limiter != nil {
+				c.limiter.Wait(n)
+			}
+			if c.progress != nil {
+				c.progress.add(remotePath, n)
+			}
+		}
+		if err == io.EOF {
+			return transferred, nil
+		}
+		if err != nil {
+			return transferred, fmt.Errorf("failed to read file data: %v", err)
+		}
+	}
+}
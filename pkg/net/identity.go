@@ -0,0 +1,141 @@
+package net
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"path"
+)
+
+// 客户端身份的访问权限
+const (
+	AccessRead  = "read"  // 只能列出和下载
+	AccessWrite = "write" // 还可以上传、创建、删除和移动
+)
+
+// readRequests 只读权限允许的请求类型
+var readRequests = map[string]bool{
+	"list": true, "file": true, "stat": true, "checksum": true, "release": true, "probe": true, "ping": true, "browse": true, "find": true, "dict": true,
+}
+
+// writeRequests 写权限额外允许的请求类型；pull、reload 和 admin 只接受服务器的控制令牌
+var writeRequests = map[string]bool{
+	"delete": true, "mkdir": true, "move": true, "signature": true, "upload": true, "commit": true, "abort": true,
+}
+
+// anonymousRequests 配置了客户端身份后仍不需要令牌的请求类型，用于健康检查和查看服务器能力
+var anonymousRequests = map[string]bool{
+	"ping": true, "probe": true,
+}
+
+// ClientIdentity 服务器配置中的客户端身份：持有 Token 的客户端的所有路径都映射到 Root 之下，
+// 使一组客户端可以使用相同的命令行，各自落到自己的目录
+type ClientIdentity struct {
+	Name   string `json:"name"`
+	Token  string `json:"token"`
+	Root   string `json:"root,omitempty"`   // 客户端请求的路径都在这个目录之下，为空时不映射，写权限的身份必须设置
+	Access string `json:"access,omitempty"` // read 或 write，为空时为 read
+}
+
+// checkIdentities 检查客户端身份的配置
+func checkIdentities(clients []ClientIdentity) error {
+	seen := make(map[string]bool)
+	for _, id := range clients {
+		if id.Name == "" || id.Token == "" {
+			return fmt.Errorf("client identities require a name and a token")
+		}
+		if seen[id.Token] {
+			return fmt.Errorf("client %s reuses the token of another client", id.Name)
+		}
+		seen[id.Token] = true
+		switch id.Access {
+		case "", AccessRead, AccessWrite:
+		default:
+			return fmt.Errorf("client %s: unknown access %q, expected read or write", id.Name, id.Access)
+		}
+		// 没有根目录的写权限身份可以修改服务器上的任意路径，与控制令牌无异
+		if id.Access == AccessWrite && id.Root == "" {
+			return fmt.Errorf("client %s has write access and requires a root", id.Name)
+		}
+	}
+	return nil
+}
+
+// SetClientIdentities 设置客户端身份，替换之前的设置
+func (s *Server) SetClientIdentities(clients []ClientIdentity) error {
+	if err := checkIdentities(clients); err != nil {
+		return err
+	}
+
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+
+	s.clients = clients
+	return nil
+}
+
+// clientIdentity 返回令牌对应的客户端身份，没有匹配时返回 nil
+func (s *Server) clientIdentity(token string) *ClientIdentity {
+	if token == "" {
+		return nil
+	}
+
+	s.configMutex.RLock()
+	defer s.configMutex.RUnlock()
+
+	for i := range s.clients {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.clients[i].Token)) == 1 {
+			id := s.clients[i]
+			return &id
+		}
+	}
+	return nil
+}
+
+// allows 检查身份是否允许该类型的请求
+func (id *ClientIdentity) allows(requestType string) bool {
+	if readRequests[requestType] {
+		return true
+	}
+	return id.Access == AccessWrite && writeRequests[requestType]
+}
+
+// mapPath 把客户端请求的路径映射到身份的根目录之下，.. 不能越过根目录
+func (id *ClientIdentity) mapPath(requested string) string {
+	if id.Root == "" {
+		return requested
+	}
+	return JoinWire(WirePath(id.Root), path.Clean("/"+WirePath(requested)))
+}
+
+// applyIdentity 按请求携带的令牌检查客户端身份的权限并映射路径，返回错误时请求应被拒绝。
+// 配置了客户端身份后，不携带身份令牌或控制令牌的请求只能是 anonymousRequests，否则各身份的根目录形同虚设；
+// 没有配置客户端身份时请求保持不变
+func (s *Server) applyIdentity(req *Request) (*ClientIdentity, error) {
+	id := s.clientIdentity(req.Token)
+	if id == nil {
+		if !anonymousRequests[req.Type] && s.requiresIdentity(req.Token) {
+			return nil, fmt.Errorf("%s requests require a client identity", req.Type)
+		}
+		return nil, nil
+	}
+	if !id.allows(req.Type) {
+		return id, fmt.Errorf("client %s is not allowed to make %s requests", id.Name, req.Type)
+	}
+
+	req.Path = id.mapPath(req.Path)
+	if req.Target != "" {
+		req.Target = id.mapPath(req.Target)
+	}
+	return id, nil
+}
+
+// requiresIdentity 检查不属于任何客户端身份的令牌是否应被拒绝：配置了客户端身份且令牌不是控制令牌
+func (s *Server) requiresIdentity(token string) bool {
+	s.configMutex.RLock()
+	defer s.configMutex.RUnlock()
+
+	if len(s.clients) == 0 {
+		return false
+	}
+	return s.controlToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.controlToken)) != 1
+}
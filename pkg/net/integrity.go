@@ -0,0 +1,44 @@
+package net
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// newIntegrityHash 创建以共享密钥为键的 HMAC-SHA256，先写入文件元数据，
+// 使中间人无法在篡改内容的同时伪造匹配的校验值或替换其他文件的内容
+func newIntegrityHash(key []byte, info *FileInfo) hash.Hash {
+	h := hmac.New(sha256.New, key)
+	fmt.Fprintf(h, "%s\x00%d\x00%d\x00%s\x00", info.Path, info.Size, info.Mode, info.MD5)
+	return h
+}
+
+// calculateIntegrity 计算文件内容的 HMAC
+func calculateIntegrity(key []byte, info *FileInfo, filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	h := newIntegrityHash(key, info)
+	if _, err := io.Copy(h, file); err != nil {
+		return "", fmt.Errorf("failed to read file: %v", err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checkIntegrity 比较计算出的 HMAC 与服务器发送的值
+func checkIntegrity(h hash.Hash, expected string) error {
+	mac, err := hex.DecodeString(expected)
+	if err != nil || !hmac.Equal(h.Sum(nil), mac) {
+		return fmt.Errorf("integrity check failed: %w (HMAC)", ErrChecksumMismatch)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package net
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// listingCacheTTL 目录遍历结果的缓存时间，用于合并频繁触发的同步请求
+	listingCacheTTL = 2 * time.Second
+	// maxListingCalls 同时保留的遍历数（进行中和缓存中），超出时新的遍历不登记，直接遍历
+	maxListingCalls = 64
+)
+
+// listingCall 一次目录遍历，完成前到达的相同请求等待其结果
+type listingCall struct {
+	done     chan struct{}
+	files    []FileInfo
+	skipped  []SkippedPath
+	err      error
+	finished time.Time
+}
+
+// listingCache 合并对同一目录的并发请求，并在短时间内复用最近的遍历结果
+type listingCache struct {
+	mutex sync.Mutex
+	calls map[string]*listingCall
+}
+
+// get 返回目录 root 的文件列表，没有进行中或未过期的遍历时调用 walk
+func (c *listingCache) get(root string, walk func() ([]FileInfo, []SkippedPath, error)) ([]FileInfo, []SkippedPath, error) {
+	c.mutex.Lock()
+	if c.calls == nil {
+		c.calls = make(map[string]*listingCall)
+	}
+	if call, ok := c.calls[root]; ok {
+		select {
+		case <-call.done:
+			if time.Since(call.finished) < listingCacheTTL {
+				c.mutex.Unlock()
+				return call.files, call.skipped, nil
+			}
+		default:
+			c.mutex.Unlock()
+			<-call.done
+			return call.files, call.skipped, call.err
+		}
+	}
+
+	if _, ok := c.calls[root]; !ok && len(c.calls) >= maxListingCalls {
+		c.mutex.Unlock()
+		return walk()
+	}
+	call := &listingCall{done: make(chan struct{})}
+	c.calls[root] = call
+	c.mutex.Unlock()
+
+	call.files, call.skipped, call.err = walk()
+	call.finished = time.Now()
+
+	c.mutex.Lock()
+	if call.err != nil {
+		// 失败的结果不缓存
+		delete(c.calls, root)
+	} else {
+		// 过期后释放列表，否则每个请求过的目录的列表会一直留在内存中
+		time.AfterFunc(listingCacheTTL, func() { c.evict(root, call) })
+	}
+	close(call.done)
+	c.mutex.Unlock()
+
+	return call.files, call.skipped, call.err
+}
+
+// evict 丢弃过期的遍历结果，root 已被新的遍历替换时不处理
+func (c *listingCache) evict(root string, call *listingCall) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.calls[root] == call {
+		delete(c.calls, root)
+	}
+}
@@ -0,0 +1,155 @@
+package net
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// 文件列表的压缩编码：路径按与上一条的公共前缀增量编码，再整体 gzip 压缩
+const (
+	CapListCompress       = "list-gzip-delta" // 客户端支持压缩列表的能力标志
+	listEncodingGzipDelta = "gzip-delta"      // 响应中标识压缩列表的编码名
+)
+
+// listEntry 压缩列表中的一条记录，Prefix 为与上一条路径相同的前缀字节数
+type listEntry struct {
+	Prefix  int          `json:"l,omitempty"`
+	Suffix  string       `json:"s"`
+	Size    int64        `json:"z,omitempty"`
+	ModTime int64        `json:"t"`
+	Nanos   int          `json:"n,omitempty"`
+	IsDir   bool         `json:"d,omitempty"`
+	Mode    int          `json:"m"`
+	Type    string       `json:"y,omitempty"`
+	MD5     string       `json:"h,omitempty"`
+	Owner   *Owner       `json:"o,omitempty"`
+	TextMD5 string       `json:"x,omitempty"`
+	Windows *WindowsMeta `json:"w,omitempty"`
+	Xattrs  []StreamInfo `json:"a,omitempty"`
+}
+
+// hasCapability 检查请求是否声明了指定能力
+func hasCapability(req Request, capability string) bool {
+	for _, c := range req.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// commonPrefix 返回两个字符串的公共前缀长度
+func commonPrefix(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// gzipWriters 复用 gzip 压缩器，每个压缩器在第一次写入时会分配近 1MB 的内部状态
+var gzipWriters = sync.Pool{
+	New: func() any {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// writeCompressedListing 将文件列表增量编码后以 gzip 流写出，逐条编码，不在内存中生成完整的列表
+func writeCompressedListing(w io.Writer, files []FileInfo) error {
+	zw := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(zw)
+	zw.Reset(w)
+	enc := json.NewEncoder(zw)
+
+	prev := ""
+	for _, f := range files {
+		prefix := commonPrefix(prev, f.Path)
+		entry := listEntry{
+			Prefix:  prefix,
+			Suffix:  f.Path[prefix:],
+			Size:    f.Size,
+			ModTime: f.ModTime,
+			Nanos:   f.ModNanos,
+			IsDir:   f.IsDir,
+			Mode:    f.Mode,
+			Type:    f.Type,
+			MD5:     f.MD5,
+			Owner:   f.Owner,
+			TextMD5: f.TextMD5,
+			Windows: f.Windows,
+			Xattrs:  f.Xattrs,
+		}
+		if err := enc.Encode(&entry); err != nil {
+			return err
+		}
+		prev = f.Path
+	}
+
+	return zw.Close()
+}
+
+// walkCompressedListing 逐条读取 count 条压缩列表记录，还原完整路径后交给 fn，不需要先读入完整的列表
+func walkCompressedListing(r io.Reader, count int, fn func(FileInfo) error) error {
+	// 跳过 JSON 响应末尾的换行符
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return fmt.Errorf("failed to read compressed listing: %v", err)
+		}
+		if b[0] != '\n' && b[0] != '\r' {
+			break
+		}
+		br.Discard(1)
+	}
+
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return fmt.Errorf("failed to open compressed listing: %v", err)
+	}
+	defer zr.Close()
+	// 列表之后不再有其他 gzip 数据，保持的连接上不能等待下一段
+	zr.Multistream(false)
+
+	dec := json.NewDecoder(zr)
+	prev := ""
+	for i := 0; i < count; i++ {
+		var entry listEntry
+		if err := dec.Decode(&entry); err != nil {
+			return fmt.Errorf("failed to decode listing entry: %v", err)
+		}
+		if entry.Prefix < 0 || entry.Prefix > len(prev) {
+			return fmt.Errorf("invalid listing entry prefix: %d", entry.Prefix)
+		}
+
+		path := prev[:entry.Prefix] + entry.Suffix
+		err := fn(FileInfo{
+			Path:     path,
+			Size:     entry.Size,
+			ModTime:  entry.ModTime,
+			ModNanos: entry.Nanos,
+			IsDir:    entry.IsDir,
+			Mode:     entry.Mode,
+			Type:     entry.Type,
+			MD5:      entry.MD5,
+			Owner:    entry.Owner,
+			TextMD5:  entry.TextMD5,
+			Windows:  entry.Windows,
+			Xattrs:   entry.Xattrs,
+		})
+		if err != nil {
+			return err
+		}
+		prev = path
+	}
+
+	// 读到压缩数据的末尾，连接复用时下一个响应才从正确的位置开始
+	if _, err := io.Copy(io.Discard, zr); err != nil {
+		return fmt.Errorf("failed to read compressed listing: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package net
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gorsync/pkg/utils"
+)
+
+// managedPath 返回删除或创建目录请求的完整路径，拒绝服务器根目录本身以及根目录之外的路径
+func (s *Server) managedPath(path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("path is required")
+	}
+
+	if s.rootDir == "" {
+		if !filepath.IsAbs(path) {
+			return "", fmt.Errorf("path must be absolute: %s", path)
+		}
+		fullPath := filepath.Clean(path)
+		if fullPath == filepath.Dir(fullPath) {
+			return "", fmt.Errorf("refusing to modify the filesystem root")
+		}
+		return fullPath, nil
+	}
+
+	fullPath := LocalPath(s.rootDir, path)
+	rel, err := filepath.Rel(s.rootDir, fullPath)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path is outside the served directory: %s", path)
+	}
+	return fullPath, nil
+}
+
+// handleDeleteRequest 处理控制请求：删除服务器上的文件或目录（递归）
+func (s *Server) handleDeleteRequest(conn net.Conn, req Request) {
+	if !s.authorizeWrite(conn, req) {
+		return
+	}
+
+	fullPath, err := s.managedPath(req.Path)
+	if err != nil {
+		s.sendError(conn, err.Error())
+		return
+	}
+	if _, err := os.Lstat(fullPath); err != nil {
+		s.sendErrorCode(conn, errorCode(err), fmt.Sprintf("Failed to stat path: %v", err))
+		return
+	}
+
+	logf(conn, "Delete requested by %s: %s\n", conn.RemoteAddr(), fullPath)
+	if err := os.RemoveAll(fullPath); err != nil {
+		s.sendError(conn, fmt.Sprintf("Delete failed: %v", err))
+		return
+	}
+
+	resp := Response{
+		Status: "ok",
+	}
+	if err := json.NewEncoder(conn).Encode(&resp); err != nil {
+		logf(conn, "Failed to send response: %v\n", err)
+	}
+}
+
+// handleMoveRequest 处理控制请求：在服务器上将 Path 改名为 Target，目标已存在时拒绝
+func (s *Server) handleMoveRequest(conn net.Conn, req Request) {
+	if !s.authorizeWrite(conn, req) {
+		return
+	}
+
+	source, err := s.managedPath(req.Path)
+	if err != nil {
+		s.sendError(conn, err.Error())
+		return
+	}
+	target, err := s.managedPath(req.Target)
+	if err != nil {
+		s.sendError(conn, err.Error())
+		return
+	}
+	if _, err := os.Lstat(source); err != nil {
+		s.sendErrorCode(conn, errorCode(err), fmt.Sprintf("Failed to stat path: %v", err))
+		return
+	}
+	if _, err := os.Lstat(target); err == nil {
+		s.sendError(conn, fmt.Sprintf("Target already exists: %s", req.Target))
+		return
+	}
+
+	logf(conn, "Move requested by %s: %s -> %s\n", conn.RemoteAddr(), source, target)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		s.sendError(conn, fmt.Sprintf("Move failed: %v", err))
+		return
+	}
+	if err := utils.Saferename(source, target); err != nil {
+		s.sendError(conn, fmt.Sprintf("Move failed: %v", err))
+		return
+	}
+
+	resp := Response{
+		Status: "ok",
+	}
+	if err := json.NewEncoder(conn).Encode(&resp); err != nil {
+		logf(conn, "Failed to send response: %v\n", err)
+	}
+}
+
+// handleMkdirRequest 处理控制请求：在服务器上创建目录（包括不存在的父目录）
+func (s *Server) handleMkdirRequest(conn net.Conn, req Request) {
+	if !s.authorizeWrite(conn, req) {
+		return
+	}
+
+	fullPath, err := s.managedPath(req.Path)
+	if err != nil {
+		s.sendError(conn, err.Error())
+		return
+	}
+
+	if err := ValidateMode(req.Mode, TypeDir); err != nil {
+		s.sendError(conn, err.Error())
+		return
+	}
+	mode := FileMode(req.Mode, false)
+	if mode == 0 {
+		mode = 0755
+	}
+
+	logf(conn, "Mkdir requested by %s: %s (%s)\n", conn.RemoteAddr(), fullPath, mode)
+	if err := os.MkdirAll(fullPath, mode); err != nil {
+		s.sendError(conn, fmt.Sprintf("Mkdir failed: %v", err))
+		return
+	}
+
+	resp := Response{
+		Status: "ok",
+	}
+	if err := json.NewEncoder(conn).Encode(&resp); err != nil {
+		logf(conn, "Failed to send response: %v\n", err)
+	}
+}
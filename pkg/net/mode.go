@@ -0,0 +1,102 @@
+package net
+
+import (
+	"fmt"
+	"os"
+)
+
+// 协议中文件模式的可移植编码：低 9 位为权限位，其上三位为 setuid、setgid 和 sticky，
+// 与 POSIX 的 st_mode 相同。os.FileMode 把类型和特殊位放在随平台解释的高位，不直接放进协议
+const (
+	ModeSetuid = 0o4000
+	ModeSetgid = 0o2000
+	ModeSticky = 0o1000
+
+	modeMask = 0o7777
+)
+
+// 文件类型，普通文件为空
+const (
+	TypeDir     = "dir"
+	TypeSymlink = "symlink"
+	TypeDevice  = "device"
+	TypePipe    = "pipe"
+	TypeSocket  = "socket"
+	TypeOther   = "other"
+)
+
+// ModeBits 本地模式中可与远程比较的部分：权限位和特殊位
+const ModeBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// EncodeMode 把 os.FileMode 的权限位和特殊位编码为协议中的模式
+func EncodeMode(m os.FileMode) int {
+	mode := int(m.Perm())
+	if m&os.ModeSetuid != 0 {
+		mode |= ModeSetuid
+	}
+	if m&os.ModeSetgid != 0 {
+		mode |= ModeSetgid
+	}
+	if m&os.ModeSticky != 0 {
+		mode |= ModeSticky
+	}
+	return mode
+}
+
+// FileType 返回 os.FileMode 的文件类型
+func FileType(m os.FileMode) string {
+	switch {
+	case m.IsRegular():
+		return ""
+	case m.IsDir():
+		return TypeDir
+	case m&os.ModeSymlink != 0:
+		return TypeSymlink
+	case m&os.ModeDevice != 0:
+		return TypeDevice
+	case m&os.ModeNamedPipe != 0:
+		return TypePipe
+	case m&os.ModeSocket != 0:
+		return TypeSocket
+	default:
+		return TypeOther
+	}
+}
+
+// ValidateMode 检查收到的模式和文件类型，拒绝超出可移植编码的位和未知类型
+func ValidateMode(mode int, fileType string) error {
+	if mode < 0 || mode&^modeMask != 0 {
+		return fmt.Errorf("invalid mode %#o", mode)
+	}
+	switch fileType {
+	case "", TypeDir, TypeSymlink, TypeDevice, TypePipe, TypeSocket, TypeOther:
+		return nil
+	default:
+		return fmt.Errorf("unknown file type %q", fileType)
+	}
+}
+
+// upgradeLegacyMode 旧版本服务器直接发送 os.FileMode 的值：类型和特殊位都在 12 位以上，
+// 低位不会出现特殊位，据此识别并转换为可移植编码
+func upgradeLegacyMode(f *FileInfo) {
+	if f.Mode > modeMask && f.Mode&(ModeSetuid|ModeSetgid|ModeSticky) == 0 && f.Type == "" {
+		m := os.FileMode(uint32(f.Mode))
+		f.Mode, f.Type = EncodeMode(m), FileType(m)
+	}
+}
+
+// FileMode 把协议中的模式转换为可以应用到本地文件的 os.FileMode。
+// special 为 false 时去掉 setuid 和 setgid，sticky 总是保留
+func FileMode(mode int, special bool) os.FileMode {
+	m := os.FileMode(mode) & os.ModePerm
+	if special && mode&ModeSetuid != 0 {
+		m |= os.ModeSetuid
+	}
+	if special && mode&ModeSetgid != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&ModeSticky != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
@@ -0,0 +1,26 @@
+package net
+
+import (
+	"time"
+)
+
+// modTimePrecision 比较修改时间的亚秒部分时的精度，与 NTFS 和 SMB 时间戳的 100 纳秒精度相同，
+// 纳秒精度的文件系统同步到这些文件系统后仍视为相同
+const modTimePrecision = 100 * time.Nanosecond
+
+// Modified 返回文件的修改时间，包括亚秒部分
+func (f FileInfo) Modified() time.Time {
+	return time.Unix(f.ModTime, int64(f.ModNanos))
+}
+
+// SameModTime 比较两个修改时间，相差不超过 window 时视为相同。
+// window 为 0 时按 100 纳秒的精度比较；任一方没有亚秒部分（只精确到秒的文件系统或旧版本的对端）时只比较到秒
+func SameModTime(a, b time.Time, window time.Duration) bool {
+	if window > 0 {
+		return a.Sub(b).Truncate(time.Second).Abs() <= window
+	}
+	if a.Nanosecond() == 0 || b.Nanosecond() == 0 {
+		return a.Unix() == b.Unix()
+	}
+	return a.Truncate(modTimePrecision).Equal(b.Truncate(modTimePrecision))
+}
@@ -0,0 +1,48 @@
+package net
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gorsync/pkg/utils"
+)
+
+// partialPrefix 保留的部分下载文件的名称前缀，与目标文件在同一目录
+const partialPrefix = ".gorsync-partial-"
+
+// PartialName 返回 localPath 的部分下载文件的路径
+func PartialName(localPath string) string {
+	return filepath.Join(filepath.Dir(localPath), partialPrefix+filepath.Base(localPath))
+}
+
+// IsPartialName 检查文件名是否为保留的部分下载文件，本地列表中不包含这些文件
+func IsPartialName(name string) bool {
+	return strings.HasPrefix(filepath.Base(name), partialPrefix)
+}
+
+// SetPartial 设置连接断开或取消时是否保留已下载的部分，下次下载同一文件时请求服务器确认已有部分未变后只传输剩余的内容
+func (c *Client) SetPartial(enabled bool) {
+	c.partial = enabled
+}
+
+// partialOffset 检查 localPath 保留的部分下载能否续传，返回其大小和MD5；不能续传时删除部分下载并返回 0。
+// 续传需要列表中的大小和MD5（续传的响应不带整个文件的MD5），文本模式转换过的内容不能续传
+func (c *Client) partialOffset(remotePath, localPath string, textMode bool) (int64, string) {
+	partialPath := PartialName(localPath)
+	info, err := os.Stat(partialPath)
+	if err != nil {
+		return 0, ""
+	}
+	f, cached := c.cachedFile(remotePath)
+	if !c.partial || textMode || !cached || f.MD5 == "" || !info.Mode().IsRegular() || info.Size() == 0 || info.Size() >= f.Size {
+		os.Remove(partialPath)
+		return 0, ""
+	}
+	md5, err := utils.CalculateMD5(partialPath)
+	if err != nil {
+		os.Remove(partialPath)
+		return 0, ""
+	}
+	return info.Size(), md5
+}
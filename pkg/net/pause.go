@@ -0,0 +1,122 @@
+package net
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Pause 一组传输的暂停状态，暂停后传输在数据块之间等待，连接保持打开。零值表示未暂停，可以直接使用。
+// 每个 Client 可以通过 SetPause 使用自己的暂停状态，PauseTransfers 等函数控制整个进程的传输
+type Pause struct {
+	mutex   sync.Mutex
+	resumed chan struct{} // 暂停期间不为 nil，恢复时关闭
+}
+
+// Pause 暂停传输，正在传输的数据块完成后才会停下，返回状态是否由运行变为暂停
+func (p *Pause) Pause() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.resumed != nil {
+		return false
+	}
+	p.resumed = make(chan struct{})
+	return true
+}
+
+// Resume 恢复传输，返回状态是否由暂停变为运行
+func (p *Pause) Resume() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.resumed == nil {
+		return false
+	}
+	close(p.resumed)
+	p.resumed = nil
+	return true
+}
+
+// Paused 返回传输是否处于暂停状态
+func (p *Pause) Paused() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.resumed != nil
+}
+
+// wait 在暂停状态下阻塞，直到传输被恢复；cancel 关闭时不再等待，返回 ErrCanceled。p 为 nil 时不等待
+func (p *Pause) wait(cancel <-chan struct{}) error {
+	if p == nil {
+		return nil
+	}
+	for {
+		p.mutex.Lock()
+		resumed := p.resumed
+		p.mutex.Unlock()
+		if resumed == nil {
+			return nil
+		}
+
+		select {
+		case <-resumed:
+		case <-cancel:
+			return ErrCanceled
+		}
+	}
+}
+
+// transfers 整个进程的暂停状态，作用于当前进程中所有正在进行的传输
+var transfers Pause
+
+// PauseTransfers 暂停进程中的所有传输，正在传输的数据块完成后才会停下，连接保持打开
+func PauseTransfers() {
+	if transfers.Pause() {
+		fmt.Printf("Transfers paused\n")
+	}
+}
+
+// ResumeTransfers 恢复进程中的所有传输，用 Pause 单独暂停的传输仍然暂停
+func ResumeTransfers() {
+	if transfers.Resume() {
+		fmt.Printf("Transfers resumed\n")
+	}
+}
+
+// TransfersPaused 返回进程中的传输是否处于暂停状态
+func TransfersPaused() bool {
+	return transfers.Paused()
+}
+
+// toggleTransfers 切换暂停状态
+func toggleTransfers() {
+	if TransfersPaused() {
+		ResumeTransfers()
+	} else {
+		PauseTransfers()
+	}
+}
+
+// waitIfPaused 服务器发送数据时在进程的暂停状态下阻塞，直到传输被恢复
+func waitIfPaused() {
+	transfers.wait(nil)
+}
+
+// waitIfPaused 在进程或客户端的暂停状态下阻塞，直到传输被恢复；SetCancel 设置的取消信号关闭时返回 ErrCanceled
+func (c *Client) waitIfPaused() error {
+	// 等待其中一个恢复时另一个可能又被暂停，两者都不在暂停状态时才继续
+	for transfers.Paused() || c.pause != nil && c.pause.Paused() {
+		if err := transfers.wait(c.cancel); err != nil {
+			return err
+		}
+		if err := c.pause.wait(c.cancel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetPause 设置客户端的暂停状态，多个客户端可共享同一个 Pause；nil 表示只受 PauseTransfers 控制
+func (c *Client) SetPause(pause *Pause) {
+	c.pause = pause
+}
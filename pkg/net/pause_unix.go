@@ -0,0 +1,21 @@
+//go:build !windows
+
+package net
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// HandlePauseSignal 收到 SIGUSR1 时切换传输的暂停状态
+func HandlePauseSignal() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1)
+
+	go func() {
+		for range sigs {
+			toggleTransfers()
+		}
+	}()
+}
@@ -0,0 +1,6 @@
+//go:build windows
+
+package net
+
+// HandlePauseSignal Windows 没有 SIGUSR1，只能通过库接口暂停和恢复
+func HandlePauseSignal() {}
@@ -0,0 +1,107 @@
+package net
+
+import (
+	"fmt"
+	"sync"
+)
+
+// PullPeer 允许服务器拉取的对端：pull 请求只能从登记的对端拉取到登记的本地目录之下
+type PullPeer struct {
+	Name   string `json:"name"`
+	Remote string `json:"remote"` // 对端地址，格式: host[:port]:path
+	Path   string `json:"path"`   // 拉取到的本地目录，请求的路径须为该目录或其下
+}
+
+// checkPeers 检查登记的对端
+func checkPeers(peers []PullPeer) error {
+	seen := make(map[string]bool)
+	for _, peer := range peers {
+		if peer.Name == "" || peer.Remote == "" || peer.Path == "" {
+			return fmt.Errorf("pull peers require a name, a remote and a path")
+		}
+		if seen[peer.Name] {
+			return fmt.Errorf("pull peer %s is listed twice", peer.Name)
+		}
+		seen[peer.Name] = true
+	}
+	return nil
+}
+
+// SetPeers 设置允许拉取的对端，替换之前的设置。没有登记对端时拒绝所有 pull 请求
+func (s *Server) SetPeers(peers []PullPeer) error {
+	if err := checkPeers(peers); err != nil {
+		return err
+	}
+
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+
+	s.peers = peers
+	return nil
+}
+
+// matchPeer 查找允许从 remote（对端名称或地址）拉取到 fullPath 的对端，返回对端地址和本地路径；
+// fullPath 为空时拉取到对端登记的目录
+func (s *Server) matchPeer(remote, fullPath string) (string, string, bool) {
+	s.configMutex.RLock()
+	defer s.configMutex.RUnlock()
+
+	for _, peer := range s.peers {
+		if remote != peer.Name && remote != peer.Remote {
+			continue
+		}
+		peerPath := peer.Path
+		if s.rootDir != "" {
+			peerPath = LocalPath(s.rootDir, peer.Path)
+		}
+		if fullPath == "" {
+			return peer.Remote, peerPath, true
+		}
+		if isWithin(peerPath, fullPath) {
+			return peer.Remote, fullPath, true
+		}
+	}
+	return "", "", false
+}
+
+// pullLocks 正在拉取的本地目录，同一目录或互相包含的目录同时只进行一个拉取
+type pullLocks struct {
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	active map[string]bool
+}
+
+// acquire 等待没有拉取写入与 path 重叠的目录后登记 path，返回的函数结束登记
+func (l *pullLocks) acquire(path string, waiting func()) func() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.cond == nil {
+		l.cond = sync.NewCond(&l.mutex)
+		l.active = make(map[string]bool)
+	}
+	for notified := false; l.overlaps(path); l.cond.Wait() {
+		if !notified {
+			waiting()
+			notified = true
+		}
+	}
+	l.active[path] = true
+
+	return func() {
+		l.mutex.Lock()
+		delete(l.active, path)
+		l.mutex.Unlock()
+		l.cond.Broadcast()
+	}
+}
+
+// overlaps 检查是否有正在进行的拉取写入 path 或其上级、下级目录，调用时需持有锁
+func (l *pullLocks) overlaps(path string) bool {
+	for active := range l.active {
+		if isWithin(active, path) || isWithin(path, active) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package net
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+// HealthInfo ping 请求返回的服务器状态，用于负载均衡器和监控的健康检查
+type HealthInfo struct {
+	Version     int   `json:"version"`        // 协议版本
+	Uptime      int64 `json:"uptime"`         // 开始监听后经过的时间（秒）
+	Connections int64 `json:"connections"`    // 正在处理的连接数（包括本次 ping）
+	Time        int64 `json:"time,omitempty"` // 服务器的当前时间（Unix 毫秒），用于估算时钟偏差
+}
+
+// handlePingRequest 返回服务器的版本、运行时间和负载，不访问文件系统
+func (s *Server) handlePingRequest(conn net.Conn) {
+	resp := Response{
+		Status: "ok",
+		Health: &HealthInfo{
+			Version:     ProtocolVersion,
+			Uptime:      int64(time.Since(s.started).Seconds()),
+			Connections: s.active.Load(),
+			Time:        time.Now().UnixMilli(),
+		},
+	}
+	if err := json.NewEncoder(conn).Encode(&resp); err != nil {
+		logf(conn, "Failed to send response: %v\n", err)
+	}
+}
+
+// Ping 检查服务器是否在线，timeout 大于 0 时限制连接和等待响应的总时间
+func (c *Client) Ping(timeout time.Duration) (*HealthInfo, error) {
+	var conn net.Conn
+	var err error
+	if timeout > 0 {
+		addr := net.JoinHostPort(c.addr, fmt.Sprintf("%d", c.port))
+		conn, err = net.DialTimeout("tcp", addr, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to server: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(timeout))
+	} else {
+		conn, err = c.connect()
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer conn.Close()
+
+	req := Request{
+		Type: "ping",
+	}
+	if err := c.send(conn, &req); err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	if resp.Status != "ok" {
+		return nil, responseError(&resp)
+	}
+	if resp.Health == nil {
+		return nil, fmt.Errorf("no health info in response")
+	}
+
+	return resp.Health, nil
+}
+
+// ClockSkew 通过 ping 估算服务器时钟比本地时钟快多少，以往返时间的中点作为服务器读取时钟的时刻
+func (c *Client) ClockSkew(timeout time.Duration) (time.Duration, error) {
+	start := time.Now()
+	health, err := c.Ping(timeout)
+	if err != nil {
+		return 0, err
+	}
+	if health.Time == 0 {
+		return 0, fmt.Errorf("server does not report its clock")
+	}
+	mid := start.Add(time.Since(start) / 2)
+	return time.UnixMilli(health.Time).Sub(mid), nil
+}
@@ -0,0 +1,149 @@
+package net
+
+import (
+	"io"
+	"runtime"
+	"sync"
+)
+
+// 并行编码的参数
+const (
+	// maxEncodeWorkers 默认每个传输最多使用的编码 goroutine 数，避免少数大文件占满所有核心
+	maxEncodeWorkers = 8
+	// parallelEncodeMin 小于此大小的文件按原来的方式在请求的 goroutine 中编码
+	parallelEncodeMin = 1 << 20
+	// gzipBlockSize 并行压缩时每个 gzip 成员的输入大小
+	gzipBlockSize = 256 * 1024
+)
+
+// defaultEncodeWorkers 返回未设置时每个传输的编码 goroutine 数
+func defaultEncodeWorkers() int {
+	return min(runtime.GOMAXPROCS(0), maxEncodeWorkers)
+}
+
+// pipelineJob 交给编码 goroutine 的一个数据块
+type pipelineJob struct {
+	seq    uint64
+	block  []byte
+	result chan pipelineResult
+}
+
+// pipelineResult 编码后的数据块
+type pipelineResult struct {
+	data []byte
+	err  error
+}
+
+// parallelWriter 把写入的数据按 blockSize 分块，由多个 goroutine 并行编码，再按原来的顺序写入下层。
+// 读取文件、编码和发送分别在不同的 goroutine 中进行，有界的通道限制同时在处理的数据块数，
+// 下层写得慢时 Write 阻塞，不会无限缓存
+type parallelWriter struct {
+	w         io.Writer
+	encode    func(seq uint64, block []byte) ([]byte, error)
+	blockSize int
+	buf       []byte
+	seq       uint64
+	jobs      chan pipelineJob
+	order     chan chan pipelineResult // 按写入顺序排列的结果，由发送 goroutine 依次等待
+	workers   sync.WaitGroup
+	done      chan struct{}
+
+	mutex sync.Mutex
+	err   error // 第一个编码或发送错误
+}
+
+// newParallelWriter 启动 workers 个编码 goroutine 和一个发送 goroutine，写完后必须调用 Close
+func newParallelWriter(w io.Writer, workers, blockSize int, encode func(seq uint64, block []byte) ([]byte, error)) *parallelWriter {
+	p := &parallelWriter{
+		w:         w,
+		encode:    encode,
+		blockSize: blockSize,
+		jobs:      make(chan pipelineJob, workers),
+		order:     make(chan chan pipelineResult, 2*workers),
+		done:      make(chan struct{}),
+	}
+	for range workers {
+		p.workers.Add(1)
+		go p.work()
+	}
+	go p.send()
+	return p
+}
+
+// work 编码数据块，结果通道有缓冲，不等待发送
+func (p *parallelWriter) work() {
+	defer p.workers.Done()
+	for job := range p.jobs {
+		data, err := p.encode(job.seq, job.block)
+		job.result <- pipelineResult{data: data, err: err}
+	}
+}
+
+// send 按顺序写出编码后的数据块，出错后丢弃剩余的数据块
+func (p *parallelWriter) send() {
+	defer close(p.done)
+	for result := range p.order {
+		r := <-result
+		if p.failed() != nil {
+			continue
+		}
+		if r.err == nil {
+			_, r.err = p.w.Write(r.data)
+		}
+		if r.err != nil {
+			p.mutex.Lock()
+			p.err = r.err
+			p.mutex.Unlock()
+		}
+	}
+}
+
+func (p *parallelWriter) failed() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.err
+}
+
+func (p *parallelWriter) Write(b []byte) (int, error) {
+	if err := p.failed(); err != nil {
+		return 0, err
+	}
+
+	written := 0
+	for len(b) > 0 {
+		if p.buf == nil {
+			p.buf = make([]byte, 0, p.blockSize)
+		}
+		n := copy(p.buf[len(p.buf):cap(p.buf)], b)
+		p.buf = p.buf[:len(p.buf)+n]
+		b = b[n:]
+		written += n
+		if len(p.buf) == cap(p.buf) {
+			p.dispatch()
+		}
+	}
+	return written, nil
+}
+
+// dispatch 把当前的数据块交给编码 goroutine，在途的数据块太多时等待
+func (p *parallelWriter) dispatch() {
+	result := make(chan pipelineResult, 1)
+	p.order <- result
+	p.jobs <- pipelineJob{seq: p.seq, block: p.buf, result: result}
+	p.seq++
+	p.buf = nil
+}
+
+// Close 编码剩余的数据，等待所有数据块写出。没有写入任何数据时也编码一个空数据块，
+// 使 gzip 等格式得到完整的空流
+func (p *parallelWriter) Close() error {
+	if len(p.buf) > 0 || p.seq == 0 {
+		p.dispatch()
+	}
+	close(p.order)
+	close(p.jobs)
+	<-p.done
+	p.workers.Wait()
+	return p.failed()
+}
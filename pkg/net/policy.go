@@ -0,0 +1,114 @@
+package net
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"gorsync/pkg/utils"
+)
+
+// 策略可以指定的增量上传块大小的范围
+const (
+	minPolicyBlock = minDeltaBlock
+	maxPolicyBlock = 4 * 1024 * 1024
+)
+
+// TransferPolicy 文件名匹配 Pattern 的文件的传输策略，为 nil 或 0 的项不设置，由后面匹配的规则或默认行为决定
+type TransferPolicy struct {
+	Pattern   string
+	Compress  *bool // 下载时是否以 gzip 压缩传输，覆盖 --transform 中的 gzip
+	Delta     *bool // 上传时是否只发送服务器没有的块，false 时不请求签名，直接发送整个文件
+	BlockSize int   // 增量上传的块大小，0 表示按文件大小选择
+}
+
+// ParseTransferPolicy 解析 pattern=setting[,setting...] 格式的策略规则，setting 为
+// compress、nocompress、delta、nodelta 或 block=<size>；模式不含 / 时匹配文件名，否则匹配完整的远程路径
+func ParseTransferPolicy(rule string) (TransferPolicy, error) {
+	pattern, settings, ok := strings.Cut(rule, "=")
+	if !ok || pattern == "" || settings == "" {
+		return TransferPolicy{}, fmt.Errorf("invalid policy rule %q, expected pattern=setting[,setting...]", rule)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return TransferPolicy{}, fmt.Errorf("invalid policy pattern %q: %v", pattern, err)
+	}
+
+	p := TransferPolicy{Pattern: pattern}
+	enabled, disabled := true, false
+	for _, setting := range strings.Split(settings, ",") {
+		setting = strings.TrimSpace(setting)
+		switch {
+		case setting == "compress":
+			p.Compress = &enabled
+		case setting == "nocompress":
+			p.Compress = &disabled
+		case setting == "delta":
+			p.Delta = &enabled
+		case setting == "nodelta":
+			p.Delta = &disabled
+		case strings.HasPrefix(setting, "block="):
+			size, err := utils.ParseSize(strings.TrimPrefix(setting, "block="))
+			if err != nil || size < minPolicyBlock || size > maxPolicyBlock {
+				return TransferPolicy{}, fmt.Errorf("invalid block size in policy %q: must be between %s and %s",
+					rule, utils.FormatSize(minPolicyBlock), utils.FormatSize(maxPolicyBlock))
+			}
+			p.BlockSize = int(size)
+		default:
+			return TransferPolicy{}, fmt.Errorf("unknown policy setting %q, expected compress, nocompress, delta, nodelta or block=<size>", setting)
+		}
+	}
+	return p, nil
+}
+
+// matchPath 检查远程路径是否匹配规则的模式，模式不含 / 时只匹配文件名
+func matchPath(pattern, remotePath string) bool {
+	name := remotePath
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(remotePath)
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+// matchPolicy 合并匹配远程路径的规则，每一项取第一条设置了该项的匹配规则
+func matchPolicy(rules []TransferPolicy, remotePath string) TransferPolicy {
+	var p TransferPolicy
+	for _, rule := range rules {
+		if !matchPath(rule.Pattern, remotePath) {
+			continue
+		}
+		if p.Compress == nil {
+			p.Compress = rule.Compress
+		}
+		if p.Delta == nil {
+			p.Delta = rule.Delta
+		}
+		if p.BlockSize == 0 {
+			p.BlockSize = rule.BlockSize
+		}
+	}
+	return p
+}
+
+// applyCompression 按策略在变换列表中加入或去掉 gzip，压缩在其他变换（如加密）之前进行
+func applyCompression(names []string, compress *bool) []string {
+	if compress == nil {
+		return names
+	}
+	has := slices.Contains(names, TransformGzip)
+	switch {
+	case *compress && !has:
+		return append([]string{TransformGzip}, names...)
+	case !*compress && has:
+		return slices.DeleteFunc(slices.Clone(names), func(name string) bool {
+			return name == TransformGzip
+		})
+	}
+	return names
+}
+
+// SetPolicies 设置按文件名选择的传输策略
+func (c *Client) SetPolicies(rules []TransferPolicy) {
+	c.policies = rules
+}
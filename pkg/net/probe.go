@@ -0,0 +1,94 @@
+package net
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+)
+
+// ProtocolVersion 协议版本，请求或响应出现不兼容的变化时递增
+const ProtocolVersion = 1
+
+// requestTypes 服务器支持的请求类型
+var requestTypes = []string{"list", "file", "stat", "checksum", "probe", "ping", "pull", "release", "reload", "delete", "mkdir", "move", "signature", "upload", "commit", "abort", "admin", "browse", "find", "dict"}
+
+// ServerInfo probe 请求返回的服务器能力，用于排查不同版本混合部署的问题
+type ServerInfo struct {
+	Version      int      `json:"version"`
+	Requests     []string `json:"requests"`     // 支持的请求类型
+	Capabilities []string `json:"capabilities"` // 可协商的可选特性，见 CapListCompress
+	Transforms   []string `json:"transforms"`   // 支持的传输变换
+	Checksums    []string `json:"checksums"`    // checksum 请求支持的算法
+	Control      bool     `json:"control"`      // 是否接受控制请求
+	Pull         bool     `json:"pull"`         // 是否支持服务器端拉取
+	Integrity    bool     `json:"integrity"`    // 是否启用完整性模式
+	Snapshots    bool     `json:"snapshots"`    // 列表请求是否使用快照
+	// 服务器端的限制
+	HashCacheEntries int   `json:"hashCacheEntries"` // MD5缓存的最大条目数
+	ListingCacheTTL  int64 `json:"listingCacheTTL"`  // 目录遍历结果的缓存时间（毫秒）
+	MaxTransfers     int   `json:"maxTransfers"`     // 同时处理的列表和传输请求数，0 表示不限制
+}
+
+// handleProbeRequest 返回服务器的协议版本和支持的特性
+func (s *Server) handleProbeRequest(conn net.Conn) {
+	s.configMutex.RLock()
+	info := &ServerInfo{
+		Version:          ProtocolVersion,
+		Requests:         requestTypes,
+		Capabilities:     []string{CapListCompress, CapKeepAlive},
+		Transforms:       slices.Sorted(maps.Keys(transforms)),
+		Checksums:        slices.Sorted(maps.Keys(checksumAlgorithms)),
+		Control:          s.controlToken != "",
+		Pull:             s.controlToken != "" && s.pullHandler != nil && len(s.peers) > 0,
+		Integrity:        len(s.integrityKey) > 0,
+		Snapshots:        s.snapshots != nil,
+		HashCacheEntries: maxHashCacheEntries,
+		ListingCacheTTL:  listingCacheTTL.Milliseconds(),
+	}
+	if !s.noFind {
+		info.Capabilities = append(info.Capabilities, CapFind)
+	}
+	s.configMutex.RUnlock()
+	info.MaxTransfers = s.MaxTransfers()
+
+	resp := Response{
+		Status: "ok",
+		Server: info,
+	}
+	if err := json.NewEncoder(conn).Encode(&resp); err != nil {
+		logf(conn, "Failed to send response: %v\n", err)
+	}
+}
+
+// Probe 获取服务器的协议版本和支持的特性
+func (c *Client) Probe() (*ServerInfo, error) {
+	conn, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	req := Request{
+		Type: "probe",
+	}
+	if err := c.send(conn, &req); err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	if resp.Status != "ok" {
+		return nil, responseError(&resp)
+	}
+	if resp.Server == nil {
+		return nil, fmt.Errorf("no server info in response")
+	}
+	c.pool.setKeepAlive(slices.Contains(resp.Server.Capabilities, CapKeepAlive))
+
+	return resp.Server, nil
+}
@@ -0,0 +1,150 @@
+package net
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 进度显示方式
+const (
+	ProgressFile  = "file"  // 每次报告列出每个正在下载的文件
+	ProgressTotal = "total" // 每次报告只打印一行汇总
+	ProgressNone  = "none"  // 不显示进度
+)
+
+// DefaultProgressInterval 默认的进度报告间隔
+const DefaultProgressInterval = time.Second
+
+// transfer 正在进行的下载
+type transfer struct {
+	size        int64
+	transferred int64
+}
+
+// Progress 汇总所有下载的进度并按固定间隔统一打印，避免并行下载时的输出交错
+type Progress struct {
+	mode     string
+	interval time.Duration
+	out      io.Writer
+
+	mutex       sync.Mutex
+	active      map[string]*transfer
+	completed   int
+	bytes       int64 // 所有下载已接收的字节数
+	lastReport  time.Time
+	reportBytes int64 // 上次报告时的 bytes
+}
+
+// NewProgress 创建进度汇总，interval 为 0 时使用 DefaultProgressInterval
+func NewProgress(mode string, interval time.Duration) (*Progress, error) {
+	switch mode {
+	case "":
+		mode = ProgressFile
+	case ProgressFile, ProgressTotal, ProgressNone:
+	default:
+		return nil, fmt.Errorf("unknown progress mode: %s", mode)
+	}
+	if interval < 0 {
+		return nil, fmt.Errorf("invalid progress interval: %v", interval)
+	}
+	if interval == 0 {
+		interval = DefaultProgressInterval
+	}
+
+	now := time.Now()
+	return &Progress{
+		mode:       mode,
+		interval:   interval,
+		active:     make(map[string]*transfer),
+		lastReport: now,
+		out:        os.Stdout,
+	}, nil
+}
+
+// SetOutput 设置进度报告的输出，默认为标准输出
+func (p *Progress) SetOutput(w io.Writer) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.out = w
+}
+
+// begin 记录开始下载的文件
+func (p *Progress) begin(path string, size int64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.active[path] = &transfer{size: size}
+}
+
+// add 记录文件新接收的字节数，距上次报告超过间隔时打印进度
+func (p *Progress) add(path string, n int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if t, ok := p.active[path]; ok {
+		t.transferred += int64(n)
+	}
+	p.bytes += int64(n)
+
+	if p.mode == ProgressNone || time.Since(p.lastReport) < p.interval {
+		return
+	}
+	p.report()
+}
+
+// end 记录文件下载结束（无论成功与否）
+func (p *Progress) end(path string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, ok := p.active[path]; ok {
+		delete(p.active, path)
+		p.completed++
+	}
+}
+
+// Active 返回正在进行的下载数及其已接收的字节数
+func (p *Progress) Active() (int, int64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var received int64
+	for _, t := range p.active {
+		received += t.transferred
+	}
+	return len(p.active), received
+}
+
+// report 打印当前进度，调用时需持有 mutex
+func (p *Progress) report() {
+	now := time.Now()
+	elapsed := now.Sub(p.lastReport).Seconds()
+	rate := float64(p.bytes-p.reportBytes) / elapsed / 1024 / 1024
+	p.lastReport = now
+	p.reportBytes = p.bytes
+
+	fmt.Fprintf(p.out, "Download progress: %d active, %d completed, %.2f MB received, %.2f MB/s\n",
+		len(p.active), p.completed, float64(p.bytes)/1024/1024, rate)
+	if p.mode != ProgressFile {
+		return
+	}
+
+	paths := make([]string, 0, len(p.active))
+	for path := range p.active {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		t := p.active[path]
+		percent := float64(100)
+		if t.size > 0 {
+			percent = float64(t.transferred) / float64(t.size) * 100
+		}
+		fmt.Fprintf(p.out, "    %s %.1f%% (%d/%d bytes)\n", path, percent, t.transferred, t.size)
+	}
+}
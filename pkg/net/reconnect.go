@@ -0,0 +1,220 @@
+package net
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorsync/pkg/utils"
+)
+
+// ErrConnectionLost 与服务器的连接在请求过程中断开，或曾经连接成功的服务器拒绝了新连接，通常是服务器正在重启
+var ErrConnectionLost = errors.New("connection to server lost")
+
+// connFiles 每个连接占用的文件描述符名额：连接本身和请求读写的本地文件
+const connFiles = 2
+
+// maxResumes 一次下载中连接断开后在新连接上续传的最多次数，超过后按连接断开失败，由同步层等待服务器恢复后重新下载
+const maxResumes = 3
+
+// 等待服务器恢复时两次检查之间的间隔，从 reconnectMinDelay 开始每次翻倍，最长 reconnectMaxDelay
+const (
+	reconnectMinDelay = time.Second
+	reconnectMaxDelay = 30 * time.Second
+)
+
+// watchedConn 记录连接是否在读写时断开，用于区分连接断开和服务器返回的错误。
+// 关闭时归还建立连接前申请的文件描述符名额
+type watchedConn struct {
+	net.Conn
+	broken   atomic.Bool
+	canceled atomic.Bool // 连接因客户端取消而被断开
+	closed   sync.Once
+	done     chan struct{} // 关闭时关闭，结束等待取消信号的 goroutine
+	pooled   bool          // 由 pooledConnect 取得，请求带 KeepAlive，结束后归还连接池
+}
+
+func (c *watchedConn) Close() error {
+	err := c.Conn.Close()
+	c.closed.Do(func() {
+		utils.ReleaseFiles(connFiles)
+		close(c.done)
+	})
+	return err
+}
+
+func (c *watchedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.watch(err)
+	return n, err
+}
+
+func (c *watchedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.watch(err)
+	return n, err
+}
+
+// watch 读写出错时标记连接已断开，超时不算断开
+func (c *watchedConn) watch(err error) {
+	if err == nil {
+		return
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return
+	}
+	c.broken.Store(true)
+}
+
+// checkLost 请求失败且连接已断开时把错误标记为 ErrConnectionLost，因取消而断开时标记为 ErrCanceled
+func checkLost(conn net.Conn, err *error) {
+	if *err == nil || errors.Is(*err, ErrConnectionLost) || errors.Is(*err, ErrCanceled) {
+		return
+	}
+	wc, ok := conn.(*watchedConn)
+	switch {
+	case !ok:
+	case wc.canceled.Load():
+		*err = fmt.Errorf("%w: %w", ErrCanceled, *err)
+	case wc.broken.Load():
+		*err = fmt.Errorf("%w: %w", ErrConnectionLost, *err)
+	}
+}
+
+// resumable 检查下载的连接是否在传输中断开，而不是因取消被关闭或数据本身解码失败
+func resumable(conn net.Conn) bool {
+	wc, ok := conn.(*watchedConn)
+	return ok && wc.broken.Load() && !wc.canceled.Load()
+}
+
+// resumeDownload 在新连接上请求文件从 offset 开始的剩余部分（与追加下载相同的 Offset，但不校验前缀），
+// 服务器上的文件大小或修改时间与第一次的响应 first 不同时返回 ErrRemoteChanged。返回的数据已按相同的变换解码
+func (c *Client) resumeDownload(path string, first *Response, offset int64) (net.Conn, io.Reader, error) {
+	conn, err := c.connect()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dictID, dict := c.dictFor(first.Transforms)
+	req := Request{
+		Type:       "file",
+		Path:       path,
+		Offset:     offset,
+		Transforms: first.Transforms,
+		DictID:     dictID,
+	}
+	if err := c.send(conn, &req); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to send request: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	var resp Response
+	jsonData, err := reader.ReadBytes('\n')
+	if err == nil {
+		err = json.Unmarshal(jsonData, &resp)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+	if resp.Status != "ok" {
+		conn.Close()
+		return nil, nil, responseError(&resp)
+	}
+	file := first.File
+	if resp.File == nil || resp.File.Size != file.Size || resp.File.ModTime != file.ModTime || resp.File.ModNanos != file.ModNanos {
+		conn.Close()
+		return nil, nil, fmt.Errorf("%w: file changed before the download could be resumed", ErrRemoteChanged)
+	}
+	if ret, err := reader.ReadByte(); err != nil || ret != '\n' {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to decode response: missing separator")
+	}
+
+	if !slices.Equal(resp.Transforms, first.Transforms) {
+		conn.Close()
+		return nil, nil, fmt.Errorf("server applied unexpected transforms: %s", strings.Join(resp.Transforms, ","))
+	}
+	var data io.Reader = reader
+	if len(resp.Transforms) > 0 {
+		if data, err = decodeTransforms(reader, resp.Transforms, c.integrityKey, dict); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+	return conn, data, nil
+}
+
+// resumeShortRead 在文件数据于 offset 处提前结束或读取失败时调用。连接在传输中断开时在新连接上续传，
+// 返回新的连接和数据，由调用者关闭旧连接；连接完好而数据流正常结束，说明服务器上的文件在传输中变短，
+// 返回 ErrRemoteChanged。不能续传时返回读取错误，连接断开的情况由 checkLost 标记为 ErrConnectionLost
+func (c *Client) resumeShortRead(conn net.Conn, path string, first *Response, offset int64, resumes *int, readErr error) (net.Conn, io.Reader, error) {
+	size := first.File.Size
+	if !resumable(conn) || c.canceled() {
+		if readErr == nil || readErr == io.EOF {
+			return nil, nil, fmt.Errorf("%w: received %d of %d bytes", ErrRemoteChanged, offset, size)
+		}
+		return nil, nil, fmt.Errorf("failed to read file data: %v", readErr)
+	}
+	if readErr == nil || readErr == io.EOF {
+		readErr = io.ErrUnexpectedEOF
+	}
+	if *resumes >= maxResumes {
+		return nil, nil, fmt.Errorf("failed to read file data after %d of %d bytes: %v", offset, size, readErr)
+	}
+
+	resumed, data, err := c.resumeDownload(path, first, offset)
+	if err != nil {
+		if errors.Is(err, ErrRemoteChanged) {
+			return nil, nil, err
+		}
+		return nil, nil, fmt.Errorf("failed to read file data after %d of %d bytes: %v (resume failed: %v)", offset, size, readErr, err)
+	}
+	*resumes++
+	return resumed, data, nil
+}
+
+// IsConnectionLost 检查错误是否由连接断开引起，此时等待服务器恢复后可以重试同一个请求
+func IsConnectionLost(err error) bool {
+	return errors.Is(err, ErrConnectionLost)
+}
+
+// WaitForServer 连接断开后按指数退避等待服务器恢复，直到 ping 成功且协议版本不变，
+// 超过 timeout 仍未恢复时返回错误
+func (c *Client) WaitForServer(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	delay := reconnectMinDelay
+	for {
+		health, err := c.Ping(reconnectMaxDelay)
+		if errors.Is(err, ErrCanceled) {
+			return err
+		}
+		if err == nil {
+			if health.Version != ProtocolVersion {
+				return fmt.Errorf("server came back with protocol version %d, expected %d", health.Version, ProtocolVersion)
+			}
+			return nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return fmt.Errorf("server did not come back within %s: %v", timeout, err)
+		}
+		c.printf("Waiting for server %s: %v, retrying in %s\n", net.JoinHostPort(c.addr, fmt.Sprint(c.port)), err, delay)
+		select {
+		case <-time.After(min(delay, remaining)):
+		case <-c.cancel:
+		}
+		delay = min(delay*2, reconnectMaxDelay)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package net
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// HandleReloadSignal 收到 SIGHUP 时重新加载服务器配置文件
+func HandleReloadSignal(s *Server) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+
+	go func() {
+		for range sigs {
+			if err := s.Reload(); err != nil {
+				fmt.Printf("Failed to reload config: %v\n", err)
+			}
+		}
+	}()
+}
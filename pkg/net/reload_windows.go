@@ -0,0 +1,6 @@
+//go:build windows
+
+package net
+
+// HandleReloadSignal Windows 没有 SIGHUP，只能通过控制请求重新加载配置
+func HandleReloadSignal(s *Server) {}
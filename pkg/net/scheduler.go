@@ -0,0 +1,108 @@
+package net
+
+import (
+	"net"
+	"sync"
+)
+
+// 请求的优先级，后台同步（--nice）的请求在服务器繁忙时让交互式同步先执行
+const (
+	PriorityInteractive = ""
+	PriorityBackground  = "background"
+)
+
+// scheduledRequests 占用传输名额的请求类型：遍历目录和传输文件内容
+var scheduledRequests = map[string]bool{"list": true, "file": true, "upload": true, "find": true, "dict": true}
+
+// transferScheduler 限制同时处理的传输请求数。名额用完时请求排队，
+// 有名额空出时先交给等待中的交互式请求，没有时才交给后台请求
+type transferScheduler struct {
+	mutex       sync.Mutex
+	limit       int // 0 表示不限制
+	active      int
+	interactive []chan struct{}
+	background  []chan struct{}
+}
+
+// SetMaxTransfers 设置同时处理的列表和传输请求的最大数量，0 表示不限制
+func (s *Server) SetMaxTransfers(n int) {
+	s.transfers.setLimit(n)
+}
+
+// MaxTransfers 返回同时处理的列表和传输请求的最大数量
+func (s *Server) MaxTransfers() int {
+	s.transfers.mutex.Lock()
+	defer s.transfers.mutex.Unlock()
+
+	return s.transfers.limit
+}
+
+// setLimit 修改名额数，增加时立即唤醒排队的请求
+func (t *transferScheduler) setLimit(n int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.limit = n
+	for t.hasRoom() && t.wakeNext() {
+		t.active++
+	}
+}
+
+// acquire 等待一个传输名额，返回释放名额的函数
+func (t *transferScheduler) acquire(conn net.Conn, priority string) func() {
+	t.mutex.Lock()
+	// 没有排队的交互式请求时才能直接开始，后台请求还要排在已排队的后台请求之后
+	queued := len(t.interactive) > 0 || priority == PriorityBackground && len(t.background) > 0
+	if t.hasRoom() && !queued {
+		t.active++
+		t.mutex.Unlock()
+		return t.release
+	}
+
+	wake := make(chan struct{})
+	if priority == PriorityBackground {
+		t.background = append(t.background, wake)
+	} else {
+		t.interactive = append(t.interactive, wake)
+	}
+	waiting := len(t.interactive) + len(t.background)
+	t.mutex.Unlock()
+
+	logf(conn, "Waiting for a transfer slot (%d queued)\n", waiting)
+	<-wake
+	return t.release
+}
+
+// release 释放名额，有排队的请求时直接把名额交给它
+func (t *transferScheduler) release() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// 名额减少后先让正在进行的请求数降到新的上限以下
+	if t.limit > 0 && t.active > t.limit {
+		t.active--
+		return
+	}
+	if !t.wakeNext() {
+		t.active--
+	}
+}
+
+// hasRoom 检查是否还有空闲名额，调用者需持有锁
+func (t *transferScheduler) hasRoom() bool {
+	return t.limit <= 0 || t.active < t.limit
+}
+
+// wakeNext 唤醒优先级最高的排队请求，没有排队的请求时返回 false，调用者需持有锁
+func (t *transferScheduler) wakeNext() bool {
+	queue := &t.interactive
+	if len(*queue) == 0 {
+		queue = &t.background
+	}
+	if len(*queue) == 0 {
+		return false
+	}
+	close((*queue)[0])
+	*queue = (*queue)[1:]
+	return true
+}
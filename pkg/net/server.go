@@ -1,45 +1,200 @@
 package net
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"gorsync/pkg/utils"
 	"io"
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // FileInfo 文件信息结构体
 type FileInfo struct {
-	Path    string `json:"path"`
+	Path    string `json:"path"` // 相对路径，总是以 / 分隔，见 WirePath
 	Size    int64  `json:"size"`
-	ModTime int64  `json:"modTime"`
-	IsDir   bool   `json:"isDir"`
-	Mode    int    `json:"mode"`
-	MD5     string `json:"md5,omitempty"`
+	ModTime int64  `json:"modTime"` // UTC 的 Unix 秒
+	// ModNanos 修改时间的亚秒部分（纳秒），旧版本不发送时为 0
+	ModNanos int    `json:"modNanos,omitempty"`
+	IsDir    bool   `json:"isDir"`
+	Mode     int    `json:"mode"`           // 权限位和特殊位的可移植编码，见 EncodeMode
+	Type     string `json:"type,omitempty"` // 文件类型，普通文件为空，见 FileType
+	MD5      string `json:"md5,omitempty"`
+	HMAC     string `json:"hmac,omitempty"`  // 以共享密钥计算的 HMAC-SHA256，仅在启用完整性模式时发送
+	Owner    *Owner `json:"owner,omitempty"` // 文件属主，平台不支持时为 nil
+	// TextMD5 文本文件去掉 BOM 并统一换行符后的MD5，仅在客户端请求文本模式时计算
+	TextMD5 string `json:"textMD5,omitempty"`
+	// Windows Windows 特有的元数据，仅在服务器运行于 Windows 时发送
+	Windows *WindowsMeta `json:"windows,omitempty"`
+	// Xattrs macOS 的扩展属性（Finder 信息、资源分支等），仅在服务器运行于 macOS 时发送
+	Xattrs []StreamInfo `json:"xattrs,omitempty"`
+}
+
+// WindowsMeta Windows 文件的属性和安全描述符
+type WindowsMeta struct {
+	Attributes uint32       `json:"attributes"`         // 只读、隐藏和系统属性，见 utils.WindowsAttributeMask
+	Security   string       `json:"security,omitempty"` // SDDL 格式的属主、属组和 DACL，仅在客户端请求时发送
+	Streams    []StreamInfo `json:"streams,omitempty"`
+}
+
+// StreamInfo NTFS 备用数据流（例如 Zone.Identifier，名称不包括冒号和流类型）或 macOS 扩展属性
+type StreamInfo struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	Data []byte `json:"data,omitempty"` // 内容，仅在客户端请求且不超过 MaxInlineStream 或 MaxInlineXattr 时发送
+}
+
+// 随列表发送内容的备用数据流和扩展属性的最大字节数，更大的只报告存在
+const (
+	MaxInlineStream = 64 * 1024
+	MaxInlineXattr  = 1024 * 1024 // 资源分支可能较大
+)
+
+// Owner 文件属主
+type Owner struct {
+	Uid int `json:"uid"`
+	Gid int `json:"gid"`
+}
+
+// SkippedPath 遍历时因访问错误被跳过的路径
+type SkippedPath struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
 }
 
 // Request 请求结构体
 type Request struct {
-	Type   string `json:"type"` // "list" or "file"
+	Type   string `json:"type"` // "list", "file", "stat", "checksum", "probe", "ping", "pull", "release", "reload", "delete", "mkdir", "move", "signature", "upload", "commit", "abort", "admin", "browse", "find" or "dict"
 	Path   string `json:"path"`
 	Offset int64  `json:"offset"`
+	Remote string `json:"remote,omitempty"` // pull 请求的对端地址，格式: host[:port]:path
+	Token  string `json:"token,omitempty"`  // 控制请求的认证令牌
+	// Capabilities 客户端支持的可选协议特性，服务器只使用双方都支持的特性
+	Capabilities []string `json:"capabilities,omitempty"`
+	// Known 客户端从列表中已得知的文件信息，大小和修改时间未变时服务器直接使用其中的MD5
+	Known *FileInfo `json:"known,omitempty"`
+	// Transforms 文件内容传输前依次应用的变换，见 TransformGzip/TransformAES/TransformDict
+	Transforms []string `json:"transforms,omitempty"`
+	// DictID 文件请求使用 TransformDict 时的字典ID，见 dict 请求
+	DictID string `json:"dictID,omitempty"`
+	// TextMode 列表请求中按文本处理的文件，格式见 utils.ParseTextFilter
+	TextMode string `json:"textMode,omitempty"`
+	// Mode mkdir 请求中新目录的权限（可移植编码，见 EncodeMode），为 0 时使用 0755
+	Mode int `json:"mode,omitempty"`
+	// PrefixMD5 追加下载时客户端本地内容的MD5，服务器文件前 Offset 字节与之相同时才发送尾部
+	PrefixMD5 string `json:"prefixMD5,omitempty"`
+	// Target move 请求的目标路径
+	Target string `json:"target,omitempty"`
+	// Algorithm checksum 请求的哈希算法：md5（默认）、sha1 或 sha256
+	Algorithm string `json:"algorithm,omitempty"`
+	// Fresh 列表请求不使用服务器缓存的遍历结果
+	Fresh bool `json:"fresh,omitempty"`
+	// Glob 列表请求中相对于 Path 的模式，只返回匹配的文件及其上级目录，见 SplitGlob
+	Glob string `json:"glob,omitempty"`
+	// Find find 请求的查询条件
+	Find *FindQuery `json:"find,omitempty"`
+	// Security 列表请求同时返回每个路径的 NTFS 安全描述符，仅 Windows 服务器支持
+	Security bool `json:"security,omitempty"`
+	// Streams 列表请求同时返回不超过 MaxInlineStream 的 NTFS 备用数据流的内容，仅 Windows 服务器支持
+	Streams bool `json:"streams,omitempty"`
+	// Xattrs 列表请求同时返回不超过 MaxInlineXattr 的扩展属性的内容，仅 macOS 服务器支持
+	Xattrs bool `json:"xattrs,omitempty"`
+	// Session 客户端本次同步的会话ID，服务器在相关日志前加上该ID
+	Session string `json:"session,omitempty"`
+	// Priority 请求的优先级，见 PriorityBackground
+	Priority string `json:"priority,omitempty"`
+	// Upload upload 请求中新文件的大小、修改时间、权限和MD5
+	Upload *FileInfo `json:"upload,omitempty"`
+	// Delta upload 请求的补丁脚本，其中的新数据紧跟在请求之后发送
+	Delta *Delta `json:"delta,omitempty"`
+	// BlockSize signature 请求希望使用的块大小，0 表示由服务器按文件大小选择
+	BlockSize int `json:"blockSize,omitempty"`
+	// Txn 推送的事务ID：commit 请求替换暂存的文件并运行 post-receive 钩子，abort 请求丢弃暂存的文件
+	Txn string `json:"txn,omitempty"`
+	// Staged upload 请求只暂存文件，提交时才替换目标文件
+	Staged bool `json:"staged,omitempty"`
+	// Action admin 请求的操作：status、connections、stats 或 kick
+	Action string `json:"action,omitempty"`
+	// Conn admin kick 请求要断开的连接ID
+	Conn uint64 `json:"conn,omitempty"`
+	// KeepAlive 处理完 keepAliveRequests 中的请求后不关闭连接，等待同一连接上的下一个请求，见 CapKeepAlive
+	KeepAlive bool `json:"keepAlive,omitempty"`
+	// identity Token 匹配的客户端身份，由服务器在收到请求后设置，不在协议中传输
+	identity *ClientIdentity
 }
 
+// PullHandler 执行服务器端拉取的回调，由调用方提供具体的同步实现
+type PullHandler func(localPath, remote string) error
+
 // Response 响应结构体
 type Response struct {
-	Status  string     `json:"status"` // "ok" or "error"
-	Message string     `json:"message,omitempty"`
-	Files   []FileInfo `json:"files,omitempty"`
-	File    *FileInfo  `json:"file,omitempty"`
+	Status  string        `json:"status"` // "ok" or "error"
+	Message string        `json:"message,omitempty"`
+	Code    string        `json:"code,omitempty"` // 错误响应的类别，见 ErrorCodeAuth/ErrorCodeNotFound
+	Files   []FileInfo    `json:"files,omitempty"`
+	File    *FileInfo     `json:"file,omitempty"`
+	Skipped []SkippedPath `json:"skipped,omitempty"`
+	// Encoding 非空时文件列表不在 Files 中，而是以该编码紧跟在响应之后发送
+	Encoding string `json:"encoding,omitempty"`
+	Count    int    `json:"count,omitempty"` // 压缩列表中的记录数
+	// Transforms 文件内容实际应用的变换，为空时发送原始内容
+	Transforms []string `json:"transforms,omitempty"`
+	// Checksum checksum 请求的结果（十六进制）
+	Checksum string `json:"checksum,omitempty"`
+	// Server probe 请求返回的服务器能力
+	Server *ServerInfo `json:"server,omitempty"`
+	// Health ping 请求返回的服务器状态
+	Health *HealthInfo `json:"health,omitempty"`
+	// Signature signature 请求返回的目标文件块签名
+	Signature *Signature `json:"signature,omitempty"`
+	// Admin、Connections 和 Stats admin 请求返回的服务器状态、连接和统计数据
+	Admin       *ServerStatus    `json:"admin,omitempty"`
+	Connections []ConnectionInfo `json:"connections,omitempty"`
+	Stats       *ServerStats     `json:"stats,omitempty"`
+	// Dirs browse 请求返回的子目录名
+	Dirs []string `json:"dirs,omitempty"`
+	// Truncated find 请求的结果达到上限，还有未返回的匹配项
+	Truncated bool `json:"truncated,omitempty"`
+	// Dict dict 请求训练的压缩字典，样本不足时为空
+	Dict *Dictionary `json:"dict,omitempty"`
 }
 
 // Server TCP服务器结构体
 type Server struct {
-	rootDir  string
-	port     int
-	listener net.Listener
+	rootDir      string
+	port         int
+	listener     net.Listener
+	controlToken string
+	pullHandler  PullHandler
+	peers        []PullPeer // 允许拉取的对端
+	pulls        pullLocks  // 正在拉取的本地目录
+	integrityKey []byte
+	snapshots    *snapshotHooks
+	configPath   string
+	configBase   ServerConfig
+	configMutex  sync.RWMutex      // 保护可在运行时重新加载的设置
+	onReady      func()            // 开始监听后调用
+	hashes       hashCache         // 文件MD5缓存
+	txns         txnTable          // 未提交的推送
+	postReceive  string            // 推送提交后执行的命令
+	clients      []ClientIdentity  // 客户端身份
+	listings     listingCache      // 最近的目录遍历结果
+	transfers    transferScheduler // 同时处理的列表和传输请求的名额
+	started      time.Time         // 开始监听的时间
+	active       atomic.Int64      // 正在处理的连接数
+	conns        connTable         // 正在处理的连接和累计的统计数据
+	encoders     int               // 每个传输编码变换的 goroutine 数，0 表示按 CPU 数，1 表示不并行
+	portRange    int               // 端口被占用时依次尝试之后的端口数
+	noFind       bool              // 拒绝 find 请求
+	dicts        dictTable         // dict 请求训练的压缩字典
 }
 
 // NewServer 创建新的服务器
@@ -50,36 +205,139 @@ func NewServer(rootDir string, port int) *Server {
 	}
 }
 
+// SetControl 启用控制请求，token 为空时控制请求被拒绝
+func (s *Server) SetControl(token string, handler PullHandler) {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+
+	s.controlToken = token
+	s.pullHandler = handler
+}
+
+// SetIntegrityKey 设置完整性模式的共享密钥，文件响应将附带内容的 HMAC
+func (s *Server) SetIntegrityKey(key string) {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+
+	s.integrityKey = []byte(key)
+}
+
+// IntegrityKey 返回当前的完整性模式共享密钥
+func (s *Server) IntegrityKey() string {
+	s.configMutex.RLock()
+	defer s.configMutex.RUnlock()
+
+	return string(s.integrityKey)
+}
+
+// SetEncodeWorkers 设置每个传输压缩和加密使用的 goroutine 数，0 表示按 CPU 数（最多 8 个），1 表示在请求的 goroutine 中编码
+func (s *Server) SetEncodeWorkers(n int) {
+	s.encoders = n
+}
+
+// SetPortRange 设置端口被占用时依次尝试之后的多少个端口，0 表示只使用指定的端口
+func (s *Server) SetPortRange(n int) {
+	s.portRange = n
+}
+
+// EncodeWorkers 返回每个传输压缩和加密实际使用的 goroutine 数
+func (s *Server) EncodeWorkers() int {
+	if s.encoders > 0 {
+		return s.encoders
+	}
+	return defaultEncodeWorkers()
+}
+
+// Port 返回监听的端口，端口为 0 时在开始监听后返回系统分配的端口
+func (s *Server) Port() int {
+	return s.port
+}
+
+// SetReadyHandler 设置开始监听后的回调，例如写入 PID 文件或通知服务管理器
+func (s *Server) SetReadyHandler(handler func()) {
+	s.onReady = handler
+}
+
 // Start 启动服务器
 func (s *Server) Start() error {
-	addr := fmt.Sprintf(":%d", s.port)
-	listener, err := net.Listen("tcp", addr)
+	listener, err := s.listen()
 	if err != nil {
-		return fmt.Errorf("failed to listen: %v", err)
+		return err
 	}
 
 	// 保存监听器到结构体中
 	s.listener = listener
+	s.started = time.Now()
+	// 端口为 0 时由系统分配，记录实际监听的端口
+	if s.port == 0 {
+		s.port = listener.Addr().(*net.TCPAddr).Port
+	}
 
 	fmt.Printf("Server started on port %d\n", s.port)
+	if s.onReady != nil {
+		s.onReady()
+	}
 
+	var backoff acceptBackoff
 	for {
+		// 文件描述符名额用完时暂停接受连接，新连接在内核的队列中等待
+		utils.AcquireServerFiles(connFiles)
 		conn, err := listener.Accept()
 		if err != nil {
-			// 检查是否是因为监听器被关闭导致的错误
-			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
-				fmt.Printf("Failed to accept connection: %v\n", err)
+			utils.ReleaseServerFiles(connFiles)
+			switch {
+			case isListenerClosed(err):
+				// 监听器被 Stop 关闭，退出循环
+				fmt.Printf("Server stopped\n")
+				return nil
+			case isTemporaryAccept(err):
+				// 例如文件描述符耗尽，退避后重试，不立即再次失败
+				backoff.wait(err)
 				continue
+			default:
+				return fmt.Errorf("failed to accept connections: %w", err)
 			}
-			// 监听器被关闭，退出循环
-			fmt.Printf("Server stopped: %v\n", err)
-			break
 		}
+		backoff.reset()
 
 		go s.handleConnection(conn)
 	}
+}
 
-	return nil
+// listen 在指定端口上监听，端口被占用时依次尝试之后 portRange 个端口，并改用第一个空闲的端口。
+// 全部被占用时返回说明占用端口的进程的错误
+func (s *Server) listen() (net.Listener, error) {
+	for port := s.port; ; port++ {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err == nil {
+			if port != s.port {
+				fmt.Printf("Port %d is in use, using port %d instead\n", s.port, port)
+				s.port = port
+			}
+			return listener, nil
+		}
+		if !utils.IsAddrInUse(err) {
+			return nil, fmt.Errorf("failed to listen: %v", err)
+		}
+		if s.port == 0 || port >= s.port+s.portRange || port >= 65535 {
+			return nil, fmt.Errorf("failed to listen: %s: %w", s.portConflict(port), err)
+		}
+	}
+}
+
+// portConflict 说明端口被哪些进程占用以及如何解决
+func (s *Server) portConflict(last int) string {
+	message := fmt.Sprintf("port %d is already in use", s.port)
+	if last > s.port {
+		message = fmt.Sprintf("ports %d-%d are all in use", s.port, last)
+	}
+	if owners, _ := utils.PortOwners(s.port); len(owners) > 0 {
+		if last > s.port {
+			message += fmt.Sprintf(", %d", s.port)
+		}
+		message += " by " + strings.Join(owners, ", ")
+	}
+	return message + "; stop the other listener, choose another port with --listen, or let gorsync try the following ports with --port-range"
 }
 
 // Stop 停止服务器
@@ -88,116 +346,446 @@ func (s *Server) Stop() error {
 		fmt.Printf("Stopping server on port %d\n", s.port)
 		err := s.listener.Close()
 		s.listener = nil
+		if snapshots := s.snapshotHooks(); snapshots != nil {
+			snapshots.releaseAll()
+		}
 		return err
 	}
 	return nil
 }
 
-// handleConnection 处理客户端连接
+// sessionConn 带有客户端会话ID的连接
+type sessionConn struct {
+	net.Conn
+	session string
+}
+
+// sessionOf 返回请求的会话ID，未带或格式不正确时返回空
+func sessionOf(req Request) string {
+	if !validSession(req.Session) {
+		return ""
+	}
+	return req.Session
+}
+
+// validSession 会话ID只允许字母、数字和 -，避免客户端在日志中注入内容
+func validSession(id string) bool {
+	if len(id) > 64 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// logf 打印与连接相关的日志，连接带有会话ID时在行首加上 [会话ID]
+func logf(conn net.Conn, format string, args ...interface{}) {
+	if sc, ok := conn.(*sessionConn); ok {
+		format = "[" + sc.session + "] " + format
+	}
+	fmt.Printf(format, args...)
+}
+
+// handleConnection 处理客户端连接，结束时归还接受连接前申请的文件描述符名额
 func (s *Server) handleConnection(conn net.Conn) {
+	defer utils.ReleaseServerFiles(connFiles)
+	s.active.Add(1)
+	defer s.active.Add(-1)
+	tracked := s.conns.add(conn)
+	defer s.conns.remove(tracked)
+	conn = tracked
 	defer func() {
-		fmt.Printf("< Client close: %s\n", conn.RemoteAddr())
+		logf(conn, "< Client close: %s\n", conn.RemoteAddr())
 		conn.Close()
 	}()
 
-	fmt.Printf("> Client connected: %s\n", conn.RemoteAddr())
+	logf(conn, "> Client connected: %s\n", conn.RemoteAddr())
 
-	// 读取请求
-	var req Request
-	if err := json.NewDecoder(conn).Decode(&req); err != nil {
-		s.sendError(conn, fmt.Sprintf("Failed to decode request: %v", err))
-		fmt.Printf("Error decoding request: %v\n", err)
-		return
+	// 请求带 KeepAlive 且类型允许时，处理完后在同一个连接上等待下一个请求
+	dec := json.NewDecoder(conn)
+	for first := true; ; first = false {
+		if !first {
+			conn.SetReadDeadline(time.Now().Add(keepAliveTimeout))
+		}
+		var req Request
+		if err := dec.Decode(&req); err != nil {
+			if first {
+				s.sendError(conn, fmt.Sprintf("Failed to decode request: %v", err))
+				logf(conn, "Error decoding request: %v\n", err)
+			}
+			// 保持的连接空闲超时或被客户端关闭，不是错误
+			return
+		}
+		conn.SetReadDeadline(time.Time{})
+		if !s.serveRequest(tracked, dec, req) || !req.KeepAlive || !keepAliveRequests[req.Type] {
+			return
+		}
+	}
+}
+
+// serveRequest 处理连接上的一个请求，身份验证失败时返回 false
+func (s *Server) serveRequest(tracked *trackedConn, dec *json.Decoder, req Request) bool {
+	var conn net.Conn = tracked
+	if req.Session != "" && validSession(req.Session) {
+		conn = &sessionConn{Conn: conn, session: req.Session}
+		logf(conn, "Session request: %s %s\n", req.Type, req.Path)
+	}
+	identity, err := s.applyIdentity(&req)
+	if err != nil {
+		s.sendErrorCode(conn, ErrorCodeAuth, err.Error())
+		logf(conn, "Rejected %s request from %s: %v\n", req.Type, conn.RemoteAddr(), err)
+		return false
+	}
+	if identity != nil {
+		req.identity = identity
+		logf(conn, "Client %s: %s %s\n", identity.Name, req.Type, req.Path)
+	}
+	s.conns.describe(tracked, req)
+	if scheduledRequests[req.Type] {
+		s.conns.setQueued(tracked, true)
+		release := s.transfers.acquire(conn, req.Priority)
+		s.conns.setQueued(tracked, false)
+		defer release()
 	}
 
 	switch req.Type {
 	case "list":
-		s.handleListRequest(conn, req.Path)
+		s.handleListRequest(conn, req)
 	case "file":
-		s.handleFileRequest(conn, req.Path)
+		s.handleFileRequest(conn, req)
+	case "pull":
+		s.handlePullRequest(conn, req)
+	case "release":
+		s.handleReleaseRequest(conn, req)
+	case "reload":
+		s.handleReloadRequest(conn, req)
+	case "delete":
+		s.handleDeleteRequest(conn, req)
+	case "mkdir":
+		s.handleMkdirRequest(conn, req)
+	case "move":
+		s.handleMoveRequest(conn, req)
+	case "signature":
+		s.handleSignatureRequest(conn, req)
+	case "upload":
+		// 解码器可能已缓存了请求之后的部分数据
+		s.handleUploadRequest(conn, io.MultiReader(dec.Buffered(), conn), req)
+	case "commit":
+		s.handleCommitRequest(conn, req)
+	case "abort":
+		s.handleAbortRequest(conn, req)
+	case "stat":
+		s.handleStatRequest(conn, req)
+	case "checksum":
+		s.handleChecksumRequest(conn, req)
+	case "browse":
+		s.handleBrowseRequest(conn, req)
+	case "find":
+		s.handleFindRequest(conn, req)
+	case "dict":
+		s.handleDictRequest(conn, req)
+	case "probe":
+		s.handleProbeRequest(conn)
+	case "ping":
+		s.handlePingRequest(conn)
+	case "admin":
+		s.handleAdminRequest(conn, req)
 	default:
 		s.sendError(conn, fmt.Sprintf("Unknown request type: %s", req.Type))
-		fmt.Printf("Unknown request type: %s\n", req.Type)
+		logf(conn, "Unknown request type: %s\n", req.Type)
 	}
+	return true
 }
 
 // handleListRequest 处理文件列表请求
-func (s *Server) handleListRequest(conn net.Conn, path string) {
+func (s *Server) handleListRequest(conn net.Conn, req Request) {
+	path := req.Path
+
 	// 确定完整路径
 	var fullPath string
 	if s.rootDir == "" {
 		fullPath = path
 	} else {
-		fullPath = filepath.Join(s.rootDir, path)
+		fullPath = LocalPath(s.rootDir, path)
 	}
 
-	// 遍历目录
-	var files []FileInfo
-	if err := filepath.Walk(fullPath, func(walkPath string, info os.FileInfo, err error) error {
+	opts := listOptions{
+		security: req.Security,
+		streams:  req.Streams,
+		xattrs:   req.Xattrs,
+		glob:     req.Glob,
+	}
+	if req.Glob != "" {
+		if err := ValidateGlob(req.Glob); err != nil {
+			s.sendError(conn, err.Error())
+			return
+		}
+	}
+	if req.TextMode != "" {
+		filter, err := utils.ParseTextFilter(req.TextMode)
+		if err != nil {
+			s.sendError(conn, err.Error())
+			return
+		}
+		opts.textFilter = filter
+	}
+
+	// 读取前为源目录创建快照，遍历快照中的对应目录
+	walkRoot := fullPath
+	if snapshots := s.snapshotHooks(); snapshots != nil {
+		snapshot, err := snapshots.create(fullPath, sessionOf(req))
 		if err != nil {
-			return err
+			s.sendError(conn, fmt.Sprintf("Failed to create snapshot: %v", err))
+			return
+		}
+		walkRoot = snapshot
+	}
+
+	// 遍历目录，未使用快照时短时间内对同一目录的重复请求共享同一次遍历结果
+	var files []FileInfo
+	var skipped []SkippedPath
+	var err error
+	if walkRoot == fullPath && !req.Fresh {
+		key := fmt.Sprintf("%s\x00%s\x00%t\x00%t\x00%t\x00%s", fullPath, req.TextMode, req.Security, req.Streams, req.Xattrs, req.Glob)
+		files, skipped, err = s.listings.get(key, func() ([]FileInfo, []SkippedPath, error) {
+			return s.walkListing(conn, path, fullPath, walkRoot, opts)
+		})
+	} else {
+		files, skipped, err = s.walkListing(conn, path, fullPath, walkRoot, opts)
+	}
+	if err != nil {
+		s.sendErrorCode(conn, errorCode(err), fmt.Sprintf("Failed to walk directory: %v", err))
+		return
+	}
+
+	// 客户端支持时发送压缩列表
+	if hasCapability(req, CapListCompress) {
+		resp := Response{
+			Status:   "ok",
+			Skipped:  skipped,
+			Encoding: listEncodingGzipDelta,
+			Count:    len(files),
+		}
+		if err := json.NewEncoder(conn).Encode(&resp); err != nil {
+			logf(conn, "Failed to send response: %v\n", err)
+			return
+		}
+		if err := writeCompressedListing(conn, files); err != nil {
+			logf(conn, "Failed to send compressed listing: %v\n", err)
+		}
+		return
+	}
+
+	// 发送响应
+	resp := Response{
+		Status:  "ok",
+		Files:   files,
+		Skipped: skipped,
+	}
+	if err := json.NewEncoder(conn).Encode(&resp); err != nil {
+		logf(conn, "Failed to send response: %v\n", err)
+	}
+}
+
+// listOptions 列表请求中影响遍历结果的选项
+type listOptions struct {
+	textFilter *utils.TextFilter // 选中的文件同时计算统一换行符后的MD5
+	security   bool              // Windows 上同时返回安全描述符
+	streams    bool              // Windows 上同时返回小的备用数据流的内容
+	xattrs     bool              // macOS 上同时返回扩展属性的内容
+	glob       string            // 只返回匹配这个相对模式的路径及其上级目录
+}
+
+// walkListing 遍历 walkRoot 生成文件列表，walkRoot 为快照目录时路径换算回源目录 fullPath，
+// 跳过的路径和读取失败记录到请求所在会话的日志
+func (s *Server) walkListing(conn net.Conn, path, fullPath, walkRoot string, opts listOptions) ([]FileInfo, []SkippedPath, error) {
+	var files []FileInfo
+	var skipped []SkippedPath
+	err := filepath.Walk(walkRoot, func(walkPath string, info os.FileInfo, err error) error {
+		// 快照中的路径换算回源目录中的路径
+		origPath := walkPath
+		if walkRoot != fullPath {
+			rel, relErr := filepath.Rel(walkRoot, walkPath)
+			if relErr != nil {
+				return relErr
+			}
+			origPath = filepath.Join(fullPath, rel)
 		}
 
 		// 计算相对路径
 		var relPath string
+		var relErr error
 		if s.rootDir == "" {
-			relPath, err = filepath.Rel(path, walkPath)
+			relPath, relErr = filepath.Rel(path, origPath)
 		} else {
-			relPath, err = filepath.Rel(s.rootDir, walkPath)
+			relPath, relErr = filepath.Rel(s.rootDir, origPath)
+		}
+		if relErr != nil {
+			return relErr
+		}
+		relPath = WirePath(relPath)
+
+		// 按模式跳过不匹配的路径，不进入不可能包含匹配项的目录，也不计算它们的MD5
+		if opts.glob != "" && walkPath != walkRoot {
+			globRel, relErr := filepath.Rel(walkRoot, walkPath)
+			if relErr != nil {
+				return relErr
+			}
+			isDir := info != nil && info.IsDir()
+			if matched, parent := MatchGlob(opts.glob, WirePath(globRel)); !matched && !(parent && isDir) {
+				if isDir {
+					return filepath.SkipDir
+				}
+				return nil
+			}
 		}
+
 		if err != nil {
-			return err
+			// 根目录本身无法访问时直接失败，其余路径记录后跳过
+			if walkPath == walkRoot && info == nil {
+				return err
+			}
+			logf(conn, "Skipping %s: %v\n", walkPath, err)
+			skipped = append(skipped, SkippedPath{Path: relPath, Error: err.Error()})
+			return nil
+		}
+		// 正在上传或等待提交的临时文件不属于目录内容，不能被其他客户端同步
+		if !info.IsDir() && utils.IsTempName(info.Name()) {
+			return nil
 		}
 
 		fileInfo := FileInfo{
-			Path:    relPath,
-			Size:    info.Size(),
-			ModTime: info.ModTime().Unix(),
-			IsDir:   info.IsDir(),
-			Mode:    int(info.Mode()),
+			Path:     relPath,
+			Size:     info.Size(),
+			ModTime:  info.ModTime().Unix(),
+			ModNanos: info.ModTime().Nanosecond(),
+			IsDir:    info.IsDir(),
+			Mode:     EncodeMode(info.Mode()),
+			Type:     FileType(info.Mode()),
+		}
+		if uid, gid, ok := utils.FileOwner(info); ok {
+			fileInfo.Owner = &Owner{Uid: uid, Gid: gid}
 		}
+		if attrs, ok := utils.FileAttributes(info); ok {
+			fileInfo.Windows = &WindowsMeta{Attributes: attrs}
+			if opts.security {
+				if sddl, err := utils.FileSecurity(walkPath); err != nil {
+					logf(conn, "Failed to read security descriptor for %s: %v\n", walkPath, err)
+				} else {
+					fileInfo.Windows.Security = sddl
+				}
+			}
+			fileInfo.Windows.Streams = readStreams(conn, walkPath, opts.streams)
+		}
+		fileInfo.Xattrs = readXattrs(conn, walkPath, opts.xattrs)
 
 		// 计算文件的MD5哈希值（仅对文件计算，不对目录）
 		if !info.IsDir() {
-			md5, err := utils.CalculateMD5(walkPath)
+			md5, err := s.hashes.md5(walkPath, info)
 			if err != nil {
-				fmt.Printf("Failed to calculate file MD5 for %s: %v\n", walkPath, err)
+				if errors.Is(err, os.ErrPermission) {
+					// 无法读取的文件不放入列表
+					logf(conn, "Skipping %s: %v\n", walkPath, err)
+					skipped = append(skipped, SkippedPath{Path: relPath, Error: err.Error()})
+					return nil
+				}
+				logf(conn, "Failed to calculate file MD5 for %s: %v\n", walkPath, err)
 				// 继续执行，即使MD5计算失败
 			} else {
 				fileInfo.MD5 = md5
 			}
+
+			if opts.textFilter.IsText(walkPath) {
+				if textMD5, err := utils.NormalizedMD5(walkPath); err == nil {
+					fileInfo.TextMD5 = textMD5
+				}
+			}
 		}
 
 		files = append(files, fileInfo)
 
 		return nil
-	}); err != nil {
-		s.sendError(conn, fmt.Sprintf("Failed to walk directory: %v", err))
-		return
+	})
+	return files, skipped, err
+}
+
+// readStreams 返回路径的备用数据流，withData 时附带不超过 MaxInlineStream 的流的内容
+func readStreams(conn net.Conn, walkPath string, withData bool) []StreamInfo {
+	streams, err := utils.FileStreams(walkPath)
+	if err != nil {
+		logf(conn, "Failed to list alternate data streams for %s: %v\n", walkPath, err)
+		return nil
 	}
 
-	// 发送响应
-	resp := Response{
-		Status: "ok",
-		Files:  files,
+	var infos []StreamInfo
+	for _, stream := range streams {
+		info := StreamInfo{Name: stream.Name, Size: stream.Size}
+		if withData && stream.Size <= MaxInlineStream {
+			data, err := utils.ReadStream(walkPath, stream.Name)
+			if err != nil {
+				logf(conn, "Failed to read alternate data stream %s:%s: %v\n", walkPath, stream.Name, err)
+			} else {
+				info.Data = data
+			}
+		}
+		infos = append(infos, info)
 	}
-	if err := json.NewEncoder(conn).Encode(&resp); err != nil {
-		fmt.Printf("Failed to send response: %v\n", err)
+	return infos
+}
+
+// readXattrs 返回路径的 macOS 扩展属性，withData 时附带不超过 MaxInlineXattr 的属性的内容
+func readXattrs(conn net.Conn, walkPath string, withData bool) []StreamInfo {
+	names, err := utils.ListXattrs(walkPath)
+	if err != nil {
+		logf(conn, "Failed to list extended attributes for %s: %v\n", walkPath, err)
+		return nil
+	}
+
+	var infos []StreamInfo
+	for _, name := range names {
+		data, err := utils.GetXattr(walkPath, name)
+		if err != nil {
+			logf(conn, "Failed to read extended attribute %s of %s: %v\n", name, walkPath, err)
+			continue
+		}
+		info := StreamInfo{Name: name, Size: int64(len(data))}
+		if withData && len(data) <= MaxInlineXattr {
+			info.Data = data
+		}
+		infos = append(infos, info)
 	}
+	return infos
 }
 
 // handleFileRequest 处理文件传输请求
-func (s *Server) handleFileRequest(conn net.Conn, path string) {
+func (s *Server) handleFileRequest(conn net.Conn, req Request) {
+	path := req.Path
+
 	// 确定完整路径
 	var fullPath string
 	if s.rootDir == "" {
 		fullPath = path
 	} else {
-		fullPath = filepath.Join(s.rootDir, path)
+		fullPath = LocalPath(s.rootDir, path)
 	}
 
-	// 检查文件是否存在
-	info, err := os.Stat(fullPath)
+	// 从快照中读取
+	if snapshots := s.snapshotHooks(); snapshots != nil {
+		fullPath = snapshots.remap(fullPath)
+	}
+
+	// 打开文件后再取文件信息，发送的大小与实际读取的文件一致，不受列表之后的替换或截断影响
+	file, err := os.Open(fullPath)
+	if err != nil {
+		s.sendErrorCode(conn, errorCode(err), fmt.Sprintf("Failed to open file: %v", err))
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
 	if err != nil {
 		s.sendError(conn, fmt.Sprintf("Failed to stat file: %v", err))
 		return
@@ -208,63 +796,154 @@ func (s *Server) handleFileRequest(conn net.Conn, path string) {
 		return
 	}
 
-	// 打开文件
-	file, err := os.Open(fullPath)
-	if err != nil {
-		s.sendError(conn, fmt.Sprintf("Failed to open file: %v", err))
+	if known := req.Known; known != nil && (known.Size != info.Size() || known.ModTime != info.ModTime().Unix()) {
+		logf(conn, "File changed since listing: %s (size %d -> %d)\n", path, known.Size, info.Size())
+	}
+
+	// 追加下载时文件比客户端已有的内容还短，说明被截断或重写，需要完整下载
+	if req.PrefixMD5 != "" && req.Offset > info.Size() {
+		resp := Response{
+			Status:  statusMismatch,
+			Message: "file is shorter than the offset",
+		}
+		if err := json.NewEncoder(conn).Encode(&resp); err != nil {
+			logf(conn, "Failed to send response: %v\n", err)
+		}
+		return
+	}
+
+	if req.Offset < 0 || req.Offset > info.Size() {
+		s.sendError(conn, fmt.Sprintf("Invalid offset: %d", req.Offset))
+		return
+	}
+	integrityKey := []byte(s.IntegrityKey())
+	var dict []byte
+	if slices.Contains(req.Transforms, TransformDict) {
+		if dict = s.dicts.get(req.DictID); dict == nil {
+			s.sendErrorCode(conn, ErrorCodeNoDict, fmt.Sprintf("Unknown dictionary: %s", req.DictID))
+			return
+		}
+	}
+	if err := checkTransforms(req.Transforms, integrityKey, dict); err != nil {
+		s.sendError(conn, err.Error())
 		return
 	}
-	defer file.Close()
+
+	// 追加下载：确认文件开头仍是客户端已有的内容
+	if req.PrefixMD5 != "" {
+		if matched, err := checkPrefix(file, req.Offset, req.PrefixMD5); err != nil {
+			s.sendError(conn, fmt.Sprintf("Failed to read file: %v", err))
+			return
+		} else if !matched {
+			resp := Response{
+				Status:  statusMismatch,
+				Message: "file content before the offset has changed",
+			}
+			if err := json.NewEncoder(conn).Encode(&resp); err != nil {
+				logf(conn, "Failed to send response: %v\n", err)
+			}
+			return
+		}
+	}
 
 	// 计算文件的MD5哈希值
-	md5, err := utils.CalculateMD5(fullPath)
-	if err != nil {
-		fmt.Printf("Failed to calculate file MD5: %v\n", err)
-		// 继续执行，即使MD5计算失败
+	var md5 string
+	known := req.Known
+	switch {
+	case req.Offset > 0:
+		// 分块请求不返回整个文件的哈希
+	case known != nil && known.MD5 != "" &&
+		known.Size == info.Size() && known.ModTime == info.ModTime().Unix():
+		// 文件自列表后未变化时不再重复计算
+		md5 = known.MD5
+	default:
+		md5, err = s.hashes.md5(fullPath, info)
+		if err != nil {
+			logf(conn, "Failed to calculate file MD5: %v\n", err)
+			// 继续执行，即使MD5计算失败
+		}
 	}
 
 	// 发送文件信息
 	fileInfo := &FileInfo{
-		Path:    path,
-		Size:    info.Size(),
-		ModTime: info.ModTime().Unix(),
-		IsDir:   info.IsDir(),
-		Mode:    int(info.Mode()),
-		MD5:     md5,
+		Path:     path,
+		Size:     info.Size(),
+		ModTime:  info.ModTime().Unix(),
+		ModNanos: info.ModTime().Nanosecond(),
+		IsDir:    info.IsDir(),
+		Mode:     EncodeMode(info.Mode()),
+		Type:     FileType(info.Mode()),
+		MD5:      md5,
+	}
+
+	// 完整性模式下计算文件内容的 HMAC
+	if key := s.IntegrityKey(); key != "" {
+		mac, err := calculateIntegrity([]byte(key), fileInfo, fullPath)
+		if err != nil {
+			s.sendError(conn, fmt.Sprintf("Failed to calculate file HMAC: %v", err))
+			return
+		}
+		fileInfo.HMAC = mac
 	}
 
 	resp := Response{
-		Status: "ok",
-		File:   fileInfo,
+		Status:     "ok",
+		File:       fileInfo,
+		Transforms: req.Transforms,
 	}
 
 	if err := json.NewEncoder(conn).Encode(resp); err != nil {
-		fmt.Printf("Failed to send response: %v\n", err)
+		logf(conn, "Failed to send response: %v\n", err)
 		return
 	}
 
 	conn.Write([]byte("\n"))
 
 	// 确定传输的偏移量和大小
-	transferSize := info.Size()
+	transferSize := info.Size() - req.Offset
+
+	// 按客户端的要求编码文件内容，大文件的压缩和加密由多个 goroutine 并行进行
+	var out io.Writer = conn
+	if len(req.Transforms) > 0 {
+		workers := 1
+		if transferSize >= parallelEncodeMin {
+			workers = s.EncodeWorkers()
+		}
+		encoder, err := encodeTransforms(conn, req.Transforms, integrityKey, dict, workers)
+		if err != nil {
+			logf(conn, "Failed to start %s transfer: %v\n", strings.Join(req.Transforms, "+"), err)
+			return
+		}
+		defer func() {
+			if err := encoder.Close(); err != nil {
+				logf(conn, "Failed to finish %s transfer: %v\n", strings.Join(req.Transforms, "+"), err)
+			}
+		}()
+		out = encoder
+	}
 
 	// 确保文件指针在正确的位置
-	if _, err := file.Seek(0, io.SeekStart); err != nil {
-		fmt.Printf("Failed to seek file: %v\n", err)
+	if _, err := file.Seek(req.Offset, io.SeekStart); err != nil {
+		logf(conn, "Failed to seek file: %v\n", err)
 		return
 	}
 
 	// 打印传输开始信息
 
-	fmt.Printf("Starting transfer: %s (size: %d bytes)\n", path, transferSize)
+	logf(conn, "Starting transfer: %s (size: %d bytes)\n", path, transferSize)
 
 	// 发送文件数据
-	buffer := make([]byte, 64*1024)
+	pooled := utils.GetBuffer()
+	defer utils.PutBuffer(pooled)
+	buffer := *pooled
 	remaining := transferSize
 	transferred := int64(0)
 	lastProgress := float64(0)
 
 	for remaining > 0 {
+		// 暂停时在数据块之间等待
+		waitIfPaused()
+
 		readSize := int64(len(buffer))
 		if readSize > remaining {
 			readSize = remaining
@@ -272,16 +951,18 @@ func (s *Server) handleFileRequest(conn net.Conn, path string) {
 
 		n, err := file.Read(buffer[:readSize])
 		if err != nil && err != io.EOF {
-			fmt.Printf("Failed to read file: %v\n", err)
+			logf(conn, "Failed to read file: %v\n", err)
 			return
 		}
 
 		if n == 0 {
-			break
+			// 文件在传输过程中被截断，关闭连接让客户端发现内容不完整
+			logf(conn, "File shrank during transfer: %s (sent %d of %d bytes)\n", path, transferred, transferSize)
+			return
 		}
 
-		if _, err := conn.Write(buffer[:n]); err != nil {
-			fmt.Printf("Failed to write to connection: %v\n", err)
+		if _, err := out.Write(buffer[:n]); err != nil {
+			logf(conn, "Failed to write to connection: %v\n", err)
 			return
 		}
 
@@ -291,22 +972,145 @@ func (s *Server) handleFileRequest(conn net.Conn, path string) {
 		// 计算进度并打印
 		progress := float64(transferred) / float64(transferSize) * 100
 		if progress-lastProgress >= 10 {
-			fmt.Printf("File transfer progress: %s %.1f%%\n", path, progress)
+			logf(conn, "File transfer progress: %s %.1f%%\n", path, progress)
 			lastProgress = progress
 		}
 	}
 
 	// 打印传输完成信息
-	fmt.Printf("File transfer completed: %s (transferred: %d bytes)\n", path, transferred)
+	logf(conn, "File transfer completed: %s (transferred: %d bytes)\n", path, transferred)
+}
+
+// handlePullRequest 处理控制请求：由服务器从另一个节点拉取文件到本地路径
+func (s *Server) handlePullRequest(conn net.Conn, req Request) {
+	if !s.authorizeControl(conn, req) {
+		return
+	}
+
+	s.configMutex.RLock()
+	pullHandler := s.pullHandler
+	s.configMutex.RUnlock()
+	if pullHandler == nil {
+		s.sendError(conn, "Pull requests are disabled on this server")
+		return
+	}
+
+	// 确定完整路径，只能从登记的对端拉取到其登记的目录之下
+	var fullPath string
+	if req.Path != "" && s.rootDir == "" {
+		fullPath = req.Path
+	} else if req.Path != "" {
+		fullPath = LocalPath(s.rootDir, req.Path)
+	}
+	remote, fullPath, ok := s.matchPeer(req.Remote, fullPath)
+	if !ok {
+		s.sendErrorCode(conn, ErrorCodeAuth, fmt.Sprintf("%s is not a registered pull peer for %s", req.Remote, req.Path))
+		logf(conn, "Rejected pull from %s into %s: not a registered peer\n", req.Remote, req.Path)
+		return
+	}
+
+	release := s.pulls.acquire(fullPath, func() {
+		logf(conn, "Waiting for another pull into %s to finish\n", fullPath)
+	})
+	defer release()
+	logf(conn, "Pull requested by %s: %s -> %s\n", conn.RemoteAddr(), remote, fullPath)
+	if err := pullHandler(fullPath, remote); err != nil {
+		s.sendErrorCode(conn, errorCode(err), fmt.Sprintf("Pull failed: %v", err))
+		return
+	}
+
+	resp := Response{
+		Status: "ok",
+	}
+	if err := json.NewEncoder(conn).Encode(&resp); err != nil {
+		logf(conn, "Failed to send response: %v\n", err)
+	}
+}
+
+// handleReleaseRequest 客户端同步结束后不再持有源目录的快照，最后一个持有的会话结束时释放快照。
+// 带会话ID的请求只能结束本会话的持有；不带会话ID的请求强制释放快照，需要控制令牌或写权限的客户端身份
+func (s *Server) handleReleaseRequest(conn net.Conn, req Request) {
+	session := sessionOf(req)
+	if session == "" {
+		if req.identity != nil && req.identity.Access != AccessWrite {
+			s.sendErrorCode(conn, ErrorCodeAuth, fmt.Sprintf("client %s is not allowed to release snapshots of other sessions", req.identity.Name))
+			return
+		}
+		if req.identity == nil && !s.authorizeControl(conn, req) {
+			return
+		}
+	}
+	if snapshots := s.snapshotHooks(); snapshots != nil {
+		var fullPath string
+		if s.rootDir == "" {
+			fullPath = req.Path
+		} else {
+			fullPath = LocalPath(s.rootDir, req.Path)
+		}
+		if session == "" {
+			logf(conn, "Snapshot release requested by %s: %s\n", conn.RemoteAddr(), fullPath)
+			snapshots.destroy(fullPath)
+		} else {
+			snapshots.release(fullPath, session)
+		}
+	}
+
+	resp := Response{
+		Status: "ok",
+	}
+	if err := json.NewEncoder(conn).Encode(&resp); err != nil {
+		logf(conn, "Failed to send response: %v\n", err)
+	}
+}
+
+// authorizeWrite 校验修改服务器文件的请求：接受写权限的客户端身份（路径已映射到其根目录之下）或控制令牌，
+// 失败时发送错误响应
+func (s *Server) authorizeWrite(conn net.Conn, req Request) bool {
+	if req.identity == nil {
+		return s.authorizeControl(conn, req)
+	}
+	if req.identity.Access != AccessWrite || !writeRequests[req.Type] {
+		s.sendErrorCode(conn, ErrorCodeAuth, fmt.Sprintf("client %s is not allowed to make %s requests", req.identity.Name, req.Type))
+		logf(conn, "Rejected %s request from %s: client %s has no write access\n", req.Type, conn.RemoteAddr(), req.identity.Name)
+		return false
+	}
+	return true
+}
+
+// authorizeControl 校验控制请求的令牌，失败时发送错误响应。客户端身份不能代替控制令牌
+func (s *Server) authorizeControl(conn net.Conn, req Request) bool {
+	s.configMutex.RLock()
+	token := s.controlToken
+	s.configMutex.RUnlock()
+
+	if token == "" {
+		s.sendError(conn, "Control requests are disabled on this server")
+		return false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(req.Token), []byte(token)) != 1 {
+		s.sendErrorCode(conn, ErrorCodeAuth, "Invalid control token")
+		logf(conn, "Rejected %s request from %s: invalid token\n", req.Type, conn.RemoteAddr())
+		return false
+	}
+
+	return true
 }
 
 // sendError 发送错误响应
 func (s *Server) sendError(conn net.Conn, message string) {
+	s.sendErrorCode(conn, "", message)
+}
+
+// sendErrorCode 发送带类别的错误响应，客户端据此返回 ErrAuth 或 ErrNotFound
+func (s *Server) sendErrorCode(conn net.Conn, code, message string) {
+	s.conns.errors.Add(1)
 	resp := Response{
 		Status:  "error",
 		Message: message,
+		Code:    code,
 	}
 	if err := json.NewEncoder(conn).Encode(resp); err != nil {
-		fmt.Printf("Failed to send error response: %v\n", err)
+		logf(conn, "Failed to send error response: %v\n", err)
 	}
 }
@@ -0,0 +1,227 @@
+package net
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// snapshotExpiry 快照最后一次被读取后保留的时间，客户端异常退出没有发送 release 请求时到期释放
+const snapshotExpiry = 6 * time.Hour
+
+// snapshotHooks 在读取源目录前创建文件系统快照（LVM/Btrfs/ZFS/VSS 等）的外部命令
+type snapshotHooks struct {
+	createCmd  string // 创建快照，环境变量 GORSYNC_SOURCE 为源目录，标准输出第一行为快照中对应的目录
+	releaseCmd string // 释放快照，环境变量 GORSYNC_SOURCE 和 GORSYNC_SNAPSHOT 同上，可为空
+
+	createMutex sync.Mutex // 同一时间只创建一个快照，避免并发的列表请求为同一目录重复创建
+	mutex       sync.Mutex
+	snapshots   map[string]*snapshot // 源目录 -> 快照
+}
+
+// snapshot 源目录的快照，持有它的会话全部结束后才释放
+type snapshot struct {
+	path    string
+	holders map[string]bool // 列出过该目录、尚未发送 release 请求的会话ID，不带会话ID的客户端为 ""
+	used    time.Time
+}
+
+// SetSnapshotHooks 设置快照命令，列表请求会先为源目录创建快照，之后的读取都重定向到快照
+func (s *Server) SetSnapshotHooks(createCmd, releaseCmd string) {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+
+	// 已有的快照保留，之后的请求使用新命令
+	if s.snapshots != nil {
+		s.snapshots.mutex.Lock()
+		s.snapshots.createCmd = createCmd
+		s.snapshots.releaseCmd = releaseCmd
+		s.snapshots.mutex.Unlock()
+		return
+	}
+
+	s.snapshots = &snapshotHooks{
+		createCmd:  createCmd,
+		releaseCmd: releaseCmd,
+		snapshots:  make(map[string]*snapshot),
+	}
+}
+
+// snapshotHooks 返回快照命令，未设置时为 nil
+func (s *Server) snapshotHooks() *snapshotHooks {
+	s.configMutex.RLock()
+	defer s.configMutex.RUnlock()
+
+	return s.snapshots
+}
+
+// runHook 通过系统 shell 执行快照命令
+func runHook(command string, env ...string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), env...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// create 为会话创建源目录的快照。其他会话仍在读取已有的快照时共用该快照；
+// 只有本会话持有时（例如 watch 模式重新列出）释放旧快照并重新创建
+func (h *snapshotHooks) create(source, session string) (string, error) {
+	h.createMutex.Lock()
+	defer h.createMutex.Unlock()
+
+	h.expire()
+	h.mutex.Lock()
+	if snap, ok := h.snapshots[source]; ok {
+		shared := false
+		for holder := range snap.holders {
+			if holder != session {
+				shared = true
+			}
+		}
+		if shared {
+			snap.holders[session] = true
+			snap.used = time.Now()
+			h.mutex.Unlock()
+			return snap.path, nil
+		}
+	}
+	createCmd := h.createCmd
+	h.mutex.Unlock()
+	h.destroy(source)
+
+	output, err := runHook(createCmd, "GORSYNC_SOURCE="+source)
+	if err != nil {
+		return "", fmt.Errorf("snapshot command failed: %v", err)
+	}
+
+	line, _, _ := strings.Cut(output, "\n")
+	path := strings.TrimSpace(line)
+	if path == "" {
+		return "", fmt.Errorf("snapshot command did not print a snapshot path")
+	}
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("invalid snapshot path: %s", path)
+	}
+
+	h.mutex.Lock()
+	h.snapshots[source] = &snapshot{path: path, holders: map[string]bool{session: true}, used: time.Now()}
+	h.mutex.Unlock()
+
+	fmt.Printf("Created snapshot of %s at %s\n", source, path)
+	return path, nil
+}
+
+// release 会话结束，不再持有源目录的快照；最后一个持有的会话结束时释放快照
+func (h *snapshotHooks) release(source, session string) {
+	h.expire()
+	h.mutex.Lock()
+	last := false
+	if snap, ok := h.snapshots[source]; ok {
+		delete(snap.holders, session)
+		last = len(snap.holders) == 0
+	}
+	h.mutex.Unlock()
+
+	if last {
+		h.destroy(source)
+	}
+}
+
+// destroy 不论是否还有会话持有，立即释放源目录的快照
+func (h *snapshotHooks) destroy(source string) {
+	h.mutex.Lock()
+	snap, ok := h.snapshots[source]
+	delete(h.snapshots, source)
+	releaseCmd := h.releaseCmd
+	h.mutex.Unlock()
+
+	if !ok {
+		return
+	}
+
+	if releaseCmd != "" {
+		if _, err := runHook(releaseCmd, "GORSYNC_SOURCE="+source, "GORSYNC_SNAPSHOT="+snap.path); err != nil {
+			fmt.Printf("Failed to release snapshot %s: %v\n", snap.path, err)
+			return
+		}
+	}
+	fmt.Printf("Released snapshot of %s\n", source)
+}
+
+// expire 释放超过 snapshotExpiry 没有被读取的快照
+func (h *snapshotHooks) expire() {
+	h.mutex.Lock()
+	var expired []string
+	for source, snap := range h.snapshots {
+		if time.Since(snap.used) > snapshotExpiry {
+			expired = append(expired, source)
+		}
+	}
+	h.mutex.Unlock()
+
+	for _, source := range expired {
+		fmt.Printf("Snapshot of %s has not been read for %s\n", source, snapshotExpiry)
+		h.destroy(source)
+	}
+}
+
+// releaseAll 释放所有快照
+func (h *snapshotHooks) releaseAll() {
+	h.mutex.Lock()
+	sources := make([]string, 0, len(h.snapshots))
+	for source := range h.snapshots {
+		sources = append(sources, source)
+	}
+	h.mutex.Unlock()
+
+	for _, source := range sources {
+		h.destroy(source)
+	}
+}
+
+// remap 将源目录下的路径映射到快照中的对应路径，不在任何快照中时原样返回
+func (h *snapshotHooks) remap(path string) string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	best := ""
+	for source := range h.snapshots {
+		if len(source) > len(best) && isWithin(source, path) {
+			best = source
+		}
+	}
+	if best == "" {
+		return path
+	}
+
+	rel, err := filepath.Rel(best, path)
+	if err != nil {
+		return path
+	}
+	snap := h.snapshots[best]
+	snap.used = time.Now()
+	return filepath.Join(snap.path, rel)
+}
+
+// isWithin 检查 path 是否为 dir 本身或位于其下
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
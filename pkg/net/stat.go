@@ -0,0 +1,132 @@
+package net
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"os"
+
+	"gorsync/pkg/utils"
+)
+
+// 校验和请求支持的算法
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// statFile 返回服务器上单个路径的完整路径和文件信息（不计算哈希）
+func (s *Server) statFile(conn net.Conn, path string) (string, os.FileInfo, *FileInfo, error) {
+	var fullPath string
+	if s.rootDir == "" {
+		fullPath = path
+	} else {
+		fullPath = LocalPath(s.rootDir, path)
+	}
+
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	// 上传的临时文件与列表中一样不可见
+	if !info.IsDir() && utils.IsTempName(info.Name()) {
+		return "", nil, nil, &os.PathError{Op: "stat", Path: fullPath, Err: os.ErrNotExist}
+	}
+
+	fileInfo := &FileInfo{
+		Path:     path,
+		Size:     info.Size(),
+		ModTime:  info.ModTime().Unix(),
+		ModNanos: info.ModTime().Nanosecond(),
+		IsDir:    info.IsDir(),
+		Mode:     EncodeMode(info.Mode()),
+		Type:     FileType(info.Mode()),
+	}
+	if uid, gid, ok := utils.FileOwner(info); ok {
+		fileInfo.Owner = &Owner{Uid: uid, Gid: gid}
+	}
+	if attrs, ok := utils.FileAttributes(info); ok {
+		fileInfo.Windows = &WindowsMeta{Attributes: attrs, Streams: readStreams(conn, fullPath, false)}
+	}
+	fileInfo.Xattrs = readXattrs(conn, fullPath, false)
+	return fullPath, info, fileInfo, nil
+}
+
+// handleStatRequest 返回单个路径的元数据
+func (s *Server) handleStatRequest(conn net.Conn, req Request) {
+	_, _, fileInfo, err := s.statFile(conn, req.Path)
+	if err != nil {
+		s.sendErrorCode(conn, errorCode(err), fmt.Sprintf("Failed to stat file: %v", err))
+		return
+	}
+
+	resp := Response{
+		Status: "ok",
+		File:   fileInfo,
+	}
+	if err := json.NewEncoder(conn).Encode(&resp); err != nil {
+		logf(conn, "Failed to send response: %v\n", err)
+	}
+}
+
+// handleChecksumRequest 计算单个文件的校验和，MD5 使用服务器的哈希缓存
+func (s *Server) handleChecksumRequest(conn net.Conn, req Request) {
+	algorithm := req.Algorithm
+	if algorithm == "" {
+		algorithm = "md5"
+	}
+	newHash, ok := checksumAlgorithms[algorithm]
+	if !ok {
+		s.sendError(conn, fmt.Sprintf("Unsupported checksum algorithm: %s", algorithm))
+		return
+	}
+
+	fullPath, info, fileInfo, err := s.statFile(conn, req.Path)
+	if err != nil {
+		s.sendErrorCode(conn, errorCode(err), fmt.Sprintf("Failed to stat file: %v", err))
+		return
+	}
+	if fileInfo.IsDir {
+		s.sendError(conn, "Path is a directory")
+		return
+	}
+
+	var checksum string
+	if algorithm == "md5" {
+		checksum, err = s.hashes.md5(fullPath, info)
+		if err != nil {
+			s.sendError(conn, fmt.Sprintf("Failed to calculate checksum: %v", err))
+			return
+		}
+	} else {
+		file, err := os.Open(fullPath)
+		if err != nil {
+			s.sendErrorCode(conn, errorCode(err), fmt.Sprintf("Failed to open file: %v", err))
+			return
+		}
+		h := newHash()
+		_, err = io.Copy(h, file)
+		file.Close()
+		if err != nil {
+			s.sendError(conn, fmt.Sprintf("Failed to calculate checksum: %v", err))
+			return
+		}
+		checksum = hex.EncodeToString(h.Sum(nil))
+	}
+
+	resp := Response{
+		Status:   "ok",
+		File:     fileInfo,
+		Checksum: checksum,
+	}
+	if err := json.NewEncoder(conn).Encode(&resp); err != nil {
+		logf(conn, "Failed to send response: %v\n", err)
+	}
+}
@@ -0,0 +1,29 @@
+package net
+
+import (
+	"fmt"
+	"os"
+)
+
+// LocalStatus 本机状态管道返回的服务器状态，不需要控制令牌，只能在服务器所在的机器上读取
+type LocalStatus struct {
+	PID         int              `json:"pid"`
+	Port        int              `json:"port"`
+	Status      *ServerStatus    `json:"status"`
+	Connections []ConnectionInfo `json:"connections"`
+}
+
+// StatusPipeName 返回监听指定端口的服务器的状态管道名
+func StatusPipeName(port int) string {
+	return fmt.Sprintf(`\\.\pipe\gorsync-%d`, port)
+}
+
+// localStatus 返回写入状态管道的服务器状态
+func (s *Server) localStatus() *LocalStatus {
+	return &LocalStatus{
+		PID:         os.Getpid(),
+		Port:        s.port,
+		Status:      s.status(),
+		Connections: s.conns.list(),
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package net
+
+import "errors"
+
+// errNoStatusPipe 只有 Windows 提供状态管道，其他系统上由 systemd 等服务管理器保存输出
+var errNoStatusPipe = errors.New("the status pipe is only available on Windows; use gorsync admin <host[:port]> status")
+
+// ServeStatusPipe 其他系统没有命名管道，什么也不做
+func (s *Server) ServeStatusPipe() (string, error) {
+	return "", nil
+}
+
+// ReadStatusPipe 其他系统没有命名管道
+func ReadStatusPipe(port int) (*LocalStatus, error) {
+	return nil, errNoStatusPipe
+}
@@ -0,0 +1,135 @@
+//go:build windows
+
+package net
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modKernel32             = syscall.NewLazyDLL("kernel32.dll")
+	procCreateNamedPipeW    = modKernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe    = modKernel32.NewProc("ConnectNamedPipe")
+	procDisconnectNamedPipe = modKernel32.NewProc("DisconnectNamedPipe")
+	procWaitNamedPipeW      = modKernel32.NewProc("WaitNamedPipeW")
+)
+
+const (
+	pipeAccessOutbound       = 0x00000002
+	pipeRejectRemoteClients  = 0x00000008
+	pipeUnlimitedInstances   = 255
+	errorPipeBusy            = syscall.Errno(231)
+	errorPipeConnected       = syscall.Errno(535)
+	statusPipeBufferSize     = 64 * 1024
+	statusPipeWaitMillis     = 1000
+	statusPipeConnectRetries = 5
+)
+
+// ServeStatusPipe 在命名管道 \\.\pipe\gorsync-<port> 上提供服务器状态，每个连接写入一个 LocalStatus 后断开。
+// 作为 Windows 服务运行时没有控制台，gorsync status 通过它查看服务的活动；管道只接受本机连接，
+// 使用默认的安全描述符（管理员和 LocalSystem 完全控制，其他账户只读）。返回管道名，管道随进程退出
+func (s *Server) ServeStatusPipe() (string, error) {
+	name := StatusPipeName(s.port)
+	handle, err := createStatusPipe(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to create status pipe %s: %v", name, err)
+	}
+
+	go func() {
+		for {
+			s.answerStatusPipe(handle)
+			// 每个连接使用一个管道实例，应答后创建新的实例等待下一个连接
+			handle, err = createStatusPipe(name)
+			if err != nil {
+				fmt.Printf("Failed to create status pipe %s: %v\n", name, err)
+				return
+			}
+		}
+	}()
+	return name, nil
+}
+
+// createStatusPipe 创建一个只写的字节流管道实例
+func createStatusPipe(name string) (syscall.Handle, error) {
+	namePtr, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return syscall.InvalidHandle, err
+	}
+	r1, _, err := procCreateNamedPipeW.Call(
+		uintptr(unsafe.Pointer(namePtr)),
+		pipeAccessOutbound,
+		pipeRejectRemoteClients,
+		pipeUnlimitedInstances,
+		statusPipeBufferSize,
+		statusPipeBufferSize,
+		0,
+		0,
+	)
+	handle := syscall.Handle(r1)
+	if handle == syscall.InvalidHandle {
+		return handle, err
+	}
+	return handle, nil
+}
+
+// answerStatusPipe 等待客户端连接，写入当前状态，等客户端读完后断开并关闭管道实例
+func (s *Server) answerStatusPipe(handle syscall.Handle) {
+	defer syscall.CloseHandle(handle)
+
+	r1, _, err := procConnectNamedPipe.Call(uintptr(handle), 0)
+	// 客户端在 ConnectNamedPipe 之前已经连上时返回 ERROR_PIPE_CONNECTED，同样可以写入
+	if r1 == 0 && !errors.Is(err, errorPipeConnected) {
+		return
+	}
+	defer procDisconnectNamedPipe.Call(uintptr(handle))
+
+	data, err := json.Marshal(s.localStatus())
+	if err != nil {
+		return
+	}
+	var written uint32
+	if err := syscall.WriteFile(handle, data, &written, nil); err != nil {
+		return
+	}
+	syscall.FlushFileBuffers(handle)
+}
+
+// ReadStatusPipe 读取本机监听指定端口的服务器的状态，所有管道实例都忙时等待后重试
+func ReadStatusPipe(port int) (*LocalStatus, error) {
+	name := StatusPipeName(port)
+	namePtr, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var file *os.File
+	for attempt := 0; ; attempt++ {
+		file, err = os.Open(name)
+		if err == nil {
+			break
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("no gorsync server is listening on port %d on this machine (%s not found)", port, name)
+		}
+		if !errors.Is(err, errorPipeBusy) || attempt >= statusPipeConnectRetries {
+			return nil, fmt.Errorf("failed to open status pipe %s: %v", name, err)
+		}
+		procWaitNamedPipeW.Call(uintptr(unsafe.Pointer(namePtr)), statusPipeWaitMillis)
+	}
+	defer file.Close()
+
+	var status LocalStatus
+	if err := json.NewDecoder(file).Decode(&status); err != nil {
+		return nil, fmt.Errorf("failed to read status from %s: %v", name, err)
+	}
+	if status.Status == nil {
+		return nil, fmt.Errorf("no status in %s", name)
+	}
+	return &status, nil
+}
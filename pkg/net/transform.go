@@ -0,0 +1,374 @@
+package net
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+)
+
+// 传输变换，服务器发送前编码，客户端接收后解码，落盘的内容与源文件相同
+const (
+	TransformGzip = "gzip" // 压缩传输
+	TransformAES  = "aes"  // 以完整性密钥派生的密钥加密传输（AES-GCM），两端都需设置完整性密钥
+	TransformDict = "dict" // 以会话的压缩字典压缩传输（带预置字典的 deflate），字典见 Client.FetchDictionary
+)
+
+// aesChunkSize 加密传输时每个数据块的最大明文长度
+const aesChunkSize = 64 * 1024
+
+// transform 可逆的内容变换，parallel 是用多个 goroutine 编码、输出格式与 encode 兼容的编码器。
+// key 为变换使用的密钥材料：aes 为完整性密钥，dict 为压缩字典，见 transformKey
+type transform struct {
+	encode   func(w io.Writer, key []byte) (io.WriteCloser, error)
+	parallel func(w io.Writer, key []byte, workers int) (io.WriteCloser, error)
+	decode   func(r io.Reader, key []byte) (io.Reader, error)
+}
+
+var transforms = map[string]transform{
+	TransformGzip: {
+		encode: func(w io.Writer, key []byte) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		},
+		parallel: newParallelGzipWriter,
+		decode: func(r io.Reader, key []byte) (io.Reader, error) {
+			return gzip.NewReader(r)
+		},
+	},
+	TransformAES: {
+		encode:   newAESWriter,
+		parallel: newParallelAESWriter,
+		decode:   newAESReader,
+	},
+	TransformDict: {
+		encode: newDictWriter,
+		// 只有小文件使用字典，不需要并行
+		parallel: func(w io.Writer, dict []byte, workers int) (io.WriteCloser, error) {
+			return newDictWriter(w, dict)
+		},
+		decode: newDictReader,
+	},
+}
+
+// unsupportedTransforms 不可逆、不能作为传输变换的内容处理，及解析规则时给出的替代办法。
+// 传输变换解码后必须与源文件完全相同，客户端按源文件的大小和MD5校验接收的内容
+var unsupportedTransforms = map[string]string{
+	"crlf":  "line endings are converted by --text-mode, which also compares text files ignoring line endings",
+	"lf":    "line endings are converted by --text-mode, which also compares text files ignoring line endings",
+	"eol":   "line endings are converted by --text-mode, which also compares text files ignoring line endings",
+	"strip": "stripping metadata changes the content, and transforms must restore the original file",
+}
+
+// transformKey 返回变换使用的密钥材料
+func transformKey(name string, key, dict []byte) []byte {
+	if name == TransformDict {
+		return dict
+	}
+	return key
+}
+
+// TransformRule 文件名匹配 Pattern 的文件传输时依次应用 Transforms
+type TransformRule struct {
+	Pattern    string
+	Transforms []string
+}
+
+// ParseTransformRule 解析 pattern=transform[,transform...] 格式的变换规则，
+// 模式不含 / 时匹配文件名，否则匹配完整的远程路径
+func ParseTransformRule(rule string) (TransformRule, error) {
+	pattern, names, ok := strings.Cut(rule, "=")
+	if !ok || pattern == "" || names == "" {
+		return TransformRule{}, fmt.Errorf("invalid transform rule %q, expected pattern=transform[,transform...]", rule)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return TransformRule{}, fmt.Errorf("invalid transform pattern %q: %v", pattern, err)
+	}
+
+	r := TransformRule{Pattern: pattern}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if hint, ok := unsupportedTransforms[name]; ok {
+			return TransformRule{}, fmt.Errorf("transform %s is not supported: %s", name, hint)
+		}
+		if _, ok := transforms[name]; !ok {
+			return TransformRule{}, fmt.Errorf("unknown transform: %s", name)
+		}
+		r.Transforms = append(r.Transforms, name)
+	}
+	return r, nil
+}
+
+// matchTransforms 返回第一条匹配远程路径的规则中的变换
+func matchTransforms(rules []TransformRule, remotePath string) []string {
+	for _, rule := range rules {
+		if matchPath(rule.Pattern, remotePath) {
+			return rule.Transforms
+		}
+	}
+	return nil
+}
+
+// checkTransforms 检查变换是否都受支持，以及需要密钥或字典的变换是否有密钥或字典
+func checkTransforms(names []string, key, dict []byte) error {
+	for _, name := range names {
+		if _, ok := transforms[name]; !ok {
+			return fmt.Errorf("unknown transform: %s", name)
+		}
+		if name == TransformAES && len(key) == 0 {
+			return fmt.Errorf("transform %s requires an integrity key", name)
+		}
+		if name == TransformDict && len(dict) == 0 {
+			return fmt.Errorf("transform %s requires a session dictionary", name)
+		}
+	}
+	return nil
+}
+
+// transformWriter 依次关闭各层编码器，刷新缓存的数据
+type transformWriter struct {
+	io.Writer
+	closers []io.Closer // 由外到内
+}
+
+func (w *transformWriter) Close() error {
+	for _, c := range w.closers {
+		if err := c.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeTransforms 返回按 names 顺序编码后写入 w 的写入端，写完后需调用 Close。
+// workers 大于 1 时每层变换用这么多 goroutine 并行编码，各层之间以及与发送之间也并行进行
+func encodeTransforms(w io.Writer, names []string, key, dict []byte, workers int) (io.WriteCloser, error) {
+	tw := &transformWriter{Writer: w}
+	for i := len(names) - 1; i >= 0; i-- {
+		var enc io.WriteCloser
+		var err error
+		if workers > 1 {
+			enc, err = transforms[names[i]].parallel(tw.Writer, transformKey(names[i], key, dict), workers)
+		} else {
+			enc, err = transforms[names[i]].encode(tw.Writer, transformKey(names[i], key, dict))
+		}
+		if err != nil {
+			return nil, err
+		}
+		tw.Writer = enc
+		tw.closers = append([]io.Closer{enc}, tw.closers...)
+	}
+	return tw, nil
+}
+
+// decodeTransforms 返回按相反顺序还原 names 编码的读取端
+func decodeTransforms(r io.Reader, names []string, key, dict []byte) (io.Reader, error) {
+	for i := len(names) - 1; i >= 0; i-- {
+		dec, err := transforms[names[i]].decode(r, transformKey(names[i], key, dict))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s transfer: %v", names[i], err)
+		}
+		r = dec
+	}
+	return r, nil
+}
+
+// newTransferCipher 从完整性密钥派生传输加密使用的 AES-256-GCM
+func newTransferCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("transform %s requires an integrity key", TransformAES)
+	}
+	derived := sha256.Sum256(append([]byte("gorsync transfer encryption\x00"), key...))
+	block, err := aes.NewCipher(derived[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce 由随机前缀和数据块序号生成每个数据块的 nonce
+func chunkNonce(base []byte, counter uint64) []byte {
+	nonce := append([]byte(nil), base...)
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], binary.BigEndian.Uint64(base[len(base)-8:])^counter)
+	return nonce
+}
+
+// aesWriter 分块加密：随机 nonce 前缀之后是若干 [4 字节长度][密文] 数据块
+type aesWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	base    []byte
+	counter uint64
+	buf     []byte
+	started bool
+}
+
+func newAESWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	aead, err := newTransferCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	base := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(base); err != nil {
+		return nil, err
+	}
+	return &aesWriter{w: w, aead: aead, base: base, buf: make([]byte, 0, aesChunkSize)}, nil
+}
+
+func (a *aesWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(a.buf[len(a.buf):cap(a.buf)], p)
+		a.buf = a.buf[:len(a.buf)+n]
+		p = p[n:]
+		written += n
+		if len(a.buf) == cap(a.buf) {
+			if err := a.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// flush 加密并写出缓存的明文
+func (a *aesWriter) flush() error {
+	if !a.started {
+		if _, err := a.w.Write(a.base); err != nil {
+			return err
+		}
+		a.started = true
+	}
+	if len(a.buf) == 0 {
+		return nil
+	}
+
+	sealed := a.aead.Seal(nil, chunkNonce(a.base, a.counter), a.buf, nil)
+	a.counter++
+	a.buf = a.buf[:0]
+
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(sealed)))
+	if _, err := a.w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := a.w.Write(sealed)
+	return err
+}
+
+func (a *aesWriter) Close() error {
+	return a.flush()
+}
+
+// newParallelGzipWriter 把输入按 gzipBlockSize 分块，各自压缩成独立的 gzip 成员后依次写出。
+// gzip.Reader 默认连续读取多个成员，客户端不需要区分
+func newParallelGzipWriter(w io.Writer, key []byte, workers int) (io.WriteCloser, error) {
+	return newParallelWriter(w, workers, gzipBlockSize, func(seq uint64, block []byte) ([]byte, error) {
+		var out bytes.Buffer
+		zw := gzipWriters.Get().(*gzip.Writer)
+		defer gzipWriters.Put(zw)
+		zw.Reset(&out)
+		if _, err := zw.Write(block); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	}), nil
+}
+
+// newParallelAESWriter 并行加密各个数据块，输出与 aesWriter 相同：数据块序号决定 nonce，与加密的先后无关
+func newParallelAESWriter(w io.Writer, key []byte, workers int) (io.WriteCloser, error) {
+	aead, err := newTransferCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	base := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(base); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(base); err != nil {
+		return nil, err
+	}
+
+	// 每个 goroutine 使用自己的 AEAD
+	aeads := sync.Pool{
+		New: func() any {
+			aead, _ := newTransferCipher(key)
+			return aead
+		},
+	}
+	aeads.Put(aead)
+	return newParallelWriter(w, workers, aesChunkSize, func(seq uint64, block []byte) ([]byte, error) {
+		if len(block) == 0 {
+			return nil, nil
+		}
+		aead := aeads.Get().(cipher.AEAD)
+		defer aeads.Put(aead)
+
+		out := make([]byte, 4, 4+len(block)+aead.Overhead())
+		out = aead.Seal(out, chunkNonce(base, seq), block, nil)
+		binary.BigEndian.PutUint32(out[:4], uint32(len(out)-4))
+		return out, nil
+	}), nil
+}
+
+// aesReader 解密 aesWriter 写出的数据
+type aesReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	base    []byte
+	counter uint64
+	plain   []byte
+}
+
+func newAESReader(r io.Reader, key []byte) (io.Reader, error) {
+	aead, err := newTransferCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	base := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(r, base); err != nil {
+		return nil, err
+	}
+	return &aesReader{r: r, aead: aead, base: base}, nil
+}
+
+func (a *aesReader) Read(p []byte) (int, error) {
+	for len(a.plain) == 0 {
+		var header [4]byte
+		if _, err := io.ReadFull(a.r, header[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return 0, fmt.Errorf("truncated encrypted chunk header")
+			}
+			return 0, err
+		}
+		size := binary.BigEndian.Uint32(header[:])
+		if size > aesChunkSize+uint32(a.aead.Overhead()) {
+			return 0, fmt.Errorf("invalid encrypted chunk size: %d", size)
+		}
+
+		sealed := make([]byte, size)
+		if _, err := io.ReadFull(a.r, sealed); err != nil {
+			return 0, fmt.Errorf("truncated encrypted chunk: %v", err)
+		}
+		plain, err := a.aead.Open(nil, chunkNonce(a.base, a.counter), sealed, nil)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decrypt chunk: %v", err)
+		}
+		a.counter++
+		a.plain = plain
+	}
+
+	n := copy(p, a.plain)
+	a.plain = a.plain[n:]
+	return n, nil
+}
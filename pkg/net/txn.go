@@ -0,0 +1,206 @@
+package net
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"gorsync/pkg/utils"
+)
+
+// txnExpiry 事务最后一次上传后超过这个时间仍未提交时丢弃，防止断开的客户端留下暂存文件
+const txnExpiry = time.Hour
+
+// stagedFile 一次推送中上传的文件
+type stagedFile struct {
+	temp   string // 目标文件旁等待提交的临时文件，已直接替换目标文件时为空
+	target string
+	path   string // 请求中的路径，提供给 post-receive 钩子
+}
+
+// transaction 一次推送中上传的文件，提交时替换暂存的文件并运行 post-receive 钩子
+type transaction struct {
+	files   []stagedFile
+	updated time.Time
+}
+
+// txnTable 未提交的推送，以客户端生成的事务ID为键
+type txnTable struct {
+	mutex sync.Mutex
+	txns  map[string]*transaction
+}
+
+// stage 记录事务中上传的文件，temp 为空表示已直接替换目标文件。同一事务中重复上传的目标只保留最后一次
+func (t *txnTable) stage(id, temp, target, path string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.expire()
+	if t.txns == nil {
+		t.txns = make(map[string]*transaction)
+	}
+	txn := t.txns[id]
+	if txn == nil {
+		txn = &transaction{}
+		t.txns[id] = txn
+	}
+	txn.updated = time.Now()
+	for i, f := range txn.files {
+		if f.target == target {
+			if f.temp != "" {
+				os.Remove(f.temp)
+			}
+			txn.files[i].temp = temp
+			return
+		}
+	}
+	txn.files = append(txn.files, stagedFile{temp: temp, target: target, path: path})
+}
+
+// take 从表中取出事务，事务不存在时返回 nil
+func (t *txnTable) take(id string) *transaction {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.expire()
+	txn := t.txns[id]
+	delete(t.txns, id)
+	return txn
+}
+
+// count 返回未提交的事务数
+func (t *txnTable) count() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.expire()
+	return len(t.txns)
+}
+
+// expire 丢弃超时未提交的事务，调用者需持有锁
+func (t *txnTable) expire() {
+	for id, txn := range t.txns {
+		if time.Since(txn.updated) > txnExpiry {
+			fmt.Printf("Discarding expired upload transaction %s (%d files)\n", id, len(txn.files))
+			txn.discard()
+			delete(t.txns, id)
+		}
+	}
+}
+
+// discard 删除事务暂存的文件
+func (txn *transaction) discard() {
+	for _, f := range txn.files {
+		if f.temp != "" {
+			os.Remove(f.temp)
+		}
+	}
+}
+
+// handleCommitRequest 处理控制请求：把事务暂存的文件依次替换到目标位置，然后运行 post-receive 钩子
+func (s *Server) handleCommitRequest(conn net.Conn, req Request) {
+	if !s.authorizeWrite(conn, req) {
+		return
+	}
+
+	txn := s.txns.take(req.Txn)
+	if txn == nil {
+		s.sendError(conn, fmt.Sprintf("Unknown or expired transaction: %s", req.Txn))
+		return
+	}
+
+	logf(conn, "Commit requested by %s: transaction %s (%d files)\n", conn.RemoteAddr(), req.Txn, len(txn.files))
+	paths := make([]string, 0, len(txn.files))
+	for i, f := range txn.files {
+		paths = append(paths, f.path)
+		if f.temp == "" {
+			continue
+		}
+		if err := utils.Saferename(f.temp, f.target); err != nil {
+			// 已替换的文件无法恢复，丢弃其余的暂存文件
+			(&transaction{files: txn.files[i:]}).discard()
+			s.sendError(conn, fmt.Sprintf("Commit failed after %d of %d files: %v", i, len(txn.files), err))
+			return
+		}
+	}
+
+	resp := Response{
+		Status: "ok",
+	}
+	if err := json.NewEncoder(conn).Encode(&resp); err != nil {
+		logf(conn, "Failed to send response: %v\n", err)
+	}
+
+	// 钩子可能很慢（重新加载服务、向下游复制），不让客户端等待
+	if cmd := s.PostReceiveCmd(); cmd != "" {
+		go s.runPostReceive(conn, cmd, req.Txn, paths)
+	}
+}
+
+// handleAbortRequest 处理控制请求：丢弃事务暂存的文件，不运行 post-receive 钩子
+func (s *Server) handleAbortRequest(conn net.Conn, req Request) {
+	if !s.authorizeWrite(conn, req) {
+		return
+	}
+
+	if txn := s.txns.take(req.Txn); txn != nil {
+		logf(conn, "Abort requested by %s: transaction %s (%d files)\n", conn.RemoteAddr(), req.Txn, len(txn.files))
+		txn.discard()
+	}
+
+	resp := Response{
+		Status: "ok",
+	}
+	if err := json.NewEncoder(conn).Encode(&resp); err != nil {
+		logf(conn, "Failed to send response: %v\n", err)
+	}
+}
+
+// SetTransaction 设置之后上传的文件所属的推送，staged 为 true 时文件只暂存，提交时才替换目标文件；
+// id 为空时每个文件上传后立即替换目标文件，也不会触发 post-receive 钩子
+func (c *Client) SetTransaction(id string, staged bool) {
+	c.txn = id
+	c.staged = staged
+}
+
+// Commit 请求服务器提交事务，把暂存的文件替换到目标位置并运行 post-receive 钩子
+func (c *Client) Commit(token string) error {
+	return c.endTransaction("commit", token)
+}
+
+// Abort 请求服务器丢弃事务暂存的文件
+func (c *Client) Abort(token string) error {
+	return c.endTransaction("abort", token)
+}
+
+// endTransaction 发送 commit 或 abort 请求
+func (c *Client) endTransaction(requestType, token string) error {
+	conn, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	req := Request{
+		Type:  requestType,
+		Token: token,
+		Txn:   c.txn,
+	}
+	if err := c.send(conn, &req); err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	if resp.Status != "ok" {
+		return responseError(&resp)
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ErrVersionMismatch 服务器的协议版本与客户端不兼容，或低于要求的最低版本
+var ErrVersionMismatch = errors.New("incompatible server version")
+
+// ErrNoProbe 服务器不认识 probe 请求，是引入协议版本之前的旧版本
+var ErrNoProbe = errors.New("server does not report its protocol version")
+
+// CheckVersion 在同步开始前通过 probe 请求确认服务器的协议版本，minVersion 大于 0 时要求服务器至少是该版本。
+// 版本不一致时返回包含双方版本和服务器能力的 ErrVersionMismatch，而不是在之后的请求中以无法解码的响应失败；
+// 服务器不支持 probe 时返回 ErrNoProbe
+func (c *Client) CheckVersion(minVersion int) (*ServerInfo, error) {
+	server := net.JoinHostPort(c.addr, fmt.Sprint(c.port))
+
+	info, err := c.Probe()
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), "Unknown request type: probe"):
+			err = fmt.Errorf("%w: %s predates protocol versioning; upgrade gorsync on the server", ErrNoProbe, server)
+		case strings.HasPrefix(err.Error(), "failed to decode response"), strings.HasPrefix(err.Error(), "no server info"):
+			err = fmt.Errorf("%w: %s did not answer with a gorsync probe response (%v); it is not a gorsync server or speaks an incompatible protocol",
+				ErrVersionMismatch, server, err)
+		}
+		return nil, err
+	}
+
+	if info.Version != ProtocolVersion {
+		older := "server"
+		if info.Version > ProtocolVersion {
+			older = "client"
+		}
+		return info, fmt.Errorf("%w: %s speaks protocol version %d, this client speaks version %d; upgrade gorsync on the %s (%s)",
+			ErrVersionMismatch, server, info.Version, ProtocolVersion, older, describeServer(info))
+	}
+	if minVersion > 0 && info.Version < minVersion {
+		return info, fmt.Errorf("%w: %s speaks protocol version %d, at least version %d is required (%s)",
+			ErrVersionMismatch, server, info.Version, minVersion, describeServer(info))
+	}
+	return info, nil
+}
+
+// describeServer 返回服务器支持的请求和能力的简短说明，附在版本错误中便于判断缺少哪些特性
+func describeServer(info *ServerInfo) string {
+	capabilities := "none"
+	if len(info.Capabilities) > 0 {
+		capabilities = strings.Join(info.Capabilities, ", ")
+	}
+	return fmt.Sprintf("requests: %s; capabilities: %s", strings.Join(info.Requests, ", "), capabilities)
+}
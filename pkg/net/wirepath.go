@@ -0,0 +1,66 @@
+package net
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// 协议中的路径（FileInfo.Path、SkippedPath.Path 和请求中的路径）总是以 / 分隔，
+// 只在访问文件系统时才转换为本机格式，避免 Windows 和 Linux 对端之间因分隔符不同而误判文件变化
+
+// WirePath 把本机格式的路径转换为协议格式
+func WirePath(osPath string) string {
+	return filepath.ToSlash(osPath)
+}
+
+// JoinWire 拼接协议格式的路径
+func JoinWire(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// LocalPath 把协议格式的相对路径转换为本机目录 root 之下的路径
+func LocalPath(root, wirePath string) string {
+	return filepath.Join(root, filepath.FromSlash(wirePath))
+}
+
+// CheckWirePath 检查服务器发来的相对路径，拒绝绝对路径和含 .. 的路径，
+// 防止恶意服务器让客户端写到同步根目录之外。\ 在 Windows 上是分隔符，也按分隔符检查
+func CheckWirePath(wirePath string) error {
+	if wirePath == "" || strings.ContainsRune(wirePath, 0) {
+		return fmt.Errorf("invalid path %q", wirePath)
+	}
+	if strings.HasPrefix(wirePath, "/") || strings.HasPrefix(wirePath, `\`) ||
+		filepath.IsAbs(wirePath) || filepath.VolumeName(wirePath) != "" {
+		return fmt.Errorf("absolute path not allowed: %q", wirePath)
+	}
+	for _, elem := range strings.FieldsFunc(wirePath, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if elem == ".." {
+			return fmt.Errorf("path escapes the sync root: %q", wirePath)
+		}
+	}
+	return nil
+}
+
+// CheckStreamName 检查服务器发来的备用数据流名称。客户端把名称拼接为 path:name 写入，
+// 名称中的分隔符、冒号、NUL 或 .. 会让写入落到同步根目录之外，一律拒绝
+func CheckStreamName(name string) error {
+	if name == "" || strings.ContainsAny(name, "/\\:\x00") || strings.Contains(name, "..") {
+		return fmt.Errorf("invalid stream name %q", name)
+	}
+	return nil
+}
+
+// checkStreams 检查文件信息中的所有备用数据流名称
+func checkStreams(f *FileInfo) error {
+	if f.Windows == nil {
+		return nil
+	}
+	for _, stream := range f.Windows.Streams {
+		if err := CheckStreamName(stream.Name); err != nil {
+			return fmt.Errorf("server sent an unsafe stream for %s: %v", f.Path, err)
+		}
+	}
+	return nil
+}
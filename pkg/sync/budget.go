@@ -0,0 +1,134 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gorsync/pkg/utils"
+)
+
+// budgetStateFile 默认的流量预算状态文件，位于同步根目录下
+const budgetStateFile = ".gorsync-budget.json"
+
+// DefaultBudgetWarn 默认在预算用到这个百分比时开始警告
+const DefaultBudgetWarn = 80
+
+// TransferBudget 按自然周期（天、周或月）累计的下载流量上限，用于按流量计费的连接
+type TransferBudget struct {
+	Limit  int64  // 每个周期最多下载的字节数
+	Period string // "day"、"week" 或 "month"
+	Warn   int    // 用量达到上限的这个百分比时警告，0 表示不警告
+}
+
+// ParseTransferBudget 解析 size/period 格式的流量预算，例如 50GB/month
+func ParseTransferBudget(spec string) (*TransferBudget, error) {
+	size, period, ok := strings.Cut(spec, "/")
+	if !ok {
+		return nil, fmt.Errorf("invalid budget %q, expected size/period, e.g. 50GB/month", spec)
+	}
+	limit, err := utils.ParseSize(size)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		return nil, fmt.Errorf("budget must be positive: %s", spec)
+	}
+
+	period = strings.ToLower(strings.TrimSpace(period))
+	switch period {
+	case "day", "week", "month":
+	default:
+		return nil, fmt.Errorf("unknown budget period %q, expected day, week or month", period)
+	}
+
+	return &TransferBudget{Limit: limit, Period: period, Warn: DefaultBudgetWarn}, nil
+}
+
+// periodKey 返回 t 所在周期的标识，周期变化时用量重新计算
+func (b *TransferBudget) periodKey(t time.Time) string {
+	switch b.Period {
+	case "day":
+		return t.Format("2006-01-02")
+	case "week":
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	default:
+		return t.Format("2006-01")
+	}
+}
+
+// budgetState 当前周期已用的流量
+type budgetState struct {
+	Period string `json:"period"`
+	Used   int64  `json:"used"`
+}
+
+// budgetStatePath 返回流量预算状态文件的路径
+func (s *Syncer) budgetStatePath() string {
+	if s.opts.BudgetFile != "" {
+		return s.opts.BudgetFile
+	}
+	return filepath.Join(s.localPath, budgetStateFile)
+}
+
+// loadBudget 读取当前周期已用的流量，进入新周期时从 0 开始
+func (s *Syncer) loadBudget() budgetState {
+	current := budgetState{Period: s.opts.Budget.periodKey(time.Now())}
+
+	data, err := os.ReadFile(s.budgetStatePath())
+	if err != nil {
+		return current
+	}
+	var saved budgetState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		s.printf("Ignoring invalid budget state: %v\n", err)
+		return current
+	}
+	if saved.Period == current.Period {
+		current.Used = saved.Used
+	}
+	return current
+}
+
+// checkBudget 计划的下载量超出本周期剩余的预算时返回错误，接近上限时打印警告
+func (s *Syncer) checkBudget(planned int64) error {
+	budget := s.opts.Budget
+	state := s.loadBudget()
+	remaining := budget.Limit - state.Used
+	if planned > remaining {
+		return fmt.Errorf("plan transfers %s but only %s of the %s per %s budget is left",
+			utils.FormatSize(planned), utils.FormatSize(max(remaining, 0)), utils.FormatSize(budget.Limit), budget.Period)
+	}
+
+	if budget.Warn > 0 && (state.Used+planned)*100 >= budget.Limit*int64(budget.Warn) {
+		s.printf("Warning: %s of the %s per %s budget will be used after this sync\n",
+			utils.FormatSize(state.Used+planned), utils.FormatSize(budget.Limit), budget.Period)
+	}
+	return nil
+}
+
+// recordBudget 把本次下载的字节数计入当前周期的用量
+func (s *Syncer) recordBudget(transferred int64) error {
+	state := s.loadBudget()
+	state.Used += transferred
+
+	data, err := json.Marshal(&state)
+	if err != nil {
+		return err
+	}
+
+	statePath := s.budgetStatePath()
+	tempPath := utils.MakeTempName(statePath)
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return err
+	}
+	if err := utils.Saferename(tempPath, statePath); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return nil
+}
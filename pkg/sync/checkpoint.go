@@ -0,0 +1,128 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"gorsync/pkg/utils"
+)
+
+// checkpointInterval 两次写入检查点文件之间的最短间隔
+const checkpointInterval = 5 * time.Second
+
+// fileState 已确认内容的本地文件状态，用于检查点和清单
+type fileState struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"` // 本地文件的修改时间（纳秒）
+	MD5     string `json:"md5"`
+}
+
+// checkpoint 定期写入磁盘的会话状态，崩溃后重新同步时可跳过已确认文件的哈希计算
+type checkpoint struct {
+	path      string
+	Remote    string               `json:"remote"`
+	Files     map[string]fileState `json:"files"`
+	dirty     bool
+	lastFlush time.Time
+	out       io.Writer // 提示信息的输出
+}
+
+// loadCheckpoint 读取检查点文件，文件不存在或属于其他远程路径时返回空的检查点
+func loadCheckpoint(path, remote string, out io.Writer) (*checkpoint, error) {
+	cp := &checkpoint{
+		path:      path,
+		Remote:    remote,
+		Files:     make(map[string]fileState),
+		lastFlush: time.Now(),
+		out:       out,
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %v", err)
+	}
+
+	var saved checkpoint
+	if err := json.Unmarshal(data, &saved); err != nil {
+		fmt.Fprintf(out, "Ignoring invalid checkpoint %s: %v\n", path, err)
+		return cp, nil
+	}
+	if saved.Remote != remote {
+		fmt.Fprintf(out, "Ignoring checkpoint %s for a different remote: %s\n", path, saved.Remote)
+		return cp, nil
+	}
+	if saved.Files != nil {
+		cp.Files = saved.Files
+	}
+
+	fmt.Fprintf(out, "Resuming from checkpoint %s: %d file(s) already confirmed\n", path, len(cp.Files))
+	return cp, nil
+}
+
+// lookup 本地文件自确认后未被修改时返回记录的MD5
+func (c *checkpoint) lookup(relPath string, info os.FileInfo) (string, bool) {
+	entry, ok := c.Files[relPath]
+	if !ok || entry.Size != info.Size() || entry.ModTime != info.ModTime().UnixNano() {
+		return "", false
+	}
+	return entry.MD5, true
+}
+
+// record 记录已确认的本地文件，距上次写入超过 checkpointInterval 时写入磁盘
+func (c *checkpoint) record(relPath, localPath, md5 string) {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return
+	}
+
+	c.Files[relPath] = fileState{
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixNano(),
+		MD5:     md5,
+	}
+	c.dirty = true
+
+	if time.Since(c.lastFlush) >= checkpointInterval {
+		if err := c.flush(); err != nil {
+			fmt.Fprintf(c.out, "Failed to write checkpoint: %v\n", err)
+		}
+	}
+}
+
+// flush 通过临时文件原子地写入检查点
+func (c *checkpoint) flush() error {
+	c.lastFlush = time.Now()
+	if !c.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	tempPath := utils.MakeTempName(c.path)
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return err
+	}
+	if err := utils.Saferename(tempPath, c.path); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	c.dirty = false
+	return nil
+}
+
+// remove 同步成功完成后删除检查点文件
+func (c *checkpoint) remove() {
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(c.out, "Failed to remove checkpoint: %v\n", err)
+	}
+}
@@ -0,0 +1,148 @@
+package sync
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gorsync/pkg/net"
+	"gorsync/pkg/utils"
+)
+
+// dedupSource 可以作为本地复制来源的文件
+type dedupSource struct {
+	path string
+	size int64
+}
+
+// dedupDownloads 内容相同的远程文件只下载一次：与本地已有的文件或本次先下载的文件MD5相同的下载
+// 改为在执行计划的最后从该文件复制。文本模式下转换换行符的文件和空文件仍然下载
+func (p *Planner) dedupDownloads(plan *Plan) {
+	sources := make(map[string]dedupSource)
+	// 本地已是最新、只需更新元数据或在本地改名的文件按本地内容的MD5索引
+	for _, action := range plan.Actions {
+		local := action.File
+		switch action.Type {
+		case ActionKeep, ActionRename:
+		case ActionMetadata, ActionPerms, ActionTouch:
+			local = action.Local
+		default:
+			continue
+		}
+		if !local.IsDir && local.Type == "" && local.MD5 != "" && local.Size > 0 {
+			if _, ok := sources[local.MD5]; !ok {
+				sources[local.MD5] = dedupSource{path: action.Path, size: local.Size}
+			}
+		}
+	}
+
+	for i := range plan.Actions {
+		action := &plan.Actions[i]
+		f := action.File
+		if action.Type != ActionDownload || f.Type != "" || f.MD5 == "" || f.TextMD5 != "" || f.Size == 0 {
+			continue
+		}
+		source, ok := sources[f.MD5]
+		if !ok || source.size != f.Size {
+			sources[f.MD5] = dedupSource{path: action.Path, size: f.Size}
+			continue
+		}
+		action.Type = ActionCopy
+		action.Source = source.path
+	}
+}
+
+// copyDuplicates 在所有下载完成后执行计划中的本地复制，复制的内容与远程的MD5不一致时改为下载
+func (s *Syncer) copyDuplicates(client *net.Client, copies []Action, index int) error {
+	copied, saved := 0, int64(0)
+	for _, action := range copies {
+		if s.canceled() {
+			return net.ErrCanceled
+		}
+		start := time.Now()
+		err := s.copyLocal(action)
+		if err == nil {
+			s.printf("%d. Copied locally: %s <- %s\n", index, action.Path, action.Source)
+			s.recordOutcome(action, start, nil)
+			copied++
+			saved += action.File.Size
+			index++
+			continue
+		}
+		s.printf("%d. Local copy failed, downloading instead: %s: %v\n", index, action.Path, err)
+		download := Action{Type: ActionDownload, Path: action.Path, File: action.File, Reason: action.Reason}
+		err = s.downloadFile(client, action.File, index)
+		s.recordOutcome(download, start, err)
+		if err != nil {
+			return err
+		}
+		index++
+	}
+	if copied > 0 {
+		s.printf("Copied %d duplicate file(s) locally instead of downloading %s\n", copied, utils.FormatSize(saved))
+	}
+	return nil
+}
+
+// copyLocal 把 action.Source 复制到部分下载文件中，确认内容的MD5与远程相同后改名到目标路径
+func (s *Syncer) copyLocal(action Action) error {
+	sourcePath := net.LocalPath(s.localPath, action.Source)
+	targetPath := net.LocalPath(s.localPath, action.Path)
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+		return err
+	}
+	if s.opts.WindowsAttrs {
+		// 只读文件不能被替换，之后再恢复远程的属性
+		s.clearReadonly(targetPath)
+	}
+
+	src, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tempPath := net.PartialName(targetPath)
+	dst, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	hash := md5.New()
+	_, err = io.Copy(io.MultiWriter(dst, hash), src)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && hex.EncodeToString(hash.Sum(nil)) != action.File.MD5 {
+		err = fmt.Errorf("%s changed since it was listed", action.Source)
+	}
+	if err == nil {
+		err = utils.Saferename(tempPath, targetPath)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	if s.opts.NoPerms {
+		// 不设置权限，例如 FAT 类文件系统没有权限
+	} else if err := os.Chmod(targetPath, net.FileMode(action.File.Mode, s.opts.PermsSpecial)); err != nil {
+		s.printf("failed to set file mode: %s: %v\n", action.Path, err)
+	}
+	s.setModTime(targetPath, action.File)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.summary.FilesCopied++
+	s.summary.BytesCopied += action.File.Size
+	if s.checkpoint != nil {
+		s.checkpoint.record(action.File.Path, targetPath, action.File.MD5)
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package sync
+
+import (
+	"gorsync/pkg/net"
+	"gorsync/pkg/utils"
+)
+
+// fetchDictionary 请求服务器从远程目录的小文件训练压缩字典，失败时只提醒，文件仍逐个压缩传输
+func (s *Syncer) fetchDictionary(client *net.Client) {
+	dict, err := client.FetchDictionary(s.remotePath)
+	if err != nil {
+		s.printf("Warning: failed to get a compression dictionary, compressing files individually: %v\n", err)
+		return
+	}
+	if dict == nil {
+		s.printf("Not enough similar small files for a compression dictionary, compressing files individually\n")
+		return
+	}
+	s.printf("Using a %s compression dictionary %s for files of %s or less\n",
+		utils.FormatSize(int64(len(dict.Data))), dict.ID, utils.FormatSize(net.DictMaxFileSize))
+}
@@ -0,0 +1,39 @@
+package sync
+
+import (
+	"fmt"
+
+	"gorsync/pkg/net"
+)
+
+// inGlob 检查相对路径是否匹配远程路径中的模式，没有模式时总是返回 true。
+// 只同步匹配的文件时，本地不匹配的文件不比较也不删除，其清单记录和删除宽限计数保持不变
+func inGlob(glob, relPath string) bool {
+	if glob == "" {
+		return true
+	}
+	matched, _ := net.MatchGlob(glob, relPath)
+	return matched
+}
+
+// remoteSpec 返回用于日志、检查点和历史记录的远程路径，含模式时还原为用户给出的形式
+func (s *Syncer) remoteSpec() string {
+	if s.glob == "" {
+		return s.remotePath
+	}
+	return net.JoinWire(s.remotePath, s.glob)
+}
+
+// checkGlob 检查远程路径中的模式，模式与子目录不能同时使用
+func (s *Syncer) checkGlob(opts Options) error {
+	if s.glob == "" {
+		return nil
+	}
+	if err := net.ValidateGlob(s.glob); err != nil {
+		return err
+	}
+	if len(opts.Subdirs) > 0 {
+		return fmt.Errorf("remote subdirectories cannot be combined with a glob remote path: %s", s.remoteSpec())
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package sync
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"gorsync/pkg/net"
+	"gorsync/pkg/utils"
+)
+
+// graceStateFile 记录本地多余文件已连续出现次数的文件，位于同步根目录下
+const graceStateFile = ".gorsync-delete-grace.json"
+
+// graceStatePath 返回删除宽限状态文件的路径
+func (s *Syncer) graceStatePath() string {
+	return filepath.Join(s.localPath, graceStateFile)
+}
+
+// loadDeleteGrace 读取上次同步保存的多余文件计数
+func (s *Syncer) loadDeleteGrace() map[string]int {
+	previous := make(map[string]int)
+	if data, err := os.ReadFile(s.graceStatePath()); err == nil {
+		if err := json.Unmarshal(data, &previous); err != nil {
+			s.printf("Ignoring invalid delete grace state: %v\n", err)
+		}
+	}
+	return previous
+}
+
+// applyDeleteGrace 更新多余文件连续被观察到的次数，只返回已达到宽限次数、可以删除的文件
+func (p *Planner) applyDeleteGrace(plan *Plan, extraneous []net.FileInfo) []net.FileInfo {
+	// 只保留本次仍然多余的文件，重新出现过的文件重新计数
+	plan.Grace = make(map[string]int)
+	var due []net.FileInfo
+	for _, f := range extraneous {
+		count := p.Grace[f.Path] + 1
+		plan.Grace[f.Path] = count
+		if count >= p.Options.DeleteGrace {
+			due = append(due, f)
+		} else {
+			p.printf("Deferring deletion of %s (missing on remote for %d of %d runs)\n", f.Path, count, p.Options.DeleteGrace)
+		}
+	}
+	// 只同步部分子目录或匹配的文件时，其他路径本次没有比较，计数保持不变
+	for path, count := range p.Grace {
+		if !inSubdirs(p.Options.Subdirs, path) || !inGlob(p.Glob, path) {
+			plan.Grace[path] = count
+		}
+	}
+
+	return due
+}
+
+// dueForDeletion 过滤出已达到宽限次数的文件，用于 delete-after 模式的重新扫描结果
+func (p *Plan) dueForDeletion(files []net.FileInfo, grace int) []net.FileInfo {
+	if p.Grace == nil {
+		return files
+	}
+
+	var due []net.FileInfo
+	for _, f := range files {
+		if p.Grace[f.Path] >= grace {
+			due = append(due, f)
+		}
+	}
+	return due
+}
+
+// saveDeleteGrace 保存尚未删除的多余文件的计数
+func (s *Syncer) saveDeleteGrace() error {
+	pending := make(map[string]int)
+	for p, count := range s.graceCounts {
+		if count < s.opts.DeleteGrace {
+			pending[p] = count
+		}
+	}
+
+	statePath := s.graceStatePath()
+	if len(pending) == 0 {
+		if err := os.Remove(statePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+
+	tempPath := utils.MakeTempName(statePath)
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return err
+	}
+	if err := utils.Saferename(tempPath, statePath); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package sync
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// RunSummary 一次同步的汇总信息
+type RunSummary struct {
+	Session          string `json:"session,omitempty"` // 会话ID，与服务器日志中的 [会话ID] 对应
+	Start            int64  `json:"start"`             // 开始时间（Unix 秒）
+	Duration         int64  `json:"duration"`          // 耗时（毫秒）
+	Remote           string `json:"remote"`
+	Local            string `json:"local"`
+	FilesTotal       int    `json:"filesTotal"`                // 远程文件总数
+	FilesTransferred int    `json:"filesTransferred"`          // 下载的文件数
+	BytesTransferred int64  `json:"bytesTransferred"`          // 下载的字节数
+	FilesDeleted     int    `json:"filesDeleted"`              // 删除的本地文件数
+	FilesRenamed     int    `json:"filesRenamed,omitempty"`    // 通过本地改名代替下载的文件数
+	FilesCopied      int    `json:"filesCopied,omitempty"`     // 从内容相同的本地文件复制代替下载的文件数
+	BytesCopied      int64  `json:"bytesCopied,omitempty"`     // 从本地文件复制代替下载的字节数
+	MetadataUpdated  int    `json:"metadataUpdated,omitempty"` // 仅更新属性的路径数
+	SkippedPaths     int    `json:"skippedPaths"`              // 远程因访问错误跳过的路径数
+	FilesLocked      int    `json:"filesLocked,omitempty"`     // 因被占用而跳过的文件数
+	Passes           int    `json:"passes,omitempty"`          // 重复同步直到稳定时执行的轮数
+	ClockSkew        int64  `json:"clockSkew,omitempty"`       // 同步开始时估算的服务器时钟偏差（毫秒，服务器减本地）
+	Volume           string `json:"volume,omitempty"`          // 本地目录所在文件系统的标识，见 utils.VolumeID
+	Error            string `json:"error,omitempty"`
+}
+
+// appendHistory 以 JSON Lines 格式将本次同步的汇总追加到历史文件
+func appendHistory(path string, summary RunSummary) error {
+	data, err := json.Marshal(&summary)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
+
+// LoadHistory 读取历史文件中的所有同步汇总，按时间先后排列
+func LoadHistory(path string) ([]RunSummary, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history: %v", err)
+	}
+	defer file.Close()
+
+	var runs []RunSummary
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var run RunSummary
+		if err := json.Unmarshal(scanner.Bytes(), &run); err != nil {
+			// 写入中途崩溃可能留下不完整的行
+			fmt.Printf("Ignoring invalid history line %d: %v\n", line, err)
+			continue
+		}
+		runs = append(runs, run)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history: %v", err)
+	}
+
+	return runs, nil
+}
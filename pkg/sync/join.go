@@ -0,0 +1,119 @@
+package sync
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"gorsync/pkg/net"
+	"gorsync/pkg/utils"
+)
+
+// listingJoin 远程和本地文件列表按相对路径的对应关系
+type listingJoin struct {
+	remote   []net.FileInfo
+	local    []net.FileInfo
+	localOf  []int // 每个远程文件对应的本地文件下标，本地不存在时为 -1
+	remoteOf []int // 每个本地文件对应的远程文件下标，远程不存在时为 -1
+}
+
+// defaultFoldCase 无法检测本地文件系统时按操作系统的默认格式判断是否不区分文件名大小写
+var defaultFoldCase = runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+
+// foldCase 返回本地目录所在的文件系统是否不区分文件名大小写，每个同步器只检测一次。
+// 目录尚不存在时检测最近的已存在的上级目录，无法创建检测文件（例如只读）时使用 defaultFoldCase
+func (s *Syncer) foldCase() bool {
+	s.foldOnce.Do(func() {
+		s.fold = defaultFoldCase
+		dir := s.localPath
+		for {
+			if info, err := os.Stat(dir); err == nil && info.IsDir() {
+				break
+			}
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				return
+			}
+			dir = parent
+		}
+		if insensitive, err := utils.CaseInsensitiveDir(dir); err == nil {
+			s.fold = insensitive
+		}
+	})
+	return s.fold
+}
+
+// pathKey 返回用于配对的规范化路径（协议路径已统一使用 /），fold 表示本地文件系统不区分大小写，此时转换为小写，
+// 使远程的 A.txt 与本地的 a.txt 被视为同一个文件，而不是下载一个再删除另一个
+func pathKey(relPath string, fold bool) string {
+	if fold {
+		return strings.ToLower(relPath)
+	}
+	return relPath
+}
+
+// joinListings 把两端的列表分别按规范化路径排序后归并配对，比较的复杂度为 O(n log n)。
+// 排序的只是下标，不复制文件信息
+func joinListings(remoteFiles, localFiles []net.FileInfo, fold bool) *listingJoin {
+	j := &listingJoin{
+		remote:   remoteFiles,
+		local:    localFiles,
+		localOf:  make([]int, len(remoteFiles)),
+		remoteOf: make([]int, len(localFiles)),
+	}
+	for i := range j.localOf {
+		j.localOf[i] = -1
+	}
+	for i := range j.remoteOf {
+		j.remoteOf[i] = -1
+	}
+
+	remoteKeys, remoteOrder := sortByPath(remoteFiles, fold)
+	localKeys, localOrder := sortByPath(localFiles, fold)
+	for r, l := 0, 0; r < len(remoteOrder) && l < len(localOrder); {
+		remoteKey := remoteKeys[remoteOrder[r]]
+		localKey := localKeys[localOrder[l]]
+		switch {
+		case remoteKey < localKey:
+			r++
+		case remoteKey > localKey:
+			l++
+		default:
+			j.localOf[remoteOrder[r]] = localOrder[l]
+			j.remoteOf[localOrder[l]] = remoteOrder[r]
+			r++
+			l++
+		}
+	}
+
+	return j
+}
+
+// localFile 返回第 i 个远程文件对应的本地文件，本地不存在时返回 nil
+func (j *listingJoin) localFile(i int) *net.FileInfo {
+	if l := j.localOf[i]; l >= 0 {
+		return &j.local[l]
+	}
+	return nil
+}
+
+// hasRemote 检查第 i 个本地文件在远程是否存在
+func (j *listingJoin) hasRemote(i int) bool {
+	return j.remoteOf[i] >= 0
+}
+
+// sortByPath 返回每个文件的规范化路径，以及按规范化路径排序的文件下标
+func sortByPath(files []net.FileInfo, fold bool) ([]string, []int) {
+	keys := make([]string, len(files))
+	order := make([]int, len(files))
+	for i := range files {
+		keys[i] = pathKey(files[i].Path, fold)
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return keys[order[a]] < keys[order[b]]
+	})
+	return keys, order
+}
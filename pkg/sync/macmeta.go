@@ -0,0 +1,114 @@
+package sync
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+
+	"gorsync/pkg/net"
+	"gorsync/pkg/utils"
+)
+
+// isMacMetadata 检查路径是否为 macOS 在不支持扩展属性的文件系统上留下的元数据文件：
+// 保存资源分支和 Finder 信息的 AppleDouble 文件 ._name，以及 Finder 的 .DS_Store
+func isMacMetadata(p string) bool {
+	name := path.Base(p)
+	return strings.HasPrefix(name, "._") || name == ".DS_Store"
+}
+
+// stripMacMetadata 从远程列表中去掉 macOS 元数据文件，它们不会被下载，本地已有的会作为多余文件删除
+func (s *Syncer) stripMacMetadata(remoteFiles []net.FileInfo) []net.FileInfo {
+	files := remoteFiles[:0]
+	stripped := 0
+	for _, f := range remoteFiles {
+		if !f.IsDir && isMacMetadata(f.Path) {
+			stripped++
+			continue
+		}
+		f.Xattrs = nil
+		files = append(files, f)
+	}
+	if stripped > 0 {
+		s.printf("Stripped %d macOS metadata file(s)\n", stripped)
+	}
+	return files
+}
+
+// restoreXattrs 把远程的 macOS 扩展属性应用到本地已有的路径，在所有文件操作完成后调用
+func (s *Syncer) restoreXattrs(remoteFiles []net.FileInfo) {
+	for _, remoteFile := range remoteFiles {
+		if len(remoteFile.Xattrs) == 0 {
+			continue
+		}
+		localPath := net.LocalPath(s.localPath, remoteFile.Path)
+		info, err := os.Lstat(localPath)
+		if err != nil || info.IsDir() != remoteFile.IsDir {
+			continue
+		}
+		s.applyXattrs(localPath, remoteFile)
+	}
+}
+
+// applyXattrs 写入远程随列表发送了内容的扩展属性，内容相同的不重写，返回是否有修改。
+// 写入资源分支可能改变文件的修改时间，完成后恢复为远程的修改时间
+func (s *Syncer) applyXattrs(localPath string, remoteFile net.FileInfo) bool {
+	if runtime.GOOS != "darwin" {
+		return false
+	}
+
+	changed := false
+	for _, xattr := range remoteFile.Xattrs {
+		if !streamInline(xattr) {
+			continue
+		}
+		if data, err := utils.GetXattr(localPath, xattr.Name); err == nil && bytes.Equal(data, xattr.Data) {
+			continue
+		}
+		if err := utils.SetXattr(localPath, xattr.Name, xattr.Data); err != nil {
+			s.printf("failed to set extended attribute: %s %s: %v\n", remoteFile.Path, xattr.Name, err)
+			continue
+		}
+		s.printf("Xattr: %s %s (%s)\n", remoteFile.Path, xattr.Name, utils.FormatSize(xattr.Size))
+		changed = true
+	}
+
+	if changed {
+		modTime := remoteFile.Modified()
+		if err := os.Chtimes(localPath, modTime, modTime); err != nil {
+			s.printf("failed to set mtime: %s: %v\n", remoteFile.Path, err)
+		}
+	}
+	return changed
+}
+
+// reportXattrs 列出远程有而本次没有同步的扩展属性，未启用 --mac-xattrs 或使用 --strip-mac-metadata 时不报告
+func (s *Syncer) reportXattrs(remoteFiles []net.FileInfo) {
+	if !s.opts.MacXattrs {
+		return
+	}
+
+	var missed []string
+	for _, remoteFile := range remoteFiles {
+		for _, xattr := range remoteFile.Xattrs {
+			if runtime.GOOS == "darwin" && streamInline(xattr) {
+				continue
+			}
+			missed = append(missed, fmt.Sprintf("%s %s (%s)", remoteFile.Path, xattr.Name, utils.FormatSize(xattr.Size)))
+		}
+	}
+	if len(missed) == 0 {
+		return
+	}
+
+	if runtime.GOOS != "darwin" {
+		s.printf("Extended attributes not transferred (only supported on macOS, use --strip-mac-metadata):\n")
+	} else {
+		s.printf("Extended attributes not transferred (larger than %s):\n", utils.FormatSize(net.MaxInlineXattr))
+	}
+	for _, xattr := range missed {
+		s.printf("  %s\n", xattr)
+	}
+}
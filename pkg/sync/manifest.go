@@ -0,0 +1,223 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"gorsync/pkg/net"
+	"gorsync/pkg/utils"
+)
+
+// 清单校验结果
+const (
+	ScrubOK        = "ok"        // 内容与清单一致
+	ScrubModified  = "modified"  // 大小或修改时间已变化，视为正常修改
+	ScrubCorrupted = "corrupted" // 大小和修改时间未变但内容不同，疑似位衰减
+	ScrubMissing   = "missing"   // 文件已不存在
+	ScrubError     = "error"     // 无法读取文件
+)
+
+// manifest 同步完成后本地文件的状态记录
+type manifest struct {
+	Created int64                `json:"created"`
+	Root    string               `json:"root,omitempty"` // 生成清单的目录，由 BuildManifest 记录，服务器据此定位文件
+	Files   map[string]fileState `json:"files"`
+}
+
+// ScrubResult 单个文件的清单校验结果
+type ScrubResult struct {
+	Path    string
+	Status  string
+	Message string
+}
+
+// writeManifest 根据远程文件列表记录本地文件的大小、修改时间和MD5。
+// 只同步部分子目录或匹配的文件时保留已有清单中其他路径的记录
+func (s *Syncer) writeManifest(remoteFiles []net.FileInfo) error {
+	m := manifest{
+		Created: time.Now().Unix(),
+		Files:   make(map[string]fileState),
+	}
+	if len(s.opts.Subdirs) > 0 || s.glob != "" {
+		var previous manifest
+		if data, err := os.ReadFile(s.opts.Manifest); err == nil && json.Unmarshal(data, &previous) == nil {
+			for relPath, state := range previous.Files {
+				if !inSubdirs(s.opts.Subdirs, relPath) || !inGlob(s.glob, relPath) {
+					m.Files[relPath] = state
+				}
+			}
+		}
+	}
+
+	for _, remoteFile := range remoteFiles {
+		if remoteFile.IsDir || remoteFile.MD5 == "" {
+			continue
+		}
+
+		info, err := os.Stat(net.LocalPath(s.localPath, remoteFile.Path))
+		if err != nil || info.Size() != remoteFile.Size {
+			continue
+		}
+
+		m.Files[remoteFile.Path] = fileState{
+			Size:    info.Size(),
+			ModTime: info.ModTime().UnixNano(),
+			MD5:     remoteFile.MD5,
+		}
+	}
+
+	if err := saveManifest(s.opts.Manifest, &m); err != nil {
+		return err
+	}
+
+	s.printf("Manifest written: %s (%d files)\n", s.opts.Manifest, len(m.Files))
+	return nil
+}
+
+// saveManifest 通过临时文件原子地写入清单
+func saveManifest(path string, m *manifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	tempPath := utils.MakeTempName(path)
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return err
+	}
+	if err := utils.Saferename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return nil
+}
+
+// BuildManifest 离线计算 root 下所有文件的MD5并写入清单，返回记录的文件数。
+// 服务器通过 LoadSeedHashes 读取后，列表请求不必再实时计算这些文件的哈希
+func BuildManifest(root, manifestPath string) (int, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return 0, err
+	}
+	manifestAbs, err := filepath.Abs(manifestPath)
+	if err != nil {
+		return 0, err
+	}
+
+	m := manifest{
+		Created: time.Now().Unix(),
+		Root:    root,
+		Files:   make(map[string]fileState),
+	}
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || path == manifestAbs {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		md5, err := utils.CalculateMD5(path)
+		if err != nil {
+			return err
+		}
+		m.Files[net.WirePath(relPath)] = fileState{
+			Size:    info.Size(),
+			ModTime: info.ModTime().UnixNano(),
+			MD5:     md5,
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to hash %s: %v", root, err)
+	}
+
+	if err := saveManifest(manifestPath, &m); err != nil {
+		return 0, fmt.Errorf("failed to write manifest: %v", err)
+	}
+	return len(m.Files), nil
+}
+
+// LoadSeedHashes 读取 BuildManifest 生成的清单，返回以服务器上完整路径为键的预计算哈希
+func LoadSeedHashes(manifestPath string) (map[string]net.SeedHash, error) {
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %v", err)
+	}
+
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %v", err)
+	}
+	if m.Root == "" {
+		return nil, fmt.Errorf("manifest %s does not record its root directory, generate it with 'gorsync manifest'", manifestPath)
+	}
+
+	hashes := make(map[string]net.SeedHash, len(m.Files))
+	for relPath, entry := range m.Files {
+		hashes[net.LocalPath(m.Root, relPath)] = net.SeedHash{
+			Size:    entry.Size,
+			ModTime: entry.ModTime,
+			MD5:     entry.MD5,
+		}
+	}
+	return hashes, nil
+}
+
+// Scrub 重新计算 root 下文件的MD5并与清单比较，找出修改时间未变但内容变化的文件
+func Scrub(root, manifestPath string) ([]ScrubResult, error) {
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %v", err)
+	}
+
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %v", err)
+	}
+
+	paths := make([]string, 0, len(m.Files))
+	for relPath := range m.Files {
+		paths = append(paths, relPath)
+	}
+	sort.Strings(paths)
+
+	results := make([]ScrubResult, 0, len(paths))
+	for _, relPath := range paths {
+		entry := m.Files[relPath]
+		result := ScrubResult{Path: relPath, Status: ScrubOK}
+
+		localPath := net.LocalPath(root, relPath)
+		info, err := os.Stat(localPath)
+		switch {
+		case os.IsNotExist(err):
+			result.Status = ScrubMissing
+		case err != nil:
+			result.Status = ScrubError
+			result.Message = err.Error()
+		case info.Size() != entry.Size || info.ModTime().UnixNano() != entry.ModTime:
+			result.Status = ScrubModified
+		default:
+			md5, err := utils.CalculateMD5(localPath)
+			if err != nil {
+				result.Status = ScrubError
+				result.Message = err.Error()
+			} else if md5 != entry.MD5 {
+				result.Status = ScrubCorrupted
+				result.Message = fmt.Sprintf("manifest MD5 %s, on-disk MD5 %s", entry.MD5, md5)
+			}
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,52 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// MarkerFile 同步目标的标记文件，位于同步根目录下。启用 RequireMarker 时本地目录必须包含这个文件才会同步，
+// 防止 --path 写错（例如 /）或备份盘未挂载时在空的挂载点上大量删除或重新下载文件
+const MarkerFile = ".gorsync-dest"
+
+// ErrNoMarker 启用 RequireMarker 时本地目录中没有标记文件，同步没有修改任何文件
+var ErrNoMarker = errors.New("destination marker not found")
+
+// markerPath 返回标记文件的路径
+func (s *Syncer) markerPath() string {
+	return filepath.Join(s.localPath, MarkerFile)
+}
+
+// checkMarker 启用 RequireMarker 时确认本地目录包含标记文件，在创建本地目录和列出文件之前调用
+func (s *Syncer) checkMarker() error {
+	if !s.opts.RequireMarker {
+		return nil
+	}
+	info, err := os.Stat(s.markerPath())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to check destination marker: %w", err)
+	}
+	if err != nil || !info.Mode().IsRegular() {
+		return fmt.Errorf("%w: %s, create it with --init-marker once this is confirmed to be the right destination", ErrNoMarker, s.markerPath())
+	}
+	return nil
+}
+
+// CreateMarker 在 dir 中创建标记文件，将其确认为同步目标，已存在时不修改
+func CreateMarker(dir string) error {
+	f, err := os.OpenFile(filepath.Join(dir, MarkerFile), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "gorsync destination, marked %s\n", time.Now().UTC().Format(time.RFC3339)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
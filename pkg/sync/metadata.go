@@ -0,0 +1,319 @@
+package sync
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"gorsync/pkg/net"
+	"gorsync/pkg/utils"
+)
+
+// 同步开始时检查时钟偏差的超时时间和报告偏差的阈值
+const (
+	clockSkewTimeout = 5 * time.Second
+	clockSkewWarn    = 2 * time.Second
+)
+
+// syncMetadata 只比较并应用权限、修改时间以及（可选）属主，不传输任何文件内容
+func (s *Syncer) syncMetadata(remoteFiles []net.FileInfo) error {
+	// 先处理文件再处理目录，避免修改文件后目录的修改时间被改变
+	for _, wantDir := range []bool{false, true} {
+		for _, remoteFile := range remoteFiles {
+			if remoteFile.IsDir != wantDir || remoteFile.Path == "." {
+				continue
+			}
+
+			localPath := net.LocalPath(s.localPath, remoteFile.Path)
+			info, err := os.Lstat(localPath)
+			if err != nil {
+				if !os.IsNotExist(err) {
+					s.printf("failed to stat: %s: %v\n", remoteFile.Path, err)
+				}
+				continue
+			}
+			if info.IsDir() != remoteFile.IsDir {
+				s.printf("Skipping %s: file type differs from remote\n", remoteFile.Path)
+				continue
+			}
+
+			if s.applyMetadata(localPath, info, remoteFile) {
+				s.mutex.Lock()
+				s.summary.MetadataUpdated++
+				s.mutex.Unlock()
+			}
+		}
+	}
+
+	s.printf("Metadata updated for %d path(s)\n", s.summary.MetadataUpdated)
+	s.reportNames()
+	s.reportStreams(remoteFiles)
+	s.reportXattrs(remoteFiles)
+	return nil
+}
+
+// updateMetadata 执行 ActionMetadata、ActionPerms 和 ActionTouch：内容与远程相同的文件只更新权限和修改时间，不重新下载
+func (s *Syncer) updateMetadata(action Action) {
+	localPath := net.LocalPath(s.localPath, action.Path)
+	info, err := os.Lstat(localPath)
+	if err != nil {
+		s.printf("failed to stat: %s: %v\n", action.Path, err)
+		return
+	}
+	if s.applyMetadata(localPath, info, action.File) {
+		s.mutex.Lock()
+		s.summary.MetadataUpdated++
+		s.mutex.Unlock()
+	}
+	if s.checkpoint != nil && action.Local.MD5 != "" {
+		s.mutex.Lock()
+		s.checkpoint.record(action.Path, localPath, action.Local.MD5)
+		s.mutex.Unlock()
+	}
+}
+
+// setModTime 下载完成后把文件的修改时间设为远程的修改时间，下次同步时不会被当作元数据不同
+func (s *Syncer) setModTime(localPath string, remoteFile net.FileInfo) {
+	if remoteFile.ModTime == 0 {
+		return
+	}
+	modTime := remoteFile.Modified()
+	if err := os.Chtimes(localPath, modTime, modTime); err != nil {
+		s.printf("failed to set mtime: %s: %v\n", remoteFile.Path, err)
+	}
+}
+
+// sameModTime 比较本地修改时间和远程文件的修改时间，相差不超过 ModifyWindow 时视为相同
+func (s *Syncer) sameModTime(local time.Time, remoteFile net.FileInfo) bool {
+	return net.SameModTime(local, remoteFile.Modified(), s.opts.ModifyWindow)
+}
+
+// checkClockSkew 在同步开始时估算与服务器的时钟偏差，偏差较大时提醒用户，
+// 不支持 ping 或不报告时间的旧版本服务器不检查
+func (s *Syncer) checkClockSkew(client *net.Client) {
+	skew, err := client.ClockSkew(clockSkewTimeout)
+	if err != nil {
+		return
+	}
+	s.mutex.Lock()
+	s.summary.ClockSkew = skew.Milliseconds()
+	s.mutex.Unlock()
+	if skew.Abs() <= max(clockSkewWarn, s.opts.ModifyWindow) {
+		return
+	}
+	s.printf("Warning: clock of %s differs from the local clock by %s; modification times are copied from the server, "+
+		"but files written on either side during the sync will look out of date. Check time synchronization on both hosts\n",
+		s.peer(), skew.Round(time.Second))
+}
+
+// applyMetadata 将远程的权限、修改时间、属主和（启用时）Windows 属性、macOS 扩展属性应用到本地路径，返回是否有修改
+func (s *Syncer) applyMetadata(localPath string, info os.FileInfo, remoteFile net.FileInfo) bool {
+	changed := false
+
+	if s.opts.Owner && remoteFile.Owner != nil {
+		uid, gid, ok := utils.FileOwner(info)
+		if ok && (uid != remoteFile.Owner.Uid || gid != remoteFile.Owner.Gid) {
+			if err := os.Lchown(localPath, remoteFile.Owner.Uid, remoteFile.Owner.Gid); err != nil {
+				s.printf("failed to set owner: %s: %v\n", remoteFile.Path, err)
+			} else {
+				s.printf("Owner: %s %d:%d -> %d:%d\n", remoteFile.Path, uid, gid, remoteFile.Owner.Uid, remoteFile.Owner.Gid)
+				changed = true
+			}
+		}
+	}
+
+	// 没有 --perms-special 时远程的 setuid 和 setgid 不生效，本地多出的也会被去掉
+	mode := net.FileMode(remoteFile.Mode, s.opts.PermsSpecial)
+	if !s.opts.NoPerms && info.Mode()&net.ModeBits != mode {
+		if err := os.Chmod(localPath, mode); err != nil {
+			s.printf("failed to set mode: %s: %v\n", remoteFile.Path, err)
+		} else {
+			s.printf("Mode: %s %s -> %s\n", remoteFile.Path, info.Mode()&net.ModeBits, mode)
+			changed = true
+		}
+	}
+
+	if !s.sameModTime(info.ModTime(), remoteFile) {
+		modTime := remoteFile.Modified()
+		if err := os.Chtimes(localPath, modTime, modTime); err != nil {
+			s.printf("failed to set mtime: %s: %v\n", remoteFile.Path, err)
+		} else {
+			s.printf("Mtime: %s %s -> %s\n", remoteFile.Path,
+				info.ModTime().Format(time.DateTime), modTime.Format(time.DateTime))
+			changed = true
+		}
+	}
+
+	if (s.opts.WindowsAttrs || s.opts.WindowsStreams) && s.applyWindowsMeta(localPath, info, remoteFile) {
+		changed = true
+	}
+	if s.opts.MacXattrs && s.applyXattrs(localPath, remoteFile) {
+		changed = true
+	}
+
+	return changed
+}
+
+// restoreWindowsMeta 把远程的 Windows 属性、安全描述符和备用数据流应用到本地已有的路径，
+// 在所有文件操作完成后调用，内容未变的文件属性也会同步
+func (s *Syncer) restoreWindowsMeta(remoteFiles []net.FileInfo) {
+	for _, remoteFile := range remoteFiles {
+		if remoteFile.Windows == nil {
+			continue
+		}
+		localPath := net.LocalPath(s.localPath, remoteFile.Path)
+		info, err := os.Lstat(localPath)
+		if err != nil || info.IsDir() != remoteFile.IsDir {
+			continue
+		}
+		s.applyWindowsMeta(localPath, info, remoteFile)
+	}
+}
+
+// applyWindowsMeta 将远程的备用数据流、只读、隐藏、系统属性和安全描述符中启用的部分应用到本地路径，返回是否有修改。
+// 远程或本地不是 Windows 时不做任何事
+func (s *Syncer) applyWindowsMeta(localPath string, info os.FileInfo, remoteFile net.FileInfo) bool {
+	if remoteFile.Windows == nil {
+		return false
+	}
+	attrs, ok := utils.FileAttributes(info)
+	if !ok {
+		return false
+	}
+	changed := false
+
+	// 只读属性会阻止写入数据流，最先写入数据流
+	if s.opts.WindowsStreams && s.applyStreams(localPath, attrs, remoteFile) {
+		changed = true
+	}
+
+	// 先设置属性：应用的 DACL 可能不再允许修改属性
+	if s.opts.WindowsAttrs && attrs != remoteFile.Windows.Attributes {
+		if err := utils.SetFileAttributes(localPath, remoteFile.Windows.Attributes); err != nil {
+			s.printf("failed to set attributes: %s: %v\n", remoteFile.Path, err)
+		} else {
+			s.printf("Attributes: %s %s -> %s\n", remoteFile.Path, formatAttributes(attrs), formatAttributes(remoteFile.Windows.Attributes))
+			changed = true
+		}
+	}
+
+	if s.opts.WindowsACL && remoteFile.Windows.Security != "" {
+		if sddl, err := utils.FileSecurity(localPath); err != nil || sddl != remoteFile.Windows.Security {
+			if err := utils.SetFileSecurity(localPath, remoteFile.Windows.Security); err != nil {
+				s.printf("failed to set security descriptor: %s: %v\n", remoteFile.Path, err)
+			} else {
+				s.printf("Security: %s %s\n", remoteFile.Path, remoteFile.Windows.Security)
+				changed = true
+			}
+		}
+	}
+
+	return changed
+}
+
+// applyStreams 写入远程随列表发送了内容的备用数据流，内容相同的流不重写，返回是否有修改。
+// 写入数据流会改变文件的修改时间，完成后恢复为远程的修改时间
+func (s *Syncer) applyStreams(localPath string, attrs uint32, remoteFile net.FileInfo) bool {
+	var pending []net.StreamInfo
+	for _, stream := range remoteFile.Windows.Streams {
+		if !streamInline(stream) {
+			continue
+		}
+		if data, err := utils.ReadStream(localPath, stream.Name); err == nil && bytes.Equal(data, stream.Data) {
+			continue
+		}
+		pending = append(pending, stream)
+	}
+	if len(pending) == 0 {
+		return false
+	}
+
+	if attrs&utils.FileAttributeReadonly != 0 {
+		s.clearReadonly(localPath)
+		defer utils.SetFileAttributes(localPath, attrs)
+	}
+
+	changed := false
+	for _, stream := range pending {
+		if err := utils.WriteStream(localPath, stream.Name, stream.Data); err != nil {
+			s.printf("failed to write alternate data stream: %s:%s: %v\n", remoteFile.Path, stream.Name, err)
+			continue
+		}
+		s.printf("Stream: %s:%s (%s)\n", remoteFile.Path, stream.Name, utils.FormatSize(stream.Size))
+		changed = true
+	}
+
+	if changed {
+		modTime := remoteFile.Modified()
+		if err := os.Chtimes(localPath, modTime, modTime); err != nil {
+			s.printf("failed to set mtime: %s: %v\n", remoteFile.Path, err)
+		}
+	}
+	return changed
+}
+
+// streamInline 检查列表中是否带有数据流的内容，空的流不带内容
+func streamInline(stream net.StreamInfo) bool {
+	return stream.Size == 0 || stream.Data != nil
+}
+
+// reportStreams 列出远程有而本次没有同步的备用数据流，避免它们被悄悄丢弃
+func (s *Syncer) reportStreams(remoteFiles []net.FileInfo) {
+	var missed []string
+	for _, remoteFile := range remoteFiles {
+		if remoteFile.Windows == nil {
+			continue
+		}
+		for _, stream := range remoteFile.Windows.Streams {
+			if s.opts.WindowsStreams && runtime.GOOS == "windows" && streamInline(stream) {
+				continue
+			}
+			missed = append(missed, fmt.Sprintf("%s:%s (%s)", remoteFile.Path, stream.Name, utils.FormatSize(stream.Size)))
+		}
+	}
+	if len(missed) == 0 {
+		return
+	}
+
+	switch {
+	case runtime.GOOS != "windows":
+		s.printf("Alternate data streams not transferred (local file system does not support them):\n")
+	case !s.opts.WindowsStreams:
+		s.printf("Alternate data streams not transferred (use --win-streams):\n")
+	default:
+		s.printf("Alternate data streams not transferred (larger than %s):\n", utils.FormatSize(net.MaxInlineStream))
+	}
+	for _, stream := range missed {
+		s.printf("  %s\n", stream)
+	}
+}
+
+// clearReadonly 去掉本地文件的只读属性，文件不存在或平台不支持时不做任何事
+func (s *Syncer) clearReadonly(localPath string) {
+	info, err := os.Lstat(localPath)
+	if err != nil {
+		return
+	}
+	if attrs, ok := utils.FileAttributes(info); ok && attrs&utils.FileAttributeReadonly != 0 {
+		if err := utils.SetFileAttributes(localPath, attrs&^utils.FileAttributeReadonly); err != nil {
+			s.printf("failed to clear read-only attribute: %s: %v\n", localPath, err)
+		}
+	}
+}
+
+// formatAttributes 以 RHS 字母形式显示属性，例如只读且隐藏为 RH-
+func formatAttributes(attrs uint32) string {
+	flags := []byte("---")
+	if attrs&utils.FileAttributeReadonly != 0 {
+		flags[0] = 'R'
+	}
+	if attrs&utils.FileAttributeHidden != 0 {
+		flags[1] = 'H'
+	}
+	if attrs&utils.FileAttributeSystem != 0 {
+		flags[2] = 'S'
+	}
+	return string(flags)
+}
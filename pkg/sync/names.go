@@ -0,0 +1,215 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gorsync/pkg/net"
+	"gorsync/pkg/utils"
+)
+
+// 文件名转换方案，把 Linux 上合法而 Windows、FAT 等目标上无法创建的文件名转换为可以保存的名称
+const (
+	NameMapNone       = ""           // 不转换
+	NameMapUnderscore = "underscore" // 换成 _，简单但不同的名称可能转换为同一个名称
+	NameMapUnicode    = "unicode"    // 换成 U+F000 起的私用区字符，与 Cygwin 和 WSL 的做法相同，Windows 上显示为相似的字符
+	NameMapPercent    = "percent"    // 换成 %XX 转义
+)
+
+// nameMapFile 记录转换过的文件名的文件，位于同步根目录下，推送时据此还原原来的名称
+const nameMapFile = ".gorsync-names.json"
+
+// illegalNameChars Windows 和 FAT 文件名中不允许的字符，控制字符也不允许
+const illegalNameChars = `"*/:<>?\|`
+
+// reservedNames 不能用作文件名（不论扩展名）的设备名
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// checkNameMapping 检查文件名转换方案
+func checkNameMapping(scheme string) error {
+	switch scheme {
+	case NameMapNone, NameMapUnderscore, NameMapUnicode, NameMapPercent:
+		return nil
+	default:
+		return fmt.Errorf("unknown name mapping: %s, expected underscore, unicode or percent", scheme)
+	}
+}
+
+// mapChar 按方案转换一个不能出现在文件名中的字符
+func mapChar(scheme string, r rune) string {
+	switch scheme {
+	case NameMapUnicode:
+		return string(0xF000 + r)
+	case NameMapPercent:
+		return fmt.Sprintf("%%%02X", r)
+	default:
+		return "_"
+	}
+}
+
+// mapName 转换一级文件名：非法字符和结尾的点、空格按方案替换，设备名后加 _
+func mapName(scheme, name string) string {
+	// 结尾的点和空格会被 Windows 去掉
+	trimmed := strings.TrimRight(name, ". ")
+	var b strings.Builder
+	for i, r := range name {
+		if r < 0x20 || strings.ContainsRune(illegalNameChars, r) || i >= len(trimmed) {
+			b.WriteString(mapChar(scheme, r))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	mapped := b.String()
+
+	base, _, _ := strings.Cut(mapped, ".")
+	if reservedNames[strings.ToUpper(base)] {
+		mapped = base + "_" + mapped[len(base):]
+	}
+	return mapped
+}
+
+// mapPath 逐级转换协议格式的相对路径
+func mapPath(scheme, relPath string) string {
+	elems := strings.Split(relPath, "/")
+	for i, elem := range elems {
+		if elem != "." {
+			elems[i] = mapName(scheme, elem)
+		}
+	}
+	return path.Join(elems...)
+}
+
+// nameMapping 是否需要调整远程列表中的名称
+func (s *Syncer) nameMapping() bool {
+	return s.opts.NameMapping != NameMapNone || s.fatTarget()
+}
+
+// adaptNames 按目标的限制调整远程列表：转换无法保存的文件名，跳过目标文件系统不支持的文件、
+// 转换后或忽略大小写后与其他路径冲突的路径。跳过的路径加入 skipped，本地已有的同名文件不会被当作多余文件删除。
+// 转换过的名称记录在 remoteNames 中，下载时使用原来的名称
+func (s *Syncer) adaptNames(remoteFiles []net.FileInfo) []net.FileInfo {
+	s.remoteNames = make(map[string]string)
+	seen := make(map[string]string)      // 不区分大小写的本地路径 -> 远程路径
+	skippedDirs := make(map[string]bool) // 被跳过的远程目录，其中的路径一并跳过
+	skip := func(f net.FileInfo, mapped, reason string) {
+		s.printf("Skipping %s: %s\n", f.Path, reason)
+		s.skipped = append(s.skipped, net.SkippedPath{Path: mapped, Error: reason})
+		if f.IsDir {
+			skippedDirs[f.Path] = true
+		}
+	}
+
+	var adapted []net.FileInfo
+next:
+	for _, f := range remoteFiles {
+		for dir := path.Dir(f.Path); dir != "."; dir = path.Dir(dir) {
+			if skippedDirs[dir] {
+				continue next
+			}
+		}
+
+		// 父目录被转换时路径中的每一级都要转换
+		mapped := mapPath(s.opts.NameMapping, f.Path)
+		if reason := s.targetUnsupported(f); reason != "" {
+			skip(f, mapped, reason)
+			continue
+		}
+		key := strings.ToLower(mapped)
+		if other, ok := seen[key]; ok {
+			skip(f, mapped, fmt.Sprintf("name collides with %s on a case-insensitive filesystem", other))
+			continue
+		}
+		seen[key] = f.Path
+
+		if mapped != f.Path {
+			s.remoteNames[mapped] = f.Path
+			f.Path = mapped
+		}
+		adapted = append(adapted, f)
+	}
+
+	if err := s.saveNameMap(); err != nil {
+		s.printf("Failed to save name map: %v\n", err)
+	}
+	return adapted
+}
+
+// remoteWire 返回本地相对路径对应的远程完整路径，转换过的名称使用原来的名称
+func (s *Syncer) remoteWire(relPath string) string {
+	if original, ok := s.remoteNames[relPath]; ok {
+		relPath = original
+	}
+	return net.JoinWire(s.remotePath, relPath)
+}
+
+// nameMapPath 返回文件名转换记录的路径
+func (s *Syncer) nameMapPath() string {
+	return filepath.Join(s.localPath, nameMapFile)
+}
+
+// saveNameMap 保存本次转换过的名称（本地相对路径 -> 远程相对路径），没有转换时删除记录
+func (s *Syncer) saveNameMap() error {
+	if s.opts.DryRun {
+		return nil
+	}
+	mapPath := s.nameMapPath()
+	if len(s.remoteNames) == 0 {
+		if err := os.Remove(mapPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.remoteNames, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tempPath := utils.MakeTempName(mapPath)
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return err
+	}
+	if err := utils.Saferename(tempPath, mapPath); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return nil
+}
+
+// loadNameMap 读取上次同步记录的转换过的名称，没有记录时返回空表
+func (s *Syncer) loadNameMap() map[string]string {
+	names := make(map[string]string)
+	if data, err := os.ReadFile(s.nameMapPath()); err == nil {
+		if err := json.Unmarshal(data, &names); err != nil {
+			s.printf("Ignoring invalid name map: %v\n", err)
+		}
+	}
+	return names
+}
+
+// reportNames 汇总本次同步中转换过的文件名，父目录被转换的路径不重复列出
+func (s *Syncer) reportNames() {
+	var local []string
+	for p, original := range s.remoteNames {
+		if path.Base(p) != path.Base(original) {
+			local = append(local, p)
+		}
+	}
+	if len(local) == 0 {
+		return
+	}
+	sort.Strings(local)
+	s.printf("Translated %d name(s) that cannot be stored on the destination (recorded in %s):\n", len(local), nameMapFile)
+	for _, p := range local {
+		s.printf("  %s -> %s\n", s.remoteNames[p], p)
+	}
+}
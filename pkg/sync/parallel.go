@@ -0,0 +1,154 @@
+package sync
+
+import (
+	"fmt"
+	"io"
+	stdsync "sync"
+	"time"
+
+	"gorsync/pkg/net"
+)
+
+// 自适应并发的调整周期和阈值
+const (
+	adjustInterval   = 2 * time.Second
+	throughputGain   = 1.10 // 总吞吐量至少提高 10% 才继续增加并发
+	throughputLoss   = 0.90 // 总吞吐量下降超过 10% 时减少并发
+	latencyThreshold = 3    // 连接延迟超过基准的倍数时认为服务器过载
+)
+
+// downloadPool 并行下载的工作池，自适应模式下根据吞吐量和延迟调整并发数
+type downloadPool struct {
+	client   *net.Client
+	max      int
+	adaptive bool
+	out      io.Writer // 自适应调整的输出
+
+	mutex  stdsync.Mutex
+	cond   *stdsync.Cond
+	limit  int // 当前允许的并发数
+	active int
+	err    error
+	wg     stdsync.WaitGroup
+
+	windowStart time.Time
+	windowBytes int64
+	windowTime  time.Duration // 本周期内各个下载耗时之和，用于计算单连接吞吐量
+	lastRate    float64       // 上一个周期的总吞吐量（字节/秒）
+	baseLatency time.Duration // 观察到的最小连接延迟
+	grew        bool          // 上一次调整是否增加了并发
+}
+
+// newDownloadPool 创建工作池，自适应模式从 1 个并发开始
+func newDownloadPool(client *net.Client, max int, adaptive bool, out io.Writer) *downloadPool {
+	p := &downloadPool{
+		client:      client,
+		max:         max,
+		adaptive:    adaptive,
+		out:         out,
+		limit:       max,
+		windowStart: time.Now(),
+	}
+	if adaptive {
+		p.limit = 1
+	}
+	p.cond = stdsync.NewCond(&p.mutex)
+	return p
+}
+
+// run 等待空闲的并发名额后在新的 goroutine 中执行下载，size 为文件大小
+func (p *downloadPool) run(size int64, download func() error) {
+	p.mutex.Lock()
+	for p.active >= p.limit {
+		p.cond.Wait()
+	}
+	p.active++
+	p.mutex.Unlock()
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		start := time.Now()
+		err := download()
+		p.finish(err, size, time.Since(start))
+	}()
+}
+
+// finish 记录一次下载的结果并释放并发名额
+func (p *downloadPool) finish(err error, size int64, elapsed time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.active--
+	if err != nil && p.err == nil {
+		p.err = err
+	}
+	if err == nil {
+		p.windowBytes += size
+		p.windowTime += elapsed
+	}
+	if p.adaptive {
+		p.adjust()
+	}
+	p.cond.Broadcast()
+}
+
+// failed 返回是否已有下载失败
+func (p *downloadPool) failed() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.err != nil
+}
+
+// wait 等待所有下载完成，返回第一个错误
+func (p *downloadPool) wait() error {
+	p.wg.Wait()
+	return p.err
+}
+
+// adjust 每个周期比较总吞吐量：增加并发带来明显提升时继续增加，吞吐量下降或
+// 连接延迟明显升高（服务器过载）时减少
+func (p *downloadPool) adjust() {
+	latency := p.client.DialLatency()
+	if latency > 0 && (p.baseLatency == 0 || latency < p.baseLatency) {
+		p.baseLatency = latency
+	}
+
+	elapsed := time.Since(p.windowStart)
+	if elapsed < adjustInterval {
+		return
+	}
+	rate := float64(p.windowBytes) / elapsed.Seconds()
+	connRate := float64(0)
+	if p.windowTime > 0 {
+		connRate = float64(p.windowBytes) / p.windowTime.Seconds()
+	}
+
+	previous := p.limit
+	switch {
+	case p.baseLatency > 0 && latency > latencyThreshold*p.baseLatency && p.limit > 1:
+		p.limit--
+		p.grew = false
+	case rate >= p.lastRate*throughputGain && p.limit < p.max:
+		p.limit++
+		p.grew = true
+	case rate < p.lastRate*throughputLoss && p.limit > 1:
+		p.limit--
+		p.grew = false
+	case p.grew && rate < p.lastRate*throughputGain && p.limit > 1:
+		// 上次增加并发没有带来明显提升，退回
+		p.limit--
+		p.grew = false
+	}
+	if p.limit != previous {
+		fmt.Fprintf(p.out, "Adaptive parallelism: %d -> %d (throughput %.2f MB/s, per connection %.2f MB/s, latency %s)\n",
+			previous, p.limit, rate/1024/1024, connRate/1024/1024, latency)
+	}
+
+	p.lastRate = rate
+	p.windowStart = time.Now()
+	p.windowBytes = 0
+	p.windowTime = 0
+}
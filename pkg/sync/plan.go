@@ -0,0 +1,460 @@
+package sync
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+
+	"gorsync/pkg/net"
+)
+
+// ActionType 同步计划中的操作类型
+type ActionType string
+
+const (
+	ActionMkdir    ActionType = "mkdir"    // 创建本地目录
+	ActionRename   ActionType = "rename"   // 远程改名的文件直接在本地改名，不重新下载
+	ActionDownload ActionType = "download" // 下载远程文件
+	ActionCopy     ActionType = "copy"     // 与本地已有或本次下载的另一个文件内容相同，从该文件复制而不是下载
+	ActionAppend   ActionType = "append"   // 远程文件在本地内容之后追加了数据，只下载尾部
+	ActionKeep     ActionType = "keep"     // 本地文件与远程相同，无需传输
+	ActionMetadata ActionType = "metadata" // 内容相同，权限和修改时间都不同，只更新元数据
+	ActionPerms    ActionType = "perms"    // 内容和修改时间相同，只更新权限
+	ActionTouch    ActionType = "touch"    // 内容和权限相同，只更新修改时间
+	ActionDelete   ActionType = "delete"   // 删除本地多余的文件或目录
+	ActionChmod    ActionType = "chmod"    // 所有文件操作完成后恢复目录的权限和修改时间
+)
+
+// 操作的原因，在计划和报告中说明为什么要修改这个路径
+const (
+	ReasonMissing = "missing"            // 本地不存在
+	ReasonContent = "content differs"    // 内容（大小或MD5）不同
+	ReasonPerms   = "permissions differ" // 只有权限不同
+	ReasonMtime   = "mtime differs"      // 只有修改时间不同
+	ReasonBoth    = "permissions and mtime differ"
+)
+
+// Action 同步计划中的单个操作
+type Action struct {
+	Type   ActionType
+	Path   string       // 相对于同步根目录的路径
+	Source string       // ActionRename 的本地原路径，ActionCopy 复制的来源
+	File   net.FileInfo // ActionDelete 为本地文件信息，其余为远程文件信息
+	Local  net.FileInfo // ActionAppend 的本地文件信息
+	// Unverified 大小相同但缺少MD5、无法比较内容的一端，见 missingChecksum，为空表示已比较内容或不需要比较
+	Unverified string
+	// Reason 下载和元数据操作的原因，见 ReasonMissing 等
+	Reason string
+}
+
+// String 返回操作的单行描述
+func (a Action) String() string {
+	if a.Type == ActionRename {
+		return fmt.Sprintf("%-8s %s -> %s", a.Type, a.Source, a.Path)
+	}
+	if a.Type == ActionCopy {
+		return fmt.Sprintf("%-8s %s <- %s", a.Type, a.Path, a.Source)
+	}
+	var notes []string
+	if a.Reason != "" {
+		notes = append(notes, a.Reason)
+	}
+	if a.Unverified != "" {
+		notes = append(notes, fmt.Sprintf("no %s MD5", a.Unverified))
+	}
+	if len(notes) > 0 {
+		return fmt.Sprintf("%-8s %s (%s)", a.Type, a.Path, strings.Join(notes, ", "))
+	}
+	return fmt.Sprintf("%-8s %s", a.Type, a.Path)
+}
+
+// Conflict 远程和本地都存在但内容不同的文件
+type Conflict struct {
+	Remote    net.FileInfo
+	Local     net.FileInfo
+	LocalPath string // 本地文件的完整路径，可用于读取本地内容
+}
+
+// ConflictResolver 决定冲突文件的处理方式：返回 ActionDownload 用远程文件覆盖本地文件，
+// 返回 ActionKeep 保留本地文件（例如解析器已将远程改动合并到本地文件）
+type ConflictResolver func(conflict Conflict) ActionType
+
+// Plan 同步计划，Actions 按执行顺序排列
+type Plan struct {
+	Actions []Action
+	Grace   map[string]int // 本次同步后多余文件已连续出现的次数，未启用删除宽限时为 nil
+}
+
+// Print 向 w 打印计划中会修改本地文件的操作，已是最新的文件只计数，
+// 但因缺少MD5只按大小判断为相同的文件也会列出
+func (p *Plan) Print(w io.Writer) {
+	var counts planCounts
+	counts.add(p, w)
+	counts.print(w)
+}
+
+// planCounts 各类操作的计数，分批同步时累计多个计划
+type planCounts struct {
+	types      map[ActionType]int
+	unverified int
+}
+
+// add 向 w 打印计划中会修改本地文件的操作并累计计数
+func (c *planCounts) add(p *Plan, w io.Writer) {
+	if c.types == nil {
+		c.types = make(map[ActionType]int)
+	}
+	for _, action := range p.Actions {
+		c.types[action.Type]++
+		if action.Unverified != "" {
+			c.unverified++
+		}
+		if action.Type != ActionKeep && action.Type != ActionChmod || action.Unverified != "" {
+			fmt.Fprintln(w, action)
+		}
+	}
+}
+
+// print 向 w 打印累计的计数
+func (c *planCounts) print(w io.Writer) {
+	counts, unverified := c.types, c.unverified
+	fmt.Fprintf(w, "Plan: %d to download, %d to copy locally, %d to append, %d to rename, %d to delete, %d directories to create, %d metadata updates (%d perms, %d touch), %d up to date\n",
+		counts[ActionDownload], counts[ActionCopy], counts[ActionAppend], counts[ActionRename], counts[ActionDelete], counts[ActionMkdir],
+		counts[ActionMetadata]+counts[ActionPerms]+counts[ActionTouch], counts[ActionPerms], counts[ActionTouch], counts[ActionKeep])
+	if unverified > 0 {
+		fmt.Fprintf(w, "Warning: %d file(s) of the same size could not be compared because an MD5 is missing\n", unverified)
+	}
+}
+
+// TransferSize 返回计划中需要下载的文件数和字节数，追加只计算新增的尾部
+func (p *Plan) TransferSize() (int, int64) {
+	files := 0
+	size := int64(0)
+	for _, action := range p.Actions {
+		switch action.Type {
+		case ActionDownload:
+			files++
+			size += action.File.Size
+		case ActionAppend:
+			files++
+			size += action.File.Size - action.Local.Size
+		}
+	}
+	return files, size
+}
+
+// Planner 比较远程和本地文件列表生成同步计划，不访问网络也不修改本地文件
+type Planner struct {
+	Options Options
+	Skipped []net.SkippedPath // 远程遍历时因访问错误被跳过的路径，其下的本地文件不删除
+	Grace   map[string]int    // 上次同步保存的多余文件计数，启用删除宽限时使用
+	Root    string            // 本地同步根目录，用于向冲突解析器提供本地文件路径
+	Glob    string            // 远程路径中的模式，只删除匹配的本地路径，不匹配的路径保留删除宽限计数
+	Fold    bool              // 本地文件系统不区分文件名大小写，按小写的路径配对，见 Syncer.foldCase
+
+	skippedKeys map[string]bool // Skipped 的规范化路径，首次使用时建立
+}
+
+// Plan 生成远程优先模式的同步计划：远程文件覆盖本地文件，按删除模式安排多余文件的删除
+func (p *Planner) Plan(remoteFiles, localFiles []net.FileInfo) *Plan {
+	plan := &Plan{}
+
+	// 按路径配对两端的文件
+	join := joinListings(remoteFiles, localFiles, p.Fold)
+
+	// 本地存在但远程不存在的文件
+	extraneous := p.findExtraneous(join)
+
+	// 远程改名的文件直接在本地改名，改名的源文件不再删除
+	renames := p.findRenames(join, extraneous)
+	renamed := make(map[string]bool)
+	if len(renames) > 0 {
+		sources := make(map[string]bool)
+		for i, action := range renames {
+			renamed[action.Path] = true
+			// 删除宽限期内源文件还不能删除，改为从源文件复制，源文件留在多余文件中继续计数
+			if p.Options.DeleteGrace > 0 && p.Grace[action.Source]+1 < p.Options.DeleteGrace {
+				renames[i].Type = ActionCopy
+				continue
+			}
+			sources[action.Source] = true
+		}
+		var remaining []net.FileInfo
+		for _, f := range extraneous {
+			if !sources[f.Path] {
+				remaining = append(remaining, f)
+			}
+		}
+		extraneous = remaining
+		plan.Actions = append(plan.Actions, renames...)
+	}
+
+	// 删除宽限：多余文件需连续多次出现才删除
+	if p.Options.DeleteGrace > 0 {
+		extraneous = p.applyDeleteGrace(plan, extraneous)
+	}
+
+	// 按所在目录分组
+	byDir := make(map[string][]net.FileInfo)
+	for _, f := range extraneous {
+		dir := path.Dir(f.Path)
+		byDir[dir] = append(byDir[dir], f)
+	}
+
+	// during 模式下先清理根目录，子目录在遍历到时清理
+	if p.Options.DeleteMode == DeleteDuring {
+		plan.addDeletes(byDir["."])
+		delete(byDir, ".")
+	}
+
+	// 记录包含文件的远程目录，用于跳过空目录
+	var nonEmpty map[string]bool
+	if p.Options.PruneEmptyDirs {
+		nonEmpty = p.findNonEmptyDirs(remoteFiles)
+	}
+
+	for i, remoteFile := range remoteFiles {
+		relPath := remoteFile.Path
+		if remoteFile.IsDir {
+			if nonEmpty != nil && !nonEmpty[relPath] {
+				p.printf("Skipping empty directory: %s\n", remoteFile.Path)
+			} else {
+				plan.Actions = append(plan.Actions, Action{Type: ActionMkdir, Path: relPath, File: remoteFile})
+			}
+
+			if p.Options.DeleteMode == DeleteDuring {
+				plan.addDeletes(byDir[relPath])
+				delete(byDir, relPath)
+			}
+			continue
+		}
+
+		if renamed[relPath] {
+			continue
+		}
+		localFile := join.localFile(i)
+		var unverified, reason string
+		var metadata ActionType
+		if localFile != nil {
+			unverified = missingChecksum(remoteFile, *localFile)
+			metadata, reason = p.metadataAction(remoteFile, *localFile)
+		}
+		switch {
+		case localFile == nil:
+			plan.Actions = append(plan.Actions, Action{Type: ActionDownload, Path: relPath, File: remoteFile, Reason: ReasonMissing})
+		case unverified != "" && p.Options.RequireChecksum:
+			// 无法确认内容相同时重新下载，服务器仍然无法计算MD5时下载失败
+			plan.Actions = append(plan.Actions, Action{Type: ActionDownload, Path: relPath, File: remoteFile, Unverified: unverified})
+		case !isFileDifferent(remoteFile, *localFile) && metadata != "":
+			plan.Actions = append(plan.Actions, Action{Type: metadata, Path: relPath, File: remoteFile, Local: *localFile, Unverified: unverified, Reason: reason})
+		case !isFileDifferent(remoteFile, *localFile) || p.resolve(remoteFile, *localFile) == ActionKeep:
+			plan.Actions = append(plan.Actions, Action{Type: ActionKeep, Path: relPath, File: *localFile, Unverified: unverified})
+		case p.canAppend(remoteFile, *localFile):
+			plan.Actions = append(plan.Actions, Action{Type: ActionAppend, Path: relPath, File: remoteFile, Local: *localFile})
+		default:
+			plan.Actions = append(plan.Actions, Action{Type: ActionDownload, Path: relPath, File: remoteFile, Reason: ReasonContent})
+		}
+	}
+
+	if !p.Options.NoDedup {
+		p.dedupDownloads(plan)
+	}
+
+	switch p.Options.DeleteMode {
+	case DeleteDelay, DeleteAfter:
+		// 传输完成后删除
+		plan.addDeletes(extraneous)
+	default:
+		// 删除父目录未出现在远程列表中的剩余文件
+		dirs := make([]string, 0, len(byDir))
+		for dir := range byDir {
+			dirs = append(dirs, dir)
+		}
+		sort.Strings(dirs)
+		for _, dir := range dirs {
+			plan.addDeletes(byDir[dir])
+		}
+	}
+
+	// 下载和删除会改变目录修改时间，最后再恢复目录属性
+	for _, remoteFile := range remoteFiles {
+		if remoteFile.IsDir {
+			plan.Actions = append(plan.Actions, Action{Type: ActionChmod, Path: remoteFile.Path, File: remoteFile})
+		}
+	}
+
+	return plan
+}
+
+// resolve 通过冲突解析器决定冲突文件的处理方式，未设置解析器时远程文件优先
+func (p *Planner) resolve(remoteFile, localFile net.FileInfo) ActionType {
+	if p.Options.Resolver == nil {
+		return ActionDownload
+	}
+
+	action := p.Options.Resolver(Conflict{
+		Remote:    remoteFile,
+		Local:     localFile,
+		LocalPath: net.LocalPath(p.Root, localFile.Path),
+	})
+	switch action {
+	case ActionDownload, ActionKeep:
+		return action
+	default:
+		p.printf("Conflict resolver returned unsupported action %q for %s, using remote file\n", action, remoteFile.Path)
+		return ActionDownload
+	}
+}
+
+// metadataAction 比较内容相同的普通文件的修改时间和权限，返回需要的操作和原因：只有修改时间不同时为 ActionTouch，
+// 只有权限不同时为 ActionPerms，都不同时为 ActionMetadata，都相同时为空。
+// Windows 上或设置了 NoPerms（包括 FAT 类目标）时只比较修改时间
+func (p *Planner) metadataAction(remoteFile, localFile net.FileInfo) (ActionType, string) {
+	if remoteFile.Type != "" || localFile.Type != "" {
+		return "", ""
+	}
+	mtime := !net.SameModTime(localFile.Modified(), remoteFile.Modified(), p.Options.ModifyWindow)
+	// 没有 --perms-special 时远程的 setuid 和 setgid 不生效，本地多出的也会被去掉
+	perms := runtime.GOOS != "windows" && !p.Options.NoPerms &&
+		net.FileMode(localFile.Mode, true) != net.FileMode(remoteFile.Mode, p.Options.PermsSpecial)
+	switch {
+	case mtime && perms:
+		return ActionMetadata, ReasonBoth
+	case mtime:
+		return ActionTouch, ReasonMtime
+	case perms:
+		return ActionPerms, ReasonPerms
+	}
+	return "", ""
+}
+
+// canAppend 追加模式下远程文件比本地文件大时尝试只下载尾部，执行时由服务器确认开头内容相同
+func (p *Planner) canAppend(remoteFile, localFile net.FileInfo) bool {
+	return p.Options.Append && !localFile.IsDir && localFile.MD5 != "" && remoteFile.TextMD5 == "" &&
+		localFile.Size > 0 && localFile.Size < remoteFile.Size
+}
+
+// addDeletes 添加删除本地文件的操作
+func (p *Plan) addDeletes(files []net.FileInfo) {
+	for _, f := range files {
+		p.Actions = append(p.Actions, Action{Type: ActionDelete, Path: f.Path, File: f})
+	}
+}
+
+// findNonEmptyDirs 查找（直接或间接）包含文件的远程目录
+func (p *Planner) findNonEmptyDirs(remoteFiles []net.FileInfo) map[string]bool {
+	nonEmpty := make(map[string]bool)
+	for _, f := range remoteFiles {
+		if f.IsDir {
+			continue
+		}
+		for dir := path.Dir(f.Path); !nonEmpty[dir]; dir = path.Dir(dir) {
+			nonEmpty[dir] = true
+			if dir == "." || dir == "/" {
+				break
+			}
+		}
+	}
+	return nonEmpty
+}
+
+// findExtraneous 查找本地存在但远程不存在的文件
+func (p *Planner) findExtraneous(join *listingJoin) []net.FileInfo {
+	var extraneous []net.FileInfo
+	for i, localFile := range join.local {
+		// 检查远程文件是否存在
+		relPath := localFile.Path
+		if p.isSkipped(relPath) {
+			// 远程路径无法访问，保留本地文件
+			continue
+		}
+		if !inGlob(p.Glob, relPath) {
+			// 只是匹配文件的上级目录，其中还有不匹配的文件，不能删除
+			continue
+		}
+		if !join.hasRemote(i) {
+			extraneous = append(extraneous, localFile)
+		}
+	}
+	return extraneous
+}
+
+// isSkipped 检查路径是否位于远程被跳过的路径之下，逐级检查上级目录而不是遍历所有被跳过的路径
+func (p *Planner) isSkipped(relPath string) bool {
+	if len(p.Skipped) == 0 {
+		return false
+	}
+	if p.skippedKeys == nil {
+		p.skippedKeys = make(map[string]bool, len(p.Skipped))
+		for _, s := range p.Skipped {
+			p.skippedKeys[pathKey(s.Path, p.Fold)] = true
+		}
+	}
+
+	for key := pathKey(relPath, p.Fold); key != "." && key != "/"; key = path.Dir(key) {
+		if p.skippedKeys[key] {
+			return true
+		}
+	}
+	return false
+}
+
+// printf 向 Options.Output 打印规划过程的信息
+func (p *Planner) printf(format string, args ...any) {
+	fmt.Fprintf(p.Options.output(), format, args...)
+}
+
+// 缺少MD5的一端，见 missingChecksum
+const (
+	missingRemote = "remote"
+	missingLocal  = "local"
+	missingBoth   = "both"
+)
+
+// missingChecksum 两个普通文件大小相同、需要比较内容但有一端缺少MD5（计算失败）时返回缺少的一端，
+// 此时 isFileDifferent 只能按大小判断为相同。两端都有文本模式的MD5时不需要原始内容的MD5
+func missingChecksum(remote, local net.FileInfo) string {
+	if remote.IsDir || local.IsDir || remote.Type != "" || local.Type != "" || remote.Size != local.Size {
+		return ""
+	}
+	if remote.TextMD5 != "" && local.TextMD5 != "" {
+		return ""
+	}
+	switch {
+	case remote.MD5 == "" && local.MD5 == "":
+		return missingBoth
+	case remote.MD5 == "":
+		return missingRemote
+	case local.MD5 == "":
+		return missingLocal
+	}
+	return ""
+}
+
+// isFileDifferent 检查文件是否不同，缺少MD5时只比较大小，见 missingChecksum
+func isFileDifferent(file1, file2 net.FileInfo) bool {
+	// 比较文件类型
+	if file1.IsDir != file2.IsDir {
+		return true
+	}
+
+	// 两端都是文本文件时只比较统一换行符后的内容
+	if file1.TextMD5 != "" && file2.TextMD5 != "" {
+		return file1.TextMD5 != file2.TextMD5
+	}
+
+	// 比较文件大小
+	if file1.Size != file2.Size {
+		return true
+	}
+
+	// 比较MD5值
+	if file1.MD5 != "" && file2.MD5 != "" && file1.MD5 != file2.MD5 {
+		return true
+	}
+
+	return false
+}
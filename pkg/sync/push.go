@@ -0,0 +1,119 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorsync/pkg/net"
+	"gorsync/pkg/utils"
+)
+
+// Push 把本地目录上传到服务器：与远程列表比较后，只上传大小或MD5不同的文件，
+// 每个文件按服务器上已有内容的块签名增量上传。不删除服务器上多余的文件，需要控制令牌。
+// atomic 为 true 时所有文件先暂存在服务器上，全部上传成功后才一起替换，失败时丢弃
+func (s *Syncer) Push(token string, atomic bool) (err error) {
+	s.printf("Starting push to %s\n", s.peer())
+	if s.glob != "" {
+		return fmt.Errorf("cannot push to a glob remote path: %s", s.remoteSpec())
+	}
+	s.printf("Local path: %s -> Remote path: %s\n", s.localPath, s.remotePath)
+	start := time.Now()
+
+	client, err := s.newClient()
+	if err != nil {
+		return err
+	}
+	defer client.CloseIdleConnections()
+	// 列表等请求也带上令牌：身份令牌使服务器把路径映射到与上传相同的目录，配置了客户端身份的服务器也不接受无令牌的请求
+	if s.opts.Identity == "" {
+		client.SetIdentity(token)
+	}
+	if err := s.checkServerVersion(client); err != nil {
+		return err
+	}
+
+	remoteFiles, _, err := client.ListFiles(s.remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to list remote files: %w", err)
+	}
+	localFiles, err := s.getLocalFiles(s.localPath)
+	if err != nil {
+		return fmt.Errorf("failed to list local files: %v", err)
+	}
+	if s.opts.StripMacMetadata {
+		localFiles = s.stripMacMetadata(localFiles)
+	}
+	// 下载时转换过的名称按记录还原为远程原来的名称，上传时仍读取本地的文件
+	names := s.loadNameMap()
+	localNames := make(map[string]string, len(names))
+	for i, f := range localFiles {
+		if original, ok := names[f.Path]; ok {
+			localNames[original] = f.Path
+			localFiles[i].Path = original
+		}
+	}
+
+	// 每次推送都是一个事务，提交时服务器运行 post-receive 钩子；atomic 时文件还要等到提交才替换
+	txn := utils.NewSessionID()
+	client.SetTransaction(txn, atomic)
+	s.printf("Transaction: %s\n", txn)
+	committed := false
+	defer func() {
+		if err != nil && !committed {
+			if abortErr := client.Abort(token); abortErr != nil {
+				s.printf("Failed to abort transaction: %v\n", abortErr)
+			}
+		}
+	}()
+
+	// 以本地为源配对，join 中的 remote 一侧是本地文件
+	join := joinListings(localFiles, remoteFiles, s.foldCase())
+	var uploaded, failed int
+	var sent, total int64
+	index := 1
+	for i, localFile := range localFiles {
+		if localFile.IsDir {
+			continue
+		}
+		if remoteFile := join.localFile(i); remoteFile != nil && !remoteFile.IsDir &&
+			remoteFile.Size == localFile.Size && remoteFile.MD5 == localFile.MD5 {
+			continue
+		}
+
+		localRel := localFile.Path
+		if p, ok := localNames[localRel]; ok {
+			localRel = p
+		}
+		n, err := client.UploadFile(token, net.LocalPath(s.localPath, localRel), net.JoinWire(s.remotePath, localFile.Path), index)
+		if err != nil {
+			// 服务器空间不足时没有写入目标文件，非原子推送只放弃这个文件，较小的文件仍可能放得下
+			if !atomic && errors.Is(err, net.ErrNoSpace) {
+				s.printf("%d. Failed to upload %s: %v\n", index, localFile.Path, err)
+				index++
+				failed++
+				continue
+			}
+			return fmt.Errorf("%d. failed to upload %s: %w", index, localFile.Path, err)
+		}
+		index++
+		uploaded++
+		sent += n
+		total += localFile.Size
+	}
+
+	if uploaded > 0 {
+		if err := client.Commit(token); err != nil {
+			return fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		committed = true
+		s.printf("Committed %d files\n", uploaded)
+	}
+
+	s.printf("Push completed in %s: %d files uploaded, sent %s of %s\n",
+		time.Since(start), uploaded, utils.FormatSize(sent), utils.FormatSize(total))
+	if failed > 0 {
+		return fmt.Errorf("%w: %d file(s) not uploaded, server is out of disk space", ErrPartial, failed)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	stdsync "sync"
+	"time"
+
+	"gorsync/pkg/net"
+)
+
+// DefaultReconnectTimeout 命令行默认等待重启的服务器恢复的时间
+const DefaultReconnectTimeout = 5 * time.Minute
+
+// maxReconnects 同一个请求因连接断开而重试的最多次数，防止服务器反复崩溃时无限重试
+const maxReconnects = 3
+
+// reconnectState 并行下载时多个请求可能同时发现连接断开，只由其中一个等待服务器恢复
+type reconnectState struct {
+	mutex    stdsync.Mutex
+	restored time.Time // 最近一次确认服务器恢复的时间
+}
+
+// withReconnect 执行请求，连接因服务器重启而断开时等待服务器恢复后重试。
+// 会话ID不变，已完成的文件已记录在本次同步和检查点中，只需重试中断的请求
+func (s *Syncer) withReconnect(client *net.Client, request func() error) error {
+	for attempt := 0; ; attempt++ {
+		failed := time.Now()
+		err := request()
+		// 文件被截断时服务器也会关闭连接，由调用者按文件变化处理
+		if err == nil || !net.IsConnectionLost(err) || errors.Is(err, net.ErrRemoteChanged) {
+			return err
+		}
+		if s.opts.ReconnectTimeout <= 0 || attempt >= maxReconnects || s.canceled() {
+			return err
+		}
+		if waitErr := s.waitForServer(client, failed, err); waitErr != nil {
+			return fmt.Errorf("%w (%v)", err, waitErr)
+		}
+	}
+}
+
+// waitForServer 等待服务器恢复，failed 之后已有其他请求确认服务器恢复时直接返回
+func (s *Syncer) waitForServer(client *net.Client, failed time.Time, cause error) error {
+	s.reconnect.mutex.Lock()
+	defer s.reconnect.mutex.Unlock()
+
+	if s.reconnect.restored.After(failed) {
+		return nil
+	}
+
+	s.printf("Lost connection to %s: %v\n", s.peer(), cause)
+	// 等待期间进程可能被终止，先把已确认的文件写入检查点，下次运行时从这里继续
+	s.mutex.Lock()
+	if s.checkpoint != nil {
+		if err := s.checkpoint.flush(); err != nil {
+			s.printf("Failed to write checkpoint: %v\n", err)
+		}
+	}
+	s.mutex.Unlock()
+
+	if err := client.WaitForServer(s.opts.ReconnectTimeout); err != nil {
+		return err
+	}
+	s.reconnect.restored = time.Now()
+	s.printf("Reconnected to %s, resuming session %s\n", s.peer(), s.summary.Session)
+	return nil
+}
@@ -0,0 +1,76 @@
+package sync
+
+import (
+	"os"
+	"path/filepath"
+
+	"gorsync/pkg/net"
+	"gorsync/pkg/utils"
+)
+
+// findRenames 远程新出现的文件与将被删除的本地文件内容相同时（远程改名），
+// 计划直接在本地改名而不是删除后重新下载
+func (p *Planner) findRenames(join *listingJoin, extraneous []net.FileInfo) []Action {
+	// 按内容索引将被删除的本地文件
+	candidates := make(map[string][]net.FileInfo)
+	for _, f := range extraneous {
+		if !f.IsDir && f.MD5 != "" {
+			candidates[f.MD5] = append(candidates[f.MD5], f)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	var renames []Action
+	for i, remoteFile := range join.remote {
+		if remoteFile.IsDir || remoteFile.MD5 == "" || len(candidates[remoteFile.MD5]) == 0 {
+			continue
+		}
+
+		localFile := join.localFile(i)
+		if localFile != nil && !isFileDifferent(remoteFile, *localFile) {
+			continue
+		}
+
+		source := candidates[remoteFile.MD5][0]
+		candidates[remoteFile.MD5] = candidates[remoteFile.MD5][1:]
+		if source.Size != remoteFile.Size {
+			continue
+		}
+
+		renames = append(renames, Action{
+			Type:   ActionRename,
+			Path:   remoteFile.Path,
+			Source: source.Path,
+			File:   remoteFile,
+		})
+	}
+
+	return renames
+}
+
+// renameLocal 执行本地改名，失败时返回 false，调用方改为下载该文件
+func (s *Syncer) renameLocal(action Action) bool {
+	sourcePath := net.LocalPath(s.localPath, action.Source)
+	targetPath := net.LocalPath(s.localPath, action.File.Path)
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+		s.printf("failed to create directory for rename: %s: %v\n", action.Path, err)
+		return false
+	}
+	if err := utils.Saferename(sourcePath, targetPath); err != nil {
+		s.printf("failed to rename %s -> %s: %v\n", action.Source, action.Path, err)
+		return false
+	}
+	if s.opts.NoPerms {
+		// 不设置权限，例如 FAT 类文件系统没有权限
+	} else if err := os.Chmod(targetPath, net.FileMode(action.File.Mode, s.opts.PermsSpecial)); err != nil {
+		s.printf("failed to set file mode: %s: %v\n", action.Path, err)
+	}
+
+	s.printf("Renamed locally: %s -> %s\n", action.Source, action.Path)
+	s.mutex.Lock()
+	s.summary.FilesRenamed++
+	s.mutex.Unlock()
+	return true
+}
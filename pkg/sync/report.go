@@ -0,0 +1,165 @@
+package sync
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	stdsync "sync"
+	"time"
+
+	"gorsync/pkg/net"
+	"gorsync/pkg/utils"
+)
+
+// 报告中每个文件操作的结果
+const (
+	OutcomeOK     = "ok"
+	OutcomeFailed = "failed"
+	OutcomeLocked = "locked" // 被其他进程占用而跳过，下次同步时重试
+)
+
+// Report 一次同步的完整报告，供备份框架存档：汇总、计划、每个文件操作的结果和跳过的路径
+type Report struct {
+	Summary RunSummary        `json:"summary"`
+	DryRun  bool              `json:"dryRun,omitempty"`
+	Plan    []ReportAction    `json:"plan"`
+	Files   []FileOutcome     `json:"files"`
+	Skipped []net.SkippedPath `json:"skipped,omitempty"` // 远程无法访问或目标无法保存的路径
+	Locked  []string          `json:"locked,omitempty"`  // 被占用而跳过的文件
+}
+
+// ReportAction 计划中的一个操作，包括已是最新、不需要修改的文件
+type ReportAction struct {
+	Pass   int    `json:"pass"` // 第几轮同步，见 --repeat-until-stable
+	Type   string `json:"type"`
+	Path   string `json:"path"`
+	Source string `json:"source,omitempty"` // 改名的本地原路径或复制的来源
+	Size   int64  `json:"size,omitempty"`
+	MD5    string `json:"md5,omitempty"`
+	Reason string `json:"reason,omitempty"` // 下载和元数据操作的原因，见 ReasonMissing 等
+	// Unverified 大小相同但缺少MD5、无法比较内容时缺少的一端（"remote"、"local" 或 "both"），
+	// 这些文件只按大小判断为相同，--require-checksum 时重新下载
+	Unverified string `json:"unverified,omitempty"`
+}
+
+// FileOutcome 执行一个修改本地文件的操作的结果
+type FileOutcome struct {
+	Pass     int    `json:"pass"`
+	Type     string `json:"type"`
+	Path     string `json:"path"`
+	Source   string `json:"source,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	MD5      string `json:"md5,omitempty"` // 操作后本地内容应有的MD5
+	Start    int64  `json:"start"`         // 开始时间（Unix 毫秒）
+	Duration int64  `json:"duration"`      // 耗时（毫秒）
+	Status   string `json:"status"`        // 见 OutcomeOK/OutcomeFailed/OutcomeLocked
+	Error    string `json:"error,omitempty"`
+}
+
+// reportRecorder 在同步过程中收集报告的计划和结果，并行下载时会被多个 goroutine 同时调用。
+// 未设置 --report 时为 nil，各方法什么也不做
+type reportRecorder struct {
+	mutex stdsync.Mutex
+	plan  []ReportAction
+	files []FileOutcome
+}
+
+// recordPlan 记录一轮同步的计划
+func (r *reportRecorder) recordPlan(pass int, plan *Plan) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, action := range plan.Actions {
+		r.plan = append(r.plan, ReportAction{
+			Pass:       pass,
+			Type:       string(action.Type),
+			Path:       action.Path,
+			Source:     action.Source,
+			Size:       action.File.Size,
+			MD5:        action.File.MD5,
+			Reason:     action.Reason,
+			Unverified: action.Unverified,
+		})
+	}
+}
+
+// record 记录一个操作的结果，start 为操作开始的时间
+func (r *reportRecorder) record(pass int, action Action, start time.Time, status string, err error) {
+	if r == nil {
+		return
+	}
+	outcome := FileOutcome{
+		Pass:     pass,
+		Type:     string(action.Type),
+		Path:     action.Path,
+		Source:   action.Source,
+		Size:     action.File.Size,
+		MD5:      action.File.MD5,
+		Start:    start.UnixMilli(),
+		Duration: time.Since(start).Milliseconds(),
+		Status:   status,
+	}
+	if err != nil {
+		outcome.Status = OutcomeFailed
+		outcome.Error = err.Error()
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.files = append(r.files, outcome)
+}
+
+// recordOutcome 记录当前一轮同步中一个操作的结果，下载因文件被占用而跳过时记为 locked
+func (s *Syncer) recordOutcome(action Action, start time.Time, err error) {
+	if s.report == nil {
+		return
+	}
+	status := OutcomeOK
+	s.mutex.Lock()
+	if err == nil && slices.Contains(s.locked, action.Path) {
+		status = OutcomeLocked
+	}
+	s.mutex.Unlock()
+	s.report.record(s.pass, action, start, status, err)
+}
+
+// writeReport 把本次同步的报告写入 --report 指定的文件，先写临时文件再改名，不会留下不完整的报告
+func (s *Syncer) writeReport() error {
+	report := Report{
+		Summary: s.summary,
+		DryRun:  s.opts.DryRun,
+		Plan:    s.report.plan,
+		Files:   s.report.files,
+		Skipped: s.skipped,
+		Locked:  s.locked,
+	}
+	if report.Plan == nil {
+		report.Plan = []ReportAction{}
+	}
+	if report.Files == nil {
+		report.Files = []FileOutcome{}
+	}
+
+	data, err := json.MarshalIndent(&report, "", "  ")
+	if err != nil {
+		return err
+	}
+	tempPath := utils.MakeTempName(s.opts.Report)
+	if err := os.WriteFile(tempPath, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	if err := utils.Saferename(tempPath, s.opts.Report); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return nil
+}
+
+// reportAction 把不属于计划的本地操作（例如 --delete-after 重新扫描后的删除）表示为报告中的操作
+func reportAction(actionType ActionType, file net.FileInfo) Action {
+	return Action{Type: actionType, Path: file.Path, File: file}
+}
@@ -0,0 +1,529 @@
+package sync
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"gorsync/pkg/net"
+	"gorsync/pkg/utils"
+)
+
+// 分批同步：文件数达到数百万时，两端的完整列表和同步计划可能超出内存。设置了 Options.SpillDir 时，
+// 两端的列表逐条按路径排序写入 SpillDir 下的临时文件，再从这些文件归并读取，
+// 每次只为一段连续的路径生成和执行计划，内存占用与文件总数无关。
+// 改名检测和重复文件复制只在同一批中匹配，其他批次中的文件仍然下载
+const (
+	spillRunEntries   = 100000 // 每个排序临时文件的最多条数，也是排序时内存中保留的最多条数
+	spillBatchEntries = 50000  // 每批计划中两端文件合计的最多条数，相同路径的文件总在同一批
+)
+
+// checkSpill 分批同步时拒绝需要完整列表或在内存中累积每个文件信息的选项
+func (s *Syncer) checkSpill(opts Options) error {
+	if opts.SpillDir == "" {
+		return nil
+	}
+
+	var conflicts []string
+	if s.sourceURL != "" {
+		conflicts = append(conflicts, "HTTP sources")
+	}
+	if s.glob != "" {
+		conflicts = append(conflicts, "remote patterns")
+	}
+	if len(opts.Subdirs) > 0 {
+		conflicts = append(conflicts, "remote subdirectories")
+	}
+	if opts.DeleteMode == DeleteAfter {
+		conflicts = append(conflicts, "--delete-after")
+	}
+	if opts.PruneEmptyDirs {
+		conflicts = append(conflicts, "--prune-empty-dirs")
+	}
+	if opts.MetadataOnly {
+		conflicts = append(conflicts, "--metadata-only")
+	}
+	if opts.NameMapping != NameMapNone {
+		conflicts = append(conflicts, "--name-map and --target-fs")
+	}
+	if opts.WindowsAttrs || opts.WindowsStreams {
+		conflicts = append(conflicts, "--win-attrs, --win-acl and --win-streams")
+	}
+	if opts.MacXattrs || opts.StripMacMetadata {
+		conflicts = append(conflicts, "--mac-xattrs and --strip-mac-metadata")
+	}
+	if opts.Checkpoint != "" {
+		conflicts = append(conflicts, "--checkpoint")
+	}
+	if opts.Manifest != "" {
+		conflicts = append(conflicts, "--manifest")
+	}
+	if opts.Report != "" {
+		conflicts = append(conflicts, "--report")
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("--spill-dir cannot be combined with %s", strings.Join(conflicts, ", "))
+	}
+	return nil
+}
+
+// syncSpilled 分批同步：两端的列表排序写入 SpillDir 下的临时目录，按路径顺序分批生成和执行计划，
+// 延迟删除的文件和需要恢复属性的目录也写入临时文件，全部传输完成后再处理
+func (s *Syncer) syncSpilled(client *net.Client) error {
+	dir, err := os.MkdirTemp(s.opts.SpillDir, "gorsync-spill-")
+	if err != nil {
+		return fmt.Errorf("failed to create spill directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var remote *spillSorter
+	var totalFiles int
+	var totalSize int64
+	err = s.withReconnect(client, func() error {
+		// 重新连接后重新获取完整的列表
+		remote = newSpillSorter(dir, "remote", s.foldCase())
+		totalFiles, totalSize = 0, 0
+		skipped, err := client.WalkFiles(s.remotePath, func(f net.FileInfo) error {
+			if !f.IsDir {
+				totalFiles++
+				totalSize += f.Size
+			}
+			return remote.add(f)
+		})
+		s.skipped = skipped
+		return err
+	})
+	if errors.Is(err, net.ErrNotFound) {
+		if hint := s.remoteHint(client, s.remotePath); hint != "" {
+			err = fmt.Errorf("%w (%s)", err, hint)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list remote files: %w", err)
+	}
+	s.mutex.Lock()
+	s.summary.FilesTotal = totalFiles
+	s.mutex.Unlock()
+	s.printf("Remote files: %d files, total size: %s\n", totalFiles, utils.FormatSize(totalSize))
+	if s.opts.Dict {
+		s.fetchDictionary(client)
+	}
+
+	s.printf("Getting local files...\n")
+	local := newSpillSorter(dir, "local", s.foldCase())
+	localEmpty := true
+	if err := s.walkLocalFiles(s.localPath, func(f net.FileInfo) error {
+		if !f.IsDir {
+			localEmpty = false
+		}
+		return local.add(f)
+	}); err != nil {
+		return fmt.Errorf("failed to list local files: %v", err)
+	}
+
+	// 本地目录意外为空或换了文件系统时可能是备份盘没有挂载，拒绝同步
+	if err := s.checkDestination(totalFiles, localEmpty); err != nil {
+		if !s.opts.DryRun {
+			return err
+		}
+		s.printf("Warning: %v\n", err)
+	}
+
+	if s.opts.DryRun {
+		s.printf("Dry run, no changes will be made:\n")
+		var counts planCounts
+		files, size, err := s.planSpilled(remote, local, s.newPlanner(), func(plan *Plan) {
+			counts.add(plan, s.opts.output())
+		})
+		if err != nil {
+			return err
+		}
+		counts.print(s.opts.output())
+		if err := s.checkTransferLimits(files, size); err != nil {
+			s.printf("Warning: %v\n", err)
+		}
+		return nil
+	}
+
+	if s.opts.MaxFiles > 0 || s.opts.MaxTransfer > 0 || s.opts.Budget != nil {
+		// 先完整规划一遍，确认不超出限制后再执行。冲突按下载计算，不调用冲突解析器
+		planner := s.newPlanner()
+		planner.Options.Resolver = nil
+		planner.Options.Output = io.Discard
+		files, size, err := s.planSpilled(remote, local, planner, func(*Plan) {})
+		if err != nil {
+			return err
+		}
+		if err := s.checkTransferLimits(files, size); err != nil {
+			return err
+		}
+	}
+
+	s.printf("Executing sync in remote-first mode, %d entries per batch...\n", spillBatchEntries)
+	start := time.Now()
+	syncErr := s.applySpilled(client, dir, remote, local)
+	if syncErr == nil {
+		s.printf("Peer sync completed with %s in %s\n", s.peer(), time.Since(start))
+	} else {
+		s.printf("Peer sync failed with %s: %v\n", s.peer(), syncErr)
+	}
+	s.reportSkipped()
+
+	return syncErr
+}
+
+// planSpilled 分批生成计划交给 fn，返回所有计划合计传输的文件数和字节数
+func (s *Syncer) planSpilled(remote, local *spillSorter, planner *Planner, fn func(plan *Plan)) (int, int64, error) {
+	remoteReader, localReader, err := openSpilled(remote, local)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer remoteReader.close()
+	defer localReader.close()
+
+	var files int
+	var size int64
+	for {
+		remoteBatch, localBatch, err := nextSpillBatch(remoteReader, localReader)
+		if err != nil {
+			return 0, 0, err
+		}
+		if len(remoteBatch) == 0 && len(localBatch) == 0 {
+			return files, size, nil
+		}
+		plan := planner.Plan(remoteBatch, localBatch)
+		fn(plan)
+		batchFiles, batchSize := plan.TransferSize()
+		files += batchFiles
+		size += batchSize
+	}
+}
+
+// applySpilled 分批生成并执行计划。延迟删除的文件在所有批次传输完成后才删除，
+// 目录属性在删除之后恢复
+func (s *Syncer) applySpilled(client *net.Client, dir string, remote, local *spillSorter) error {
+	remoteReader, localReader, err := openSpilled(remote, local)
+	if err != nil {
+		return err
+	}
+	defer remoteReader.close()
+	defer localReader.close()
+
+	planner := s.newPlanner()
+	deletes := newSpillSorter(dir, "delete", s.foldCase())
+	dirs := newSpillSorter(dir, "dirs", s.foldCase())
+	var grace map[string]int
+	index := 1
+	for batch := 1; ; batch++ {
+		if s.canceled() {
+			return net.ErrCanceled
+		}
+		remoteBatch, localBatch, err := nextSpillBatch(remoteReader, localReader)
+		if err != nil {
+			return err
+		}
+		if len(remoteBatch) == 0 && len(localBatch) == 0 {
+			break
+		}
+		s.printf("Batch %d: %d remote and %d local entries from %s\n", batch, len(remoteBatch), len(localBatch), firstPath(remoteBatch, localBatch, planner.Fold))
+
+		plan := planner.Plan(remoteBatch, localBatch)
+		if plan.Grace != nil {
+			if grace == nil {
+				grace = make(map[string]int)
+			}
+			maps.Copy(grace, plan.Grace)
+		}
+		actions := plan.Actions
+		if s.opts.DeleteMode == DeleteDelay {
+			// 等待所有批次传输完成后再删除
+			actions = nil
+			for _, action := range plan.Actions {
+				if action.Type != ActionDelete {
+					actions = append(actions, action)
+				} else if err := deletes.add(action.File); err != nil {
+					return err
+				}
+			}
+		}
+
+		// 只缓存本批的远程文件，下载时服务器仍可使用已知的MD5
+		client.CacheListing(s.remotePath, remoteBatch)
+		batchDirs, next, err := s.applyActions(client, actions, index)
+		if err != nil {
+			return err
+		}
+		index = next
+		for _, f := range batchDirs {
+			if err := dirs.add(f); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := eachSpilled(deletes, s.removeFiles); err != nil {
+		return err
+	}
+
+	s.graceCounts = grace
+	if s.graceCounts != nil {
+		if err := s.saveDeleteGrace(); err != nil {
+			s.printf("Failed to save delete grace state: %v\n", err)
+		}
+	}
+
+	// 所有文件操作完成后再恢复目录属性，避免下载和删除改变目录修改时间
+	return eachSpilled(dirs, s.restoreDirMetadata)
+}
+
+// firstPath 返回一批中排在最前的路径，用于显示进度
+func firstPath(remoteBatch, localBatch []net.FileInfo, fold bool) string {
+	switch {
+	case len(remoteBatch) == 0:
+		return localBatch[0].Path
+	case len(localBatch) == 0 || pathKey(remoteBatch[0].Path, fold) <= pathKey(localBatch[0].Path, fold):
+		return remoteBatch[0].Path
+	default:
+		return localBatch[0].Path
+	}
+}
+
+// openSpilled 打开两端排序后的列表
+func openSpilled(remote, local *spillSorter) (*spillReader, *spillReader, error) {
+	remoteReader, err := remote.open()
+	if err != nil {
+		return nil, nil, err
+	}
+	localReader, err := local.open()
+	if err != nil {
+		remoteReader.close()
+		return nil, nil, err
+	}
+	return remoteReader, localReader, nil
+}
+
+// eachSpilled 按路径顺序每次最多 spillBatchEntries 条读取排序后的文件信息交给 fn
+func eachSpilled(sorter *spillSorter, fn func(files []net.FileInfo)) error {
+	r, err := sorter.open()
+	if err != nil {
+		return err
+	}
+	defer r.close()
+
+	var files []net.FileInfo
+	for {
+		if _, ok := r.peek(); !ok || len(files) >= spillBatchEntries {
+			if len(files) > 0 {
+				fn(files)
+				files = files[:0]
+			}
+			if !ok {
+				return nil
+			}
+		}
+		f, err := r.next()
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+	}
+}
+
+// nextSpillBatch 从两端按路径顺序读取下一批文件信息，两端合计达到 spillBatchEntries 后在下一个路径处截断，
+// 同一路径（不区分大小写的文件系统上为同一规范化路径）的文件总在同一批中，读完时返回空
+func nextSpillBatch(remote, local *spillReader) ([]net.FileInfo, []net.FileInfo, error) {
+	var remoteBatch, localBatch []net.FileInfo
+	for len(remoteBatch)+len(localBatch) < spillBatchEntries {
+		remoteKey, remoteOK := remote.peek()
+		localKey, localOK := local.peek()
+		if !remoteOK && !localOK {
+			break
+		}
+		key := remoteKey
+		if !remoteOK || localOK && localKey < remoteKey {
+			key = localKey
+		}
+
+		for k, ok := remote.peek(); ok && k == key; k, ok = remote.peek() {
+			f, err := remote.next()
+			if err != nil {
+				return nil, nil, err
+			}
+			remoteBatch = append(remoteBatch, f)
+		}
+		for k, ok := local.peek(); ok && k == key; k, ok = local.peek() {
+			f, err := local.next()
+			if err != nil {
+				return nil, nil, err
+			}
+			localBatch = append(localBatch, f)
+		}
+	}
+	return remoteBatch, localBatch, nil
+}
+
+// spillSorter 外部排序：文件信息每积累 spillRunEntries 条就按规范化路径排序写入一个临时文件，
+// 读取时归并所有临时文件
+type spillSorter struct {
+	dir  string
+	name string
+	fold bool // 按小写的路径排序，见 pathKey
+	buf  []net.FileInfo
+	runs []string // 已写入的排序临时文件
+}
+
+// newSpillSorter 创建在 dir 下以 name 为前缀写入临时文件的排序器
+func newSpillSorter(dir, name string, fold bool) *spillSorter {
+	return &spillSorter{dir: dir, name: name, fold: fold}
+}
+
+// add 添加一条文件信息，缓冲区满时写入临时文件
+func (s *spillSorter) add(f net.FileInfo) error {
+	s.buf = append(s.buf, f)
+	if len(s.buf) >= spillRunEntries {
+		return s.flush()
+	}
+	return nil
+}
+
+// flush 把缓冲区按规范化路径排序后写入一个新的临时文件
+func (s *spillSorter) flush() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+	slices.SortStableFunc(s.buf, func(a, b net.FileInfo) int {
+		return strings.Compare(pathKey(a.Path, s.fold), pathKey(b.Path, s.fold))
+	})
+
+	file, err := os.CreateTemp(s.dir, s.name+"-*.jsonl")
+	if err != nil {
+		return fmt.Errorf("failed to create spill file: %v", err)
+	}
+	w := bufio.NewWriter(file)
+	enc := json.NewEncoder(w)
+	for _, f := range s.buf {
+		if err = enc.Encode(f); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write spill file: %v", err)
+	}
+
+	s.runs = append(s.runs, file.Name())
+	s.buf = s.buf[:0]
+	return nil
+}
+
+// open 写入缓冲区中剩余的文件信息，返回按规范化路径归并读取所有临时文件的读取器，可以多次打开
+func (s *spillSorter) open() (*spillReader, error) {
+	if err := s.flush(); err != nil {
+		return nil, err
+	}
+
+	r := &spillReader{}
+	for _, name := range s.runs {
+		file, err := os.Open(name)
+		if err != nil {
+			r.close()
+			return nil, fmt.Errorf("failed to open spill file: %v", err)
+		}
+		r.files = append(r.files, file)
+		run := &spillRun{dec: json.NewDecoder(bufio.NewReader(file)), fold: s.fold}
+		ok, err := run.advance()
+		if err != nil {
+			r.close()
+			return nil, err
+		}
+		if ok {
+			r.runs = append(r.runs, run)
+		}
+	}
+	heap.Init(&r.runs)
+	return r, nil
+}
+
+// spillRun 一个排序临时文件的读取位置
+type spillRun struct {
+	dec  *json.Decoder
+	head net.FileInfo // 下一条文件信息
+	key  string       // head 的规范化路径
+	fold bool
+}
+
+// advance 读取下一条文件信息，文件读完时返回 false
+func (r *spillRun) advance() (bool, error) {
+	r.head = net.FileInfo{}
+	if err := r.dec.Decode(&r.head); err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to read spill file: %v", err)
+	}
+	r.key = pathKey(r.head.Path, r.fold)
+	return true, nil
+}
+
+// spillHeap 按下一条文件信息的规范化路径排列的最小堆
+type spillHeap []*spillRun
+
+func (h spillHeap) Len() int           { return len(h) }
+func (h spillHeap) Less(i, j int) bool { return h[i].key < h[j].key }
+func (h spillHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *spillHeap) Push(x any)        { *h = append(*h, x.(*spillRun)) }
+func (h *spillHeap) Pop() any {
+	old := *h
+	run := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return run
+}
+
+// spillReader 归并读取多个排序临时文件
+type spillReader struct {
+	files []*os.File
+	runs  spillHeap
+}
+
+// peek 返回下一条文件信息的规范化路径，读完时返回 false
+func (r *spillReader) peek() (string, bool) {
+	if len(r.runs) == 0 {
+		return "", false
+	}
+	return r.runs[0].key, true
+}
+
+// next 返回下一条文件信息，调用前需用 peek 确认还有数据
+func (r *spillReader) next() (net.FileInfo, error) {
+	run := r.runs[0]
+	f := run.head
+	ok, err := run.advance()
+	if err != nil {
+		return f, err
+	}
+	if ok {
+		heap.Fix(&r.runs, 0)
+	} else {
+		heap.Pop(&r.runs)
+	}
+	return f, nil
+}
+
+// close 关闭所有临时文件
+func (r *spillReader) close() {
+	for _, file := range r.files {
+		file.Close()
+	}
+}
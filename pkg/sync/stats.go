@@ -0,0 +1,39 @@
+package sync
+
+import "time"
+
+// SyncStats 正在进行或已完成的同步的统计数据，供嵌入 gorsync 的图形界面显示总量和速度，不需要解析标准输出
+type SyncStats struct {
+	RunSummary
+	Running       bool  `json:"running"`
+	Elapsed       int64 `json:"elapsed"`       // 已用时间（毫秒），同步结束后与 Duration 相同
+	ActiveFiles   int   `json:"activeFiles"`   // 正在下载的文件数
+	BytesReceived int64 `json:"bytesReceived"` // 已接收的字节数，包括正在进行的下载
+	Speed         int64 `json:"speed"`         // 平均下载速度（字节/秒）
+}
+
+// Stats 返回当前或最近一次同步的统计数据，可以在同步进行时从其他 goroutine 调用
+func (s *Syncer) Stats() SyncStats {
+	s.mutex.Lock()
+	stats := SyncStats{
+		RunSummary:    s.summary,
+		Running:       s.running,
+		Elapsed:       s.summary.Duration,
+		BytesReceived: s.summary.BytesTransferred,
+	}
+	if s.running {
+		stats.Elapsed = time.Since(s.started).Milliseconds()
+	}
+	progress := s.progress
+	s.mutex.Unlock()
+
+	if stats.Running && progress != nil {
+		active, received := progress.Active()
+		stats.ActiveFiles = active
+		stats.BytesReceived += received
+	}
+	if stats.Elapsed > 0 {
+		stats.Speed = stats.BytesReceived * 1000 / stats.Elapsed
+	}
+	return stats
+}
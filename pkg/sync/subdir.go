@@ -0,0 +1,155 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gorsync/pkg/net"
+)
+
+// cleanSubdir 规范化子目录，拒绝绝对路径和跳出同步根目录的路径
+func cleanSubdir(subdir string) (string, error) {
+	cleaned := path.Clean(filepath.ToSlash(subdir))
+	if cleaned == "." || path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("invalid subdirectory: %s", subdir)
+	}
+	return cleaned, nil
+}
+
+// inSubdirs 检查相对路径是否在 subdirs 中的某个子目录之下，没有设置子目录时总是返回 true。
+// 只同步部分子目录时，其他路径的清单记录和删除宽限计数保持不变
+func inSubdirs(subdirs []string, relPath string) bool {
+	if len(subdirs) == 0 {
+		return true
+	}
+	for _, subdir := range subdirs {
+		if relPath == subdir || strings.HasPrefix(relPath, subdir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteRoots 返回需要获取列表的远程目录
+func (s *Syncer) remoteRoots() []string {
+	if len(s.opts.Subdirs) == 0 {
+		return []string{s.remotePath}
+	}
+
+	roots := make([]string, 0, len(s.opts.Subdirs))
+	for _, subdir := range s.opts.Subdirs {
+		roots = append(roots, net.JoinWire(s.remotePath, subdir))
+	}
+	return roots
+}
+
+// releaseSnapshots 通知服务器释放为本次同步创建的快照，旧版本服务器不支持时忽略错误
+func (s *Syncer) releaseSnapshots(client *net.Client) {
+	for _, root := range s.remoteRoots() {
+		client.ReleaseSnapshot(root)
+	}
+}
+
+// listRemoteFiles 获取远程文件列表，设置了子目录时逐个获取并以子目录为前缀合并
+func (s *Syncer) listRemoteFiles(client *net.Client) ([]net.FileInfo, []net.SkippedPath, error) {
+	if len(s.opts.Subdirs) == 0 {
+		return s.listRemote(client, s.remotePath)
+	}
+
+	var files []net.FileInfo
+	var skipped []net.SkippedPath
+	for i, remotePath := range s.remoteRoots() {
+		subdir := s.opts.Subdirs[i]
+		s.printf("Listing remote subdirectory: %s\n", remotePath)
+
+		subFiles, subSkipped, err := s.listRemote(client, remotePath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", subdir, err)
+		}
+		for _, f := range subFiles {
+			f.Path = path.Join(subdir, f.Path)
+			files = append(files, f)
+		}
+		for _, p := range subSkipped {
+			p.Path = path.Join(subdir, p.Path)
+			skipped = append(skipped, p)
+		}
+	}
+
+	return files, skipped, nil
+}
+
+// listRemote 获取一个远程目录的列表，服务器重启时等待其恢复后重新获取
+func (s *Syncer) listRemote(client *net.Client, remotePath string) (files []net.FileInfo, skipped []net.SkippedPath, err error) {
+	err = s.withReconnect(client, func() error {
+		files, skipped, err = client.ListFiles(remotePath)
+		return err
+	})
+	if errors.Is(err, net.ErrNotFound) {
+		if hint := s.remoteHint(client, remotePath); hint != "" {
+			err = fmt.Errorf("%w (%s)", err, hint)
+		}
+	}
+	return files, skipped, err
+}
+
+// maxHintDirs 远程路径不存在时最多列出的父目录中的子目录数
+const maxHintDirs = 20
+
+// remoteHint 远程路径不存在时列出已存在的最近一级父目录中的子目录，帮助发现路径中的拼写错误。
+// HTTP 源和不支持 browse 请求的旧版本服务器返回空
+func (s *Syncer) remoteHint(client *net.Client, remotePath string) string {
+	if s.sourceURL != "" {
+		return ""
+	}
+	for dir := path.Dir(net.WirePath(remotePath)); ; dir = path.Dir(dir) {
+		dirs, err := client.Browse(dir)
+		if err == nil {
+			if len(dirs) == 0 {
+				return fmt.Sprintf("%s has no subdirectories", dir)
+			}
+			if len(dirs) > maxHintDirs {
+				dirs = append(dirs[:maxHintDirs], "...")
+			}
+			return fmt.Sprintf("directories in %s: %s", dir, strings.Join(dirs, ", "))
+		}
+		if !errors.Is(err, net.ErrNotFound) || dir == "/" || dir == "." {
+			return ""
+		}
+	}
+}
+
+// listLocalFiles 获取本地文件列表，设置了子目录时只包含这些子目录下的文件，
+// 远程路径含模式时只包含匹配的文件及其上级目录
+func (s *Syncer) listLocalFiles() ([]net.FileInfo, error) {
+	if s.glob != "" {
+		files, err := s.getLocalFiles(s.localPath)
+		if err != nil {
+			return nil, err
+		}
+		return net.FilterGlob(files, s.glob), nil
+	}
+	if len(s.opts.Subdirs) == 0 {
+		return s.getLocalFiles(s.localPath)
+	}
+
+	var files []net.FileInfo
+	for _, subdir := range s.opts.Subdirs {
+		root := net.LocalPath(s.localPath, subdir)
+		if _, err := os.Stat(root); os.IsNotExist(err) {
+			continue
+		}
+
+		subFiles, err := s.getLocalFiles(root)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, subFiles...)
+	}
+
+	return files, nil
+}
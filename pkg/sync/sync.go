@@ -1,53 +1,416 @@
 package sync
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	stdsync "sync"
 	"time"
 
 	"gorsync/pkg/net"
 	"gorsync/pkg/utils"
 )
 
+// 删除时机，与 rsync 的 --delete-during/--delete-delay/--delete-after 对应
+const (
+	DeleteDuring = "during" // 处理到某个目录时立即删除该目录下的多余文件，同步中途失败时可能已删除了尚未下载替代文件的旧文件
+	DeleteDelay  = "delay"  // 传输过程中记录多余文件，全部传输成功后再删除（默认）
+	DeleteAfter  = "after"  // 全部传输成功后重新扫描本地目录再删除
+)
+
+// ErrPartial 同步完成，但有远程路径因访问错误或文件被占用而没有同步，下次同步时重试；
+// 推送时表示有文件因服务器空间不足没有上传。
+// 连接、认证、路径不存在和校验失败等错误可以用 errors.Is 与 pkg/net 中的 ErrConnect 等比较
+var ErrPartial = errors.New("some paths were not synced")
+
+// Options 同步选项
+type Options struct {
+	DeleteMode       string                   // 删除时机，见 DeleteDuring/DeleteDelay/DeleteAfter
+	PruneEmptyDirs   bool                     // 不创建不包含任何文件的目录，并清理删除后留下的空目录
+	Bandwidth        *utils.BandwidthSchedule // 下载带宽限制，nil 表示不限速
+	Checkpoint       string                   // 会话检查点文件路径，为空表示不记录
+	VerifyReadback   bool                     // 下载完成后从磁盘重新读取并校验MD5
+	NoVerify         bool                     // 下载完成后不再读取整个文件比较MD5，用于可信的高速局域网
+	RequireChecksum  bool                     // 大小相同但缺少MD5的文件重新下载而不是视为相同，服务器无法提供MD5时下载失败
+	VerifySample     int                      // 只对这个百分比的随机抽样比较整个文件的MD5，0 表示全部比较
+	FullVerify       time.Duration            // 抽样校验时每隔这么久全部校验一次
+	Manifest         string                   // 同步成功后写入的文件清单路径，供 Scrub 检查位衰减
+	History          string                   // 每次同步后追加汇总信息的历史文件路径
+	Report           string                   // 同步结束时写入完整 JSON 报告（计划、每个文件的结果、耗时、MD5、错误）的路径
+	IntegrityKey     string                   // 完整性模式的共享密钥，设置后用 HMAC 校验下载内容
+	Identity         string                   // 客户端身份令牌，服务器据此映射到该客户端的目录
+	MetadataOnly     bool                     // 只同步权限和修改时间，不传输文件内容也不删除文件
+	Owner            bool                     // 仅同步属性时同时同步属主（需要相应权限）
+	ModifyWindow     time.Duration            // 修改时间相差不超过这个值时视为相同，用于时间戳精度较低的文件系统
+	MinServerVersion int                      // 服务器的协议版本低于此值或不报告版本时拒绝同步，0 表示只要求版本兼容
+	TargetFS         string                   // 目标文件系统的兼容模式，见 TargetFSFAT/TargetFSExFAT
+	NameMapping      string                   // 转换目标上无法创建的文件名的方案，见 NameMapUnderscore/NameMapUnicode/NameMapPercent
+	PermsSpecial     bool                     // 应用远程的 setuid 和 setgid 位，默认去掉（sticky 总是保留）
+	NoPerms          bool                     // 不比较也不设置权限，用于 Samba 挂载、Windows 等权限总是与远程不同的目标
+	Nice             bool                     // 作为后台同步运行，服务器繁忙时请求排在交互式同步之后
+	WindowsAttrs     bool                     // 同步 Windows 文件的只读、隐藏和系统属性（两端都是 Windows 时有效）
+	WindowsACL       bool                     // 同时同步 NTFS 安全描述符（属主、属组和 DACL），隐含 WindowsAttrs
+	WindowsStreams   bool                     // 同步不超过 net.MaxInlineStream 的 NTFS 备用数据流，更大的流只报告
+	MacXattrs        bool                     // 同步不超过 net.MaxInlineXattr 的 macOS 扩展属性（Finder 信息、资源分支等）
+	StripMacMetadata bool                     // 不同步 AppleDouble（._name）和 .DS_Store 文件，本地已有的作为多余文件删除
+	SkipLocked       bool                     // 目标文件被其他进程占用时跳过，留到下次同步
+	ReconnectTimeout time.Duration            // 连接因服务器重启而断开时等待服务器恢复的最长时间，0 表示直接失败
+	Subdirs          []string                 // 只同步远程路径下的这些子目录，分别对应本地的同名子目录
+	DeleteGrace      int                      // 多余文件连续这么多次同步都不在远程时才删除，0 表示立即删除
+	Parallel         int                      // 同时下载的最大文件数，0 或 1 表示顺序下载
+	ConnPool         int                      // 列表、stat、checksum 和签名请求复用的空闲连接数，0 表示每个请求新建连接
+	ConnIdle         time.Duration            // 空闲连接保留的时间，0 表示使用 net.DefaultPoolIdle
+	Adaptive         bool                     // 根据吞吐量和连接延迟在 1 到 Parallel 之间动态调整并发数
+	Progress         string                   // 进度显示方式，见 net.ProgressFile/ProgressTotal/ProgressNone
+	ProgressEvery    time.Duration            // 进度报告间隔，0 表示使用默认值
+	Quiet            bool                     // 不显示进度和每个文件的下载信息
+	Output           io.Writer                // 同步过程的输出，nil 表示标准输出；并行下载时会被同时调用
+	Cancel           <-chan struct{}          // 关闭后中止同步，断开正在进行的请求，Sync 返回 net.ErrCanceled
+	DryRun           bool                     // 只打印同步计划，不修改本地文件
+	Resolver         ConflictResolver         // 远程和本地文件不同时的处理方式，nil 表示远程文件优先
+	Transforms       []net.TransformRule      // 按文件名选择的传输变换
+	Policies         []net.TransferPolicy     // 按文件名选择的压缩、增量和块大小策略
+	TextMode         *utils.TextFilter        // 按文本处理的文件，比较时忽略换行符和 BOM 差异，下载时转换为本机换行符
+	Append           bool                     // 远程文件只是在本地内容之后追加了数据时只下载新增的尾部
+	NoDedup          bool                     // 内容相同的远程文件也分别下载，不从本地已有或先下载的副本复制
+	Dict             bool                     // 请求服务器从远程目录的小文件训练压缩字典，小文件用字典压缩传输
+	Partial          bool                     // 下载因连接断开或取消而中断时保留已下载的部分，重试或下次同步时只下载剩余部分
+	RequireMarker    bool                     // 本地目录必须包含 MarkerFile 才同步，防止路径写错或备份盘未挂载时大量删除或重新下载
+	Force            bool                     // 本地目录与 History 中上次同步时相比为空或换了文件系统时仍然同步，见 ErrUnmounted
+	MaxPasses        int                      // 大于 1 时重复同步直到某一轮没有任何变化，最多这么多轮
+	MaxFiles         int                      // 一次同步最多传输的文件数，计划超出时中止，0 表示不限制
+	MaxTransfer      int64                    // 一次同步最多传输的字节数，计划超出时中止，0 表示不限制
+	Budget           *TransferBudget          // 跨多次同步累计的流量预算，nil 表示不限制
+	BudgetFile       string                   // 流量预算的状态文件，为空时使用同步根目录下的 .gorsync-budget.json
+	SpillDir         string                   // 不为空时两端的列表排序写入这个目录下的临时文件，分批生成和执行计划，见 syncSpilled
+}
+
 // Syncer 同步器结构体
 type Syncer struct {
 	localPath   string
 	remotePath  string
+	glob        string // 远程路径中通配符部分的相对模式，为空时同步整个目录
 	remoteAddr  string
 	port        int
+	sourceURL   string // 不为空时从普通 HTTP(S) 服务器镜像，见 NewHTTPSyncer
+	sourceList  string // HTTP 源上清单文件的路径
 	isListening bool
+	opts        Options
+	skipped     []net.SkippedPath // 远程遍历时因访问错误被跳过的路径
+	checkpoint  *checkpoint       // 会话检查点，未启用时为 nil
+	summary     RunSummary        // 最近一次同步的汇总信息
+	graceCounts map[string]int    // 本次同步中多余文件已连续出现的次数，未启用删除宽限时为 nil
+	locked      []string          // 因被其他进程占用而跳过的文件
+	pass        int               // 当前是第几轮同步，从 1 开始
+	sample      int               // 本次同步整文件校验的抽样百分比，0 表示全部校验
+	report      *reportRecorder   // 收集 --report 的计划和结果，未设置时为 nil
+	mutex       stdsync.Mutex     // 并行下载时保护 summary、locked 和 checkpoint，也供 Stats 读取
+	running     bool              // Sync 正在执行
+	started     time.Time         // 最近一次同步开始的时间
+	progress    *net.Progress     // 当前客户端的下载进度，供 Stats 统计正在进行的下载
+	reconnect   reconnectState    // 等待重启的服务器恢复
+	remoteNames map[string]string // 转换过名称的本地相对路径 -> 远程相对路径
+	pause       net.Pause         // 这个同步器的暂停状态，见 Pause
+	foldOnce    stdsync.Once      // 检测本地文件系统是否区分大小写，见 foldCase
+	fold        bool
 }
 
 // NewPeerSyncer 创建对等节点模式的同步器
+// 远程路径含通配符时只同步匹配的文件，通配符之前的目录对应本地目录
 func NewPeerSyncer(localPath, remoteAddr string, remotePath string, port int) *Syncer {
+	remotePath, glob := net.SplitGlob(net.WirePath(remotePath))
 	return &Syncer{
 		localPath:   localPath,
 		remotePath:  remotePath,
+		glob:        glob,
 		remoteAddr:  remoteAddr,
 		port:        port,
 		isListening: true,
+		opts: Options{
+			DeleteMode: DeleteDelay,
+		},
+	}
+}
+
+// NewHTTPSyncer 创建从普通 HTTP(S) 服务器镜像的同步器，源端提供 gorsync manifest 生成的清单，
+// manifest 为清单相对于 sourceURL 的路径，为空时使用 net.DefaultHTTPManifest
+func NewHTTPSyncer(localPath, sourceURL, manifest string) *Syncer {
+	return &Syncer{
+		localPath:  localPath,
+		sourceURL:  sourceURL,
+		sourceList: manifest,
+		opts: Options{
+			DeleteMode: DeleteDelay,
+		},
+	}
+}
+
+// peer 返回用于日志和检查点的远程端名称
+func (s *Syncer) peer() string {
+	if s.sourceURL != "" {
+		return s.sourceURL
+	}
+	return fmt.Sprintf("%s:%d", s.remoteAddr, s.port)
+}
+
+// SetOptions 设置同步选项
+func (s *Syncer) SetOptions(opts Options) error {
+	switch opts.DeleteMode {
+	case "":
+		opts.DeleteMode = DeleteDelay
+	case DeleteDuring, DeleteDelay, DeleteAfter:
+	default:
+		return fmt.Errorf("unknown delete mode: %s", opts.DeleteMode)
+	}
+	if opts.DeleteGrace < 0 {
+		return fmt.Errorf("invalid delete grace: %d", opts.DeleteGrace)
+	}
+	if _, err := net.NewProgress(opts.Progress, opts.ProgressEvery); err != nil {
+		return err
+	}
+	if opts.MaxPasses < 0 {
+		return fmt.Errorf("invalid max passes: %d", opts.MaxPasses)
+	}
+	if opts.MinServerVersion < 0 || opts.MinServerVersion > net.ProtocolVersion {
+		return fmt.Errorf("invalid min server version %d: this client speaks protocol version %d", opts.MinServerVersion, net.ProtocolVersion)
+	}
+	if opts.ModifyWindow < 0 {
+		return fmt.Errorf("invalid modify window: %s", opts.ModifyWindow)
+	}
+	if opts.NoVerify && opts.VerifyReadback {
+		return fmt.Errorf("--no-verify and --verify-readback are mutually exclusive")
+	}
+	if opts.VerifySample < 0 || opts.VerifySample > 100 {
+		return fmt.Errorf("invalid verification sample: %d%%", opts.VerifySample)
+	}
+	if opts.NoVerify && opts.VerifySample > 0 {
+		return fmt.Errorf("--no-verify and --verify-sample are mutually exclusive")
+	}
+	if opts.VerifySample > 0 && opts.FullVerify <= 0 {
+		opts.FullVerify = DefaultFullVerify
+	}
+	if opts.NoPerms && opts.PermsSpecial {
+		return fmt.Errorf("--no-perms and --perms-special are mutually exclusive")
+	}
+	switch opts.TargetFS {
+	case TargetFSNative:
+	case TargetFSFAT, TargetFSExFAT:
+		// FAT 类文件系统没有权限和属主，修改时间只精确到 2 秒
+		opts.ModifyWindow = max(opts.ModifyWindow, fatModifyWindow)
+		opts.Owner = false
+		opts.PermsSpecial = false
+		opts.NoPerms = true
+		if opts.NameMapping == NameMapNone {
+			opts.NameMapping = NameMapUnderscore
+		}
+	default:
+		return fmt.Errorf("unknown target filesystem: %s, expected fat or exfat", opts.TargetFS)
+	}
+	if err := checkNameMapping(opts.NameMapping); err != nil {
+		return err
+	}
+	if opts.ReconnectTimeout < 0 {
+		return fmt.Errorf("invalid reconnect timeout: %s", opts.ReconnectTimeout)
+	}
+	if opts.ConnPool < 0 || opts.ConnIdle < 0 {
+		return fmt.Errorf("connection pool settings must not be negative")
+	}
+	if opts.MaxFiles < 0 || opts.MaxTransfer < 0 {
+		return fmt.Errorf("transfer limits must not be negative")
+	}
+	if opts.Budget != nil && (opts.Budget.Warn < 0 || opts.Budget.Warn > 100) {
+		return fmt.Errorf("invalid budget warning threshold: %d%%", opts.Budget.Warn)
+	}
+	if opts.DryRun && opts.MetadataOnly {
+		return fmt.Errorf("dry run is not supported in metadata-only mode")
+	}
+	if opts.WindowsACL {
+		opts.WindowsAttrs = true
+	}
+	if opts.MacXattrs && opts.StripMacMetadata {
+		return fmt.Errorf("--mac-xattrs and --strip-mac-metadata are mutually exclusive")
+	}
+
+	subdirs := make([]string, 0, len(opts.Subdirs))
+	for _, subdir := range opts.Subdirs {
+		cleaned, err := cleanSubdir(subdir)
+		if err != nil {
+			return err
+		}
+		subdirs = append(subdirs, cleaned)
+	}
+	opts.Subdirs = subdirs
+	if err := s.checkGlob(opts); err != nil {
+		return err
+	}
+	if err := s.checkSpill(opts); err != nil {
+		return err
 	}
+	s.opts = opts
+	return nil
 }
 
 // Sync 执行同步操作
 func (s *Syncer) Sync() error {
 	// 打印同步开始信息
-	fmt.Printf("Starting sync operation with peer %s:%d\n", s.remoteAddr, s.port)
-	fmt.Printf("Remote path: %s -> Local path: %s\n", s.remotePath, s.localPath)
+	s.printf("Starting sync operation with peer %s\n", s.peer())
+	s.printf("Remote path: %s -> Local path: %s\n", s.remoteSpec(), s.localPath)
 
-	// 所有同步操作都通过 TCP 进行
-	err := s.syncWithPeer()
-	if err != nil {
-		fmt.Printf("Sync operation failed with peer %s:%d: %v\n", s.remoteAddr, s.port, err)
+	start := time.Now()
+	s.mutex.Lock()
+	s.summary = RunSummary{
+		Session: utils.NewSessionID(),
+		Start:   start.Unix(),
+		Remote:  fmt.Sprintf("%s:%s", s.peer(), s.remoteSpec()),
+		Local:   s.localPath,
+	}
+	s.running = true
+	s.started = start
+	s.mutex.Unlock()
+	s.printf("Session: %s\n", s.summary.Session)
+
+	s.sample = s.planVerification()
+	s.report = nil
+	if s.opts.Report != "" {
+		s.report = &reportRecorder{}
+	}
+
+	// 所有同步操作都通过 TCP 进行，设置了 MaxPasses 时重复同步直到某一轮没有变化，
+	// 使同步期间仍在变化的目录在结束时收敛到一致的状态
+	var err error
+	for s.pass = 1; ; s.pass++ {
+		changes := s.changeCount()
+		transferred := s.summary.BytesTransferred
+		s.mutex.Lock()
+		s.locked = nil
+		s.summary.Passes = s.pass
+		s.mutex.Unlock()
+
+		err = s.syncWithPeer()
+		if err != nil && s.canceled() && !errors.Is(err, net.ErrCanceled) {
+			err = fmt.Errorf("%w: %w", net.ErrCanceled, err)
+		}
+		// 失败的同步也可能已经下载了部分数据
+		if s.opts.Budget != nil && !s.opts.DryRun && s.summary.BytesTransferred > transferred {
+			if err := s.recordBudget(s.summary.BytesTransferred - transferred); err != nil {
+				s.printf("Failed to write budget state: %v\n", err)
+			}
+		}
+		if err != nil {
+			s.printf("Sync operation failed with peer %s (session %s): %v\n", s.peer(), s.summary.Session, err)
+			s.mutex.Lock()
+			s.summary.Error = err.Error()
+			s.mutex.Unlock()
+			break
+		}
+		if s.opts.MaxPasses <= 1 || s.opts.DryRun {
+			break
+		}
+		if s.changeCount() == changes {
+			s.printf("Pass %d found no changes, tree is stable\n", s.pass)
+			break
+		}
+		if s.pass >= s.opts.MaxPasses {
+			s.printf("Tree still changing after %d passes, giving up\n", s.pass)
+			break
+		}
+		s.printf("Pass %d made changes, rescanning...\n", s.pass)
+	}
+
+	// 抽样校验时记录成功的全部校验，之后的同步在间隔内只校验抽样
+	if err == nil && s.opts.VerifySample > 0 && s.sample == 0 && !s.opts.DryRun {
+		if err := s.recordFullVerification(); err != nil {
+			s.printf("Failed to write verification state: %v\n", err)
+		}
+	}
+
+	s.mutex.Lock()
+	s.summary.Duration = time.Since(start).Milliseconds()
+	s.summary.SkippedPaths = len(s.skipped)
+	s.summary.FilesLocked = len(s.locked)
+	s.running = false
+	s.mutex.Unlock()
+	if s.opts.History != "" && !s.opts.DryRun {
+		if err := appendHistory(s.opts.History, s.summary); err != nil {
+			s.printf("Failed to write history: %v\n", err)
+		}
+	}
+	if s.report != nil {
+		if err := s.writeReport(); err != nil {
+			s.printf("Failed to write report: %v\n", err)
+		}
+	}
+
+	if err == nil && !s.opts.DryRun && (len(s.skipped) > 0 || len(s.locked) > 0) {
+		return fmt.Errorf("%w: %d remote path(s) skipped, %d locked file(s)", ErrPartial, len(s.skipped), len(s.locked))
 	}
 	return err
 }
 
+// output 返回同步过程的输出
+func (o *Options) output() io.Writer {
+	if o.Output != nil {
+		return o.Output
+	}
+	return os.Stdout
+}
+
+// canceled 检查 Options.Cancel 是否已关闭
+func (s *Syncer) canceled() bool {
+	select {
+	case <-s.opts.Cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// Pause 暂停这个同步器的传输，正在传输的数据块完成后才会停下，连接保持打开，不影响其他同步。
+// 暂停期间关闭 Options.Cancel 仍会中止同步
+func (s *Syncer) Pause() {
+	if s.pause.Pause() {
+		s.printf("Transfers paused\n")
+	}
+}
+
+// Resume 恢复 Pause 暂停的传输
+func (s *Syncer) Resume() {
+	if s.pause.Resume() {
+		s.printf("Transfers resumed\n")
+	}
+}
+
+// Paused 返回这个同步器的传输是否处于暂停状态
+func (s *Syncer) Paused() bool {
+	return s.pause.Paused()
+}
+
+// printf 向 Options.Output 打印同步过程的信息
+func (s *Syncer) printf(format string, args ...any) {
+	fmt.Fprintf(s.opts.output(), format, args...)
+}
+
+// changeCount 返回本次同步到目前为止修改本地文件的次数
+func (s *Syncer) changeCount() int {
+	return s.summary.FilesTransferred + s.summary.FilesDeleted + s.summary.FilesRenamed + s.summary.FilesCopied + s.summary.MetadataUpdated
+}
+
+// Summary 返回最近一次同步的汇总信息
+func (s *Syncer) Summary() RunSummary {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.summary
+}
+
 // syncWithPeer 与对等节点同步
 func (s *Syncer) syncWithPeer() error {
 	// 打印对等节点同步开始信息
-	fmt.Printf("Starting peer sync with %s:%d\n", s.remoteAddr, s.port)
+	s.printf("Starting peer sync with %s\n", s.peer())
 
 	// 启动本地监听服务（仅在监听模式下）
 	// 注释掉这部分代码，避免客户端在对等节点模式下启动本地服务器
@@ -55,27 +418,66 @@ func (s *Syncer) syncWithPeer() error {
 	// 	go func() {
 	// 		server := net.NewServer(s.localPath, s.port)
 	// 		if err := server.Start(); err != nil {
-	// 			fmt.Printf("Failed to start local server: %v\n", err)
+	// 			s.printf("Failed to start local server: %v\n", err)
 	// 		}
 	// 	}()
 
 	// 	// 等待服务器启动
-	// 	fmt.Printf("Started local listener on port %d\n", s.port)
+	// 	s.printf("Started local listener on port %d\n", s.port)
 	// }
 
+	// 要求标记文件时先确认本地目录确实是同步目标
+	if err := s.checkMarker(); err != nil {
+		return err
+	}
+
 	// 确保本地目录存在
 	if err := os.MkdirAll(s.localPath, 0755); err != nil {
 		return fmt.Errorf("failed to create local directory: %v", err)
 	}
 
-	client := net.NewClient(s.remoteAddr, s.port)
+	// 加载上次中断的会话状态
+	if s.opts.Checkpoint != "" {
+		remote := fmt.Sprintf("%s:%s", s.peer(), s.remoteSpec())
+		cp, err := loadCheckpoint(s.opts.Checkpoint, remote, s.opts.output())
+		if err != nil {
+			return err
+		}
+		s.checkpoint = cp
+		defer func() {
+			s.checkpoint = nil
+		}()
+	}
+
+	client, err := s.newClient()
+	if err != nil {
+		return err
+	}
+	defer client.CloseIdleConnections()
+	if s.sourceURL == "" {
+		if err := s.checkServerVersion(client); err != nil {
+			return err
+		}
+		s.checkClockSkew(client)
+	}
 
 	// 获取远程文件列表
 	// 传递远程路径，让服务器知道要遍历哪个目录
-	fmt.Printf("Getting remote files from %s:%d...\n", s.remoteAddr, s.port)
-	remoteFiles, err := client.ListFiles(s.remotePath)
+	s.printf("Getting remote files from %s...\n", s.peer())
+	defer s.releaseSnapshots(client)
+	if s.opts.SpillDir != "" {
+		return s.syncSpilled(client)
+	}
+	remoteFiles, skipped, err := s.listRemoteFiles(client)
 	if err != nil {
-		return fmt.Errorf("failed to list remote files: %v", err)
+		return fmt.Errorf("failed to list remote files: %w", err)
+	}
+	s.skipped = skipped
+	if s.opts.StripMacMetadata {
+		remoteFiles = s.stripMacMetadata(remoteFiles)
+	}
+	if s.nameMapping() {
+		remoteFiles = s.adaptNames(remoteFiles)
 	}
 
 	var totalFiles int
@@ -86,158 +488,631 @@ func (s *Syncer) syncWithPeer() error {
 			totalSize += f.Size
 		}
 	}
-	fmt.Printf("Remote files: %d files, total size: %s\n", totalFiles, utils.FormatSize(totalSize))
+	s.mutex.Lock()
+	s.summary.FilesTotal = totalFiles
+	s.mutex.Unlock()
+	s.printf("Remote files: %d files, total size: %s\n", totalFiles, utils.FormatSize(totalSize))
+	if s.opts.Dict && s.sourceURL == "" {
+		s.fetchDictionary(client)
+	}
+
+	if s.opts.MetadataOnly {
+		s.printf("Executing metadata-only sync...\n")
+		return s.syncMetadata(remoteFiles)
+	}
 
 	// 获取本地文件列表
-	fmt.Printf("Getting local files...\n")
-	localFiles, err := s.getLocalFiles(s.localPath)
+	s.printf("Getting local files...\n")
+	localFiles, err := s.listLocalFiles()
 	if err != nil {
 		return fmt.Errorf("failed to list local files: %v", err)
 	}
 
+	// 本地目录意外为空或换了文件系统时可能是备份盘没有挂载，拒绝同步
+	localEmpty := !slices.ContainsFunc(localFiles, func(f net.FileInfo) bool { return !f.IsDir })
+	if err := s.checkDestination(totalFiles, localEmpty); err != nil {
+		if !s.opts.DryRun {
+			return err
+		}
+		s.printf("Warning: %v\n", err)
+	}
+
+	if s.opts.DryRun {
+		s.printf("Dry run, no changes will be made:\n")
+		plan := s.planRemoteFirst(remoteFiles, localFiles)
+		s.report.recordPlan(s.pass, plan)
+		plan.Print(s.opts.output())
+		if err := s.checkLimits(plan); err != nil {
+			s.printf("Warning: %v\n", err)
+		}
+		s.reportNames()
+		return nil
+	}
+
 	// 执行 remote-first 模式同步
-	fmt.Printf("Executing sync in remote-first mode...\n")
+	s.printf("Executing sync in remote-first mode...\n")
 	start := time.Now()
 	var syncErr error
 	syncErr = s.syncRemoteFirst(client, remoteFiles, localFiles)
 
 	if syncErr == nil {
 		elapsed := time.Since(start)
-		fmt.Printf("Peer sync completed with %s:%d in %s\n", s.remoteAddr, s.port, elapsed)
+		s.printf("Peer sync completed with %s in %s\n", s.peer(), elapsed)
 	} else {
-		fmt.Printf("Peer sync failed with %s:%d: %v\n", s.remoteAddr, s.port, syncErr)
+		s.printf("Peer sync failed with %s: %v\n", s.peer(), syncErr)
+	}
+
+	// 成功时删除检查点，失败时保留已确认的文件供下次恢复
+	if s.checkpoint != nil {
+		if syncErr == nil {
+			s.checkpoint.remove()
+		} else if err := s.checkpoint.flush(); err != nil {
+			s.printf("Failed to write checkpoint: %v\n", err)
+		}
 	}
 
+	// 记录同步后的文件状态
+	if syncErr == nil && s.opts.Manifest != "" {
+		if err := s.writeManifest(remoteFiles); err != nil {
+			s.printf("Failed to write manifest: %v\n", err)
+		}
+	}
+
+	s.reportNames()
+	s.reportSkipped()
+
 	return syncErr
 }
 
+// reportSkipped 汇总被占用而跳过的文件，以及远程无法访问或目标无法保存的路径
+func (s *Syncer) reportSkipped() {
+	if len(s.locked) > 0 {
+		s.printf("Skipped %d locked file(s), they will be retried on the next run:\n", len(s.locked))
+		for _, p := range s.locked {
+			s.printf("  %s\n", p)
+		}
+	}
+
+	if len(s.skipped) > 0 {
+		s.printf("Skipped %d remote path(s) that could not be synced:\n", len(s.skipped))
+		for _, p := range s.skipped {
+			s.printf("  %s: %s\n", p.Path, p.Error)
+		}
+	}
+}
+
+// newClient 按同步选项创建连接远程服务器的客户端
+func (s *Syncer) newClient() (*net.Client, error) {
+	client := net.NewClient(s.remoteAddr, s.port)
+	if s.sourceURL != "" {
+		var err error
+		client, err = net.NewHTTPClient(s.sourceURL, s.sourceList)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s.opts.Bandwidth != nil {
+		client.SetRateLimiter(utils.NewRateLimiter(s.opts.Bandwidth))
+	}
+	client.SetVerifyReadback(s.opts.VerifyReadback)
+	client.SetNoVerify(s.opts.NoVerify)
+	client.SetRequireChecksum(s.opts.RequireChecksum)
+	client.SetVerifySample(s.sample)
+	// 重复同步时后续各轮需要看到最新的远程目录，不使用服务器缓存的列表
+	client.SetFreshListing(s.pass > 1)
+	client.SetGlob(s.glob)
+	client.SetSession(s.summary.Session)
+	client.SetConnPool(s.opts.ConnPool, s.opts.ConnIdle)
+	client.SetPartial(s.opts.Partial)
+	if s.opts.Nice {
+		client.SetPriority(net.PriorityBackground)
+	}
+	client.SetQuiet(s.opts.Quiet)
+	client.SetTransforms(s.opts.Transforms)
+	client.SetPolicies(s.opts.Policies)
+	client.SetTextFilter(s.opts.TextMode)
+	client.SetSecurity(s.opts.WindowsACL)
+	client.SetSpecialPerms(s.opts.PermsSpecial)
+	client.SetKeepPerms(!s.opts.NoPerms)
+	client.SetStreams(s.opts.WindowsStreams)
+	client.SetXattrs(s.opts.MacXattrs)
+	progressMode := s.opts.Progress
+	if s.opts.Quiet {
+		progressMode = net.ProgressNone
+	}
+	progress, err := net.NewProgress(progressMode, s.opts.ProgressEvery)
+	if err != nil {
+		return nil, err
+	}
+	progress.SetOutput(s.opts.output())
+	client.SetProgress(progress)
+	client.SetOutput(s.opts.output())
+	client.SetCancel(s.opts.Cancel)
+	client.SetPause(&s.pause)
+	s.mutex.Lock()
+	s.progress = progress
+	s.mutex.Unlock()
+	if s.opts.IntegrityKey != "" {
+		client.SetIntegrityKey(s.opts.IntegrityKey)
+	}
+	client.SetIdentity(s.opts.Identity)
+	return client, nil
+}
+
+// planRemoteFirst 生成远程优先模式的同步计划
+func (s *Syncer) planRemoteFirst(remoteFiles []net.FileInfo, localFiles []net.FileInfo) *Plan {
+	return s.newPlanner().Plan(remoteFiles, localFiles)
+}
+
+// newPlanner 按同步选项创建规划器，启用删除宽限时载入上次同步保存的计数
+func (s *Syncer) newPlanner() *Planner {
+	planner := &Planner{
+		Options: s.opts,
+		Skipped: s.skipped,
+		Root:    s.localPath,
+		Glob:    s.glob,
+		Fold:    s.foldCase(),
+	}
+	if s.opts.DeleteGrace > 0 {
+		planner.Grace = s.loadDeleteGrace()
+	}
+	return planner
+}
+
 // syncRemoteFirst 远程优先模式同步
 func (s *Syncer) syncRemoteFirst(client *net.Client, remoteFiles []net.FileInfo, localFiles []net.FileInfo) error {
-	// 远程优先模式：远程文件覆盖本地文件
-	var index = 1
-	for _, remoteFile := range remoteFiles {
-		if remoteFile.IsDir {
-			// 创建本地目录
-			dirPath := filepath.Join(s.localPath, remoteFile.Path)
-			if err := os.MkdirAll(dirPath, os.FileMode(remoteFile.Mode)); err != nil {
-				return fmt.Errorf("failed to create directory: %v", err)
-			}
-		} else {
-			// 检查本地文件是否存在或不同
-			localFile := s.findFile(localFiles, remoteFile.Path)
-			if localFile == nil || s.isFileDifferent(remoteFile, *localFile) {
-				// 下载文件
-				localPath := filepath.Join(s.localPath, remoteFile.Path)
-				// 构建完整的远程路径
-				fullRemotePath := filepath.Join(s.remotePath, remoteFile.Path)
-				fullRemotePath = filepath.ToSlash(fullRemotePath)
-				if err := client.DownloadFile(fullRemotePath, localPath, index); err != nil {
-					return fmt.Errorf("%d. failed to get file: %v", index, err)
-				}
+	plan := s.planRemoteFirst(remoteFiles, localFiles)
+	s.report.recordPlan(s.pass, plan)
+	if err := s.checkLimits(plan); err != nil {
+		return err
+	}
+	return s.applyPlan(client, plan, remoteFiles)
+}
+
+// checkLimits 检查计划传输的文件数和字节数是否超出限制，防止误同步了意料之外的大目录
+func (s *Syncer) checkLimits(plan *Plan) error {
+	files, size := plan.TransferSize()
+	return s.checkTransferLimits(files, size)
+}
+
+// checkTransferLimits 检查计划传输的文件数和字节数是否超出 MaxFiles、MaxTransfer 和流量预算
+func (s *Syncer) checkTransferLimits(files int, size int64) error {
+	if s.opts.MaxFiles > 0 && files > s.opts.MaxFiles {
+		return fmt.Errorf("plan transfers %d files, more than the limit of %d", files, s.opts.MaxFiles)
+	}
+	if s.opts.MaxTransfer > 0 && size > s.opts.MaxTransfer {
+		return fmt.Errorf("plan transfers %s, more than the limit of %s", utils.FormatSize(size), utils.FormatSize(s.opts.MaxTransfer))
+	}
+	if s.opts.Budget != nil {
+		return s.checkBudget(size)
+	}
+	return nil
+}
+
+// applyPlan 按顺序执行同步计划
+func (s *Syncer) applyPlan(client *net.Client, plan *Plan, remoteFiles []net.FileInfo) error {
+	s.graceCounts = plan.Grace
+	dirs, _, err := s.applyActions(client, plan.Actions, 1)
+	if err != nil {
+		return err
+	}
+
+	if s.opts.DeleteMode == DeleteAfter {
+		// 重新扫描本地目录，删除此时多余的文件
+		currentFiles, err := s.listLocalFiles()
+		if err != nil {
+			return fmt.Errorf("failed to list local files: %v", err)
+		}
+		planner := &Planner{Options: s.opts, Skipped: s.skipped, Fold: s.foldCase()}
+		s.removeFiles(plan.dueForDeletion(planner.findExtraneous(joinListings(remoteFiles, currentFiles, planner.Fold)), s.opts.DeleteGrace))
+	}
+
+	if s.graceCounts != nil {
+		if err := s.saveDeleteGrace(); err != nil {
+			s.printf("Failed to save delete grace state: %v\n", err)
+		}
+	}
+
+	if s.opts.PruneEmptyDirs {
+		if err := s.pruneEmptyDirs(); err != nil {
+			return fmt.Errorf("failed to prune empty directories: %v", err)
+		}
+	}
+
+	// 所有文件操作完成后再恢复目录属性，避免下载和删除改变目录修改时间
+	s.restoreDirMetadata(dirs)
+	if s.opts.WindowsAttrs || s.opts.WindowsStreams {
+		s.restoreWindowsMeta(remoteFiles)
+	}
+	s.reportStreams(remoteFiles)
+	if s.opts.MacXattrs {
+		s.restoreXattrs(remoteFiles)
+	}
+	s.reportXattrs(remoteFiles)
+
+	return nil
+}
+
+// applyActions 按顺序执行计划中的操作，下载编号从 index 开始，返回需要最后恢复属性的目录和下一个编号
+func (s *Syncer) applyActions(client *net.Client, actions []Action, index int) ([]net.FileInfo, int, error) {
+	// 设置了并行下载时通过工作池下载
+	var pool *downloadPool
+	if s.opts.Parallel > 1 {
+		pool = newDownloadPool(client, s.opts.Parallel, s.opts.Adaptive, s.opts.output())
+	}
+
+	transfer := func(size int64, fn func(index int) error) error {
+		fileIndex := index
+		index++
+		if pool == nil {
+			return fn(fileIndex)
+		}
+		pool.run(size, func() error {
+			return fn(fileIndex)
+		})
+		return nil
+	}
+	download := func(action Action) error {
+		return transfer(action.File.Size, func(index int) error {
+			start := time.Now()
+			err := s.downloadFile(client, action.File, index)
+			s.recordOutcome(action, start, err)
+			return err
+		})
+	}
+
+	var dirs []net.FileInfo
+	var copies []Action
+	transferred := false
+	for _, action := range actions {
+		// 并行下载出错或同步被取消后不再执行后续操作
+		if pool != nil && pool.failed() || s.canceled() {
+			break
+		}
+
+		switch action.Type {
+		case ActionRename:
+			start := time.Now()
+			if s.renameLocal(action) {
+				s.recordOutcome(action, start, nil)
 			} else {
-				fmt.Printf("%d. Skipping download: %s\n", index, remoteFile.Path)
+				// 改名失败时改为下载
+				if err := download(Action{Type: ActionDownload, Path: action.Path, File: action.File}); err != nil {
+					return nil, 0, err
+				}
+			}
+		case ActionMkdir:
+			start := time.Now()
+			dirPath := net.LocalPath(s.localPath, action.File.Path)
+			if err := os.MkdirAll(dirPath, net.FileMode(action.File.Mode, false).Perm()); err != nil {
+				err = fmt.Errorf("failed to create directory: %v", err)
+				s.recordOutcome(action, start, err)
+				return nil, 0, err
+			}
+			s.recordOutcome(action, start, nil)
+		case ActionDownload:
+			if err := download(action); err != nil {
+				return nil, 0, err
 			}
+		case ActionCopy:
+			// 复制的来源可能是本次下载的文件，下载全部完成后再复制
+			copies = append(copies, action)
+		case ActionAppend:
+			action := action
+			if err := transfer(action.File.Size-action.Local.Size, func(index int) error {
+				start := time.Now()
+				err := s.appendFile(client, action, index)
+				s.recordOutcome(action, start, err)
+				return err
+			}); err != nil {
+				return nil, 0, err
+			}
+		case ActionMetadata, ActionPerms, ActionTouch:
+			s.printf("%d. Updating metadata: %s (%s)\n", index, action.Path, action.Reason)
+			index++
+			start := time.Now()
+			s.updateMetadata(action)
+			s.recordOutcome(action, start, nil)
+		case ActionKeep:
+			s.printf("%d. Skipping download: %s\n", index, action.Path)
 			index++
+			if s.checkpoint != nil && action.File.MD5 != "" {
+				s.mutex.Lock()
+				s.checkpoint.record(action.File.Path, net.LocalPath(s.localPath, action.File.Path), action.File.MD5)
+				s.mutex.Unlock()
+			}
+		case ActionDelete:
+			switch s.opts.DeleteMode {
+			case DeleteAfter:
+				// 传输完成后重新扫描再删除
+				continue
+			case DeleteDelay:
+				// 等待所有传输完成后再删除
+				if pool != nil && !transferred {
+					if err := pool.wait(); err != nil {
+						return nil, 0, err
+					}
+					transferred = true
+				}
+			}
+			s.removeFiles([]net.FileInfo{action.File})
+		case ActionChmod:
+			dirs = append(dirs, action.File)
 		}
 	}
 
-	// 删除本地多余的文件（本地存在但远程不存在的文件）
-	for _, localFile := range localFiles {
-		// 检查远程文件是否存在
-		relPath := filepath.ToSlash(localFile.Path)
-		remoteFile := s.findFile(remoteFiles, relPath)
-		if remoteFile == nil {
-			// 远程文件不存在，删除本地文件
-			localPath := filepath.Join(s.localPath, localFile.Path)
-			_, err := os.Stat(localPath)
-			if err == nil {
-				if err := os.RemoveAll(localPath); err != nil {
-					fmt.Printf("failed to removed: %s\n", localFile.Path)
-				}
+	if pool != nil {
+		if err := pool.wait(); err != nil {
+			return nil, 0, err
+		}
+	}
+	if s.canceled() {
+		return nil, 0, net.ErrCanceled
+	}
+	if err := s.copyDuplicates(client, copies, index); err != nil {
+		return nil, 0, err
+	}
+	return dirs, index + len(copies), nil
+}
+
+// downloadFile 下载单个文件并记录结果，并行下载时会被多个 goroutine 同时调用
+func (s *Syncer) downloadFile(client *net.Client, remoteFile net.FileInfo, index int) error {
+	localPath := net.LocalPath(s.localPath, remoteFile.Path)
+	// 构建完整的远程路径
+	fullRemotePath := s.remoteWire(remoteFile.Path)
+	if s.opts.WindowsAttrs {
+		// 只读文件不能被替换，下载完成后再恢复远程的属性
+		s.clearReadonly(localPath)
+	}
+	download := func() error {
+		return client.DownloadFile(fullRemotePath, localPath, index)
+	}
+	err := s.withReconnect(client, download)
+	if errors.Is(err, net.ErrRemoteChanged) {
+		// 文件在列表之后被截断或改写，服务器重新打开文件后按新的大小发送
+		s.printf("%d. %v, retrying: %s\n", index, err, remoteFile.Path)
+		err = s.withReconnect(client, download)
+	}
+	if err != nil {
+		if !utils.IsFileLocked(err) {
+			return fmt.Errorf("%d. failed to get file: %w", index, err)
+		}
+		if holders, _ := utils.LockHolders(localPath); len(holders) > 0 {
+			err = fmt.Errorf("%w (held by %s)", err, strings.Join(holders, ", "))
+		}
+		if !s.opts.SkipLocked {
+			return fmt.Errorf("%d. failed to get file: %w", index, err)
+		}
+		// 跳过被占用的文件，下次同步时重试
+		s.printf("%d. Skipping locked file: %s: %v\n", index, remoteFile.Path, err)
+		s.mutex.Lock()
+		s.locked = append(s.locked, remoteFile.Path)
+		s.mutex.Unlock()
+		return nil
+	}
+	s.setModTime(localPath, remoteFile)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.summary.FilesTransferred++
+	s.summary.BytesTransferred += remoteFile.Size
+	if s.checkpoint != nil {
+		s.checkpoint.record(remoteFile.Path, localPath, remoteFile.MD5)
+	}
+	return nil
+}
+
+// appendFile 只下载远程文件新增的尾部，远程文件开头与本地内容不同时改为完整下载
+func (s *Syncer) appendFile(client *net.Client, action Action, index int) error {
+	remoteFile := action.File
+	localPath := net.LocalPath(s.localPath, remoteFile.Path)
+	fullRemotePath := s.remoteWire(remoteFile.Path)
+	err := s.withReconnect(client, func() error {
+		return client.AppendFile(fullRemotePath, localPath, action.Local.MD5, index)
+	})
+	if errors.Is(err, net.ErrPrefixMismatch) {
+		s.printf("%d. Remote file was rewritten, downloading in full: %s\n", index, remoteFile.Path)
+		return s.downloadFile(client, remoteFile, index)
+	}
+	if err != nil {
+		return fmt.Errorf("%d. failed to append file: %w", index, err)
+	}
+	s.setModTime(localPath, remoteFile)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.summary.FilesTransferred++
+	s.summary.BytesTransferred += remoteFile.Size - action.Local.Size
+	if s.checkpoint != nil {
+		s.checkpoint.record(remoteFile.Path, localPath, remoteFile.MD5)
+	}
+	return nil
+}
+
+// restoreDirMetadata 自顶向下恢复目录权限和修改时间
+func (s *Syncer) restoreDirMetadata(remoteFiles []net.FileInfo) {
+	for _, remoteFile := range remoteFiles {
+		if !remoteFile.IsDir {
+			continue
+		}
+
+		dirPath := net.LocalPath(s.localPath, remoteFile.Path)
+		info, err := os.Stat(dirPath)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+
+		mode := net.FileMode(remoteFile.Mode, s.opts.PermsSpecial)
+		if !s.opts.NoPerms && info.Mode()&net.ModeBits != mode {
+			if err := os.Chmod(dirPath, mode); err != nil {
+				s.printf("failed to set directory mode: %s: %v\n", remoteFile.Path, err)
 			}
 		}
+
+		if s.sameModTime(info.ModTime(), remoteFile) {
+			continue
+		}
+		modTime := remoteFile.Modified()
+		if err := os.Chtimes(dirPath, modTime, modTime); err != nil {
+			s.printf("failed to set directory mtime: %s: %v\n", remoteFile.Path, err)
+		}
+	}
+}
+
+// pruneEmptyDirs 自底向上删除本地空目录（不包括根目录）。与删除多余文件的范围相同：
+// 只同步部分子目录时只处理这些子目录，远程无法访问的路径和不匹配远程模式的目录保留
+func (s *Syncer) pruneEmptyDirs() error {
+	planner := &Planner{Options: s.opts, Skipped: s.skipped, Glob: s.glob, Fold: s.foldCase()}
+	var dirs []string
+	if err := filepath.Walk(s.localPath, func(walkPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() || walkPath == s.localPath {
+			return nil
+		}
+		relPath, err := filepath.Rel(s.localPath, walkPath)
+		if err != nil {
+			return err
+		}
+		relPath = net.WirePath(relPath)
+		if planner.isSkipped(relPath) {
+			// 远程无法访问，其下的目录都保留
+			return filepath.SkipDir
+		}
+		if inSubdirs(s.opts.Subdirs, relPath) && inGlob(s.glob, relPath) {
+			dirs = append(dirs, walkPath)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	// Walk 按字典序先序遍历，逆序处理即可保证子目录先于父目录
+	for i := len(dirs) - 1; i >= 0; i-- {
+		entries, err := os.ReadDir(dirs[i])
+		if err != nil || len(entries) > 0 {
+			continue
+		}
+		if err := os.Remove(dirs[i]); err != nil {
+			s.printf("failed to remove empty directory: %s\n", dirs[i])
+		} else {
+			s.printf("Pruned empty directory: %s\n", dirs[i])
+		}
 	}
 
 	return nil
 }
 
+// removeFiles 删除本地文件
+func (s *Syncer) removeFiles(files []net.FileInfo) {
+	for _, localFile := range files {
+		localPath := net.LocalPath(s.localPath, localFile.Path)
+		_, err := os.Stat(localPath)
+		if err == nil {
+			start := time.Now()
+			if err := os.RemoveAll(localPath); err != nil {
+				s.printf("failed to removed: %s\n", localFile.Path)
+				s.recordOutcome(reportAction(ActionDelete, localFile), start, err)
+			} else {
+				// during 模式下与下载并行执行
+				s.mutex.Lock()
+				s.summary.FilesDeleted++
+				s.mutex.Unlock()
+				s.recordOutcome(reportAction(ActionDelete, localFile), start, nil)
+			}
+		}
+	}
+}
+
 // getLocalFiles 获取本地文件列表
 func (s *Syncer) getLocalFiles(root string) ([]net.FileInfo, error) {
 	var files []net.FileInfo
+	if err := s.walkLocalFiles(root, func(f net.FileInfo) error {
+		files = append(files, f)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
 
-	if err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+// walkLocalFiles 遍历 root 下的本地文件，逐个计算MD5后交给 fn
+func (s *Syncer) walkLocalFiles(root string, fn func(net.FileInfo) error) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
-		// 计算相对路径
-		relPath, err := filepath.Rel(root, path)
+		// 计算相对于同步根目录的路径
+		relPath, err := filepath.Rel(s.localPath, path)
 		if err != nil {
 			return err
 		}
-		relPath = filepath.ToSlash(relPath)
+		relPath = net.WirePath(relPath)
 
 		// 跳过根目录本身
 		if relPath == "." {
 			return nil
 		}
 
+		// 状态文件位于同步目录内时不参与同步，保留的部分下载也不参与
+		if s.isStateFile(path) || !info.IsDir() && net.IsPartialName(path) {
+			return nil
+		}
+
 		// 初始化FileInfo
 		fileInfo := net.FileInfo{
-			Path:    relPath,
-			Size:    info.Size(),
-			ModTime: info.ModTime().Unix(),
-			IsDir:   info.IsDir(),
-			Mode:    int(info.Mode()),
+			Path:     relPath,
+			Size:     info.Size(),
+			ModTime:  info.ModTime().Unix(),
+			ModNanos: info.ModTime().Nanosecond(),
+			IsDir:    info.IsDir(),
+			Mode:     net.EncodeMode(info.Mode()),
+			Type:     net.FileType(info.Mode()),
 		}
 
 		// 计算文件的MD5哈希值（仅对文件计算，不对目录）
 		if !info.IsDir() {
-			md5, err := utils.CalculateMD5(path)
-			if err != nil {
-				fmt.Printf("Failed to calculate file MD5 for %s: %v\n", path, err)
+			// 本次会话已确认且未被修改的文件直接使用记录的MD5
+			if md5, ok := s.checkpointMD5(relPath, info); ok {
+				fileInfo.MD5 = md5
+			} else if md5, err := utils.CalculateMD5(path); err != nil {
+				s.printf("Failed to calculate file MD5 for %s: %v\n", path, err)
 				// 继续执行，即使MD5计算失败
 			} else {
 				fileInfo.MD5 = md5
 			}
-		}
-
-		files = append(files, fileInfo)
 
-		return nil
-	}); err != nil {
-		return nil, err
-	}
+			// 文本文件比较统一换行符后的内容
+			if s.opts.TextMode.IsText(path) {
+				if textMD5, err := utils.NormalizedMD5(path); err == nil {
+					fileInfo.TextMD5 = textMD5
+				}
+			}
+		}
 
-	return files, nil
+		return fn(fileInfo)
+	})
 }
 
-// findFile 在文件列表中查找指定路径的文件
-func (s *Syncer) findFile(files []net.FileInfo, path string) *net.FileInfo {
-	for i := range files {
-		if files[i].Path == path {
-			return &files[i]
-		}
+// checkpointMD5 返回检查点中记录的、自确认后未被修改的本地文件的MD5
+func (s *Syncer) checkpointMD5(relPath string, info os.FileInfo) (string, bool) {
+	if s.checkpoint == nil {
+		return "", false
 	}
-	return nil
+	return s.checkpoint.lookup(relPath, info)
 }
 
-// isFileDifferent 检查文件是否不同
-func (s *Syncer) isFileDifferent(file1, file2 net.FileInfo) bool {
-	// 比较文件类型
-	if file1.IsDir != file2.IsDir {
+// isStateFile 检查路径是否为检查点、清单、历史、报告、删除宽限状态、抽样校验状态或文件名转换记录
+func (s *Syncer) isStateFile(path string) bool {
+	if path == s.graceStatePath() || path == s.budgetStatePath() || path == s.nameMapPath() || path == s.verifyStatePath() || path == s.markerPath() {
 		return true
 	}
-
-	// 比较文件大小
-	if file1.Size != file2.Size {
+	if s.checkpoint != nil && path == s.checkpoint.path {
 		return true
 	}
-
-	// 比较MD5值
-	if file1.MD5 != "" && file2.MD5 != "" && file1.MD5 != file2.MD5 {
-		return true
-	}
-
-	return false
+	return path == s.opts.Manifest || path == s.opts.History || path == s.opts.Report
 }
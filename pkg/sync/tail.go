@@ -0,0 +1,198 @@
+package sync
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gorsync/pkg/net"
+)
+
+// DefaultTailInterval 跟踪模式下两次检查远程文件大小之间的默认间隔
+const DefaultTailInterval = time.Second
+
+// tailRescanInterval 跟踪模式下重新获取远程文件列表以发现新文件的间隔
+const tailRescanInterval = time.Minute
+
+// tailedFile 跟踪中的文件及其本地内容的累计MD5
+type tailedFile struct {
+	path string    // 相对路径
+	size int64     // 已计入 hash 的本地文件大小
+	hash hash.Hash // 本地文件前 size 字节的MD5，Sum 不会重置状态，可以继续追加
+}
+
+// Tail 持续跟踪匹配 patterns 的远程文件，每隔 interval 检查一次大小并只下载新增的尾部，
+// 直到 stop 关闭。模式不含 / 时匹配文件名，否则匹配相对路径
+func (s *Syncer) Tail(patterns []string, interval time.Duration, stop <-chan struct{}) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid tail pattern %q: %v", pattern, err)
+		}
+	}
+	if interval <= 0 {
+		interval = DefaultTailInterval
+	}
+
+	client, err := s.newClient()
+	if err != nil {
+		return err
+	}
+	defer client.CloseIdleConnections()
+
+	tailed := make(map[string]*tailedFile)
+	var lastScan time.Time
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for index := 1; ; {
+		if time.Since(lastScan) >= tailRescanInterval {
+			if err := s.scanTailed(client, patterns, tailed); err != nil {
+				s.printf("Failed to list remote files: %v\n", err)
+			}
+			lastScan = time.Now()
+		}
+
+		paths := make([]string, 0, len(tailed))
+		for relPath := range tailed {
+			paths = append(paths, relPath)
+		}
+		sort.Strings(paths)
+		for _, relPath := range paths {
+			if s.followFile(client, tailed[relPath], index) {
+				index++
+			}
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// scanTailed 获取远程文件列表，把新出现的匹配文件加入跟踪
+func (s *Syncer) scanTailed(client *net.Client, patterns []string, tailed map[string]*tailedFile) error {
+	remoteFiles, _, err := s.listRemoteFiles(client)
+	if err != nil {
+		return err
+	}
+
+	for _, remoteFile := range remoteFiles {
+		relPath := remoteFile.Path
+		if remoteFile.IsDir || tailed[relPath] != nil || !matchTail(patterns, relPath) {
+			continue
+		}
+
+		t := &tailedFile{path: relPath}
+		if err := s.rehashTailed(t); err != nil {
+			s.printf("Failed to read %s: %v\n", relPath, err)
+			continue
+		}
+		tailed[relPath] = t
+		s.printf("Following %s\n", relPath)
+	}
+	return nil
+}
+
+// followFile 远程文件变大时下载新增的尾部，变小或开头被改写时完整下载，返回是否传输了数据
+func (s *Syncer) followFile(client *net.Client, t *tailedFile, index int) bool {
+	localPath := net.LocalPath(s.localPath, t.path)
+	remotePath := s.remoteWire(t.path)
+
+	remoteFile, err := client.Stat(remotePath)
+	if err != nil {
+		s.printf("Failed to stat %s: %v\n", t.path, err)
+		return false
+	}
+
+	// 本地文件在跟踪期间被其他程序修改时重新计算
+	if info, err := os.Stat(localPath); err != nil || info.Size() != t.size {
+		if err := s.rehashTailed(t); err != nil {
+			s.printf("Failed to read %s: %v\n", t.path, err)
+			return false
+		}
+	}
+	if remoteFile.Size == t.size {
+		return false
+	}
+
+	if remoteFile.Size > t.size && t.size > 0 {
+		localMD5 := fmt.Sprintf("%x", t.hash.Sum(nil))
+		err := client.AppendFile(remotePath, localPath, localMD5, index)
+		if err == nil {
+			if err := s.hashTail(t, localPath); err != nil {
+				s.printf("Failed to read %s: %v\n", t.path, err)
+			}
+			return true
+		}
+		if !errors.Is(err, net.ErrPrefixMismatch) {
+			s.printf("%d. failed to append file: %v\n", index, err)
+			return false
+		}
+		s.printf("%d. Remote file was rewritten, downloading in full: %s\n", index, t.path)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		s.printf("Failed to create directory for %s: %v\n", t.path, err)
+		return false
+	}
+	if err := client.DownloadFile(remotePath, localPath, index); err != nil {
+		s.printf("%d. failed to get file: %v\n", index, err)
+		return false
+	}
+	if err := s.rehashTailed(t); err != nil {
+		s.printf("Failed to read %s: %v\n", t.path, err)
+	}
+	return true
+}
+
+// rehashTailed 重新计算本地文件的MD5，本地文件不存在时视为空文件
+func (s *Syncer) rehashTailed(t *tailedFile) error {
+	t.size = 0
+	t.hash = md5.New()
+
+	localPath := net.LocalPath(s.localPath, t.path)
+	if _, err := os.Stat(localPath); os.IsNotExist(err) {
+		return nil
+	}
+	return s.hashTail(t, localPath)
+}
+
+// hashTail 把本地文件 size 之后的内容计入MD5
+func (s *Syncer) hashTail(t *tailedFile, localPath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(t.size, io.SeekStart); err != nil {
+		return err
+	}
+	n, err := io.Copy(t.hash, file)
+	t.size += n
+	return err
+}
+
+// matchTail 判断相对路径是否匹配任一跟踪模式
+func matchTail(patterns []string, relPath string) bool {
+	for _, pattern := range patterns {
+		name := relPath
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(relPath)
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package sync
+
+import (
+	"fmt"
+	"time"
+
+	"gorsync/pkg/net"
+	"gorsync/pkg/utils"
+)
+
+// 目标文件系统的兼容模式，用于同步到 U 盘、SD 卡等使用 FAT 类文件系统的设备
+const (
+	TargetFSNative = ""      // 不做调整
+	TargetFSFAT    = "fat"   // FAT32：单个文件小于 4GB
+	TargetFSExFAT  = "exfat" // exFAT：没有文件大小限制
+)
+
+// fatMaxFileSize FAT32 能保存的最大文件
+const fatMaxFileSize = 1<<32 - 1
+
+// fatModifyWindow FAT 类文件系统修改时间的精度
+const fatModifyWindow = 2 * time.Second
+
+// fatTarget 是否以 FAT 类文件系统为目标
+func (s *Syncer) fatTarget() bool {
+	return s.opts.TargetFS != TargetFSNative
+}
+
+// targetUnsupported 返回目标文件系统无法保存该远程文件的原因，可以保存时返回空字符串
+func (s *Syncer) targetUnsupported(f net.FileInfo) string {
+	if !s.fatTarget() {
+		return ""
+	}
+	switch f.Type {
+	case net.TypeDevice, net.TypePipe, net.TypeSocket, net.TypeOther:
+		return fmt.Sprintf("%s files cannot be stored on %s", f.Type, s.opts.TargetFS)
+	}
+	if !f.IsDir && s.opts.TargetFS == TargetFSFAT && f.Size > fatMaxFileSize {
+		return fmt.Sprintf("%s is larger than the 4GB FAT32 limit", utils.FormatSize(f.Size))
+	}
+	return ""
+}
@@ -0,0 +1,82 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gorsync/pkg/utils"
+)
+
+// ErrUnmounted 本地目录意外为空或换了文件系统，可能是备份盘没有挂载，同步没有修改任何文件
+var ErrUnmounted = errors.New("destination looks unmounted")
+
+// lastRun 返回历史文件中同一对远程和本地路径最近一次成功的同步，没有时返回 nil
+func (s *Syncer) lastRun() *RunSummary {
+	if s.opts.History == "" {
+		return nil
+	}
+	if _, err := os.Stat(s.opts.History); err != nil {
+		return nil
+	}
+	runs, err := LoadHistory(s.opts.History)
+	if err != nil {
+		s.printf("Failed to read history: %v\n", err)
+		return nil
+	}
+	for i := len(runs) - 1; i >= 0; i-- {
+		run := runs[i]
+		if run.Local == s.summary.Local && run.Remote == s.summary.Remote && run.Error == "" {
+			return &run
+		}
+	}
+	return nil
+}
+
+// checkDestination 记录本地目录所在的文件系统，并与历史文件中上一次成功的同步比较：
+// 文件系统变了，或上次同步了文件而本地目录现在是空的、远程仍有文件时，本地目录很可能是未挂载的挂载点，
+// 此时删除多余文件或重新下载整个目录都会造成损失，除非设置了 Force。没有 --history 时只记录不检查。
+// localEmpty 表示本地列表中没有任何文件
+func (s *Syncer) checkDestination(remoteFiles int, localEmpty bool) error {
+	volume, err := utils.VolumeID(s.localPath)
+	if err != nil {
+		s.printf("Failed to identify the filesystem of %s: %v\n", s.localPath, err)
+	}
+	s.mutex.Lock()
+	s.summary.Volume = volume
+	s.mutex.Unlock()
+
+	last := s.lastRun()
+	if last == nil || s.opts.Force {
+		return nil
+	}
+	if volume != "" && last.Volume != "" && volume != last.Volume {
+		return fmt.Errorf("%w: %s is on a different filesystem than at the last sync (%s, was %s), use --force if this is intended",
+			ErrUnmounted, s.localPath, volume, last.Volume)
+	}
+	empty := localEmpty
+	if empty && (len(s.opts.Subdirs) > 0 || s.glob != "") {
+		// 只列出了要同步的子目录或匹配的文件，其他路径下仍有文件时本地目录不是空的
+		empty = s.rootEmpty()
+	}
+	if empty && remoteFiles > 0 && last.FilesTotal > 0 {
+		return fmt.Errorf("%w: %s is empty but the last sync left %d file(s) there, use --force to download all %d remote file(s) again",
+			ErrUnmounted, s.localPath, last.FilesTotal, remoteFiles)
+	}
+	return nil
+}
+
+// rootEmpty 检查本地同步根目录中除状态文件外是否没有任何文件或目录
+func (s *Syncer) rootEmpty() bool {
+	entries, err := os.ReadDir(s.localPath)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		if !s.isStateFile(filepath.Join(s.localPath, entry.Name())) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorsync/pkg/utils"
+)
+
+// verifyStateFile 记录上次全部校验的时间的文件，位于同步根目录下
+const verifyStateFile = ".gorsync-verify.json"
+
+// DefaultFullVerify 抽样校验时全部校验一次的默认间隔
+const DefaultFullVerify = 7 * 24 * time.Hour
+
+// verifyState 上次校验了所有下载文件的同步
+type verifyState struct {
+	LastFull int64 `json:"lastFull"` // 完成时间（Unix 秒）
+}
+
+// ParseVerifySample 解析 N% 或 N 格式的抽样比例，范围为 1 到 100
+func ParseVerifySample(spec string) (int, error) {
+	percent, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(spec), "%"))
+	if err != nil || percent < 1 || percent > 100 {
+		return 0, fmt.Errorf("invalid verification sample %q, expected a percentage from 1%% to 100%%", spec)
+	}
+	return percent, nil
+}
+
+// verifyStatePath 返回抽样校验状态文件的路径
+func (s *Syncer) verifyStatePath() string {
+	return filepath.Join(s.localPath, verifyStateFile)
+}
+
+// planVerification 决定本次同步校验的比例：距离上次全部校验超过间隔（或从未全部校验）时校验所有文件，
+// 否则只校验 VerifySample 比例的随机抽样。返回 0 表示全部校验
+func (s *Syncer) planVerification() int {
+	if s.opts.VerifySample == 0 || s.opts.VerifySample == 100 {
+		return 0
+	}
+
+	var state verifyState
+	if data, err := os.ReadFile(s.verifyStatePath()); err == nil {
+		if err := json.Unmarshal(data, &state); err != nil {
+			s.printf("Ignoring invalid verification state: %v\n", err)
+		}
+	}
+	if state.LastFull == 0 {
+		s.printf("Verifying all downloaded files (no full verification recorded yet)\n")
+		return 0
+	}
+	since := time.Since(time.Unix(state.LastFull, 0))
+	if since >= s.opts.FullVerify {
+		s.printf("Verifying all downloaded files (last full verification %s ago)\n", since.Round(time.Minute))
+		return 0
+	}
+	s.printf("Verifying a %d%% sample of downloaded files (next full verification in %s)\n",
+		s.opts.VerifySample, (s.opts.FullVerify - since).Round(time.Minute))
+	return s.opts.VerifySample
+}
+
+// recordFullVerification 记录本次成功的同步校验了所有下载的文件
+func (s *Syncer) recordFullVerification() error {
+	data, err := json.Marshal(&verifyState{LastFull: time.Now().Unix()})
+	if err != nil {
+		return err
+	}
+
+	statePath := s.verifyStatePath()
+	tempPath := utils.MakeTempName(statePath)
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return err
+	}
+	if err := utils.Saferename(tempPath, statePath); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return nil
+}
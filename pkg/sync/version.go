@@ -0,0 +1,23 @@
+package sync
+
+import (
+	"errors"
+
+	"gorsync/pkg/net"
+)
+
+// checkServerVersion 同步开始前确认服务器的协议版本，版本不兼容或低于 MinServerVersion 时立即失败。
+// 未设置 MinServerVersion 时，不报告版本的旧版本服务器只提醒，连接失败留给列表请求报告
+func (s *Syncer) checkServerVersion(client *net.Client) error {
+	_, err := client.CheckVersion(s.opts.MinServerVersion)
+	if err == nil {
+		return nil
+	}
+	if s.opts.MinServerVersion > 0 || errors.Is(err, net.ErrVersionMismatch) {
+		return err
+	}
+	if errors.Is(err, net.ErrNoProbe) {
+		s.printf("Warning: %v\n", err)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"math"
+	"runtime/debug"
+	"sync"
+)
+
+// BufferSize 传输文件数据时每次读写的缓冲区大小
+const BufferSize = 64 * 1024
+
+// bufferShare 设置内存上限后，正在使用的传输缓冲区最多占上限的比例（分母），
+// 其余留给文件列表、JSON 解码和运行时本身
+const bufferShare = 4
+
+// bufferPool 复用传输缓冲区，避免每个文件、每个请求重新分配
+var bufferPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, BufferSize)
+		return &b
+	},
+}
+
+// memoryBudget 正在使用的传输缓冲区的总大小，超过上限时新的传输等待其他传输结束
+type memoryBudget struct {
+	mutex sync.Mutex
+	cond  *sync.Cond
+	limit int64 // 0 表示不限制
+	used  int64
+}
+
+var buffers = newMemoryBudget()
+
+func newMemoryBudget() *memoryBudget {
+	b := &memoryBudget{}
+	b.cond = sync.NewCond(&b.mutex)
+	return b
+}
+
+// SetMemoryLimit 设置进程的内存上限：运行时在接近上限时更积极地回收内存，
+// 同时把正在使用的传输缓冲区限制在上限的 1/4 以内。0 表示不限制
+func SetMemoryLimit(n int64) {
+	if n <= 0 {
+		debug.SetMemoryLimit(math.MaxInt64)
+	} else {
+		debug.SetMemoryLimit(n)
+	}
+
+	buffers.mutex.Lock()
+	defer buffers.mutex.Unlock()
+
+	if n > 0 {
+		buffers.limit = max(n/bufferShare, BufferSize)
+	} else {
+		buffers.limit = 0
+	}
+	buffers.cond.Broadcast()
+}
+
+// BufferMemory 返回正在使用的传输缓冲区的总大小和上限，上限为 0 表示不限制
+func BufferMemory() (used, limit int64) {
+	buffers.mutex.Lock()
+	defer buffers.mutex.Unlock()
+
+	return buffers.used, buffers.limit
+}
+
+// GetBuffer 从池中取出一个 BufferSize 大小的缓冲区，超过内存上限时等待其他传输归还，
+// 没有其他传输占用时总是允许，避免永远等待。用完后必须调用 PutBuffer
+func GetBuffer() *[]byte {
+	buffers.mutex.Lock()
+	for buffers.limit > 0 && buffers.used > 0 && buffers.used+BufferSize > buffers.limit {
+		buffers.cond.Wait()
+	}
+	buffers.used += BufferSize
+	buffers.mutex.Unlock()
+
+	return bufferPool.Get().(*[]byte)
+}
+
+// PutBuffer 把 GetBuffer 取出的缓冲区放回池中
+func PutBuffer(b *[]byte) {
+	bufferPool.Put(b)
+
+	buffers.mutex.Lock()
+	defer buffers.mutex.Unlock()
+
+	buffers.used -= BufferSize
+	buffers.cond.Broadcast()
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CaseInsensitiveDir 在 dir 中创建一个临时文件，再按全大写的名称查找，判断所在文件系统是否不区分文件名大小写。
+// macOS 的 APFS、HFS+ 以及 Windows 的 NTFS 都可能格式化为区分大小写，不能只按操作系统判断
+func CaseInsensitiveDir(dir string) (bool, error) {
+	probe := MakeTempName(filepath.Join(dir, "probe"))
+	file, err := os.OpenFile(probe, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return false, err
+	}
+	file.Close()
+	defer os.Remove(probe)
+
+	info, err := os.Stat(probe)
+	if err != nil {
+		return false, err
+	}
+	upper, err := os.Stat(filepath.Join(dir, strings.ToUpper(filepath.Base(probe))))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return os.SameFile(info, upper), nil
+}
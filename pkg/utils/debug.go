@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	rpprof "runtime/pprof"
+	"strconv"
+)
+
+// StartDebugServer 在本机地址上提供 net/http/pprof 的性能分析接口，返回实际监听的地址。
+// addr 可以只写端口，此时监听 127.0.0.1；为避免泄露运行状态，不接受非回环地址
+func StartDebugServer(addr string) (string, error) {
+	if _, err := strconv.Atoi(addr); err == nil {
+		addr = net.JoinHostPort("127.0.0.1", addr)
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid debug address: %v", err)
+	}
+	if host == "" {
+		return "", fmt.Errorf("debug address must name a loopback host: %s", addr)
+	}
+	if host != "localhost" {
+		ip := net.ParseIP(host)
+		if ip == nil || !ip.IsLoopback() {
+			return "", fmt.Errorf("debug address must be a loopback address: %s", addr)
+		}
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+
+	// 使用单独的路由，不影响 http.DefaultServeMux
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	go http.Serve(listener, mux)
+	return listener.Addr().String(), nil
+}
+
+// StartProfiles 开始记录 CPU 性能分析数据，返回的 stop 结束记录并写入堆内存分析数据。
+// 路径为空的分析不记录；stop 只在第一次调用时生效，可以同时用于 defer 和出错退出前
+func StartProfiles(cpuPath, heapPath string) (stop func(), err error) {
+	var cpuFile *os.File
+	if cpuPath != "" {
+		cpuFile, err = os.Create(cpuPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create CPU profile: %v", err)
+		}
+		if err := rpprof.StartCPUProfile(cpuFile); err != nil {
+			cpuFile.Close()
+			return nil, fmt.Errorf("failed to start CPU profile: %v", err)
+		}
+	}
+
+	stopped := false
+	return func() {
+		if stopped {
+			return
+		}
+		stopped = true
+
+		if cpuFile != nil {
+			rpprof.StopCPUProfile()
+			if err := cpuFile.Close(); err != nil {
+				fmt.Printf("Failed to write CPU profile: %v\n", err)
+			}
+		}
+		if heapPath != "" {
+			if err := writeHeapProfile(heapPath); err != nil {
+				fmt.Printf("Failed to write heap profile: %v\n", err)
+			}
+		}
+	}, nil
+}
+
+// writeHeapProfile 回收内存后写入堆内存分析数据，反映仍在使用的内存
+func writeHeapProfile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	runtime.GC()
+	if err := rpprof.WriteHeapProfile(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
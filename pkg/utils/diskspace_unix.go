@@ -0,0 +1,32 @@
+//go:build !windows
+
+package utils
+
+import (
+	"errors"
+	"strconv"
+	"syscall"
+)
+
+// FreeSpace 返回 path 所在文件系统中当前用户可用的字节数
+func FreeSpace(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
+
+// IsNoSpace 检查错误是否因磁盘空间（或配额）不足
+func IsNoSpace(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
+
+// VolumeID 返回 path 所在文件系统的标识（设备号），挂载点上换了文件系统或未挂载时会改变
+func VolumeID(path string) (string, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(uint64(st.Dev), 16), nil
+}
@@ -0,0 +1,59 @@
+//go:build windows
+
+package utils
+
+import (
+	"errors"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procGetDiskFreeSpaceExW   = modKernel32.NewProc("GetDiskFreeSpaceExW")
+	procGetVolumePathNameW    = modKernel32.NewProc("GetVolumePathNameW")
+	procGetVolumeInformationW = modKernel32.NewProc("GetVolumeInformationW")
+)
+
+const (
+	errorHandleDiskFull syscall.Errno = 39
+	errorDiskFull       syscall.Errno = 112
+)
+
+// FreeSpace 返回 path 所在卷中当前用户可用的字节数（考虑磁盘配额）
+func FreeSpace(path string) (uint64, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var available uint64
+	r, _, e := procGetDiskFreeSpaceExW.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&available)), 0, 0)
+	if r == 0 {
+		return 0, e
+	}
+	return available, nil
+}
+
+// IsNoSpace 检查错误是否因磁盘空间不足
+func IsNoSpace(err error) bool {
+	return errors.Is(err, errorDiskFull) || errors.Is(err, errorHandleDiskFull)
+}
+
+// VolumeID 返回 path 所在卷的序列号，换了磁盘或卷未挂载时会改变
+func VolumeID(path string) (string, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return "", err
+	}
+	var root [syscall.MAX_PATH + 1]uint16
+	r, _, e := procGetVolumePathNameW.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&root[0])), uintptr(len(root)))
+	if r == 0 {
+		return "", e
+	}
+	var serial uint32
+	r, _, e = procGetVolumeInformationW.Call(uintptr(unsafe.Pointer(&root[0])), 0, 0, uintptr(unsafe.Pointer(&serial)), 0, 0, 0, 0)
+	if r == 0 {
+		return "", e
+	}
+	return strconv.FormatUint(uint64(serial), 16), nil
+}
@@ -0,0 +1,30 @@
+//go:build linux && (amd64 || arm64)
+
+package utils
+
+import (
+	"os"
+	"syscall"
+)
+
+// fadvDontNeed 即 POSIX_FADV_DONTNEED
+const fadvDontNeed = 4
+
+// DropFileCache 将文件写入磁盘并丢弃其页缓存，使后续读取来自磁盘而非内存
+func DropFileCache(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := file.Sync(); err != nil {
+		return err
+	}
+
+	_, _, errno := syscall.Syscall6(syscall.SYS_FADVISE64, file.Fd(), 0, 0, fadvDontNeed, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build !(linux && (amd64 || arm64))
+
+package utils
+
+import "os"
+
+// DropFileCache 其他平台无法丢弃页缓存，只将文件写入磁盘
+func DropFileCache(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return file.Sync()
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+)
+
+// 事件日志中的事件级别
+const (
+	eventInfo = iota
+	eventWarning
+	eventError
+)
+
+// EventLog 把输出按行写入系统事件日志的 io.Writer，用于没有控制台的 Windows 服务
+type EventLog struct {
+	mutex   sync.Mutex
+	source  eventSource
+	pending []byte // 还没有遇到换行符的部分
+}
+
+// OpenEventLog 以 source 为事件来源打开事件日志
+func OpenEventLog(source string) (*EventLog, error) {
+	handle, err := openEventSource(source)
+	if err != nil {
+		return nil, err
+	}
+	return &EventLog{source: handle}, nil
+}
+
+// Write 每一行写成一条事件，最后不完整的一行等到换行符或 Close 时写入
+func (e *EventLog) Write(p []byte) (int, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.pending = append(e.pending, p...)
+	for {
+		i := bytes.IndexByte(e.pending, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimRight(string(e.pending[:i]), "\r")
+		e.pending = e.pending[i+1:]
+		if line != "" {
+			if err := e.source.report(eventLevel(line), line); err != nil {
+				return len(p), err
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// Close 写入剩余的输出并关闭事件来源
+func (e *EventLog) Close() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if len(e.pending) > 0 {
+		line := string(e.pending)
+		e.pending = nil
+		e.source.report(eventLevel(line), line)
+	}
+	return e.source.close()
+}
+
+// eventLevel 按输出的内容判断事件级别：失败和错误为错误事件，Warning 开头的为警告事件
+func eventLevel(line string) int {
+	lower := strings.ToLower(line)
+	switch {
+	case strings.Contains(lower, "failed") || strings.Contains(lower, "error"):
+		return eventError
+	case strings.HasPrefix(lower, "warning"):
+		return eventWarning
+	}
+	return eventInfo
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+
+package utils
+
+import "errors"
+
+// eventSource 其他系统没有 Windows 事件日志
+type eventSource struct{}
+
+func openEventSource(source string) (eventSource, error) {
+	return eventSource{}, errors.New("the event log is only available on Windows; use --log-file or the service manager's log")
+}
+
+func (eventSource) report(level int, message string) error {
+	return nil
+}
+
+func (eventSource) close() error {
+	return nil
+}
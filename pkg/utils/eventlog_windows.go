@@ -0,0 +1,78 @@
+//go:build windows
+
+package utils
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procRegisterEventSourceW  = modAdvapi32.NewProc("RegisterEventSourceW")
+	procReportEventW          = modAdvapi32.NewProc("ReportEventW")
+	procDeregisterEventSource = modAdvapi32.NewProc("DeregisterEventSource")
+)
+
+const (
+	eventlogErrorType       = 0x0001
+	eventlogWarningType     = 0x0002
+	eventlogInformationType = 0x0004
+	// eventID 所有事件使用同一个事件 ID，内容完全由写入的文本说明
+	eventID = 1
+)
+
+// eventTypes 事件级别对应的 ReportEventW 事件类型
+var eventTypes = [...]uintptr{
+	eventInfo:    eventlogInformationType,
+	eventWarning: eventlogWarningType,
+	eventError:   eventlogErrorType,
+}
+
+// eventSource RegisterEventSourceW 返回的事件来源句柄。来源没有在注册表中登记消息文件时，
+// 事件查看器会提示找不到事件 ID 的描述，但仍然显示写入的文本
+type eventSource syscall.Handle
+
+func openEventSource(source string) (eventSource, error) {
+	sourcePtr, err := syscall.UTF16PtrFromString(source)
+	if err != nil {
+		return 0, err
+	}
+	r1, _, err := procRegisterEventSourceW.Call(0, uintptr(unsafe.Pointer(sourcePtr)))
+	if r1 == 0 {
+		return 0, err
+	}
+	return eventSource(r1), nil
+}
+
+// report 写入一条只包含一个字符串的事件
+func (s eventSource) report(level int, message string) error {
+	messagePtr, err := syscall.UTF16PtrFromString(message)
+	if err != nil {
+		// 含有 NUL 字符的输出不能作为字符串写入
+		messagePtr, _ = syscall.UTF16PtrFromString("(output contains NUL characters)")
+	}
+	messages := [1]*uint16{messagePtr}
+	r1, _, err := procReportEventW.Call(
+		uintptr(s),
+		eventTypes[level],
+		0,
+		eventID,
+		0,
+		1,
+		0,
+		uintptr(unsafe.Pointer(&messages[0])),
+		0,
+	)
+	if r1 == 0 {
+		return err
+	}
+	return nil
+}
+
+func (s eventSource) close() error {
+	r1, _, err := procDeregisterEventSource.Call(uintptr(s))
+	if r1 == 0 {
+		return err
+	}
+	return nil
+}
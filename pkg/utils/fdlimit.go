@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"sync"
+)
+
+// fdReserve 留给标准输入输出、监听器、日志等不经过限制的描述符
+const fdReserve = 32
+
+// minOpenFiles 自动检测时的最小限制，rlimit 很小时仍能进行基本的传输
+const minOpenFiles = 8
+
+// 名额的使用方：同一进程中服务器的请求处理可能发起对外的连接（pull、selftest），
+// 两者各自保留一部分名额，接受的连接占满名额时对外的连接仍能建立，反之亦然，不会互相等待而死锁
+const (
+	clientFiles = iota // 对外的连接及其读写的文件
+	serverFiles        // 服务器接受的连接及其读写的文件
+)
+
+// reserveShare 每个使用方保留的名额为上限的这个分之一
+const reserveShare = 4
+
+// fdSemaphore 全局的文件描述符名额：每个连接和它读写的文件在打开前申请名额，
+// 并行下载和同时处理的请求较多时排队等待，而不是在超过 ulimit -n 后以
+// "too many open files" 之类难以理解的错误失败
+type fdSemaphore struct {
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	limit   int    // 0 表示不限制
+	used    [2]int // 按使用方统计
+	serving bool   // 服务器已开始接受连接，之后为服务器保留名额；只作为客户端的进程可以使用全部名额
+}
+
+var openFiles = newFDSemaphore(defaultMaxOpenFiles())
+
+func newFDSemaphore(limit int) *fdSemaphore {
+	s := &fdSemaphore{limit: limit}
+	s.cond = sync.NewCond(&s.mutex)
+	return s
+}
+
+// reserved 返回为使用方保留的名额，调用时需持有锁
+func (s *fdSemaphore) reserved(kind int) int {
+	if kind == serverFiles && !s.serving {
+		return 0
+	}
+	return s.limit / reserveShare
+}
+
+// available 检查使用方能否再申请 n 个名额：另一方未用完的保留名额不可占用。
+// 超过上限的申请在没有其他占用时也允许，避免永远等待。调用时需持有锁
+func (s *fdSemaphore) available(kind, n int) bool {
+	other := 1 - kind
+	if s.limit <= 0 || s.used[kind]+s.used[other] == 0 {
+		return true
+	}
+	return s.used[kind]+n+max(s.used[other], s.reserved(other)) <= s.limit
+}
+
+// acquire 等待使用方的 n 个名额
+func (s *fdSemaphore) acquire(kind, n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if kind == serverFiles {
+		s.serving = true
+	}
+	for !s.available(kind, n) {
+		s.cond.Wait()
+	}
+	s.used[kind] += n
+}
+
+// release 归还使用方的名额
+func (s *fdSemaphore) release(kind, n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.used[kind] -= n
+	s.cond.Broadcast()
+}
+
+// defaultMaxOpenFiles 按进程的文件描述符上限计算默认的名额，平台没有上限时返回 0
+func defaultMaxOpenFiles() int {
+	limit := fdLimit()
+	if limit <= 0 {
+		return 0
+	}
+	return max(limit-fdReserve, minOpenFiles)
+}
+
+// SetMaxOpenFiles 设置同时打开的文件和连接的最大数量，0 表示按进程的文件描述符上限自动计算
+func SetMaxOpenFiles(n int) {
+	if n <= 0 {
+		n = defaultMaxOpenFiles()
+	}
+
+	openFiles.mutex.Lock()
+	defer openFiles.mutex.Unlock()
+
+	openFiles.limit = n
+	openFiles.cond.Broadcast()
+}
+
+// MaxOpenFiles 返回同时打开的文件和连接的最大数量，0 表示不限制
+func MaxOpenFiles() int {
+	openFiles.mutex.Lock()
+	defer openFiles.mutex.Unlock()
+
+	return openFiles.limit
+}
+
+// OpenFiles 返回当前占用的名额数
+func OpenFiles() int {
+	openFiles.mutex.Lock()
+	defer openFiles.mutex.Unlock()
+
+	return openFiles.used[clientFiles] + openFiles.used[serverFiles]
+}
+
+// AcquireFiles 为对外的连接等待 n 个文件描述符名额
+func AcquireFiles(n int) {
+	openFiles.acquire(clientFiles, n)
+}
+
+// TryAcquireFiles 不等待地为对外的连接申请 n 个名额，名额不足时返回 false
+func TryAcquireFiles(n int) bool {
+	openFiles.mutex.Lock()
+	defer openFiles.mutex.Unlock()
+
+	if !openFiles.available(clientFiles, n) {
+		return false
+	}
+	openFiles.used[clientFiles] += n
+	return true
+}
+
+// ReleaseFiles 归还 AcquireFiles 或 TryAcquireFiles 申请的名额
+func ReleaseFiles(n int) {
+	openFiles.release(clientFiles, n)
+}
+
+// AcquireServerFiles 为服务器接受的连接等待 n 个名额，此后进程为服务器保留一部分名额
+func AcquireServerFiles(n int) {
+	openFiles.acquire(serverFiles, n)
+}
+
+// ReleaseServerFiles 归还 AcquireServerFiles 申请的名额
+func ReleaseServerFiles(n int) {
+	openFiles.release(serverFiles, n)
+}
@@ -0,0 +1,23 @@
+//go:build !windows
+
+package utils
+
+import (
+	"syscall"
+)
+
+// maxDetectedFiles rlimit 为无限大时使用的上限
+const maxDetectedFiles = 1 << 20
+
+// fdLimit 返回进程可以打开的文件描述符数（RLIMIT_NOFILE 的软限制）。
+// Go 运行时启动时已把软限制提高到硬限制
+func fdLimit() int {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0
+	}
+	if rl.Cur > maxDetectedFiles {
+		return maxDetectedFiles
+	}
+	return int(rl.Cur)
+}
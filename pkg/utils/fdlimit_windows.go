@@ -0,0 +1,8 @@
+//go:build windows
+
+package utils
+
+// fdLimit Windows 的句柄数没有类似 ulimit -n 的进程上限，默认不限制
+func fdLimit() int {
+	return 0
+}
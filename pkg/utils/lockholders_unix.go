@@ -0,0 +1,8 @@
+//go:build !windows
+
+package utils
+
+// LockHolders 其他平台重命名不会因文件被打开而失败，无需查询
+func LockHolders(path string) ([]string, error) {
+	return nil, nil
+}
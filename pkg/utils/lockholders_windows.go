@@ -0,0 +1,75 @@
+//go:build windows
+
+package utils
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modRstrtmgr             = syscall.NewLazyDLL("rstrtmgr.dll")
+	procRmStartSession      = modRstrtmgr.NewProc("RmStartSession")
+	procRmRegisterResources = modRstrtmgr.NewProc("RmRegisterResources")
+	procRmGetList           = modRstrtmgr.NewProc("RmGetList")
+	procRmEndSession        = modRstrtmgr.NewProc("RmEndSession")
+)
+
+const errorMoreData = 234
+
+// rmProcessInfo 对应 Restart Manager 的 RM_PROCESS_INFO
+type rmProcessInfo struct {
+	ProcessID        uint32
+	ProcessStartTime syscall.Filetime
+	AppName          [256]uint16
+	ServiceShortName [64]uint16
+	ApplicationType  uint32
+	AppStatus        uint32
+	TSSessionID      uint32
+	Restartable      int32
+}
+
+// LockHolders 通过 Restart Manager 查询打开了指定文件的进程
+func LockHolders(path string) ([]string, error) {
+	if err := modRstrtmgr.Load(); err != nil {
+		return nil, err
+	}
+
+	var session uint32
+	var key [33]uint16
+	if ret, _, _ := procRmStartSession.Call(uintptr(unsafe.Pointer(&session)), 0, uintptr(unsafe.Pointer(&key[0]))); ret != 0 {
+		return nil, fmt.Errorf("RmStartSession failed: %d", ret)
+	}
+	defer procRmEndSession.Call(uintptr(session))
+
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	if ret, _, _ := procRmRegisterResources.Call(uintptr(session), 1, uintptr(unsafe.Pointer(&name)), 0, 0, 0, 0); ret != 0 {
+		return nil, fmt.Errorf("RmRegisterResources failed: %d", ret)
+	}
+
+	var needed, count uint32
+	var reasons uint32
+	ret, _, _ := procRmGetList.Call(uintptr(session), uintptr(unsafe.Pointer(&needed)), uintptr(unsafe.Pointer(&count)), 0, uintptr(unsafe.Pointer(&reasons)))
+	if ret == 0 || needed == 0 {
+		return nil, nil
+	}
+	if ret != errorMoreData {
+		return nil, fmt.Errorf("RmGetList failed: %d", ret)
+	}
+
+	infos := make([]rmProcessInfo, needed)
+	count = needed
+	if ret, _, _ := procRmGetList.Call(uintptr(session), uintptr(unsafe.Pointer(&needed)), uintptr(unsafe.Pointer(&count)), uintptr(unsafe.Pointer(&infos[0])), uintptr(unsafe.Pointer(&reasons))); ret != 0 {
+		return nil, fmt.Errorf("RmGetList failed: %d", ret)
+	}
+
+	holders := make([]string, 0, count)
+	for _, info := range infos[:count] {
+		holders = append(holders, fmt.Sprintf("%s (pid %d)", syscall.UTF16ToString(info.AppName[:]), info.ProcessID))
+	}
+	return holders, nil
+}
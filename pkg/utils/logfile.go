@@ -0,0 +1,186 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatedSuffix 轮转后的日志文件名后缀格式，精确到毫秒，同一秒内多次轮转不会覆盖之前的旧文件
+const rotatedSuffix = "20060102-150405.000"
+
+// legacyRotatedSuffix 旧版本精确到秒的后缀，清理时仍然识别
+const legacyRotatedSuffix = "20060102-150405"
+
+// RotatingFile 按大小和时间轮转的日志文件
+type RotatingFile struct {
+	path       string
+	maxSize    int64         // 单个文件的最大字节数，0 表示不按大小轮转
+	interval   time.Duration // 轮转间隔，0 表示不按时间轮转
+	maxBackups int           // 保留的旧文件数，0 表示不限制
+	maxAge     time.Duration // 旧文件保留时间，0 表示不限制
+
+	mutex   sync.Mutex
+	file    *os.File
+	size    int64
+	opened  time.Time
+	rotated time.Time // 上次轮转使用的时间戳，之后的轮转时间戳总是更晚，旧文件按名称排序即按时间排序
+}
+
+// NewRotatingFile 打开（或追加到）日志文件
+func NewRotatingFile(path string, maxSize int64, interval time.Duration, maxBackups int, maxAge time.Duration) (*RotatingFile, error) {
+	r := &RotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		interval:   interval,
+		maxBackups: maxBackups,
+		maxAge:     maxAge,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open 打开日志文件，已存在时从其修改时间开始计算轮转间隔
+func (r *RotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %v", err)
+	}
+
+	r.file = file
+	r.size = info.Size()
+	r.opened = time.Now()
+	if r.size > 0 {
+		r.opened = info.ModTime()
+	}
+	return nil
+}
+
+// Write 写入日志，超过大小或时间限制时先轮转
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.size > 0 && ((r.maxSize > 0 && r.size+int64(len(p)) > r.maxSize) ||
+		(r.interval > 0 && time.Since(r.opened) >= r.interval)) {
+		if err := r.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to rotate log file: %v\n", err)
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate 将当前文件改名为带时间戳的旧文件，打开新文件并清理过期的旧文件
+func (r *RotatingFile) rotate() error {
+	r.file.Close()
+
+	// 同一毫秒内已轮转过时顺延，改名不能覆盖已有的旧文件
+	now := time.Now().Truncate(time.Millisecond)
+	if !now.After(r.rotated) {
+		now = r.rotated.Add(time.Millisecond)
+	}
+	rotated := r.path + "." + now.Format(rotatedSuffix)
+	for {
+		if _, err := os.Lstat(rotated); err != nil {
+			break
+		}
+		now = now.Add(time.Millisecond)
+		rotated = r.path + "." + now.Format(rotatedSuffix)
+	}
+	r.rotated = now
+	if err := os.Rename(r.path, rotated); err != nil {
+		// 改名失败时继续写入原文件
+		if openErr := r.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+
+	if err := r.open(); err != nil {
+		return err
+	}
+
+	r.cleanup()
+	return nil
+}
+
+// cleanup 删除超过数量或保留时间的旧日志文件
+func (r *RotatingFile) cleanup() {
+	matches, err := filepath.Glob(r.path + ".*")
+	if err != nil {
+		return
+	}
+
+	var backups []string
+	for _, m := range matches {
+		suffix := strings.TrimPrefix(m, r.path+".")
+		if _, err := time.Parse(rotatedSuffix, suffix); err == nil {
+			backups = append(backups, m)
+		} else if _, err := time.Parse(legacyRotatedSuffix, suffix); err == nil {
+			backups = append(backups, m)
+		}
+	}
+	// 时间戳后缀按字典序即按时间排序，新的在前
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+
+	for i, backup := range backups {
+		expired := r.maxBackups > 0 && i >= r.maxBackups
+		if !expired && r.maxAge > 0 {
+			if info, err := os.Stat(backup); err == nil && time.Since(info.ModTime()) > r.maxAge {
+				expired = true
+			}
+		}
+		if expired {
+			os.Remove(backup)
+		}
+	}
+}
+
+// Close 关闭日志文件
+func (r *RotatingFile) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.file.Close()
+}
+
+// RedirectOutput 将标准输出、标准错误和 log 包的输出重定向到 w，返回的函数在退出前调用以写完剩余输出
+func RedirectOutput(w io.Writer) (func(), error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(w, reader)
+		close(done)
+	}()
+
+	os.Stdout = writer
+	os.Stderr = writer
+	// log.Fatal 会直接退出进程，直接写入以免丢失最后的错误信息
+	log.SetOutput(w)
+
+	return func() {
+		writer.Close()
+		<-done
+	}, nil
+}
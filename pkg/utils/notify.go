@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"net"
+	"os"
+)
+
+// SdNotify 向 systemd 发送服务状态（例如 READY=1），未由 systemd 以 Type=notify 启动时什么也不做
+func SdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	// 以 @ 开头的是抽象命名空间套接字
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
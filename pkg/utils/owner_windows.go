@@ -0,0 +1,10 @@
+//go:build windows
+
+package utils
+
+import "os"
+
+// FileOwner Windows 没有 uid/gid，总是返回 ok == false
+func FileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// CheckPIDFile 检查 PID 文件，文件中的进程仍在运行时返回错误，残留的 PID 文件视为可覆盖
+func CheckPIDFile(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read pid file: %v", err)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		fmt.Printf("Ignoring invalid pid file: %s\n", path)
+		return nil
+	}
+	if pid != os.Getpid() && processAlive(pid) {
+		return fmt.Errorf("another instance is already running (pid %d, pid file %s)", pid, path)
+	}
+
+	fmt.Printf("Removing stale pid file: %s (pid %d)\n", path, pid)
+	return nil
+}
+
+// WritePIDFile 将当前进程号写入 PID 文件
+func WritePIDFile(path string) error {
+	tempPath := MakeTempName(path)
+	if err := os.WriteFile(tempPath, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		return err
+	}
+	if err := Saferename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return nil
+}
+
+// RemovePIDFile 删除属于当前进程的 PID 文件
+func RemovePIDFile(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && pid == os.Getpid() {
+		os.Remove(path)
+	}
+}
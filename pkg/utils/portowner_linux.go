@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// tcpListen /proc/net/tcp 中监听状态的编码
+const tcpListen = "0A"
+
+// IsAddrInUse 检查监听失败是否因为端口已被占用
+func IsAddrInUse(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE)
+}
+
+// PortOwners 查询在指定 TCP 端口上监听的进程，返回 "名称 (pid N)"。
+// 通过 /proc/net/tcp 找到套接字，再在 /proc/<pid>/fd 中查找，其他用户的进程需要 root 权限才能找到
+func PortOwners(port int) ([]string, error) {
+	sockets := make(map[string]bool)
+	for _, table := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		data, err := os.ReadFile(table)
+		if err != nil {
+			continue
+		}
+		lines := strings.Split(string(data), "\n")
+		for _, line := range lines[1:] {
+			// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+			fields := strings.Fields(line)
+			if len(fields) < 10 || fields[3] != tcpListen {
+				continue
+			}
+			_, localPort, ok := strings.Cut(fields[1], ":")
+			if !ok {
+				continue
+			}
+			if p, err := strconv.ParseUint(localPort, 16, 16); err == nil && int(p) == port {
+				sockets[fmt.Sprintf("socket:[%s]", fields[9])] = true
+			}
+		}
+	}
+	if len(sockets) == 0 {
+		return nil, nil
+	}
+
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	var owners []string
+	for _, proc := range procs {
+		pid, err := strconv.Atoi(proc.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join("/proc", proc.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			if target, err := os.Readlink(filepath.Join(fdDir, fd.Name())); err == nil && sockets[target] {
+				name, _ := os.ReadFile(filepath.Join("/proc", proc.Name(), "comm"))
+				owners = append(owners, fmt.Sprintf("%s (pid %d)", strings.TrimSpace(string(name)), pid))
+				break
+			}
+		}
+	}
+	return owners, nil
+}
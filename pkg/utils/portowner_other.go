@@ -0,0 +1,42 @@
+//go:build !linux && !windows
+
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// IsAddrInUse 检查监听失败是否因为端口已被占用
+func IsAddrInUse(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE)
+}
+
+// PortOwners 通过 lsof 查询在指定 TCP 端口上监听的进程，返回 "名称 (pid N)"
+func PortOwners(port int) ([]string, error) {
+	// -F pc 每个进程输出 p<pid> 和 c<命令名> 两行
+	out, err := exec.Command("lsof", "-nP", fmt.Sprintf("-iTCP:%d", port), "-sTCP:LISTEN", "-Fpc").Output()
+	if err != nil {
+		// 没有匹配的进程时 lsof 也以 1 退出
+		if _, ok := err.(*exec.ExitError); ok && len(out) == 0 {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var owners []string
+	pid := ""
+	for _, line := range strings.Split(string(out), "\n") {
+		switch {
+		case strings.HasPrefix(line, "p"):
+			pid = line[1:]
+		case strings.HasPrefix(line, "c") && pid != "":
+			owners = append(owners, fmt.Sprintf("%s (pid %s)", line[1:], pid))
+			pid = ""
+		}
+	}
+	return owners, nil
+}
@@ -0,0 +1,112 @@
+//go:build windows
+
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modIphlpapi             = syscall.NewLazyDLL("iphlpapi.dll")
+	procGetExtendedTcpTable = modIphlpapi.NewProc("GetExtendedTcpTable")
+
+	procQueryFullProcessImageNameW = modKernel32.NewProc("QueryFullProcessImageNameW")
+)
+
+const (
+	// errorAddrInUse WSAEADDRINUSE
+	errorAddrInUse = syscall.Errno(10048)
+	// tcpTableOwnerPIDListener TCP_TABLE_OWNER_PID_LISTENER，只列出监听的套接字及其进程
+	tcpTableOwnerPIDListener = 3
+	afInet                   = 2
+	afInet6                  = 23
+)
+
+// IsAddrInUse 检查监听失败是否因为端口已被占用
+func IsAddrInUse(err error) bool {
+	return errors.Is(err, errorAddrInUse)
+}
+
+// PortOwners 通过 GetExtendedTcpTable 查询在指定 TCP 端口上监听的进程，返回 "名称 (pid N)"
+func PortOwners(port int) ([]string, error) {
+	if err := modIphlpapi.Load(); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[uint32]bool)
+	var owners []string
+	// MIB_TCPROW_OWNER_PID 和 MIB_TCP6ROW_OWNER_PID 中本地端口和进程ID的偏移
+	for _, family := range []struct {
+		af                     uintptr
+		rowSize, portAt, pidAt int
+	}{
+		{afInet, 24, 8, 20},
+		{afInet6, 56, 20, 52},
+	} {
+		table, err := tcpTable(family.af)
+		if err != nil {
+			return owners, err
+		}
+		if len(table) < 4 {
+			continue
+		}
+		count := int(binary.LittleEndian.Uint32(table))
+		for i := 0; i < count; i++ {
+			row := table[4+i*family.rowSize:]
+			if len(row) < family.rowSize {
+				break
+			}
+			// 端口以网络字节序存放在低 16 位
+			if int(binary.BigEndian.Uint16(row[family.portAt:])) != port {
+				continue
+			}
+			pid := binary.LittleEndian.Uint32(row[family.pidAt:])
+			if !seen[pid] {
+				seen[pid] = true
+				owners = append(owners, fmt.Sprintf("%s (pid %d)", processImageName(pid), pid))
+			}
+		}
+	}
+	return owners, nil
+}
+
+// tcpTable 读取指定地址族的监听套接字表
+func tcpTable(af uintptr) ([]byte, error) {
+	var size uint32
+	procGetExtendedTcpTable.Call(0, uintptr(unsafe.Pointer(&size)), 0, af, tcpTableOwnerPIDListener, 0)
+	for size > 0 {
+		buf := make([]byte, size)
+		ret, _, _ := procGetExtendedTcpTable.Call(uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size)), 0, af, tcpTableOwnerPIDListener, 0)
+		switch syscall.Errno(ret) {
+		case 0:
+			return buf, nil
+		case syscall.ERROR_INSUFFICIENT_BUFFER:
+			// 两次调用之间表变大了，按新的大小重试
+			continue
+		default:
+			return nil, fmt.Errorf("GetExtendedTcpTable failed: %d", ret)
+		}
+	}
+	return nil, nil
+}
+
+// processImageName 返回进程的可执行文件名，没有权限查询时返回 "unknown"
+func processImageName(pid uint32) string {
+	handle, err := syscall.OpenProcess(processQueryLimitedInformation, false, pid)
+	if err != nil {
+		return "unknown"
+	}
+	defer syscall.CloseHandle(handle)
+
+	var name [syscall.MAX_PATH]uint16
+	size := uint32(len(name))
+	if ret, _, _ := procQueryFullProcessImageNameW.Call(uintptr(handle), 0, uintptr(unsafe.Pointer(&name[0])), uintptr(unsafe.Pointer(&size))); ret == 0 {
+		return "unknown"
+	}
+	return filepath.Base(syscall.UTF16ToString(name[:size]))
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package utils
+
+import (
+	"errors"
+	"syscall"
+)
+
+// processAlive 检查进程是否存在，没有权限发送信号的进程也视为存在
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
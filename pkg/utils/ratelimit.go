@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BandwidthRule 某个时间段内的带宽限制，Start/End 为一天中的分钟数
+type BandwidthRule struct {
+	Start int
+	End   int
+	Limit int64 // 每秒字节数，0 表示不限速
+}
+
+// BandwidthSchedule 按一天中的时间段设置的带宽限制
+type BandwidthSchedule struct {
+	Rules   []BandwidthRule
+	Default int64 // 不在任何时间段内时的限制，0 表示不限速
+}
+
+// ParseBandwidthSchedule 解析带宽限制，格式: 10MB 或 10MB@08:00-20:00,1MB@20:00-23:00,0
+// 不带时间段的项作为默认限制
+func ParseBandwidthSchedule(spec string) (*BandwidthSchedule, error) {
+	schedule := &BandwidthSchedule{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		limitStr, window, hasWindow := strings.Cut(item, "@")
+		limit, err := ParseSize(limitStr)
+		if err != nil {
+			return nil, err
+		}
+
+		if !hasWindow {
+			schedule.Default = limit
+			continue
+		}
+
+		startStr, endStr, ok := strings.Cut(window, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid time window: %s", window)
+		}
+		start, err := parseClock(startStr)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseClock(endStr)
+		if err != nil {
+			return nil, err
+		}
+
+		schedule.Rules = append(schedule.Rules, BandwidthRule{Start: start, End: end, Limit: limit})
+	}
+
+	return schedule, nil
+}
+
+// LimitAt 返回指定时间的带宽限制
+func (s *BandwidthSchedule) LimitAt(t time.Time) int64 {
+	minute := t.Hour()*60 + t.Minute()
+	for _, rule := range s.Rules {
+		if rule.Start <= rule.End {
+			if minute >= rule.Start && minute < rule.End {
+				return rule.Limit
+			}
+		} else if minute >= rule.Start || minute < rule.End {
+			// 跨越午夜的时间段，例如 22:00-06:00
+			return rule.Limit
+		}
+	}
+	return s.Default
+}
+
+// RateLimiter 按带宽计划限制传输速度，每次调用 Wait 时重新计算当前限制
+type RateLimiter struct {
+	mutex    sync.Mutex
+	schedule *BandwidthSchedule
+	limit    int64
+	start    time.Time
+	bytes    int64
+}
+
+// NewRateLimiter 创建新的限速器
+func NewRateLimiter(schedule *BandwidthSchedule) *RateLimiter {
+	return &RateLimiter{
+		schedule: schedule,
+		limit:    -1,
+	}
+}
+
+// Wait 记录已传输的 n 个字节，必要时休眠以满足当前限制
+func (l *RateLimiter) Wait(n int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	limit := l.schedule.LimitAt(now)
+	if limit != l.limit {
+		// 限制发生变化时重新开始计时
+		l.limit = limit
+		l.start = now
+		l.bytes = 0
+	}
+
+	if limit <= 0 {
+		return
+	}
+
+	l.bytes += int64(n)
+	expected := time.Duration(float64(l.bytes) / float64(limit) * float64(time.Second))
+	if elapsed := now.Sub(l.start); expected > elapsed {
+		time.Sleep(expected - elapsed)
+	}
+}
+
+// ParseSize 解析带单位的大小，例如 512KB、10MB、1GB，纯数字表示字节数
+func ParseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{
+		{"GB", 1024 * 1024 * 1024},
+		{"MB", 1024 * 1024},
+		{"KB", 1024},
+		{"G", 1024 * 1024 * 1024},
+		{"M", 1024 * 1024},
+		{"K", 1024},
+		{"B", 1},
+	} {
+		if strings.HasSuffix(s, unit.suffix) {
+			multiplier = unit.size
+			s = strings.TrimSuffix(s, unit.suffix)
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || value < 0 || math.IsInf(value, 0) || math.IsNaN(value) {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+
+	return int64(value * float64(multiplier)), nil
+}
+
+// parseClock 解析 HH:MM 格式的时间，返回一天中的分钟数
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time: %s", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
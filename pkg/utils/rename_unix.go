@@ -0,0 +1,18 @@
+//go:build !windows
+
+package utils
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isCrossDevice 检查重命名是否因源和目标位于不同文件系统而失败
+func isCrossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
+
+// isSharingViolation 只有 Windows 会因文件被其他进程打开而拒绝重命名
+func isSharingViolation(err error) bool {
+	return false
+}
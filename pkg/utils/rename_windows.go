@@ -0,0 +1,25 @@
+//go:build windows
+
+package utils
+
+import (
+	"errors"
+	"syscall"
+)
+
+const (
+	errorNotSameDevice    syscall.Errno = 17
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+)
+
+// isCrossDevice 检查重命名是否因源和目标位于不同卷而失败
+func isCrossDevice(err error) bool {
+	return errors.Is(err, errorNotSameDevice)
+}
+
+// isSharingViolation 检查重命名是否因目标文件被其他进程（杀毒软件、索引服务等）打开而失败。
+// 拒绝访问是权限问题，重试不会成功，不算在内
+func isSharingViolation(err error) bool {
+	return errors.Is(err, errorSharingViolation) || errors.Is(err, errorLockViolation)
+}
@@ -0,0 +1,9 @@
+package utils
+
+// ServiceConfig 注册为系统服务的监听模式
+type ServiceConfig struct {
+	Name        string   // systemd 单元名（不含 .service）或 Windows 服务名
+	Description string   // 服务的说明
+	Executable  string   // gorsync 可执行文件的绝对路径
+	Args        []string // 监听模式的命令行参数
+}
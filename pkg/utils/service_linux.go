@@ -0,0 +1,106 @@
+//go:build linux
+
+package utils
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// systemdUnitDir 系统管理员安装的 systemd 单元所在的目录
+const systemdUnitDir = "/etc/systemd/system"
+
+// unitPath 返回服务的单元文件路径
+func unitPath(name string) string {
+	return filepath.Join(systemdUnitDir, name+".service")
+}
+
+// InstallService 写入 Type=notify 的 systemd 单元并设为开机启动，不立即启动。单元已存在时返回错误
+func InstallService(config ServiceConfig) error {
+	path := unitPath(config.Name)
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists; uninstall the service first", path)
+	}
+
+	command := make([]string, 0, len(config.Args)+1)
+	for _, arg := range append([]string{config.Executable}, config.Args...) {
+		command = append(command, systemdQuote(arg))
+	}
+	unit := fmt.Sprintf(`[Unit]
+Description=%s
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=notify
+ExecStart=%s
+ExecReload=/bin/kill -HUP $MAINPID
+Restart=on-failure
+
+[Install]
+WantedBy=multi-user.target
+`, config.Description, strings.Join(command, " "))
+
+	if err := os.WriteFile(path, []byte(unit), 0644); err != nil {
+		return fmt.Errorf("failed to write unit file: %v", err)
+	}
+	if err := systemctl("daemon-reload"); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return systemctl("enable", config.Name)
+}
+
+// UninstallService 停止并禁用服务，删除单元文件
+func UninstallService(name string) error {
+	path := unitPath(name)
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("service %s is not installed (%s not found)", name, path)
+	}
+	if err := systemctl("disable", "--now", name); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove unit file: %v", err)
+	}
+	return systemctl("daemon-reload")
+}
+
+// StartService 启动已安装的服务
+func StartService(name string) error {
+	return systemctl("start", name)
+}
+
+// StopService 停止正在运行的服务
+func StopService(name string) error {
+	return systemctl("stop", name)
+}
+
+// RunService systemd 直接运行监听模式，不需要服务调度
+func RunService(name string, run func() error, stop func()) error {
+	return run()
+}
+
+// systemctl 执行 systemctl 命令，失败时带上它的输出
+func systemctl(args ...string) error {
+	output, err := exec.Command("systemctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl %s failed: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// systemdQuote 按 systemd 的命令行语法引用参数，% 和 $ 需要转义以免被当作说明符和环境变量
+func systemdQuote(arg string) string {
+	arg = strings.ReplaceAll(arg, "%", "%%")
+	arg = strings.ReplaceAll(arg, "$", "$$")
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\;") {
+		return arg
+	}
+	arg = strings.ReplaceAll(arg, `\`, `\\`)
+	arg = strings.ReplaceAll(arg, `"`, `\"`)
+	return `"` + arg + `"`
+}
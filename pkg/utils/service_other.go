@@ -0,0 +1,33 @@
+//go:build !linux && !windows
+
+package utils
+
+import "errors"
+
+// errNoServiceManager 只支持 systemd 和 Windows 服务管理器
+var errNoServiceManager = errors.New("service management is only supported with systemd (Linux) and on Windows")
+
+// InstallService 其他系统不支持
+func InstallService(config ServiceConfig) error {
+	return errNoServiceManager
+}
+
+// UninstallService 其他系统不支持
+func UninstallService(name string) error {
+	return errNoServiceManager
+}
+
+// StartService 其他系统不支持
+func StartService(name string) error {
+	return errNoServiceManager
+}
+
+// StopService 其他系统不支持
+func StopService(name string) error {
+	return errNoServiceManager
+}
+
+// RunService 其他系统直接运行监听模式
+func RunService(name string, run func() error, stop func()) error {
+	return run()
+}
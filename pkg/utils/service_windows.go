@@ -0,0 +1,276 @@
+//go:build windows
+
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procOpenSCManagerW               = modAdvapi32.NewProc("OpenSCManagerW")
+	procCreateServiceW               = modAdvapi32.NewProc("CreateServiceW")
+	procOpenServiceW                 = modAdvapi32.NewProc("OpenServiceW")
+	procDeleteService                = modAdvapi32.NewProc("DeleteService")
+	procStartServiceW                = modAdvapi32.NewProc("StartServiceW")
+	procControlService               = modAdvapi32.NewProc("ControlService")
+	procCloseServiceHandle           = modAdvapi32.NewProc("CloseServiceHandle")
+	procChangeServiceConfig2W        = modAdvapi32.NewProc("ChangeServiceConfig2W")
+	procStartServiceCtrlDispatcherW  = modAdvapi32.NewProc("StartServiceCtrlDispatcherW")
+	procRegisterServiceCtrlHandlerEx = modAdvapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	procSetServiceStatus             = modAdvapi32.NewProc("SetServiceStatus")
+)
+
+const (
+	scManagerConnect       = 0x0001
+	scManagerCreateService = 0x0002
+
+	serviceQueryStatus  = 0x0004
+	serviceStart        = 0x0010
+	serviceStop         = 0x0020
+	serviceChangeConfig = 0x0002
+	serviceDelete       = 0x00010000 // DELETE
+
+	serviceWin32OwnProcess   = 0x10
+	serviceAutoStart         = 2
+	serviceErrorNormal       = 1
+	serviceConfigDescription = 1
+
+	serviceControlStop        = 1
+	serviceControlInterrogate = 4
+	serviceControlShutdown    = 5
+
+	serviceStopped     = 1
+	serviceStopPending = 3
+	serviceRunning     = 4
+
+	serviceAcceptStop     = 0x1
+	serviceAcceptShutdown = 0x4
+
+	errorServiceSpecificError = syscall.Errno(1066)
+	errorServiceDoesNotExist  = syscall.Errno(1060)
+	errorServiceNotActive     = syscall.Errno(1062)
+	errorServiceExists        = syscall.Errno(1073)
+)
+
+// serviceStatus 对应 SERVICE_STATUS
+type serviceStatus struct {
+	ServiceType             uint32
+	CurrentState            uint32
+	ControlsAccepted        uint32
+	Win32ExitCode           uint32
+	ServiceSpecificExitCode uint32
+	CheckPoint              uint32
+	WaitHint                uint32
+}
+
+// serviceTableEntry 对应 SERVICE_TABLE_ENTRYW
+type serviceTableEntry struct {
+	name *uint16
+	proc uintptr
+}
+
+// InstallService 注册为开机自动启动、以 LocalSystem 运行的 Windows 服务，不立即启动
+func InstallService(config ServiceConfig) error {
+	scm, err := openSCManager(scManagerConnect | scManagerCreateService)
+	if err != nil {
+		return err
+	}
+	defer procCloseServiceHandle.Call(scm)
+
+	command := make([]string, 0, len(config.Args)+1)
+	for _, arg := range append([]string{config.Executable}, config.Args...) {
+		command = append(command, syscall.EscapeArg(arg))
+	}
+	name, err := syscall.UTF16PtrFromString(config.Name)
+	if err != nil {
+		return err
+	}
+	display, err := syscall.UTF16PtrFromString(config.Description)
+	if err != nil {
+		return err
+	}
+	binary, err := syscall.UTF16PtrFromString(strings.Join(command, " "))
+	if err != nil {
+		return err
+	}
+
+	service, _, err := procCreateServiceW.Call(
+		scm,
+		uintptr(unsafe.Pointer(name)),
+		uintptr(unsafe.Pointer(display)),
+		serviceChangeConfig|serviceQueryStatus,
+		serviceWin32OwnProcess,
+		serviceAutoStart,
+		serviceErrorNormal,
+		uintptr(unsafe.Pointer(binary)),
+		0, 0, 0, 0, 0,
+	)
+	if service == 0 {
+		if errors.Is(err, errorServiceExists) {
+			return fmt.Errorf("service %s already exists; uninstall it first", config.Name)
+		}
+		return fmt.Errorf("failed to create service: %v", err)
+	}
+	defer procCloseServiceHandle.Call(service)
+
+	// 说明只用于服务管理器中的显示，设置失败不影响服务运行
+	description := struct{ text *uint16 }{display}
+	procChangeServiceConfig2W.Call(service, serviceConfigDescription, uintptr(unsafe.Pointer(&description)))
+	return nil
+}
+
+// UninstallService 停止并删除服务，服务管理器在最后一个句柄关闭后移除它
+func UninstallService(name string) error {
+	return withService(name, serviceStop|serviceQueryStatus|serviceDelete, func(service uintptr) error {
+		if err := controlService(service, serviceControlStop); err != nil && !errors.Is(err, errorServiceNotActive) {
+			return fmt.Errorf("failed to stop service: %v", err)
+		}
+		if r1, _, err := procDeleteService.Call(service); r1 == 0 {
+			return fmt.Errorf("failed to delete service: %v", err)
+		}
+		return nil
+	})
+}
+
+// StartService 启动已安装的服务
+func StartService(name string) error {
+	return withService(name, serviceStart, func(service uintptr) error {
+		if r1, _, err := procStartServiceW.Call(service, 0, 0); r1 == 0 {
+			return fmt.Errorf("failed to start service: %v", err)
+		}
+		return nil
+	})
+}
+
+// StopService 停止正在运行的服务
+func StopService(name string) error {
+	return withService(name, serviceStop, func(service uintptr) error {
+		if err := controlService(service, serviceControlStop); err != nil {
+			return fmt.Errorf("failed to stop service: %v", err)
+		}
+		return nil
+	})
+}
+
+// openSCManager 连接本机的服务管理器，没有管理员权限时返回拒绝访问
+func openSCManager(access uint32) (uintptr, error) {
+	scm, _, err := procOpenSCManagerW.Call(0, 0, uintptr(access))
+	if scm == 0 {
+		return 0, fmt.Errorf("failed to open service manager (run as administrator): %v", err)
+	}
+	return scm, nil
+}
+
+// withService 以指定的权限打开服务并调用 fn
+func withService(name string, access uint32, fn func(service uintptr) error) error {
+	scm, err := openSCManager(scManagerConnect)
+	if err != nil {
+		return err
+	}
+	defer procCloseServiceHandle.Call(scm)
+
+	namePtr, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+	service, _, err := procOpenServiceW.Call(scm, uintptr(unsafe.Pointer(namePtr)), uintptr(access))
+	if service == 0 {
+		if errors.Is(err, errorServiceDoesNotExist) {
+			return fmt.Errorf("service %s is not installed", name)
+		}
+		return fmt.Errorf("failed to open service: %v", err)
+	}
+	defer procCloseServiceHandle.Call(service)
+
+	return fn(service)
+}
+
+// controlService 向服务发送控制码
+func controlService(service uintptr, control uint32) error {
+	var status serviceStatus
+	if r1, _, err := procControlService.Call(service, uintptr(control), uintptr(unsafe.Pointer(&status))); r1 == 0 {
+		return err
+	}
+	return nil
+}
+
+// runningService 由服务管理器回调访问的服务状态，一个进程只运行一个服务
+var runningService struct {
+	name   *uint16
+	handle uintptr
+	run    func() error
+	stop   func()
+	err    error
+}
+
+// RunService 把当前进程作为服务管理器启动的服务运行：run 阻塞直到服务结束，收到停止或关机控制时调用 stop。
+// 不是由服务管理器启动时返回错误
+func RunService(name string, run func() error, stop func()) error {
+	namePtr, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+	runningService.name = namePtr
+	runningService.run = run
+	runningService.stop = stop
+
+	table := []serviceTableEntry{
+		{name: namePtr, proc: syscall.NewCallback(serviceMain)},
+		{},
+	}
+	// 服务结束前不返回
+	if r1, _, err := procStartServiceCtrlDispatcherW.Call(uintptr(unsafe.Pointer(&table[0]))); r1 == 0 {
+		return fmt.Errorf("failed to connect to the service manager (only the service manager may start a process with a service name): %v", err)
+	}
+	return runningService.err
+}
+
+// serviceMain 服务管理器在自己的线程中调用的服务入口
+func serviceMain(argc, argv uintptr) uintptr {
+	handle, _, err := procRegisterServiceCtrlHandlerEx.Call(uintptr(unsafe.Pointer(runningService.name)), syscall.NewCallback(serviceHandler), 0)
+	if handle == 0 {
+		runningService.err = fmt.Errorf("failed to register service handler: %v", err)
+		return 0
+	}
+	runningService.handle = handle
+
+	setServiceStatus(serviceRunning, false)
+	runningService.err = runningService.run()
+	setServiceStatus(serviceStopped, runningService.err != nil)
+	return 0
+}
+
+// serviceHandler 处理服务管理器发来的控制码
+func serviceHandler(control, eventType, eventData, context uintptr) uintptr {
+	switch control {
+	case serviceControlStop, serviceControlShutdown:
+		setServiceStatus(serviceStopPending, false)
+		go runningService.stop()
+	case serviceControlInterrogate:
+		// 服务管理器使用最近一次报告的状态
+	}
+	return 0
+}
+
+// setServiceStatus 报告服务状态，failed 时以服务自定义的错误码退出，服务管理器据此记录失败
+func setServiceStatus(state uint32, failed bool) {
+	status := serviceStatus{
+		ServiceType:  serviceWin32OwnProcess,
+		CurrentState: state,
+	}
+	switch state {
+	case serviceRunning:
+		status.ControlsAccepted = serviceAcceptStop | serviceAcceptShutdown
+	case serviceStopPending:
+		status.WaitHint = 10000
+	}
+	if failed {
+		status.Win32ExitCode = uint32(errorServiceSpecificError)
+		status.ServiceSpecificExitCode = 1
+	}
+	procSetServiceStatus.Call(runningService.handle, uintptr(unsafe.Pointer(&status)))
+}
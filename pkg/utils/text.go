@@ -0,0 +1,181 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// textSniffSize 按内容判断文本文件时检查的字节数
+const textSniffSize = 8000
+
+// utf8BOM UTF-8 字节顺序标记
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// TextFilter 选择按文本处理的文件：扩展名匹配，或启用 Auto 时开头不含 NUL 字节的文件
+type TextFilter struct {
+	Auto bool
+	Exts map[string]bool // 小写的扩展名，包含开头的点
+}
+
+// ParseTextFilter 解析逗号分隔的扩展名列表，其中 auto 表示按内容判断，例如 "auto" 或 ".txt,.md,go"
+func ParseTextFilter(spec string) (*TextFilter, error) {
+	f := &TextFilter{Exts: make(map[string]bool)}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		switch {
+		case item == "":
+			continue
+		case item == "auto":
+			f.Auto = true
+		case strings.ContainsAny(item, `/\*?`):
+			return nil, fmt.Errorf("invalid text file extension: %s", item)
+		default:
+			if !strings.HasPrefix(item, ".") {
+				item = "." + item
+			}
+			f.Exts[item] = true
+		}
+	}
+	if !f.Auto && len(f.Exts) == 0 {
+		return nil, fmt.Errorf("empty text mode filter")
+	}
+	return f, nil
+}
+
+// String 返回可被 ParseTextFilter 解析的规范形式
+func (f *TextFilter) String() string {
+	items := make([]string, 0, len(f.Exts)+1)
+	for ext := range f.Exts {
+		items = append(items, ext)
+	}
+	sort.Strings(items)
+	if f.Auto {
+		items = append([]string{"auto"}, items...)
+	}
+	return strings.Join(items, ",")
+}
+
+// IsText 检查文件是否按文本处理
+func (f *TextFilter) IsText(path string) bool {
+	if f == nil {
+		return false
+	}
+	if f.Exts[strings.ToLower(filepath.Ext(path))] {
+		return true
+	}
+	return f.Auto && isTextContent(path)
+}
+
+// isTextContent 文件开头不含 NUL 字节时视为文本
+func isTextContent(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	buf := make([]byte, textSniffSize)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false
+	}
+	return bytes.IndexByte(buf[:n], 0) < 0
+}
+
+// NormalizedMD5 计算去掉 UTF-8 BOM 并将换行符统一为 LF 后的内容的MD5，
+// 换行符不同的同一文本文件得到相同的值
+func NormalizedMD5(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	hash := md5.New()
+	w := newTextWriter(hash, false)
+	if _, err := io.Copy(w, file); err != nil {
+		return "", fmt.Errorf("failed to read file: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// NewTextWriter 返回去掉 UTF-8 BOM 并将 CRLF、CR 和 LF 换行符统一转换为本机格式
+// （Windows 为 CRLF，其他系统为 LF）后写入 w 的写入端，写完后需调用 Close
+func NewTextWriter(w io.Writer) io.WriteCloser {
+	return newTextWriter(w, runtime.GOOS == "windows")
+}
+
+// textWriter 换行符转换
+type textWriter struct {
+	w       io.Writer
+	newline []byte
+	head    []byte // 尚未确定是否为 BOM 的开头字节
+	started bool   // 已越过文件开头
+	lastCR  bool   // 上一个字节是 CR，紧随其后的 LF 属于同一个换行符
+	out     []byte
+}
+
+func newTextWriter(w io.Writer, crlf bool) *textWriter {
+	t := &textWriter{w: w, newline: []byte("\n")}
+	if crlf {
+		t.newline = []byte("\r\n")
+	}
+	return t
+}
+
+func (t *textWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if !t.started {
+		t.head = append(t.head, p...)
+		if len(t.head) < len(utf8BOM) && bytes.HasPrefix(utf8BOM, t.head) {
+			return n, nil
+		}
+		p = bytes.TrimPrefix(t.head, utf8BOM)
+		t.head = nil
+		t.started = true
+	}
+
+	t.out = t.out[:0]
+	for _, b := range p {
+		switch {
+		case b == '\r':
+			t.out = append(t.out, t.newline...)
+			t.lastCR = true
+			continue
+		case b == '\n' && t.lastCR:
+			// CRLF 已在 CR 处转换
+		case b == '\n':
+			t.out = append(t.out, t.newline...)
+		default:
+			t.out = append(t.out, b)
+		}
+		t.lastCR = false
+	}
+
+	if _, err := t.w.Write(t.out); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Close 写出不足 BOM 长度的开头字节
+func (t *textWriter) Close() error {
+	if t.started || len(t.head) == 0 {
+		return nil
+	}
+	head := t.head
+	t.head = nil
+	t.started = true
+	_, err := t.Write(head)
+	return err
+}
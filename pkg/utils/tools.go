@@ -9,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 func FormatSize(bytes int64) string {
@@ -34,7 +35,7 @@ func CalculateMD5(filePath string) (string, error) {
 	// 打开文件
 	file, err := os.Open(filePath)
 	if err != nil {
-		return "", fmt.Errorf("failed to open file: %v", err)
+		return "", fmt.Errorf("failed to open file: %w", err)
 	}
 	defer file.Close()
 
@@ -66,9 +67,110 @@ func MakeTempName(origname string) string {
 	return filepath.Join(filepath.Dir(origname), name)
 }
 
+// IsTempName 检查文件名是否为 MakeTempName 生成的临时文件名
+func IsTempName(name string) bool {
+	rnd, ok := strings.CutPrefix(name, "tmp-")
+	if !ok {
+		return false
+	}
+	rnd, ok = strings.CutSuffix(rnd, ".tmp")
+	if !ok || len(rnd) != base32.StdEncoding.EncodedLen(10) {
+		return false
+	}
+	_, err := base32.StdEncoding.DecodeString(strings.ToUpper(rnd))
+	return err == nil
+}
+
+// NewSessionID 生成随机的 UUID（版本 4），用于标识一次同步会话
+func NewSessionID() string {
+	var b [16]byte
+	rand.Read(b[:]) // 忽略错误
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// 文件被占用时重命名的初始等待时间，之后每次重试翻倍
+const renameRetryDelay = 100 * time.Millisecond
+
+// 文件被占用时重命名的重试次数
+var renameRetries = 5
+
+// SetRenameRetries 设置目标文件被其他进程占用时重命名的重试次数
+func SetRenameRetries(n int) {
+	if n < 0 {
+		n = 0
+	}
+	renameRetries = n
+}
+
+// IsFileLocked 检查错误是否由目标文件被其他进程占用引起
+func IsFileLocked(err error) bool {
+	return isSharingViolation(err)
+}
+
+// renameWithRetry 重命名文件，Windows 上目标被占用时按指数退避重试
+func renameWithRetry(oldname, newname string) error {
+	delay := renameRetryDelay
+	for attempt := 0; ; attempt++ {
+		err := os.Rename(oldname, newname)
+		if err == nil || !isSharingViolation(err) || attempt >= renameRetries {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// copyRename 跨文件系统时先复制到目标目录中的临时文件并写入磁盘，再在目标文件系统内重命名
+func copyRename(oldname, newname string) error {
+	src, err := os.Open(oldname)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	tempPath := MakeTempName(newname)
+	dst, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, src)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	if err := Saferename(tempPath, newname); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	src.Close()
+	return os.Remove(oldname)
+}
+
 // Saferename 安全地重命名文件
 func Saferename(oldname, newname string) error {
-	err := os.Rename(oldname, newname)
+	err := renameWithRetry(oldname, newname)
+	if err != nil && isCrossDevice(err) {
+		if err := copyRename(oldname, newname); err != nil {
+			return fmt.Errorf("failed to move %s to %s across filesystems: %w", oldname, newname, err)
+		}
+		return nil
+	}
 	if err != nil {
 		// If newname exists ("original"), we will try renaming it to a
 		// new temporary name, then renaming oldname to the newname,
@@ -86,11 +188,14 @@ func Saferename(oldname, newname string) error {
 			}
 			break
 		}
-		err = os.Rename(newname, origtmp)
+		err = renameWithRetry(newname, origtmp)
 		if err != nil {
+			if isSharingViolation(err) {
+				return fmt.Errorf("destination %s is in use by another process: %w", newname, err)
+			}
 			return err
 		}
-		err = os.Rename(oldname, newname)
+		err = renameWithRetry(oldname, newname)
 		if err != nil {
 			// Rename still fails, try to revert original rename,
 			// ignoring errors.
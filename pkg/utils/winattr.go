@@ -0,0 +1,17 @@
+package utils
+
+// 在 Windows 之间同步的文件属性（FILE_ATTRIBUTE_*），其余属性（归档、压缩等）由文件系统维护
+const (
+	FileAttributeReadonly = 0x1
+	FileAttributeHidden   = 0x2
+	FileAttributeSystem   = 0x4
+
+	// WindowsAttributeMask 同步的属性位
+	WindowsAttributeMask = FileAttributeReadonly | FileAttributeHidden | FileAttributeSystem
+)
+
+// Stream NTFS 备用数据流，Name 不包括前面的冒号和后面的 :$DATA 流类型
+type Stream struct {
+	Name string
+	Size int64
+}
@@ -0,0 +1,45 @@
+//go:build !windows
+
+package utils
+
+import (
+	"errors"
+	"os"
+)
+
+var errWindowsOnly = errors.New("only supported on Windows")
+
+// FileAttributes 非 Windows 平台没有文件属性，总是返回 ok == false
+func FileAttributes(info os.FileInfo) (attrs uint32, ok bool) {
+	return 0, false
+}
+
+// SetFileAttributes 非 Windows 平台不支持
+func SetFileAttributes(path string, attrs uint32) error {
+	return errWindowsOnly
+}
+
+// FileSecurity 非 Windows 平台不支持
+func FileSecurity(path string) (string, error) {
+	return "", errWindowsOnly
+}
+
+// SetFileSecurity 非 Windows 平台不支持
+func SetFileSecurity(path, sddl string) error {
+	return errWindowsOnly
+}
+
+// FileStreams 非 Windows 平台没有备用数据流，总是返回空列表
+func FileStreams(path string) ([]Stream, error) {
+	return nil, nil
+}
+
+// ReadStream 非 Windows 平台不支持
+func ReadStream(path, name string) ([]byte, error) {
+	return nil, errWindowsOnly
+}
+
+// WriteStream 非 Windows 平台不支持
+func WriteStream(path, name string, data []byte) error {
+	return errWindowsOnly
+}
@@ -0,0 +1,176 @@
+//go:build windows
+
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modAdvapi32                                              = syscall.NewLazyDLL("advapi32.dll")
+	procGetNamedSecurityInfoW                                = modAdvapi32.NewProc("GetNamedSecurityInfoW")
+	procSetFileSecurityW                                     = modAdvapi32.NewProc("SetFileSecurityW")
+	procConvertSecurityDescriptorToStringSecurityDescriptorW = modAdvapi32.NewProc("ConvertSecurityDescriptorToStringSecurityDescriptorW")
+	procConvertStringSecurityDescriptorToSecurityDescriptorW = modAdvapi32.NewProc("ConvertStringSecurityDescriptorToSecurityDescriptorW")
+)
+
+var (
+	modKernel32          = syscall.NewLazyDLL("kernel32.dll")
+	procFindFirstStreamW = modKernel32.NewProc("FindFirstStreamW")
+	procFindNextStreamW  = modKernel32.NewProc("FindNextStreamW")
+)
+
+const (
+	seFileObject = 1 // SE_FILE_OBJECT
+	sddlRevision = 1 // SDDL_REVISION_1
+
+	// 同步属主、属组和 DACL，不包括需要 SeSecurityPrivilege 的 SACL
+	securityInformation = 0x1 | 0x2 | 0x4 // OWNER | GROUP | DACL_SECURITY_INFORMATION
+
+	errorHandleEOF = 38 // ERROR_HANDLE_EOF，没有（更多）数据流
+)
+
+// win32FindStreamData 对应 WIN32_FIND_STREAM_DATA
+type win32FindStreamData struct {
+	StreamSize int64
+	StreamName [syscall.MAX_PATH + 36]uint16
+}
+
+// FileAttributes 返回文件的只读、隐藏和系统属性
+func FileAttributes(info os.FileInfo) (attrs uint32, ok bool) {
+	data, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return 0, false
+	}
+	return data.FileAttributes & WindowsAttributeMask, true
+}
+
+// SetFileAttributes 把文件的只读、隐藏和系统属性设置为 attrs，其余属性保持不变
+func SetFileAttributes(path string, attrs uint32) error {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	current, err := syscall.GetFileAttributes(name)
+	if err != nil {
+		return err
+	}
+	return syscall.SetFileAttributes(name, current&^WindowsAttributeMask|attrs&WindowsAttributeMask)
+}
+
+// FileSecurity 以 SDDL 字符串返回文件的属主、属组和 DACL
+func FileSecurity(path string) (string, error) {
+	if err := modAdvapi32.Load(); err != nil {
+		return "", err
+	}
+
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return "", err
+	}
+
+	var sd uintptr
+	if ret, _, _ := procGetNamedSecurityInfoW.Call(uintptr(unsafe.Pointer(name)), seFileObject, securityInformation,
+		0, 0, 0, 0, uintptr(unsafe.Pointer(&sd))); ret != 0 {
+		return "", fmt.Errorf("GetNamedSecurityInfo failed: %v", syscall.Errno(ret))
+	}
+	defer syscall.LocalFree(syscall.Handle(sd))
+
+	var str *uint16
+	if ret, _, err := procConvertSecurityDescriptorToStringSecurityDescriptorW.Call(sd, sddlRevision, securityInformation,
+		uintptr(unsafe.Pointer(&str)), 0); ret == 0 {
+		return "", fmt.Errorf("ConvertSecurityDescriptorToStringSecurityDescriptor failed: %v", err)
+	}
+	defer syscall.LocalFree(syscall.Handle(unsafe.Pointer(str)))
+
+	return syscall.UTF16ToString(unsafe.Slice(str, utf16Len(str))), nil
+}
+
+// SetFileSecurity 把 SDDL 字符串描述的属主、属组和 DACL 应用到文件。
+// 设置其他账户为属主需要 SeRestorePrivilege，通常要以管理员身份运行
+func SetFileSecurity(path, sddl string) error {
+	if err := modAdvapi32.Load(); err != nil {
+		return err
+	}
+
+	str, err := syscall.UTF16PtrFromString(sddl)
+	if err != nil {
+		return err
+	}
+	var sd uintptr
+	if ret, _, err := procConvertStringSecurityDescriptorToSecurityDescriptorW.Call(uintptr(unsafe.Pointer(str)), sddlRevision,
+		uintptr(unsafe.Pointer(&sd)), 0); ret == 0 {
+		return fmt.Errorf("invalid security descriptor: %v", err)
+	}
+	defer syscall.LocalFree(syscall.Handle(sd))
+
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	if ret, _, err := procSetFileSecurityW.Call(uintptr(unsafe.Pointer(name)), securityInformation, sd); ret == 0 {
+		return fmt.Errorf("SetFileSecurity failed: %v", err)
+	}
+	return nil
+}
+
+// FileStreams 返回文件或目录的备用数据流，不包括文件内容所在的默认流
+func FileStreams(path string) ([]Stream, error) {
+	if err := modKernel32.Load(); err != nil {
+		return nil, err
+	}
+
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var data win32FindStreamData
+	handle, _, err := procFindFirstStreamW.Call(uintptr(unsafe.Pointer(name)), 0, uintptr(unsafe.Pointer(&data)), 0)
+	if syscall.Handle(handle) == syscall.InvalidHandle {
+		if err == syscall.Errno(errorHandleEOF) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("FindFirstStream failed: %v", err)
+	}
+	defer syscall.FindClose(syscall.Handle(handle))
+
+	var streams []Stream
+	for {
+		// 流名称形如 :Zone.Identifier:$DATA，默认流为 ::$DATA
+		streamName := strings.TrimSuffix(strings.TrimPrefix(syscall.UTF16ToString(data.StreamName[:]), ":"), ":$DATA")
+		if streamName != "" {
+			streams = append(streams, Stream{Name: streamName, Size: data.StreamSize})
+		}
+
+		if ret, _, err := procFindNextStreamW.Call(handle, uintptr(unsafe.Pointer(&data))); ret == 0 {
+			if err == syscall.Errno(errorHandleEOF) {
+				return streams, nil
+			}
+			return nil, fmt.Errorf("FindNextStream failed: %v", err)
+		}
+	}
+}
+
+// ReadStream 读取文件的备用数据流
+func ReadStream(path, name string) ([]byte, error) {
+	return os.ReadFile(path + ":" + name)
+}
+
+// WriteStream 写入文件的备用数据流，流不存在时创建
+func WriteStream(path, name string, data []byte) error {
+	return os.WriteFile(path+":"+name, data, 0644)
+}
+
+// utf16Len 返回以 0 结尾的 UTF-16 字符串的长度
+func utf16Len(p *uint16) int {
+	n := 0
+	for ptr := unsafe.Pointer(p); *(*uint16)(ptr) != 0; ptr = unsafe.Add(ptr, 2) {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,102 @@
+//go:build darwin
+
+package utils
+
+import (
+	"bytes"
+	"syscall"
+	"unsafe"
+)
+
+// xattrNoFollow 对应 XATTR_NOFOLLOW，符号链接操作链接本身
+const xattrNoFollow = 0x1
+
+// ListXattrs 返回文件的扩展属性名，包括 com.apple.FinderInfo 和资源分支 com.apple.ResourceFork
+func ListXattrs(path string) ([]string, error) {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		size, _, errno := syscall.Syscall6(syscall.SYS_LISTXATTR, uintptr(unsafe.Pointer(p)), 0, 0, xattrNoFollow, 0, 0)
+		if errno != 0 {
+			return nil, errno
+		}
+		if size == 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size)
+		n, _, errno := syscall.Syscall6(syscall.SYS_LISTXATTR, uintptr(unsafe.Pointer(p)),
+			uintptr(unsafe.Pointer(&buf[0])), size, xattrNoFollow, 0, 0)
+		if errno == syscall.ERANGE {
+			// 两次调用之间增加了属性
+			continue
+		}
+		if errno != 0 {
+			return nil, errno
+		}
+
+		var names []string
+		for _, name := range bytes.Split(buf[:n], []byte{0}) {
+			if len(name) > 0 {
+				names = append(names, string(name))
+			}
+		}
+		return names, nil
+	}
+}
+
+// GetXattr 读取文件的扩展属性
+func GetXattr(path, name string) ([]byte, error) {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	attr, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		size, _, errno := syscall.Syscall6(syscall.SYS_GETXATTR, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(attr)), 0, 0, 0, xattrNoFollow)
+		if errno != 0 {
+			return nil, errno
+		}
+		if size == 0 {
+			return []byte{}, nil
+		}
+		buf := make([]byte, size)
+		n, _, errno := syscall.Syscall6(syscall.SYS_GETXATTR, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(attr)),
+			uintptr(unsafe.Pointer(&buf[0])), size, 0, xattrNoFollow)
+		if errno == syscall.ERANGE {
+			continue
+		}
+		if errno != 0 {
+			return nil, errno
+		}
+		return buf[:n], nil
+	}
+}
+
+// SetXattr 设置文件的扩展属性，属性不存在时创建
+func SetXattr(path, name string, data []byte) error {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	attr, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return err
+	}
+
+	var value uintptr
+	if len(data) > 0 {
+		value = uintptr(unsafe.Pointer(&data[0]))
+	}
+	if _, _, errno := syscall.Syscall6(syscall.SYS_SETXATTR, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(attr)),
+		value, uintptr(len(data)), 0, xattrNoFollow); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build !darwin
+
+package utils
+
+import "errors"
+
+var errDarwinOnly = errors.New("only supported on macOS")
+
+// ListXattrs 只收集 macOS 的扩展属性，其他平台总是返回空列表
+func ListXattrs(path string) ([]string, error) {
+	return nil, nil
+}
+
+// GetXattr 其他平台不支持
+func GetXattr(path, name string) ([]byte, error) {
+	return nil, errDarwinOnly
+}
+
+// SetXattr 其他平台不支持
+func SetXattr(path, name string, data []byte) error {
+	return errDarwinOnly
+}